@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// clientOptions are the persistent flags every command uses to reach the instance
+type clientOptions struct {
+	server string
+	token  string
+}
+
+// client calls the API of the instance as the signed in super admin
+type client struct {
+	server string
+	token  string
+	http   *http.Client
+}
+
+// newClient returns a client with the token of the flags, or the one saved by login
+func newClient(opts *clientOptions) (*client, error) {
+	token := opts.token
+	if token == "" {
+		saved, err := loadToken()
+		if err != nil {
+			return nil, errors.New("not signed in, run `hoppctl login` or pass --token")
+		}
+		token = saved
+	}
+
+	return &client{
+		server: strings.TrimSuffix(opts.server, "/"),
+		token:  token,
+		http:   &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+// do sends the request with the body encoded as JSON, and decodes the response
+// into out when it is given. Error responses are returned as errors.
+func (c *client) do(method, path string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(encoded)
+	}
+
+	req, err := http.NewRequest(method, c.server+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		var apiErr struct {
+			Message string `json:"message"`
+			Error   string `json:"error"`
+		}
+		_ = json.NewDecoder(resp.Body).Decode(&apiErr)
+		if apiErr.Message == "" {
+			apiErr.Message = apiErr.Error
+		}
+		return &apiError{status: resp.StatusCode, message: apiErr.Message}
+	}
+
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// apiError is an error response of the API
+type apiError struct {
+	status  int
+	message string
+}
+
+func (e *apiError) Error() string {
+	if e.message == "" {
+		return fmt.Sprintf("request failed with status %d", e.status)
+	}
+
+	return fmt.Sprintf("%s (status %d)", e.message, e.status)
+}
+
+// tokenPath is where login saves the token, readable only by the user
+func tokenPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, "hoppctl", "token"), nil
+}
+
+func loadToken() (string, error) {
+	path, err := tokenPath()
+	if err != nil {
+		return "", err
+	}
+
+	token, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(token)), nil
+}
+
+func saveToken(token string) error {
+	path, err := tokenPath()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, []byte(token), 0o600)
+}
+
+// printJSON prints the value as indented JSON
+func printJSON(value interface{}) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+func newFlagsCommand(opts *clientOptions) *cobra.Command {
+	flags := &cobra.Command{
+		Use:   "flags",
+		Short: "Toggle the feature flags of the instance",
+	}
+
+	flags.AddCommand(
+		&cobra.Command{
+			Use:   "list",
+			Short: "List the feature flags that were ever set",
+			Args:  cobra.NoArgs,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				c, err := newClient(opts)
+				if err != nil {
+					return err
+				}
+
+				var flags []struct {
+					Name    string `json:"name"`
+					Enabled bool   `json:"enabled"`
+				}
+				if err := c.do(http.MethodGet, "/api/admin/feature-flags", nil, &flags); err != nil {
+					return err
+				}
+
+				w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+				fmt.Fprintln(w, "NAME\tENABLED")
+				for _, flag := range flags {
+					fmt.Fprintf(w, "%s\t%t\n", flag.Name, flag.Enabled)
+				}
+				return w.Flush()
+			},
+		},
+		newFlagsSetCommand(opts, "enable", true),
+		newFlagsSetCommand(opts, "disable", false),
+	)
+
+	return flags
+}
+
+func newFlagsSetCommand(opts *clientOptions, use string, enabled bool) *cobra.Command {
+	return &cobra.Command{
+		Use:   use + " NAME",
+		Short: fmt.Sprintf("Turn a feature flag %s", map[bool]string{true: "on", false: "off"}[enabled]),
+		Args:  exactArgs("NAME"),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := newClient(opts)
+			if err != nil {
+				return err
+			}
+
+			body := map[string]bool{"enabled": enabled}
+			if err := c.do(http.MethodPut, "/api/admin/feature-flags/"+url.PathEscape(args[0]), body, nil); err != nil {
+				return err
+			}
+
+			fmt.Printf("%s %sd\n", args[0], use)
+			return nil
+		},
+	}
+}
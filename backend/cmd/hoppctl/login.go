@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func newLoginCommand(opts *clientOptions) *cobra.Command {
+	return &cobra.Command{
+		Use:   "login",
+		Short: "Sign in through the browser and save the token",
+		Long: "Signs in with the device authorization flow of the desktop app: approve\n" +
+			"the code in the browser and the token is saved for the next commands.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c := &client{
+				server: strings.TrimSuffix(opts.server, "/"),
+				http:   &http.Client{Timeout: 30 * time.Second},
+			}
+
+			var code struct {
+				DeviceCode              string `json:"device_code"`
+				UserCode                string `json:"user_code"`
+				VerificationURIComplete string `json:"verification_uri_complete"`
+				ExpiresIn               int    `json:"expires_in"`
+				Interval                int    `json:"interval"`
+			}
+			if err := c.do(http.MethodPost, "/api/auth/device/code", nil, &code); err != nil {
+				return err
+			}
+
+			fmt.Printf("Open %s and approve the code %s\n", code.VerificationURIComplete, code.UserCode)
+
+			interval := time.Duration(code.Interval) * time.Second
+			deadline := time.Now().Add(time.Duration(code.ExpiresIn) * time.Second)
+			for time.Now().Before(deadline) {
+				time.Sleep(interval)
+
+				var token struct {
+					Token string `json:"token"`
+				}
+				err := c.do(http.MethodPost, "/api/auth/device/token", map[string]string{"device_code": code.DeviceCode}, &token)
+
+				var apiErr *apiError
+				switch {
+				case err == nil:
+					if err := saveToken(token.Token); err != nil {
+						return fmt.Errorf("saving the token: %w", err)
+					}
+					fmt.Println("Signed in")
+					return nil
+				case errors.As(err, &apiErr) && apiErr.message == "authorization_pending":
+				case errors.As(err, &apiErr) && apiErr.message == "slow_down":
+					interval += 5 * time.Second
+				default:
+					return err
+				}
+			}
+
+			return errors.New("the code expired before it was approved")
+		},
+	}
+}
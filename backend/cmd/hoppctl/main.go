@@ -0,0 +1,58 @@
+// Command hoppctl operates a Hopp instance from the terminal through the admin
+// API, for the super admins of the instance. Sign in once with `hoppctl login`,
+// or pass a token with --token or HOPP_TOKEN.
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+func main() {
+	if err := newRootCommand().Execute(); err != nil {
+		os.Exit(1)
+	}
+}
+
+func newRootCommand() *cobra.Command {
+	var opts clientOptions
+
+	root := &cobra.Command{
+		Use:          "hoppctl",
+		Short:        "Operate a Hopp instance through its admin API",
+		SilenceUsage: true,
+	}
+
+	root.PersistentFlags().StringVar(&opts.server, "server", envOr("HOPP_SERVER", "https://localhost:1926"), "URL of the Hopp instance (HOPP_SERVER)")
+	root.PersistentFlags().StringVar(&opts.token, "token", os.Getenv("HOPP_TOKEN"), "token of a super admin, the one saved by login if empty (HOPP_TOKEN)")
+
+	root.AddCommand(
+		newLoginCommand(&opts),
+		newUsersCommand(&opts),
+		newTeamsCommand(&opts),
+		newFlagsCommand(&opts),
+	)
+
+	return root
+}
+
+// envOr returns the environment variable, or the fallback if it isn't set
+func envOr(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+
+	return fallback
+}
+
+// exactArgs is cobra.ExactArgs with the names of the arguments in the error
+func exactArgs(names ...string) cobra.PositionalArgs {
+	return func(cmd *cobra.Command, args []string) error {
+		if len(args) != len(names) {
+			return fmt.Errorf("expected %d argument(s): %v", len(names), names)
+		}
+		return nil
+	}
+}
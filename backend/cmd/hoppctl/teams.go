@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/spf13/cobra"
+)
+
+func newTeamsCommand(opts *clientOptions) *cobra.Command {
+	teams := &cobra.Command{
+		Use:   "teams",
+		Short: "Inspect teams and manage their invitations",
+	}
+
+	teams.AddCommand(
+		newTeamsGetCommand(opts),
+		newTeamsInvitationsCommand(opts),
+		newTeamsRotateInviteCommand(opts),
+	)
+
+	return teams
+}
+
+func newTeamsGetCommand(opts *clientOptions) *cobra.Command {
+	return &cobra.Command{
+		Use:   "get TEAM_ID",
+		Short: "Show a team along with its members",
+		Args:  exactArgs("TEAM_ID"),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := newClient(opts)
+			if err != nil {
+				return err
+			}
+
+			var team map[string]interface{}
+			if err := c.do(http.MethodGet, "/api/admin/teams/"+url.PathEscape(args[0]), nil, &team); err != nil {
+				return err
+			}
+
+			return printJSON(team)
+		},
+	}
+}
+
+func newTeamsInvitationsCommand(opts *clientOptions) *cobra.Command {
+	var status string
+
+	cmd := &cobra.Command{
+		Use:   "invitations TEAM_ID",
+		Short: "List the email invitations of a team",
+		Args:  exactArgs("TEAM_ID"),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := newClient(opts)
+			if err != nil {
+				return err
+			}
+
+			var invitations []map[string]interface{}
+			path := "/api/admin/teams/" + url.PathEscape(args[0]) + "/invitations?status=" + url.QueryEscape(status)
+			if err := c.do(http.MethodGet, path, nil, &invitations); err != nil {
+				return err
+			}
+
+			return printJSON(invitations)
+		},
+	}
+
+	cmd.Flags().StringVar(&status, "status", "pending", "one of pending, accepted, expired or all")
+
+	return cmd
+}
+
+func newTeamsRotateInviteCommand(opts *clientOptions) *cobra.Command {
+	return &cobra.Command{
+		Use:   "rotate-invite TEAM_ID",
+		Short: "Revoke the invitation link of a team and create a new one",
+		Args:  exactArgs("TEAM_ID"),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := newClient(opts)
+			if err != nil {
+				return err
+			}
+
+			var invitation struct {
+				InviteUUID string `json:"invite_uuid"`
+				TeamName   string `json:"team_name"`
+			}
+			if err := c.do(http.MethodPost, "/api/admin/teams/"+url.PathEscape(args[0])+"/invite-link", nil, &invitation); err != nil {
+				return err
+			}
+
+			fmt.Printf("New invitation of %s: %s\n", invitation.TeamName, invitation.InviteUUID)
+			return nil
+		},
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+// user is a user as the admin API returns them
+type user struct {
+	ID        string `json:"id"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Email     string `json:"email"`
+	TeamID    *uint  `json:"team_id"`
+	IsAdmin   bool   `json:"is_admin"`
+	IsOnline  bool   `json:"is_online"`
+}
+
+func newUsersCommand(opts *clientOptions) *cobra.Command {
+	users := &cobra.Command{
+		Use:   "users",
+		Short: "List, inspect and create users, and sign them out",
+	}
+
+	users.AddCommand(
+		newUsersListCommand(opts),
+		newUsersGetCommand(opts),
+		newUsersCreateCommand(opts),
+		newUsersRevokeSessionsCommand(opts),
+	)
+
+	return users
+}
+
+func newUsersListCommand(opts *clientOptions) *cobra.Command {
+	var search string
+	var page int
+
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List the users, newest first",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := newClient(opts)
+			if err != nil {
+				return err
+			}
+
+			query := url.Values{}
+			query.Set("page", strconv.Itoa(page))
+			if search != "" {
+				query.Set("search", search)
+			}
+
+			var users []user
+			if err := c.do(http.MethodGet, "/api/admin/users?"+query.Encode(), nil, &users); err != nil {
+				return err
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "ID\tNAME\tEMAIL\tTEAM\tADMIN\tONLINE")
+			for _, u := range users {
+				team := "-"
+				if u.TeamID != nil {
+					team = strconv.FormatUint(uint64(*u.TeamID), 10)
+				}
+				fmt.Fprintf(w, "%s\t%s %s\t%s\t%s\t%t\t%t\n", u.ID, u.FirstName, u.LastName, u.Email, team, u.IsAdmin, u.IsOnline)
+			}
+			return w.Flush()
+		},
+	}
+
+	cmd.Flags().StringVar(&search, "search", "", "match the name or the email")
+	cmd.Flags().IntVar(&page, "page", 1, "page of 100 users")
+
+	return cmd
+}
+
+func newUsersGetCommand(opts *clientOptions) *cobra.Command {
+	return &cobra.Command{
+		Use:   "get USER_ID",
+		Short: "Show a user along with their teams and presence",
+		Args:  exactArgs("USER_ID"),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := newClient(opts)
+			if err != nil {
+				return err
+			}
+
+			var details, presence map[string]interface{}
+			if err := c.do(http.MethodGet, "/api/admin/users/"+url.PathEscape(args[0]), nil, &details); err != nil {
+				return err
+			}
+			if err := c.do(http.MethodGet, "/api/admin/users/"+url.PathEscape(args[0])+"/presence", nil, &presence); err != nil {
+				return err
+			}
+			details["presence"] = presence
+
+			return printJSON(details)
+		},
+	}
+}
+
+func newUsersCreateCommand(opts *clientOptions) *cobra.Command {
+	var req struct {
+		FirstName string `json:"first_name"`
+		LastName  string `json:"last_name"`
+		Email     string `json:"email"`
+		Password  string `json:"password"`
+		TeamID    *uint  `json:"team_id,omitempty"`
+		IsAdmin   bool   `json:"is_admin"`
+	}
+	var teamID uint
+
+	cmd := &cobra.Command{
+		Use:   "create",
+		Short: "Create a user with a password",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := newClient(opts)
+			if err != nil {
+				return err
+			}
+
+			if cmd.Flags().Changed("team") {
+				req.TeamID = &teamID
+			}
+
+			var created user
+			if err := c.do(http.MethodPost, "/api/admin/users", req, &created); err != nil {
+				return err
+			}
+
+			fmt.Printf("Created %s (%s)\n", created.Email, created.ID)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&req.FirstName, "first-name", "", "first name of the user")
+	cmd.Flags().StringVar(&req.LastName, "last-name", "", "last name of the user")
+	cmd.Flags().StringVar(&req.Email, "email", "", "email of the user")
+	cmd.Flags().StringVar(&req.Password, "password", "", "password of the user, it has to follow the password policy of the instance")
+	cmd.Flags().UintVar(&teamID, "team", 0, "ID of the team the user joins")
+	cmd.Flags().BoolVar(&req.IsAdmin, "admin", false, "make the user an admin of the team")
+	for _, name := range []string{"first-name", "last-name", "email", "password"} {
+		_ = cmd.MarkFlagRequired(name)
+	}
+
+	return cmd
+}
+
+func newUsersRevokeSessionsCommand(opts *clientOptions) *cobra.Command {
+	return &cobra.Command{
+		Use:   "revoke-sessions USER_ID",
+		Short: "Sign the user out of every device",
+		Args:  exactArgs("USER_ID"),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := newClient(opts)
+			if err != nil {
+				return err
+			}
+
+			if err := c.do(http.MethodDelete, "/api/admin/users/"+url.PathEscape(args[0])+"/sessions", nil, nil); err != nil {
+				return err
+			}
+
+			fmt.Println("Signed out of every device")
+			return nil
+		},
+	}
+}
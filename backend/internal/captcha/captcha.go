@@ -0,0 +1,79 @@
+package captcha
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hopp-backend/internal/config"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	ProviderHCaptcha  = "hcaptcha"
+	ProviderTurnstile = "turnstile"
+)
+
+// ErrFailed is returned when the captcha token is missing or was rejected
+var ErrFailed = errors.New("captcha verification failed")
+
+var verifyURLs = map[string]string{
+	ProviderHCaptcha:  "https://api.hcaptcha.com/siteverify",
+	ProviderTurnstile: "https://challenges.cloudflare.com/turnstile/v0/siteverify",
+}
+
+var verifyClient = &http.Client{Timeout: 5 * time.Second}
+
+// Enabled reports whether requests need to pass a captcha.
+// Verification is skipped when no provider is configured and in debug mode.
+func Enabled(cfg *config.Config) bool {
+	return cfg.Captcha.Provider != "" && !cfg.Server.Debug
+}
+
+// Verify checks the captcha token sent by the client with the configured provider.
+// Both hCaptcha and Turnstile share the same siteverify API.
+func Verify(token, remoteIP string, cfg *config.Config) error {
+	if !Enabled(cfg) {
+		return nil
+	}
+
+	if token == "" {
+		return ErrFailed
+	}
+
+	verifyURL, ok := verifyURLs[cfg.Captcha.Provider]
+	if !ok {
+		return fmt.Errorf("unsupported captcha provider: %s", cfg.Captcha.Provider)
+	}
+
+	form := url.Values{
+		"secret":   {cfg.Captcha.SecretKey},
+		"response": {token},
+		"remoteip": {remoteIP},
+	}
+
+	resp, err := verifyClient.PostForm(verifyURL, form)
+	if err != nil {
+		return fmt.Errorf("verifying captcha: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("captcha provider returned status %d", resp.StatusCode)
+	}
+
+	var result struct {
+		Success    bool     `json:"success"`
+		ErrorCodes []string `json:"error-codes"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("decoding captcha response: %w", err)
+	}
+
+	if !result.Success {
+		return ErrFailed
+	}
+
+	return nil
+}
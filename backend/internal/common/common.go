@@ -1,8 +1,12 @@
 package common
 
 import (
+	"context"
 	"hopp-backend/internal/config"
 	"hopp-backend/internal/email"
+	"hopp-backend/internal/notifications"
+	"hopp-backend/internal/storage"
+	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/labstack/echo/v4"
@@ -11,20 +15,48 @@ import (
 	"gorm.io/gorm"
 )
 
+// APIKeyEmailContextKey is set on the request context with the owner's email
+// when the request was authenticated with a personal access token
+const APIKeyEmailContextKey = "api_key_email"
+
 type JwtCustomClaims struct {
 	Email string `json:"email"`
+	// Impersonator is the email of the support admin acting as the user,
+	// empty for regular tokens
+	Impersonator string `json:"impersonator,omitempty"`
 	jwt.RegisteredClaims
 }
 
 type JwtAuth struct {
 	Secret string
 	Claims JwtCustomClaims
+	// SigningMethod is HS256 with Secret by default, or RS256/EdDSA
+	// when an asymmetric key is configured
+	SigningMethod jwt.SigningMethod
+	SigningKey    interface{}
+	VerifyKey     interface{}
+	KeyID         string
+	// Redis is used to keep the denylist of revoked tokens
+	Redis *redis.Client
+	// AllowQueryToken accepts tokens in the token query parameter, a deprecated fallback
+	AllowQueryToken bool
+}
+
+// IssuedToken is a signed JWT along with the claims needed to track it
+type IssuedToken struct {
+	Token     string
+	ID        string
+	ExpiresAt time.Time
 }
 
 type JWTIssuer interface {
 	GenerateToken(email string) (string, error)
+	IssueToken(email string) (IssuedToken, error)
+	IssueImpersonationToken(email, impersonator string, ttl time.Duration) (IssuedToken, error)
 	Middleware() echo.MiddlewareFunc
 	GetUserEmail(c echo.Context) (string, error)
+	RevokeToken(c echo.Context) error
+	RevokeTokenID(ctx context.Context, jti string, expiresAt time.Time) error
 }
 
 type AuthHandler interface {
@@ -48,4 +80,8 @@ type ServerState struct {
 	JwtIssuer   JWTIssuer
 	Redis       *redis.Client
 	EmailClient email.EmailClient
+	Storage     storage.Storage
+	Notifier    notifications.Notifier
+	// PrivateStorage holds the files shared through presigned URLs only
+	PrivateStorage storage.Storage
 }
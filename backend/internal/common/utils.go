@@ -7,3 +7,185 @@ import (
 func GetUserChannel(id string) string {
 	return fmt.Sprintf("channel-user-%s", id)
 }
+
+// GetRevokedTokenKey returns the Redis key used to denylist a JWT by its JTI
+func GetRevokedTokenKey(jti string) string {
+	return fmt.Sprintf("revoked-token-%s", jti)
+}
+
+// GetDeviceCodeKey returns the Redis key of a pending device authorization grant
+func GetDeviceCodeKey(deviceCode string) string {
+	return fmt.Sprintf("device-code-%s", deviceCode)
+}
+
+// GetDeviceUserCodeKey returns the Redis key mapping a user code to its device code
+func GetDeviceUserCodeKey(userCode string) string {
+	return fmt.Sprintf("device-user-code-%s", userCode)
+}
+
+// GetDevicePollKey returns the Redis key used to throttle device token polling
+func GetDevicePollKey(deviceCode string) string {
+	return fmt.Sprintf("device-poll-%s", deviceCode)
+}
+
+// GetSignInFailuresKey returns the Redis key counting failed sign-ins for
+// a subject, where the subject is either an IP or an account email
+func GetSignInFailuresKey(subject string) string {
+	return fmt.Sprintf("sign-in-failures-%s", subject)
+}
+
+// GetSignInLockoutKey returns the Redis key that exists while a subject is locked out
+func GetSignInLockoutKey(subject string) string {
+	return fmt.Sprintf("sign-in-lockout-%s", subject)
+}
+
+// GetIdentityLinkKey returns the Redis key mapping a one-time link token
+// to the user that is linking a new OAuth provider
+func GetIdentityLinkKey(token string) string {
+	return fmt.Sprintf("identity-link-%s", token)
+}
+
+// GetCallStateKey returns the Redis hash that holds the state of a call
+func GetCallStateKey(callID string) string {
+	return fmt.Sprintf("call-state-%s", callID)
+}
+
+// GetLatestCallKey returns the Redis key that holds the ID of the latest
+// call from the caller to the callee
+func GetLatestCallKey(callerID, calleeID string) string {
+	return fmt.Sprintf("latest-call-%s-%s", callerID, calleeID)
+}
+
+// GetInCallKey returns the Redis key that holds the room of the call
+// the user is currently in
+func GetInCallKey(userID string) string {
+	return fmt.Sprintf("in-call-%s", userID)
+}
+
+// GetStaleCallKey returns the Redis key that marks a call whose participants
+// were found disconnected by the previous stale call sweep
+func GetStaleCallKey(callID uint) string {
+	return fmt.Sprintf("stale-call-%d", callID)
+}
+
+// GetCallReactionRateKey returns the Redis key counting the user's recent call reactions
+func GetCallReactionRateKey(userID string) string {
+	return fmt.Sprintf("call-reaction-rate-%s", userID)
+}
+
+// GetAnnotationSequenceKey returns the Redis counter ordering the annotation messages of a call room
+func GetAnnotationSequenceKey(roomName string) string {
+	return fmt.Sprintf("annotation-sequence-%s", roomName)
+}
+
+// GetRingGroupKey returns the Redis set of the calls that ring together
+func GetRingGroupKey(ringGroupID string) string {
+	return fmt.Sprintf("ring-group-%s", ringGroupID)
+}
+
+// GetPresenceKey returns the Redis sorted set of the user's live connections
+func GetPresenceKey(userID string) string {
+	return fmt.Sprintf("presence-%s", userID)
+}
+
+// GetPresenceStatusKey returns the Redis hash of the status the user set
+func GetPresenceStatusKey(userID string) string {
+	return fmt.Sprintf("presence-status-%s", userID)
+}
+
+// GetUserSequenceKey returns the Redis counter that numbers the messages published to the user
+func GetUserSequenceKey(userID string) string {
+	return fmt.Sprintf("user-seq-%s", userID)
+}
+
+// GetUserReplayKey returns the Redis sorted set of the latest messages published
+// to the user, scored by their sequence number
+func GetUserReplayKey(userID string) string {
+	return fmt.Sprintf("user-replay-%s", userID)
+}
+
+// GetOfflineQueueKey returns the Redis list of the messages published while the user was offline
+func GetOfflineQueueKey(userID string) string {
+	return fmt.Sprintf("offline-queue-%s", userID)
+}
+
+// GetUserDeviceChannel returns the Redis channel of one device of the user,
+// for messages that only the device should get
+func GetUserDeviceChannel(userID, deviceID string) string {
+	return fmt.Sprintf("channel-user-%s-device-%s", userID, deviceID)
+}
+
+// GetTeamChannel returns the Redis channel of the team, for messages
+// that every connected member of the team gets
+func GetTeamChannel(teamID uint) string {
+	return fmt.Sprintf("channel-team-%d", teamID)
+}
+
+// GetUserDevicesKey returns the Redis sorted set of the user's connected devices
+func GetUserDevicesKey(userID string) string {
+	return fmt.Sprintf("user-devices-%s", userID)
+}
+
+// GetDeviceOwnerKey returns the Redis key of the connection that owns the device of the user,
+// the latest one the device opened
+func GetDeviceOwnerKey(userID, deviceID string) string {
+	return fmt.Sprintf("device-owner-%s-%s", userID, deviceID)
+}
+
+// GetPresenceActivityKey returns the Redis key of the session activity of the user, e.g. sharing their screen
+func GetPresenceActivityKey(userID string) string {
+	return fmt.Sprintf("presence-activity-%s", userID)
+}
+
+// GetUnreadMessagesKey returns the Redis hash of the unread direct messages of the user, per sender
+func GetUnreadMessagesKey(userID string) string {
+	return fmt.Sprintf("unread-messages-%s", userID)
+}
+
+// GetUnreadMissedCallsKey returns the Redis hash of the missed calls the user didn't clear, per caller
+func GetUnreadMissedCallsKey(userID string) string {
+	return fmt.Sprintf("unread-missed-calls-%s", userID)
+}
+
+// GetLinkPreviewKey returns the Redis key of the cached preview of a link, by the hash of the link
+func GetLinkPreviewKey(linkHash string) string {
+	return fmt.Sprintf("link-preview-%s", linkHash)
+}
+
+// GetSIPDialInKey returns the Redis hash that holds the phone dial-in of a call room
+func GetSIPDialInKey(roomName string) string {
+	return fmt.Sprintf("sip-dial-in-%s", roomName)
+}
+
+// GetSIPParticipantsKey returns the Redis set of the phone participants in a call room
+func GetSIPParticipantsKey(roomName string) string {
+	return fmt.Sprintf("sip-participants-%s", roomName)
+}
+
+// GetDoNotDisturbKey returns the Redis key that exists while the user is in
+// do not disturb mode, it expires along with the mode
+func GetDoNotDisturbKey(userID string) string {
+	return fmt.Sprintf("do-not-disturb-%s", userID)
+}
+
+// GetGroupCallParticipantsKey returns the Redis set of the users in a group call
+func GetGroupCallParticipantsKey(roomName string) string {
+	return fmt.Sprintf("group-call-participants-%s", roomName)
+}
+
+// GetGroupCallInvitedKey returns the Redis set of the users invited to a
+// group call that haven't joined yet
+func GetGroupCallInvitedKey(roomName string) string {
+	return fmt.Sprintf("group-call-invited-%s", roomName)
+}
+
+// GetActiveUsersKey returns the Redis HyperLogLog of the users that were online on the UTC day
+func GetActiveUsersKey(day string) string {
+	return fmt.Sprintf("active-users-%s", day)
+}
+
+// GetFileTransfersKey returns the Redis sorted set of the uploaded file transfers,
+// scored by the Unix time their download URL expires at
+func GetFileTransfersKey() string {
+	return "file-transfers"
+}
@@ -3,6 +3,9 @@ package config
 import (
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -18,6 +21,14 @@ type Config struct {
 		}
 		DeployDomain string
 		Debug        bool
+		// Requests are only accepted from these CIDRs, e.g. office or VPN ranges,
+		// from anywhere if empty. Teams can further restrict their members.
+		AllowedCIDRs []string
+		// Requests from these CIDRs are refused, even if they are allowed
+		DeniedCIDRs []string
+		// CIDRs of the reverse proxies trusted to set X-Forwarded-For, the
+		// address of the connection is the client address if empty
+		TrustedProxyCIDRs []string
 	}
 	Auth struct {
 		GoogleKey      string
@@ -26,13 +37,88 @@ type Config struct {
 		SlackKey       string
 		SlackSecret    string
 		SlackRedirect  string
-		CallbackURL    string
-		SessionSecret  string
+		// How often the Slack workspace members of Slack users are refreshed, disabled if zero
+		SlackSyncInterval time.Duration
+		// Microsoft Entra ID (Azure AD) login
+		MicrosoftKey      string
+		MicrosoftSecret   string
+		MicrosoftTenant   string
+		MicrosoftRedirect string
+		CallbackURL       string
+		SessionSecret     string
+		// Secret the OAuth tokens of the linked identities are encrypted with, defaults to SessionSecret
+		TokenEncryptionKey string
+		// Emails of the support admins allowed to impersonate users
+		SupportAdminEmails []string
+		// Restricts registration to these email domains, anyone can register if empty
+		AllowedEmailDomains []string
+		// Only users with a team invitation link or an email invitation can sign up
+		InviteOnly bool
+		// Generic OpenID Connect provider for self-hosted deployments
+		// (Keycloak, Authentik, Okta etc.)
+		OIDC struct {
+			Name         string
+			DiscoveryURL string
+			ClientID     string
+			ClientSecret string
+			Scopes       []string
+			Redirect     string
+		}
+	}
+	JWT struct {
+		// One of HS256 (signed with the session secret), RS256 or EdDSA
+		Algorithm string
+		// PEM encoded private key, required for RS256 and EdDSA
+		PrivateKeyFile string
+		// Key ID advertised in the JWKS, derived from the public key if empty
+		KeyID string
+		// Accept tokens in the token query parameter, deprecated since the
+		// URL ends up in logs and proxies, off unless turned on
+		AllowQueryToken bool
+	}
+	Password struct {
+		MinLength     int
+		RequireUpper  bool
+		RequireLower  bool
+		RequireDigit  bool
+		RequireSymbol bool
+		// Check passwords against the HaveIBeenPwned breached passwords list
+		CheckBreached bool
+	}
+	// Captcha protects public endpoints from bots, disabled when Provider is empty
+	Captcha struct {
+		// One of hcaptcha or turnstile
+		Provider  string
+		SecretKey string
+	}
+	Calls struct {
+		// How long a call rings before it counts as missed
+		RingTimeout time.Duration
+		// Email callees about calls they missed while offline
+		MissedCallEmails bool
+		// How often calls left behind by disconnected participants are cleaned up, disabled if zero
+		StaleCallSweepInterval time.Duration
+	}
+	WebSocket struct {
+		// Negotiate permessage-deflate with the clients that support it
+		Compression bool
+		// Flate compression level, from 1 (fastest) to 9 (smallest)
+		CompressionLevel int
+		// Messages smaller than this many bytes are sent uncompressed
+		CompressionThreshold int
+		// Live connections a user, or all the members of a team together, can
+		// have across the servers, unlimited if zero
+		MaxConnectionsPerUser int
+		MaxConnectionsPerTeam int
 	}
 	Livekit struct {
 		APIKey    string
 		Secret    string
 		ServerURL string
+		// Phone number routed to the LiveKit SIP trunk, phone dial-in is disabled if empty
+		SIPDialInNumber string
+		// LiveKit SIP inbound trunk of the dial-in number, any trunk if empty
+		SIPTrunkID string
 	}
 	Database struct {
 		DSN      string
@@ -41,14 +127,66 @@ type Config struct {
 	Telegram struct {
 		BotToken string
 		ChatID   string
+		// How often the info events are posted as a summary, they are posted one by one if zero
+		BatchInterval time.Duration
+	}
+	// Slack incoming webhooks of the ops notifications, each posts to its own channel.
+	// The events of a category without a webhook go to the default one, if any.
+	SlackNotifications struct {
+		WebhookURL        string
+		SignupsWebhookURL string
+		SigninsWebhookURL string
+		CallsWebhookURL   string
+	}
+	// Discord webhook of the ops notifications
+	DiscordNotifications struct {
+		WebhookURL string
+	}
+	// PagerDuty service the ops notifications trigger incidents on
+	PagerDutyNotifications struct {
+		RoutingKey string
+	}
+	// Opsgenie integration the ops notifications create alerts with
+	OpsgenieNotifications struct {
+		APIKey string
+		// The API of the US region by default, EU accounts use https://api.eu.opsgenie.com
+		APIURL string
+	}
+	Notifications struct {
+		// Rules of the sinks each severity goes to, like "critical=telegram,pagerduty;info=slack".
+		// Every event goes to every sink when empty.
+		Routes string
+		// JSON file of the templates of the messages by event kind, see notifications.LoadTemplates
+		TemplatesFile string
+		// Name of the deployment passed to the templates, like "production"
+		Environment string
 	}
 	Resend struct {
 		APIKey        string
 		DefaultSender string
+		// How often the email queue is checked for retries, new emails are sent right away
+		QueueInterval time.Duration
+		// Signing secret of the Resend webhook endpoint, delivery events are ignored if empty
+		WebhookSecret string
+		// Signs the unsubscribe links of the emails, the session secret is used if empty
+		UnsubscribeSecret string
 	}
 	Sentry struct {
 		DSN string
 	}
+	// S3 compatible object storage for uploads, disabled when Bucket is empty
+	Storage struct {
+		Endpoint  string
+		Region    string
+		Bucket    string
+		AccessKey string
+		SecretKey string
+		// Base URL the uploaded objects are served from, e.g. a CDN
+		PublicURL string
+		// Bucket of the files only shared through presigned URLs, like file transfers.
+		// It must not be publicly readable
+		PrivateBucket string
+	}
 }
 
 func Load() (*Config, error) {
@@ -88,6 +226,10 @@ func Load() (*Config, error) {
 
 	c.Server.Debug = os.Getenv("ENABLE_DEBUG_ENDPOINTS") == "true"
 
+	c.Server.AllowedCIDRs = splitList(os.Getenv("IP_ALLOWLIST"))
+	c.Server.DeniedCIDRs = splitList(os.Getenv("IP_DENYLIST"))
+	c.Server.TrustedProxyCIDRs = splitList(os.Getenv("TRUSTED_PROXIES"))
+
 	// TLS Configuration
 	useTLS := os.Getenv("USE_TLS")
 	c.Server.TLS.Enabled = useTLS != "false" && useTLS != "0"
@@ -95,6 +237,10 @@ func Load() (*Config, error) {
 	c.Server.TLS.KeyFile = "./certs/localhost-key.pem"
 
 	c.Auth.SessionSecret = os.Getenv("SESSION_SECRET")
+	c.Auth.TokenEncryptionKey = os.Getenv("TOKEN_ENCRYPTION_KEY")
+	if c.Auth.TokenEncryptionKey == "" {
+		c.Auth.TokenEncryptionKey = c.Auth.SessionSecret
+	}
 
 	c.Auth.GoogleKey = os.Getenv("GOOGLE_KEY")
 	c.Auth.GoogleSecret = os.Getenv("GOOGLE_SECRET")
@@ -103,24 +249,171 @@ func Load() (*Config, error) {
 	c.Auth.SlackKey = os.Getenv("SLACK_KEY")
 	c.Auth.SlackSecret = os.Getenv("SLACK_SECRET")
 	c.Auth.SlackRedirect = fmt.Sprintf("https://%s/api/auth/social/slack/callback", c.Server.DeployDomain)
+	c.Auth.SlackSyncInterval = 24 * time.Hour
+	if interval, err := time.ParseDuration(os.Getenv("SLACK_SYNC_INTERVAL")); err == nil {
+		c.Auth.SlackSyncInterval = interval
+	}
+
+	c.Auth.MicrosoftKey = os.Getenv("MICROSOFT_KEY")
+	c.Auth.MicrosoftSecret = os.Getenv("MICROSOFT_SECRET")
+	// Can be "common", "organizations", "consumers" or a specific tenant ID/domain
+	c.Auth.MicrosoftTenant = os.Getenv("MICROSOFT_TENANT")
+	if c.Auth.MicrosoftTenant == "" {
+		c.Auth.MicrosoftTenant = "organizations"
+	}
+	c.Auth.MicrosoftRedirect = fmt.Sprintf("https://%s/api/auth/social/microsoft/callback", c.Server.DeployDomain)
+
+	// The name is used in the login URL: /api/auth/social/<name>
+	c.Auth.OIDC.Name = os.Getenv("OIDC_PROVIDER_NAME")
+	if c.Auth.OIDC.Name == "" {
+		c.Auth.OIDC.Name = "oidc"
+	}
+	c.Auth.OIDC.DiscoveryURL = os.Getenv("OIDC_DISCOVERY_URL")
+	c.Auth.OIDC.ClientID = os.Getenv("OIDC_CLIENT_ID")
+	c.Auth.OIDC.ClientSecret = os.Getenv("OIDC_CLIENT_SECRET")
+	c.Auth.OIDC.Scopes = []string{"openid", "email", "profile"}
+	if scopes := os.Getenv("OIDC_SCOPES"); scopes != "" {
+		c.Auth.OIDC.Scopes = strings.Split(scopes, ",")
+	}
+	c.Auth.OIDC.Redirect = fmt.Sprintf("https://%s/api/auth/social/%s/callback", c.Server.DeployDomain, c.Auth.OIDC.Name)
+
+	if emails := os.Getenv("SUPPORT_ADMIN_EMAILS"); emails != "" {
+		c.Auth.SupportAdminEmails = strings.Split(emails, ",")
+	}
+
+	c.Auth.InviteOnly = os.Getenv("INVITE_ONLY") == "true"
+
+	if domains := os.Getenv("ALLOWED_EMAIL_DOMAINS"); domains != "" {
+		for _, domain := range strings.Split(domains, ",") {
+			c.Auth.AllowedEmailDomains = append(c.Auth.AllowedEmailDomains, strings.ToLower(strings.TrimSpace(domain)))
+		}
+	}
+
+	c.Captcha.Provider = os.Getenv("CAPTCHA_PROVIDER")
+	c.Captcha.SecretKey = os.Getenv("CAPTCHA_SECRET_KEY")
+
+	c.JWT.Algorithm = os.Getenv("JWT_ALGORITHM")
+	if c.JWT.Algorithm == "" {
+		c.JWT.Algorithm = "HS256"
+	}
+	c.JWT.PrivateKeyFile = os.Getenv("JWT_PRIVATE_KEY_FILE")
+	c.JWT.KeyID = os.Getenv("JWT_KEY_ID")
+	c.JWT.AllowQueryToken = os.Getenv("JWT_ALLOW_QUERY_TOKEN") == "true"
+
+	c.Password.MinLength = 8
+	if minLength, err := strconv.Atoi(os.Getenv("PASSWORD_MIN_LENGTH")); err == nil && minLength > 0 {
+		c.Password.MinLength = minLength
+	}
+	c.Password.RequireUpper = os.Getenv("PASSWORD_REQUIRE_UPPER") == "true"
+	c.Password.RequireLower = os.Getenv("PASSWORD_REQUIRE_LOWER") == "true"
+	c.Password.RequireDigit = os.Getenv("PASSWORD_REQUIRE_DIGIT") == "true"
+	c.Password.RequireSymbol = os.Getenv("PASSWORD_REQUIRE_SYMBOL") == "true"
+	c.Password.CheckBreached = os.Getenv("PASSWORD_CHECK_BREACHED") == "true"
 
 	c.Database.DSN = os.Getenv("DATABASE_DSN")
 	c.Database.RedisURI = os.Getenv("REDIS_URI")
 
+	c.Calls.RingTimeout = 45 * time.Second
+	if timeout, err := time.ParseDuration(os.Getenv("CALL_RING_TIMEOUT")); err == nil && timeout > 0 {
+		c.Calls.RingTimeout = timeout
+	}
+	c.Calls.MissedCallEmails = os.Getenv("MISSED_CALL_EMAILS") == "true"
+	c.Calls.StaleCallSweepInterval = time.Minute
+	if interval, err := time.ParseDuration(os.Getenv("STALE_CALL_SWEEP_INTERVAL")); err == nil {
+		c.Calls.StaleCallSweepInterval = interval
+	}
+
+	c.WebSocket.Compression = os.Getenv("WS_COMPRESSION") == "true"
+	c.WebSocket.CompressionLevel = 1
+	if level, err := strconv.Atoi(os.Getenv("WS_COMPRESSION_LEVEL")); err == nil && level >= 1 && level <= 9 {
+		c.WebSocket.CompressionLevel = level
+	}
+	c.WebSocket.CompressionThreshold = 512
+	if threshold, err := strconv.Atoi(os.Getenv("WS_COMPRESSION_THRESHOLD")); err == nil && threshold >= 0 {
+		c.WebSocket.CompressionThreshold = threshold
+	}
+	c.WebSocket.MaxConnectionsPerUser = 10
+	if limit, err := strconv.Atoi(os.Getenv("WS_MAX_CONNECTIONS_PER_USER")); err == nil && limit >= 0 {
+		c.WebSocket.MaxConnectionsPerUser = limit
+	}
+	if limit, err := strconv.Atoi(os.Getenv("WS_MAX_CONNECTIONS_PER_TEAM")); err == nil && limit >= 0 {
+		c.WebSocket.MaxConnectionsPerTeam = limit
+	}
+
 	c.Livekit.APIKey = os.Getenv("LIVEKIT_API_KEY")
 	c.Livekit.Secret = os.Getenv("LIVEKIT_API_SECRET")
 	c.Livekit.ServerURL = os.Getenv("LIVEKIT_SERVER_URL")
+	c.Livekit.SIPDialInNumber = os.Getenv("LIVEKIT_SIP_DIAL_IN_NUMBER")
+	c.Livekit.SIPTrunkID = os.Getenv("LIVEKIT_SIP_TRUNK_ID")
 
 	c.Telegram.BotToken = os.Getenv("TELEGRAM_BOT_TOKEN")
 	c.Telegram.ChatID = os.Getenv("TELEGRAM_CHAT_ID")
+	c.Telegram.BatchInterval = 5 * time.Minute
+	if interval, err := time.ParseDuration(os.Getenv("TELEGRAM_BATCH_INTERVAL")); err == nil && interval >= 0 {
+		c.Telegram.BatchInterval = interval
+	}
+
+	c.SlackNotifications.WebhookURL = os.Getenv("SLACK_NOTIFICATIONS_WEBHOOK_URL")
+	c.SlackNotifications.SignupsWebhookURL = os.Getenv("SLACK_NOTIFICATIONS_SIGNUPS_WEBHOOK_URL")
+	c.SlackNotifications.SigninsWebhookURL = os.Getenv("SLACK_NOTIFICATIONS_SIGNINS_WEBHOOK_URL")
+	c.SlackNotifications.CallsWebhookURL = os.Getenv("SLACK_NOTIFICATIONS_CALLS_WEBHOOK_URL")
+
+	c.DiscordNotifications.WebhookURL = os.Getenv("DISCORD_NOTIFICATIONS_WEBHOOK_URL")
+	c.PagerDutyNotifications.RoutingKey = os.Getenv("PAGERDUTY_ROUTING_KEY")
+	c.OpsgenieNotifications.APIKey = os.Getenv("OPSGENIE_API_KEY")
+	c.OpsgenieNotifications.APIURL = os.Getenv("OPSGENIE_API_URL")
+	if c.OpsgenieNotifications.APIURL == "" {
+		c.OpsgenieNotifications.APIURL = "https://api.opsgenie.com"
+	}
+	c.Notifications.Routes = os.Getenv("NOTIFICATION_ROUTES")
+	c.Notifications.TemplatesFile = os.Getenv("NOTIFICATION_TEMPLATES_FILE")
+	c.Notifications.Environment = os.Getenv("NOTIFICATION_ENVIRONMENT")
+	if c.Notifications.Environment == "" {
+		c.Notifications.Environment = envStack
+	}
 
 	c.Resend.APIKey = os.Getenv("RESEND_API_KEY")
 	c.Resend.DefaultSender = os.Getenv("RESEND_DEFAULT_SENDER")
 	if c.Resend.DefaultSender == "" {
 		c.Resend.DefaultSender = "noreply@gethopp.app"
 	}
+	c.Resend.WebhookSecret = os.Getenv("RESEND_WEBHOOK_SECRET")
+	c.Resend.UnsubscribeSecret = os.Getenv("EMAIL_UNSUBSCRIBE_SECRET")
+	if c.Resend.UnsubscribeSecret == "" {
+		c.Resend.UnsubscribeSecret = c.Auth.SessionSecret
+	}
+	c.Resend.QueueInterval = 10 * time.Second
+	if interval, err := time.ParseDuration(os.Getenv("EMAIL_QUEUE_INTERVAL")); err == nil && interval > 0 {
+		c.Resend.QueueInterval = interval
+	}
 
 	c.Sentry.DSN = os.Getenv("SENTRY_DSN")
 
+	c.Storage.Endpoint = os.Getenv("STORAGE_ENDPOINT")
+	if c.Storage.Endpoint == "" {
+		c.Storage.Endpoint = "https://s3.amazonaws.com"
+	}
+	c.Storage.Region = os.Getenv("STORAGE_REGION")
+	if c.Storage.Region == "" {
+		c.Storage.Region = "us-east-1"
+	}
+	c.Storage.Bucket = os.Getenv("STORAGE_BUCKET")
+	c.Storage.PrivateBucket = os.Getenv("STORAGE_PRIVATE_BUCKET")
+	c.Storage.AccessKey = os.Getenv("STORAGE_ACCESS_KEY")
+	c.Storage.SecretKey = os.Getenv("STORAGE_SECRET_KEY")
+	c.Storage.PublicURL = os.Getenv("STORAGE_PUBLIC_URL")
+
 	return c, nil
 }
+
+// splitList splits a comma separated list, without blank entries
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
@@ -1,67 +1,125 @@
 package email
 
 import (
+	"errors"
 	"fmt"
 	"hopp-backend/internal/models"
-	"os"
-	"strings"
+	"time"
 
 	"github.com/labstack/echo/v4"
 	resend "github.com/resend/resend-go/v2"
+	"gorm.io/gorm"
 )
 
 // EmailClient is an interface for sending emails
 type EmailClient interface {
-	SendAsync(toEmail, subject, htmlBody string)
+	SendAsync(toEmail string, message *Message)
 	SendWelcomeEmail(user *models.User)
-	SendTeamInvitationEmail(inviterName, teamName, inviteLink, toEmail string)
+	SendTeamInvitationEmail(inviterName, teamName, inviteLink, toEmail, locale string) uint
+	SendEmailChangeConfirmation(user *models.User, newEmail, confirmLink, toEmail string)
+	SendNewDeviceAlert(user *models.User, device, ipAddress string, signedInAt time.Time, revokeLink string)
+	SendJoinRequestEmail(admin, requester *models.User, teamName, reviewLink string)
+	SendMissedCallEmail(callee, caller *models.User, missedAt time.Time, appLink string)
+	SendTestEmail(toEmail, locale, templateName string) (string, error)
 }
 
 // ResendEmailClient implements EmailClient using the Resend service
 type ResendEmailClient struct {
 	client        *resend.Client
 	defaultSender string
-	logger        echo.Logger
+	templates     *Templates
+	// Signs the unsubscribe links of the non-essential emails, they have none when nil
+	unsubscribeLinks *UnsubscribeLinks
+	// Emails are queued in the database and sent by the queue worker
+	db     *gorm.DB
+	wake   chan struct{}
+	logger echo.Logger
 }
 
 // NewResendEmailClient creates a new ResendEmailClient
-func NewResendEmailClient(client *resend.Client, defaultSender string, logger echo.Logger) *ResendEmailClient {
+func NewResendEmailClient(client *resend.Client, defaultSender string, templates *Templates, unsubscribeLinks *UnsubscribeLinks, db *gorm.DB, logger echo.Logger) *ResendEmailClient {
 	return &ResendEmailClient{
-		client:        client,
-		defaultSender: defaultSender,
-		logger:        logger,
+		client:           client,
+		defaultSender:    defaultSender,
+		templates:        templates,
+		unsubscribeLinks: unsubscribeLinks,
+		db:               db,
+		wake:             make(chan struct{}, 1),
+		logger:           logger,
 	}
 }
 
-// SendAsync sends an email asynchronously
-func (c *ResendEmailClient) SendAsync(toEmail, subject, htmlBody string) {
+// SendAsync queues an email, the queue worker sends it and retries it until Resend accepts it
+func (c *ResendEmailClient) SendAsync(toEmail string, message *Message) {
+	c.enqueue(toEmail, message)
+}
+
+// enqueue queues the email unless the address is suppressed, or unsubscribed from the
+// category of the email, and returns the ID of the queued email, 0 if it wasn't queued
+func (c *ResendEmailClient) enqueue(toEmail string, message *Message) uint {
 	if c == nil || c.client == nil {
 		fmt.Println("Resend client not initialized, skipping email.")
-		return
+		return 0
 	}
 
 	if c.defaultSender == "" {
 		c.logger.Errorf("Resend default sender not configured, skipping email.")
-		return
+		return 0
+	}
+
+	suppressed, err := models.IsEmailSuppressed(c.db, toEmail, message.Category)
+	if err != nil {
+		c.logger.Errorf("Failed to check email suppression of %s: %v", toEmail, err)
+		return 0
+	}
+	if suppressed {
+		c.logger.Infof("Skipping email to suppressed address %s (Subject: %s)", toEmail, message.Subject)
+		return 0
+	}
+
+	outboundEmail, err := models.EnqueueEmail(c.db, toEmail, message.Subject, message.HTML, message.Text, message.UnsubscribeURL)
+	if err != nil {
+		c.logger.Errorf("Failed to queue email to %s (Subject: %s): %v", toEmail, message.Subject, err)
+		return 0
+	}
+
+	c.wakeWorker()
+
+	return outboundEmail.ID
+}
+
+// send renders the email of the data in the locale and queues it, see enqueue
+func (c *ResendEmailClient) send(toEmail, locale string, data TemplateData) uint {
+	if c == nil || c.client == nil {
+		fmt.Println("Resend client not initialized, skipping email.")
+		return 0
 	}
 
-	go func() {
-		params := &resend.SendEmailRequest{
-			From:    c.defaultSender,
-			To:      []string{toEmail},
-			Subject: subject,
-			Html:    htmlBody,
-		}
-
-		_, err := c.client.Emails.Send(params)
-		if err != nil {
-			// Replace with proper logging
-			c.logger.Errorf("Failed to send email to %s (Subject: %s): %v\n", toEmail, subject, err)
-		} else {
-			// Replace with proper logging
-			c.logger.Infof("Email sent successfully to %s (Subject: %s)\n", toEmail, subject)
-		}
-	}()
+	message, err := c.render(toEmail, locale, data)
+	if err != nil {
+		c.logger.Errorf("Failed to render email: %v", err)
+		return 0
+	}
+
+	return c.enqueue(toEmail, message)
+}
+
+// render renders the email of the data in the locale, with the unsubscribe link
+// of the address when users can unsubscribe from the email
+func (c *ResendEmailClient) render(toEmail, locale string, data TemplateData) (*Message, error) {
+	var unsubscribeURL string
+	if target, ok := data.(interface{ setUnsubscribeURL(string) }); ok && c.unsubscribeLinks != nil {
+		unsubscribeURL = c.unsubscribeLinks.URL(toEmail, data.category())
+		target.setUnsubscribeURL(unsubscribeURL)
+	}
+
+	message, err := c.templates.Render(locale, data)
+	if err != nil {
+		return nil, err
+	}
+	message.UnsubscribeURL = unsubscribeURL
+
+	return message, nil
 }
 
 // SendWelcomeEmail sends a welcome email to a new user
@@ -71,39 +129,97 @@ func (c *ResendEmailClient) SendWelcomeEmail(user *models.User) {
 		return
 	}
 
-	// Read the template file
-	templateBytes, err := os.ReadFile("web/emails/hopp-welcome.html")
-	if err != nil {
-		c.logger.Errorf("Failed to read welcome email template: %v", err)
-		return
+	c.send(user.Email, user.Locale, &WelcomeData{FirstName: user.FirstName})
+}
+
+// SendTeamInvitationEmail sends an invitation email to join a team, and returns the
+// ID of the queued email to follow its delivery, 0 if it wasn't queued. The invitee
+// has no account yet, so the email is in the locale of the inviter.
+func (c *ResendEmailClient) SendTeamInvitationEmail(inviterName, teamName, inviteLink, toEmail, locale string) uint {
+	return c.send(toEmail, locale, TeamInvitationData{
+		InviterName: inviterName,
+		TeamName:    teamName,
+		InviteURL:   inviteLink,
+	})
+}
+
+// SendEmailChangeConfirmation sends the confirmation link of an email change
+// to either the current or the new address of the user
+func (c *ResendEmailClient) SendEmailChangeConfirmation(user *models.User, newEmail, confirmLink, toEmail string) {
+	message := "Confirm that you own this address to start using it with Hopp."
+	if toEmail == user.Email {
+		message = "If you didn't request this change, ignore this email and your email will stay the same."
 	}
 
-	htmlBody := strings.Replace(string(templateBytes), "{first_name}", user.FirstName, -1)
-	subject := "Welcome to Hopp " + user.FirstName
+	c.send(toEmail, user.Locale, EmailChangeData{
+		FirstName:  user.FirstName,
+		NewEmail:   newEmail,
+		Message:    message,
+		ConfirmURL: confirmLink,
+	})
+}
 
-	c.SendAsync(user.Email, subject, htmlBody)
+// SendNewDeviceAlert lets the user know their account was signed in from
+// a device that wasn't seen before, with a link to sign that device out
+func (c *ResendEmailClient) SendNewDeviceAlert(user *models.User, device, ipAddress string, signedInAt time.Time, revokeLink string) {
+	c.send(user.Email, user.Locale, NewDeviceData{
+		FirstName: user.FirstName,
+		Device:    device,
+		IPAddress: ipAddress,
+		Time:      signedInAt,
+		RevokeURL: revokeLink,
+	})
 }
 
-// SendTeamInvitationEmail sends an invitation email to join a team
-func (c *ResendEmailClient) SendTeamInvitationEmail(inviterName, teamName, inviteLink, toEmail string) {
+// SendJoinRequestEmail lets a team admin know that a user asked to join their team
+func (c *ResendEmailClient) SendJoinRequestEmail(admin, requester *models.User, teamName, reviewLink string) {
+	c.send(admin.Email, admin.Locale, &JoinRequestData{
+		FirstName:      admin.FirstName,
+		RequesterName:  requester.GetDisplayName(),
+		RequesterEmail: requester.Email,
+		TeamName:       teamName,
+		ReviewURL:      reviewLink,
+	})
+}
+
+// SendMissedCallEmail lets the callee know they missed a call while offline
+func (c *ResendEmailClient) SendMissedCallEmail(callee, caller *models.User, missedAt time.Time, appLink string) {
+	c.send(callee.Email, callee.Locale, &MissedCallData{
+		FirstName:  callee.FirstName,
+		CallerName: caller.GetDisplayName(),
+		Time:       missedAt,
+		AppURL:     appLink,
+	})
+}
+
+// SendTestEmail renders the template with sample data and sends it right away, skipping
+// the queue and the suppressions, so a failure to send is returned to the caller.
+// It returns the ID of the email at Resend.
+func (c *ResendEmailClient) SendTestEmail(toEmail, locale, templateName string) (string, error) {
 	if c == nil || c.client == nil {
-		fmt.Println("Resend client not initialized, skipping email.")
-		return
+		return "", errors.New("resend client not initialized")
 	}
 
-	// Read the template file
-	templateBytes, err := os.ReadFile("web/emails/hopp-invite-teammate.html")
-	if err != nil {
-		c.logger.Errorf("Failed to read team invitation email template: %v", err)
-		return
+	data, ok := SampleData(templateName)
+	if !ok {
+		return "", fmt.Errorf("unknown email template %s", templateName)
 	}
 
-	htmlBody := string(templateBytes)
-	htmlBody = strings.Replace(htmlBody, "{inviter_name}", inviterName, -1)
-	htmlBody = strings.Replace(htmlBody, "{team_name}", teamName, -1)
-	htmlBody = strings.Replace(htmlBody, "{invite_url}", inviteLink, -1)
+	message, err := c.render(toEmail, locale, data)
+	if err != nil {
+		return "", fmt.Errorf("rendering test email: %w", err)
+	}
 
-	subject := fmt.Sprintf("%s has invited you to join %s team - join the team", inviterName, teamName)
+	sent, err := c.client.Emails.Send(c.sendRequest(&models.OutboundEmail{
+		ToEmail:        toEmail,
+		Subject:        "[Test] " + message.Subject,
+		HTML:           message.HTML,
+		Text:           message.Text,
+		UnsubscribeURL: message.UnsubscribeURL,
+	}))
+	if err != nil {
+		return "", err
+	}
 
-	c.SendAsync(toEmail, subject, htmlBody)
+	return sent.Id, nil
 }
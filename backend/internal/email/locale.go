@@ -0,0 +1,35 @@
+package email
+
+import "strings"
+
+// DefaultLocale is the locale of the emails of users without one, and of the
+// emails that aren't translated to the locale of the user
+const DefaultLocale = "en"
+
+// SupportedLocales are the locales the emails are translated to
+var SupportedLocales = []string{"en", "el"}
+
+// ParseLocale maps a locale like "el-GR" or "el_GR" to the supported locale of its
+// language, and reports whether there is one
+func ParseLocale(locale string) (string, bool) {
+	locale = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(locale), "_", "-"))
+
+	for _, candidate := range []string{locale, strings.SplitN(locale, "-", 2)[0]} {
+		for _, supported := range SupportedLocales {
+			if candidate == supported {
+				return supported, true
+			}
+		}
+	}
+
+	return "", false
+}
+
+// NormalizeLocale is ParseLocale falling back to the default locale
+func NormalizeLocale(locale string) string {
+	if parsed, ok := ParseLocale(locale); ok {
+		return parsed
+	}
+
+	return DefaultLocale
+}
@@ -0,0 +1,124 @@
+package email
+
+import (
+	"hopp-backend/internal/models"
+	"time"
+
+	resend "github.com/resend/resend-go/v2"
+)
+
+const (
+	// queueBatchSize is how many emails the worker sends at a time
+	queueBatchSize = 20
+	// queueLease is how long a claimed email is left to its worker before another tries it
+	queueLease = 2 * time.Minute
+	// maxSendAttempts is how many times an email is tried before it is dead-lettered
+	maxSendAttempts = 8
+	// minRetryBackoff and maxRetryBackoff bound the wait between the attempts,
+	// it doubles after every failure
+	minRetryBackoff = 30 * time.Second
+	maxRetryBackoff = time.Hour
+)
+
+// StartQueueWorker sends the queued emails, checking for due retries every interval.
+// New emails are sent right away, they wake the worker up.
+func (c *ResendEmailClient) StartQueueWorker(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			c.processQueue()
+
+			select {
+			case <-ticker.C:
+			case <-c.wake:
+			}
+		}
+	}()
+}
+
+// wakeWorker lets the worker know there is a new email, without waiting for it
+func (c *ResendEmailClient) wakeWorker() {
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+}
+
+// processQueue sends the due emails until there are none left
+func (c *ResendEmailClient) processQueue() {
+	for {
+		due, err := models.ClaimDueEmails(c.db, queueBatchSize, queueLease)
+		if err != nil {
+			c.logger.Errorf("Failed to claim queued emails: %v", err)
+			return
+		}
+
+		for i := range due {
+			c.deliver(&due[i])
+		}
+
+		if len(due) < queueBatchSize {
+			return
+		}
+	}
+}
+
+// deliver sends a queued email with Resend and records the outcome
+func (c *ResendEmailClient) deliver(outboundEmail *models.OutboundEmail) {
+	sent, sendErr := c.client.Emails.Send(c.sendRequest(outboundEmail))
+	if sendErr == nil {
+		if err := models.MarkEmailSent(c.db, outboundEmail, sent.Id); err != nil {
+			c.logger.Errorf("Failed to mark email %d as sent: %v", outboundEmail.ID, err)
+		}
+		c.logger.Infof("Email sent successfully to %s (Subject: %s)", outboundEmail.ToEmail, outboundEmail.Subject)
+		return
+	}
+
+	attempts := outboundEmail.Attempts + 1
+	var retryAt *time.Time
+	if attempts < maxSendAttempts {
+		next := time.Now().Add(retryBackoff(attempts))
+		retryAt = &next
+		c.logger.Warnf("Failed to send email %d to %s (attempt %d), retrying at %s: %v",
+			outboundEmail.ID, outboundEmail.ToEmail, attempts, next.Format(time.RFC3339), sendErr)
+	} else {
+		c.logger.Errorf("Giving up on email %d to %s (Subject: %s) after %d attempts: %v",
+			outboundEmail.ID, outboundEmail.ToEmail, outboundEmail.Subject, attempts, sendErr)
+	}
+
+	if err := models.MarkEmailFailed(c.db, outboundEmail, sendErr, retryAt); err != nil {
+		c.logger.Errorf("Failed to record failed email %d: %v", outboundEmail.ID, err)
+	}
+}
+
+// sendRequest is the request that sends the email with Resend
+func (c *ResendEmailClient) sendRequest(outboundEmail *models.OutboundEmail) *resend.SendEmailRequest {
+	params := &resend.SendEmailRequest{
+		From:    c.defaultSender,
+		To:      []string{outboundEmail.ToEmail},
+		Subject: outboundEmail.Subject,
+		Html:    outboundEmail.HTML,
+		Text:    outboundEmail.Text,
+	}
+	if outboundEmail.UnsubscribeURL != "" {
+		// One-click unsubscribe of RFC 8058, mail clients POST to the link
+		params.Headers = map[string]string{
+			"List-Unsubscribe":      "<" + outboundEmail.UnsubscribeURL + ">",
+			"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+		}
+	}
+
+	return params
+}
+
+// retryBackoff is the wait before the next attempt after the failed ones
+func retryBackoff(attempts int) time.Duration {
+	backoff := minRetryBackoff
+	for i := 1; i < attempts && backoff < maxRetryBackoff; i++ {
+		backoff *= 2
+	}
+
+	return min(backoff, maxRetryBackoff)
+}
@@ -0,0 +1,76 @@
+package email
+
+import (
+	"slices"
+	"time"
+)
+
+// sampleData creates the data of each email with placeholder values, to send test
+// emails of the templates without real users, teams or calls behind them
+var sampleData = map[string]func() TemplateData{
+	"hopp-welcome": func() TemplateData {
+		return &WelcomeData{FirstName: "Ada"}
+	},
+	"hopp-invite-teammate": func() TemplateData {
+		return TeamInvitationData{
+			InviterName: "Ada Lovelace",
+			TeamName:    "Analytical Engines",
+			InviteURL:   "https://gethopp.app/invitation/sample",
+		}
+	},
+	"hopp-email-change": func() TemplateData {
+		return EmailChangeData{
+			FirstName:  "Ada",
+			NewEmail:   "ada@example.com",
+			Message:    "Confirm that you own this address to start using it with Hopp.",
+			ConfirmURL: "https://gethopp.app/api/email-change/confirm?token=sample",
+		}
+	},
+	"hopp-new-device": func() TemplateData {
+		return NewDeviceData{
+			FirstName: "Ada",
+			Device:    "Hopp on macOS",
+			IPAddress: "203.0.113.7",
+			Time:      time.Now(),
+			RevokeURL: "https://gethopp.app/settings/sessions",
+		}
+	},
+	"hopp-join-request": func() TemplateData {
+		return &JoinRequestData{
+			FirstName:      "Ada",
+			RequesterName:  "Charles Babbage",
+			RequesterEmail: "charles@example.com",
+			TeamName:       "Analytical Engines",
+			ReviewURL:      "https://gethopp.app/settings/team",
+		}
+	},
+	"hopp-missed-call": func() TemplateData {
+		return &MissedCallData{
+			FirstName:  "Ada",
+			CallerName: "Charles Babbage",
+			Time:       time.Now(),
+			AppURL:     "https://gethopp.app",
+		}
+	},
+}
+
+// TemplateNames are the names of the email templates, the ones test emails can be sent of
+func TemplateNames() []string {
+	names := make([]string, 0, len(sampleData))
+	for name := range sampleData {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	return names
+}
+
+// SampleData returns the data of the email with placeholder values
+func SampleData(templateName string) (TemplateData, bool) {
+	newData, ok := sampleData[templateName]
+	if !ok {
+		return nil, false
+	}
+
+	return newData(), true
+}
@@ -0,0 +1,249 @@
+package email
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hopp-backend/internal/models"
+	htmltemplate "html/template"
+	"io/fs"
+	"os"
+	"path/filepath"
+	texttemplate "text/template"
+	"time"
+)
+
+// DefaultTemplatesDir is where the email templates are, relative to the working directory.
+// Every email has an HTML template, <name>.html, a plain-text one, <name>.txt, and
+// its subject in subjects.json. Translations are in a subdirectory per locale.
+const DefaultTemplatesDir = "web/emails"
+
+// Message is a rendered email, ready to send
+type Message struct {
+	Subject string
+	HTML    string
+	// Plain-text alternative of the HTML, for clients that don't show HTML
+	Text string
+	// Category of the email, users can unsubscribe from the non-essential ones
+	Category models.EmailCategory
+	// One-click unsubscribe link of the email, empty for essential emails
+	UnsubscribeURL string
+}
+
+// TemplateData is the data of an email kind, it knows its template and category
+type TemplateData interface {
+	templateName() string
+	category() models.EmailCategory
+}
+
+// unsubscribable is embedded in the data of the emails users can unsubscribe
+// from, the templates link to UnsubscribeURL
+type unsubscribable struct {
+	UnsubscribeURL string
+}
+
+func (u *unsubscribable) setUnsubscribeURL(unsubscribeURL string) { u.UnsubscribeURL = unsubscribeURL }
+
+// WelcomeData is the data of the welcome email of new users
+type WelcomeData struct {
+	unsubscribable
+	FirstName string
+}
+
+func (WelcomeData) templateName() string           { return "hopp-welcome" }
+func (WelcomeData) category() models.EmailCategory { return models.EmailCategoryProduct }
+
+// TeamInvitationData is the data of the invitation to join a team
+type TeamInvitationData struct {
+	InviterName string
+	TeamName    string
+	InviteURL   string
+}
+
+func (TeamInvitationData) templateName() string           { return "hopp-invite-teammate" }
+func (TeamInvitationData) category() models.EmailCategory { return models.EmailCategoryEssential }
+
+// EmailChangeData is the data of the confirmation of an email change, sent to both addresses
+type EmailChangeData struct {
+	FirstName  string
+	NewEmail   string
+	Message    string
+	ConfirmURL string
+}
+
+func (EmailChangeData) templateName() string           { return "hopp-email-change" }
+func (EmailChangeData) category() models.EmailCategory { return models.EmailCategoryEssential }
+
+// NewDeviceData is the data of the alert of a sign-in from a new device
+type NewDeviceData struct {
+	FirstName string
+	Device    string
+	IPAddress string
+	Time      time.Time
+	RevokeURL string
+}
+
+func (NewDeviceData) templateName() string           { return "hopp-new-device" }
+func (NewDeviceData) category() models.EmailCategory { return models.EmailCategoryEssential }
+
+// JoinRequestData is the data of the email that lets an admin know a user asked to join their team
+type JoinRequestData struct {
+	unsubscribable
+	FirstName      string
+	RequesterName  string
+	RequesterEmail string
+	TeamName       string
+	ReviewURL      string
+}
+
+func (JoinRequestData) templateName() string           { return "hopp-join-request" }
+func (JoinRequestData) category() models.EmailCategory { return models.EmailCategoryJoinRequests }
+
+// MissedCallData is the data of the email of a call the user missed while offline
+type MissedCallData struct {
+	unsubscribable
+	FirstName  string
+	CallerName string
+	Time       time.Time
+	AppURL     string
+}
+
+func (MissedCallData) templateName() string           { return "hopp-missed-call" }
+func (MissedCallData) category() models.EmailCategory { return models.EmailCategoryMissedCalls }
+
+// formatTime is how the emails show times
+func formatTime(t time.Time) string {
+	return t.UTC().Format("January 2, 2006 15:04 MST")
+}
+
+// localeTemplates are the parsed templates of one locale
+type localeTemplates struct {
+	html     *htmltemplate.Template
+	text     *texttemplate.Template
+	subjects map[string]*texttemplate.Template
+}
+
+// Templates are the parsed email templates of every locale, parsed once and shared by all the sends
+type Templates struct {
+	locales map[string]*localeTemplates
+}
+
+// LoadTemplates parses the templates of every supported locale in the directory. The
+// default locale is in the directory itself, the others in a subdirectory named after the locale.
+func LoadTemplates(dir string) (*Templates, error) {
+	templates := &Templates{locales: make(map[string]*localeTemplates)}
+
+	for _, locale := range SupportedLocales {
+		localeDir := dir
+		if locale != DefaultLocale {
+			localeDir = filepath.Join(dir, locale)
+		}
+
+		parsed, err := loadLocaleTemplates(localeDir)
+		if err != nil {
+			return nil, fmt.Errorf("loading %s email templates: %w", locale, err)
+		}
+		templates.locales[locale] = parsed
+	}
+
+	if len(templates.locales[DefaultLocale].subjects) == 0 {
+		return nil, fmt.Errorf("no email subjects in %s", dir)
+	}
+
+	return templates, nil
+}
+
+// loadLocaleTemplates parses the HTML, plain-text and subject templates in the directory.
+// A locale can translate only some of the emails, the rest are sent in the default locale.
+func loadLocaleTemplates(dir string) (*localeTemplates, error) {
+	parsed := &localeTemplates{
+		html: htmltemplate.New("emails").Funcs(htmltemplate.FuncMap{
+			"formatTime": formatTime,
+			// For the conditional comments of Outlook, html/template drops comments otherwise
+			"safeHTML": func(s string) htmltemplate.HTML { return htmltemplate.HTML(s) },
+		}),
+		text: texttemplate.New("emails").Funcs(texttemplate.FuncMap{
+			"formatTime": formatTime,
+		}),
+		subjects: make(map[string]*texttemplate.Template),
+	}
+
+	// ParseGlob fails when nothing matches, and a locale without translations yet is fine
+	if files, _ := filepath.Glob(filepath.Join(dir, "*.html")); len(files) > 0 {
+		if _, err := parsed.html.ParseFiles(files...); err != nil {
+			return nil, fmt.Errorf("parsing HTML email templates: %w", err)
+		}
+	}
+
+	if files, _ := filepath.Glob(filepath.Join(dir, "*.txt")); len(files) > 0 {
+		if _, err := parsed.text.ParseFiles(files...); err != nil {
+			return nil, fmt.Errorf("parsing text email templates: %w", err)
+		}
+	}
+
+	content, err := os.ReadFile(filepath.Join(dir, "subjects.json"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return parsed, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading email subjects: %w", err)
+	}
+
+	var subjects map[string]string
+	if err := json.Unmarshal(content, &subjects); err != nil {
+		return nil, fmt.Errorf("parsing email subjects: %w", err)
+	}
+
+	for name, subject := range subjects {
+		tmpl, err := texttemplate.New(name).Parse(subject)
+		if err != nil {
+			return nil, fmt.Errorf("parsing subject of %s: %w", name, err)
+		}
+		parsed.subjects[name] = tmpl
+	}
+
+	return parsed, nil
+}
+
+// has checks if the locale translates all the parts of the email
+func (l *localeTemplates) has(name string) bool {
+	return l.html.Lookup(name+".html") != nil && l.text.Lookup(name+".txt") != nil && l.subjects[name] != nil
+}
+
+// Render renders the email of the data in the locale, without sending it.
+// Emails the locale doesn't translate are rendered in the default locale.
+func (t *Templates) Render(locale string, data TemplateData) (*Message, error) {
+	name := data.templateName()
+
+	templates, ok := t.locales[NormalizeLocale(locale)]
+	if !ok || !templates.has(name) {
+		templates = t.locales[DefaultLocale]
+	}
+
+	subjectTemplate, ok := templates.subjects[name]
+	if !ok {
+		return nil, fmt.Errorf("no subject for %s", name)
+	}
+
+	var subject, html, text bytes.Buffer
+
+	if err := subjectTemplate.Execute(&subject, data); err != nil {
+		return nil, fmt.Errorf("rendering %s subject: %w", name, err)
+	}
+
+	if err := templates.html.ExecuteTemplate(&html, name+".html", data); err != nil {
+		return nil, fmt.Errorf("rendering %s HTML: %w", name, err)
+	}
+
+	if err := templates.text.ExecuteTemplate(&text, name+".txt", data); err != nil {
+		return nil, fmt.Errorf("rendering %s text: %w", name, err)
+	}
+
+	return &Message{
+		Subject:  subject.String(),
+		HTML:     html.String(),
+		Text:     text.String(),
+		Category: data.category(),
+	}, nil
+}
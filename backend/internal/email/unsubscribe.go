@@ -0,0 +1,78 @@
+package email
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"hopp-backend/internal/models"
+	"net/url"
+	"strings"
+)
+
+// UnsubscribePath is the path of the unsubscribe links of the emails
+const UnsubscribePath = "/api/email/unsubscribe"
+
+// ErrInvalidUnsubscribeToken is returned for tokens that weren't signed with the secret
+var ErrInvalidUnsubscribeToken = errors.New("invalid unsubscribe token")
+
+// UnsubscribeLinks signs and verifies the one-click unsubscribe links of the emails.
+// The token carries the address and the category, so the links keep working
+// without storing anything and without the user signing in.
+type UnsubscribeLinks struct {
+	baseURL string
+	secret  []byte
+}
+
+// NewUnsubscribeLinks creates the unsubscribe links of the domain, signed with the secret
+func NewUnsubscribeLinks(domain, secret string) *UnsubscribeLinks {
+	return &UnsubscribeLinks{
+		baseURL: "https://" + domain + UnsubscribePath,
+		secret:  []byte(secret),
+	}
+}
+
+// URL returns the link that unsubscribes the address from the category
+func (l *UnsubscribeLinks) URL(toEmail string, category models.EmailCategory) string {
+	return l.baseURL + "?token=" + url.QueryEscape(l.token(toEmail, category))
+}
+
+// token is "<payload>.<signature>", the payload is the address and the
+// category separated by a newline, both parts base64url encoded
+func (l *UnsubscribeLinks) token(toEmail string, category models.EmailCategory) string {
+	payload := []byte(strings.ToLower(toEmail) + "\n" + string(category))
+
+	return base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(l.sign(payload))
+}
+
+func (l *UnsubscribeLinks) sign(payload []byte) []byte {
+	mac := hmac.New(sha256.New, l.secret)
+	mac.Write(payload)
+
+	return mac.Sum(nil)
+}
+
+// Verify checks the signature of the token and returns the address and category it unsubscribes
+func (l *UnsubscribeLinks) Verify(token string) (string, models.EmailCategory, error) {
+	encodedPayload, encodedSignature, ok := strings.Cut(token, ".")
+	if !ok || len(l.secret) == 0 {
+		return "", "", ErrInvalidUnsubscribeToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		return "", "", ErrInvalidUnsubscribeToken
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(encodedSignature)
+	if err != nil || !hmac.Equal(signature, l.sign(payload)) {
+		return "", "", ErrInvalidUnsubscribeToken
+	}
+
+	toEmail, category, ok := strings.Cut(string(payload), "\n")
+	if !ok || !models.IsValidEmailCategory(models.EmailCategory(category)) {
+		return "", "", ErrInvalidUnsubscribeToken
+	}
+
+	return toEmail, models.EmailCategory(category), nil
+}
@@ -0,0 +1,417 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"hopp-backend/internal/models"
+	"hopp-backend/internal/password"
+	"hopp-backend/internal/presence"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// adminMaxPerPage caps the page size of the admin API, also used as the default
+const adminMaxPerPage = 100
+
+// adminUser is a user as the admin API lists them, with whether they are online
+type adminUser struct {
+	models.User
+	IsOnline bool `json:"is_online"`
+}
+
+// SuperAdminMiddleware only lets super admins that are also admins of their team
+// through, for operating the hosted service. API keys and impersonation tokens
+// are refused, an admin has to sign in as themselves.
+func (h *AuthHandler) SuperAdminMiddleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			admin, isAuthenticated := h.getAuthenticatedUserFromJWT(c)
+			if !isAuthenticated {
+				return echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
+			}
+
+			if claims, err := getClaims(c); err != nil || claims.Impersonator != "" {
+				return echo.NewHTTPError(http.StatusForbidden, "Forbidden")
+			}
+
+			if isAPIKeyRequest(c) || !admin.IsAdmin || !admin.IsSuperAdmin {
+				return echo.NewHTTPError(http.StatusForbidden, "Forbidden")
+			}
+
+			return next(c)
+		}
+	}
+}
+
+// AdminListUsers returns the page of all the users matching the search, newest
+// first. The total number of matching users is returned in the X-Total-Count header.
+func (h *AuthHandler) AdminListUsers(c echo.Context) error {
+	page, err := parsePositiveQueryParam(c, "page", 1)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid page")
+	}
+
+	perPage, err := parsePositiveQueryParam(c, "per_page", adminMaxPerPage)
+	if err != nil || perPage > adminMaxPerPage {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("per_page must be between 1 and %d", adminMaxPerPage))
+	}
+
+	users, total, err := models.SearchUsers(h.DB, models.UsersQuery{
+		Search: strings.TrimSpace(c.QueryParam("search")),
+		Limit:  perPage,
+		Offset: (page - 1) * perPage,
+	})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get users")
+	}
+
+	c.Response().Header().Set("X-Total-Count", strconv.FormatInt(total, 10))
+
+	return c.JSON(http.StatusOK, h.withOnlineState(c, users))
+}
+
+// AdminGetUser returns a user along with all the teams they are a member of
+func (h *AuthHandler) AdminGetUser(c echo.Context) error {
+	var user models.User
+	if err := h.DB.Preload("Team").Where("id = ?", c.Param("id")).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return echo.NewHTTPError(http.StatusNotFound, "User not found")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get user")
+	}
+
+	var memberships []models.TeamMembership
+	if err := h.DB.Preload("Team").Where("user_id = ?", user.ID).Find(&memberships).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get teams")
+	}
+
+	teams := make([]models.Team, len(memberships))
+	for i, membership := range memberships {
+		teams[i] = membership.Team
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"user":  user,
+		"teams": teams,
+	})
+}
+
+// AdminUserPresence returns whether the user is online and from which devices,
+// along with the status and the activity teammates see
+func (h *AuthHandler) AdminUserPresence(c echo.Context) error {
+	userID := c.Param("id")
+	if err := h.DB.Where("id = ?", userID).First(&models.User{}).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return echo.NewHTTPError(http.StatusNotFound, "User not found")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get user")
+	}
+
+	ctx := c.Request().Context()
+	online, err := presence.IsOnline(ctx, h.Redis, userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get presence")
+	}
+
+	devices, err := presence.Devices(ctx, h.Redis, userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get presence")
+	}
+
+	status, err := presence.GetStatus(ctx, h.Redis, userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get presence")
+	}
+
+	activities, err := presence.BulkGetActivity(ctx, h.Redis, []string{userID})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get presence")
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"is_online":   online,
+		"devices":     devices,
+		"status":      status.Status,
+		"status_text": status.Text,
+		"activity":    activities[userID],
+	})
+}
+
+// AdminGetTeam returns a team along with its members
+func (h *AuthHandler) AdminGetTeam(c echo.Context) error {
+	team, err := h.adminTeam(c)
+	if err != nil {
+		return err
+	}
+
+	var members []models.User
+	err = h.DB.Joins("JOIN team_memberships ON team_memberships.user_id = users.id AND team_memberships.deleted_at IS NULL").
+		Where("team_memberships.team_id = ?", team.ID).
+		Order("first_name, last_name").
+		Find(&members).Error
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get team members")
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"team":    team,
+		"members": h.withOnlineState(c, members),
+	})
+}
+
+// AdminListTeamInvitations returns the email invitations of a team, filtered
+// by status like ListTeamInvitations
+func (h *AuthHandler) AdminListTeamInvitations(c echo.Context) error {
+	team, err := h.adminTeam(c)
+	if err != nil {
+		return err
+	}
+
+	return h.listEmailInvitations(c, team.ID)
+}
+
+// adminTeam returns the team of the id path parameter
+func (h *AuthHandler) adminTeam(c echo.Context) (*models.Team, error) {
+	var team models.Team
+	if err := h.DB.Where("id = ?", c.Param("id")).First(&team).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, echo.NewHTTPError(http.StatusNotFound, "Team not found")
+		}
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "Failed to get team")
+	}
+
+	return &team, nil
+}
+
+// withOnlineState adds whether each of the users has a live connection
+func (h *AuthHandler) withOnlineState(c echo.Context, users []models.User) []adminUser {
+	userIDs := make([]string, len(users))
+	for i := range users {
+		userIDs[i] = users[i].ID
+	}
+
+	online, err := presence.BulkIsOnline(c.Request().Context(), h.Redis, userIDs)
+	if err != nil {
+		c.Logger().Error("Error checking presence: ", err)
+	}
+
+	result := make([]adminUser, len(users))
+	for i, user := range users {
+		result[i] = adminUser{User: user, IsOnline: online[user.ID]}
+	}
+
+	return result
+}
+
+// AdminCreateUser creates a user with a password, in an existing team if one is given
+func (h *AuthHandler) AdminCreateUser(c echo.Context) error {
+	type CreateUserRequest struct {
+		FirstName string `json:"first_name" validate:"required"`
+		LastName  string `json:"last_name" validate:"required"`
+		Email     string `json:"email" validate:"required,email"`
+		Password  string `json:"password" validate:"required"`
+		TeamID    *uint  `json:"team_id"`
+		IsAdmin   bool   `json:"is_admin"`
+	}
+
+	req := new(CreateUserRequest)
+	if err := c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request format")
+	}
+
+	if err := c.Validate(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	if err := password.Validate(req.Password, h.Config); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	if req.TeamID != nil {
+		if err := h.DB.Where("id = ?", *req.TeamID).First(&models.Team{}).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return echo.NewHTTPError(http.StatusNotFound, "Team not found")
+			}
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get team")
+		}
+	}
+
+	user := models.User{
+		FirstName: req.FirstName,
+		LastName:  req.LastName,
+		Email:     req.Email,
+		Password:  req.Password,
+		TeamID:    req.TeamID,
+	}
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&user).Error; err != nil {
+			return err
+		}
+
+		if req.IsAdmin && user.TeamID != nil {
+			user.IsAdmin = true
+			return models.SetTeamAdmin(tx, user.ID, *user.TeamID, true)
+		}
+
+		return nil
+	})
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		return echo.NewHTTPError(http.StatusConflict, "user with this email already exists")
+	}
+	if err != nil {
+		c.Logger().Errorf("Failed to create user: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create user")
+	}
+
+	if user.TeamID != nil {
+		broadcastMemberJoined(&h.ServerState, *user.TeamID, &user)
+	}
+
+	return c.JSON(http.StatusCreated, user)
+}
+
+// AdminRevokeUserSessions signs the user out of every device
+func (h *AuthHandler) AdminRevokeUserSessions(c echo.Context) error {
+	var user models.User
+	if err := h.DB.Where("id = ?", c.Param("id")).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return echo.NewHTTPError(http.StatusNotFound, "User not found")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get user")
+	}
+
+	if err := h.revokeAllSessions(c, user.ID); err != nil {
+		c.Logger().Error("Failed to revoke sessions: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to revoke sessions")
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// AdminRotateTeamInvite revokes the invitation link of a team and creates a new one
+func (h *AuthHandler) AdminRotateTeamInvite(c echo.Context) error {
+	team, err := h.adminTeam(c)
+	if err != nil {
+		return err
+	}
+
+	invitation, err := models.RegenerateTeamInvitation(h.DB, int(team.ID))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create team invitation")
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{
+		"invite_uuid": invitation.UniqueID,
+		"team_name":   team.Name,
+	})
+}
+
+// AdminListFeatureFlags returns the feature flags that were ever set
+func (h *AuthHandler) AdminListFeatureFlags(c echo.Context) error {
+	var flags []models.FeatureFlag
+	if err := h.DB.Order("name").Find(&flags).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get feature flags")
+	}
+
+	return c.JSON(http.StatusOK, flags)
+}
+
+// AdminSetFeatureFlag turns a feature flag of the instance on or off
+func (h *AuthHandler) AdminSetFeatureFlag(c echo.Context) error {
+	type SetFeatureFlagRequest struct {
+		Enabled *bool `json:"enabled" validate:"required"`
+	}
+
+	req := new(SetFeatureFlagRequest)
+	if err := c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request format")
+	}
+
+	if err := c.Validate(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	name := c.Param("name")
+	before := models.IsFeatureEnabled(h.DB, name)
+
+	flag, err := models.SetFeatureFlag(h.DB, name, *req.Enabled)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to set feature flag")
+	}
+
+	recordAuditChange(c, "feature_flag."+name, before, flag.Enabled)
+
+	return c.JSON(http.StatusOK, flag)
+}
+
+// adminStatsMaxDays caps the range of days of the admin stats
+const adminStatsMaxDays = 366
+
+// AdminStats returns the signups, active users, calls and invitation conversions
+// of the whole service per day between the from and to days (inclusive)
+func (h *AuthHandler) AdminStats(c echo.Context) error {
+	from, to, err := parseDayRange(c, adminStatsMaxDays)
+	if err != nil {
+		return err
+	}
+
+	type DayStats struct {
+		Day                 string `json:"day"`
+		Signups             int64  `json:"signups"`
+		ActiveUsers         int64  `json:"active_users"`
+		Calls               int64  `json:"calls"`
+		InvitationsSent     int64  `json:"invitations_sent"`
+		InvitationsAccepted int64  `json:"invitations_accepted"`
+		// Share of the invitations sent during the day that were accepted
+		InviteConversionRate float64 `json:"invite_conversion_rate"`
+	}
+
+	var stats []models.DailyStats
+	if err := h.DB.Where("day BETWEEN ? AND ?", from, to).Order("day").Find(&stats).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get stats")
+	}
+
+	days := make([]DayStats, len(stats))
+	var signups, calls, invitationsSent, invitationsAccepted int64
+	for i, day := range stats {
+		days[i] = DayStats{
+			Day:                  day.Day.Format(time.DateOnly),
+			Signups:              day.Signups,
+			ActiveUsers:          day.ActiveUsers,
+			Calls:                day.Calls,
+			InvitationsSent:      day.InvitationsSent,
+			InvitationsAccepted:  day.InvitationsAccepted,
+			InviteConversionRate: conversionRate(day.InvitationsAccepted, day.InvitationsSent),
+		}
+		signups += day.Signups
+		calls += day.Calls
+		invitationsSent += day.InvitationsSent
+		invitationsAccepted += day.InvitationsAccepted
+	}
+
+	// Active users aren't totaled, the same users are active on many days
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"from":                       from.Format(time.DateOnly),
+		"to":                         to.Format(time.DateOnly),
+		"total_signups":              signups,
+		"total_calls":                calls,
+		"total_invitations_sent":     invitationsSent,
+		"total_invitations_accepted": invitationsAccepted,
+		"invite_conversion_rate":     conversionRate(invitationsAccepted, invitationsSent),
+		"days":                       days,
+	})
+}
+
+// conversionRate returns the share of the sent that converted, zero when nothing was sent
+func conversionRate(converted, sent int64) float64 {
+	if sent == 0 {
+		return 0
+	}
+
+	return float64(converted) / float64(sent)
+}
@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"hopp-backend/internal/common"
+	"hopp-backend/internal/messages"
+
+	"github.com/labstack/echo/v4"
+	"github.com/redis/go-redis/v9"
+)
+
+// Limits of a single annotation message, drawing clients send the points
+// of a stroke in batches while it is drawn
+const (
+	maxAnnotationPoints   = 256
+	maxAnnotationStrokeID = 64
+	maxAnnotationColor    = 32
+	maxAnnotationWidth    = 64
+)
+
+// publishAnnotationScript numbers the annotation message and publishes it to the
+// participants in one step, so every participant receives the messages of a room
+// in the order of their sequence, whoever drew them
+var publishAnnotationScript = redis.NewScript(`
+local sequence = redis.call("INCR", KEYS[1])
+redis.call("PEXPIRE", KEYS[1], ARGV[1])
+local message = cjson.decode(ARGV[2])
+message.payload.sequence = sequence
+local encoded = cjson.encode(message)
+for i = 3, #ARGV do
+	redis.call("PUBLISH", ARGV[i], encoded)
+end
+return sequence
+`)
+
+// relayAnnotation sends the user's annotation of the shared screen to the other participants of the call
+func relayAnnotation(ctx echo.Context, s *common.ServerState, userID string, messageType messages.MessageType, payload messages.AnnotationPayload) error {
+	if reason := validateAnnotation(messageType, payload); reason != "" {
+		return errors.New(reason)
+	}
+
+	_, inCall, err := getRoomParticipant(s, payload.RoomName, userID)
+	if err != nil {
+		ctx.Logger().Error("Failed to get call state: ", err)
+		return errMessageFailed
+	}
+	if !inCall {
+		return errors.New("Call not found")
+	}
+
+	payload.SenderID = userID
+	payload.Sequence = 0
+	if messageType == messages.MessageTypeAnnotationClear {
+		payload.StrokeID = ""
+		payload.Points = nil
+	}
+
+	msgJSON, err := json.Marshal(messages.NewAnnotationMessage(messageType, payload))
+	if err != nil {
+		ctx.Logger().Error(err)
+		return errMessageFailed
+	}
+
+	args := []interface{}{maxCallDuration.Milliseconds(), string(msgJSON)}
+	for _, participantID := range getRoomUsers(s, payload.RoomName) {
+		if participantID == userID {
+			continue
+		}
+		args = append(args, common.GetUserChannel(participantID))
+	}
+
+	err = publishAnnotationScript.Run(context.Background(), s.Redis,
+		[]string{common.GetAnnotationSequenceKey(payload.RoomName)}, args...).Err()
+	if err != nil {
+		ctx.Logger().Error("Failed to publish annotation: ", err)
+		return errMessageFailed
+	}
+
+	return nil
+}
+
+// validateAnnotation checks the annotation is within the size limits,
+// it returns why it isn't or an empty string
+func validateAnnotation(messageType messages.MessageType, payload messages.AnnotationPayload) string {
+	if payload.RoomName == "" {
+		return "Missing room name"
+	}
+	if messageType == messages.MessageTypeAnnotationClear {
+		return ""
+	}
+
+	if payload.StrokeID == "" || len(payload.StrokeID) > maxAnnotationStrokeID {
+		return "Invalid stroke ID"
+	}
+	if len(payload.Points) > maxAnnotationPoints {
+		return "Too many points in one annotation message"
+	}
+	for _, point := range payload.Points {
+		if point.X < 0 || point.X > 1 || point.Y < 0 || point.Y > 1 {
+			return "Annotation points must be relative to the shared screen"
+		}
+	}
+
+	if messageType == messages.MessageTypeAnnotationStrokeStart {
+		if len(payload.Color) > maxAnnotationColor {
+			return "Invalid stroke color"
+		}
+		if payload.Width < 0 || payload.Width > maxAnnotationWidth {
+			return "Invalid stroke width"
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"hopp-backend/internal/common"
+	"hopp-backend/internal/models"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// APIKeyMiddleware authenticates requests that carry a personal access token
+// (`Authorization: Bearer hopp_pat_...`). Requests with any other credentials
+// are passed through untouched so the JWT middleware can handle them.
+func APIKeyMiddleware(db *gorm.DB) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			plainKey, found := strings.CutPrefix(c.Request().Header.Get(echo.HeaderAuthorization), "Bearer "+models.ApiKeyPrefix)
+			if !found {
+				return next(c)
+			}
+			plainKey = models.ApiKeyPrefix + plainKey
+
+			key, err := models.GetApiKeyByPlainKey(db, plainKey)
+			if err != nil || key.IsExpired() {
+				return echo.NewHTTPError(http.StatusUnauthorized, "invalid or expired API key")
+			}
+
+			// Read-only keys can't be used for mutating requests. GET routes that act
+			// as the user, like the websocket, refuse API keys themselves.
+			method := c.Request().Method
+			if method != http.MethodGet && method != http.MethodHead && !key.HasScope(models.ApiKeyScopeWrite) {
+				return echo.NewHTTPError(http.StatusForbidden, "API key does not have the write scope")
+			}
+
+			if err := db.Model(key).UpdateColumn("last_used_at", time.Now()).Error; err != nil {
+				c.Logger().Error("Failed to update API key last usage: ", err)
+			}
+
+			c.Set(common.APIKeyEmailContextKey, key.User.Email)
+			return next(c)
+		}
+	}
+}
+
+// CreateApiKey creates a new personal access token for the authenticated user.
+// The plain text key is only returned in this response.
+func (h *AuthHandler) CreateApiKey(c echo.Context) error {
+	user, isAuthenticated := h.getAuthenticatedUserFromJWT(c)
+	if !isAuthenticated {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
+	}
+
+	// Don't allow API keys to mint more API keys
+	if c.Get(common.APIKeyEmailContextKey) != nil {
+		return echo.NewHTTPError(http.StatusForbidden, "API keys can't be managed with an API key")
+	}
+
+	if isImpersonationRequest(c) {
+		return echo.NewHTTPError(http.StatusForbidden, "Forbidden")
+	}
+
+	type CreateApiKeyRequest struct {
+		Name          string   `json:"name" validate:"required"`
+		Scopes        []string `json:"scopes" validate:"required,min=1"`
+		ExpiresInDays int      `json:"expires_in_days" validate:"min=0"`
+	}
+
+	req := new(CreateApiKeyRequest)
+	if err := c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request format")
+	}
+
+	if err := c.Validate(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	for _, scope := range req.Scopes {
+		if !slices.Contains(models.ApiKeyScopes, scope) {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid scope: "+scope)
+		}
+	}
+
+	var expiresAt *time.Time
+	if req.ExpiresInDays > 0 {
+		t := time.Now().AddDate(0, 0, req.ExpiresInDays)
+		expiresAt = &t
+	}
+
+	key, plainKey := models.NewApiKey(user.ID, req.Name, req.Scopes, expiresAt)
+	if err := h.DB.Create(key).Error; err != nil {
+		c.Logger().Error("Failed to create API key: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create API key")
+	}
+
+	return c.JSON(http.StatusCreated, map[string]interface{}{
+		"api_key": key,
+		"key":     plainKey,
+	})
+}
+
+// ListApiKeys returns the non-revoked API keys of the authenticated user
+func (h *AuthHandler) ListApiKeys(c echo.Context) error {
+	user, isAuthenticated := h.getAuthenticatedUserFromJWT(c)
+	if !isAuthenticated {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
+	}
+
+	var keys []models.ApiKey
+	if err := h.DB.Where("user_id = ?", user.ID).Order("created_at DESC").Find(&keys).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get API keys")
+	}
+
+	return c.JSON(http.StatusOK, keys)
+}
+
+// RevokeApiKey revokes one of the authenticated user's API keys
+func (h *AuthHandler) RevokeApiKey(c echo.Context) error {
+	user, isAuthenticated := h.getAuthenticatedUserFromJWT(c)
+	if !isAuthenticated {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
+	}
+
+	if c.Get(common.APIKeyEmailContextKey) != nil {
+		return echo.NewHTTPError(http.StatusForbidden, "API keys can't be managed with an API key")
+	}
+
+	result := h.DB.Where("id = ? AND user_id = ?", c.Param("id"), user.ID).Delete(&models.ApiKey{})
+	if result.Error != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to revoke API key")
+	}
+	if result.RowsAffected == 0 {
+		return echo.NewHTTPError(http.StatusNotFound, "API key not found")
+	}
+
+	return c.NoContent(http.StatusOK)
+}
@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"hopp-backend/internal/models"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// recentActivityLimit is the number of events returned by RecentActivity
+const recentActivityLimit = 50
+
+// recordAuditEvent stores an authentication event for the request.
+// Failing to record an event never fails the request itself.
+func (h *AuthHandler) recordAuditEvent(c echo.Context, event models.AuditEventType, user *models.User, email string, metadata map[string]interface{}) {
+	auditEvent := models.AuditEvent{
+		Email:     email,
+		Event:     event,
+		IPAddress: c.RealIP(),
+		UserAgent: c.Request().UserAgent(),
+		Metadata:  metadata,
+	}
+
+	if user != nil {
+		auditEvent.UserID = &user.ID
+		auditEvent.Email = user.Email
+	}
+
+	if event == models.AuditEventSocialLogin {
+		auditEvent.Provider = c.Param("provider")
+	}
+
+	if err := h.DB.Create(&auditEvent).Error; err != nil {
+		c.Logger().Error("Failed to record audit event: ", err)
+	}
+}
+
+// RecentActivity returns the latest authentication events of the authenticated user
+func (h *AuthHandler) RecentActivity(c echo.Context) error {
+	user, isAuthenticated := h.getAuthenticatedUserFromJWT(c)
+	if !isAuthenticated {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
+	}
+
+	// Failed sign-ins aren't linked to a user ID, match them by email
+	var events []models.AuditEvent
+	err := h.DB.Where("user_id = ? OR email = ?", user.ID, user.Email).
+		Order("created_at DESC").
+		Limit(recentActivityLimit).
+		Find(&events).Error
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get recent activity")
+	}
+
+	return c.JSON(http.StatusOK, events)
+}
@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"hopp-backend/internal/common"
+	"hopp-backend/internal/models"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+const (
+	// auditChangesContextKey keeps the changes of sensitive fields the handler recorded
+	auditChangesContextKey = "audit_changes"
+	// auditActorContextKey keeps the user behind requests without credentials, like
+	// the links of confirmation emails
+	auditActorContextKey = "audit_actor"
+)
+
+// auditLogMaxDays caps the range of days of the audit log queries
+const auditLogMaxDays = 366
+
+// recordAuditChange adds the change of a sensitive field to the audit log entry
+// of the request. Fields that didn't change are left out.
+func recordAuditChange(c echo.Context, field string, before, after interface{}) {
+	if reflect.DeepEqual(before, after) {
+		return
+	}
+
+	changes, _ := c.Get(auditChangesContextKey).([]models.AuditChange)
+	c.Set(auditChangesContextKey, append(changes, models.AuditChange{
+		Field:  field,
+		Before: before,
+		After:  after,
+	}))
+}
+
+// setAuditActor sets who made a request that doesn't carry credentials
+func setAuditActor(c echo.Context, user *models.User) {
+	c.Set(auditActorContextKey, user)
+}
+
+// AuditLogMiddleware writes every mutating request to the audit log, along with
+// who made it and the changes of sensitive fields its handler recorded. Reads are
+// only written when they changed something, like the links of confirmation emails.
+// It reads the credentials after the request was handled, so it can run before
+// the authentication middlewares.
+func AuditLogMiddleware(db *gorm.DB) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			err := next(c)
+
+			changes, _ := c.Get(auditChangesContextKey).([]models.AuditChange)
+			method := c.Request().Method
+			if len(changes) == 0 && (method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions) {
+				return err
+			}
+
+			status := c.Response().Status
+			var httpErr *echo.HTTPError
+			if errors.As(err, &httpErr) {
+				status = httpErr.Code
+			} else if err != nil {
+				status = http.StatusInternalServerError
+			}
+
+			entry := models.AuditLogEntry{
+				Method:    method,
+				Route:     c.Path(),
+				Path:      c.Request().URL.Path,
+				Status:    status,
+				IPAddress: c.RealIP(),
+				UserAgent: c.Request().UserAgent(),
+				Changes:   changes,
+			}
+
+			if names := c.ParamNames(); len(names) > 0 {
+				entry.Params = make(map[string]string, len(names))
+				for i, name := range names {
+					entry.Params[name] = c.ParamValues()[i]
+				}
+			}
+
+			if email, ok := c.Get(common.APIKeyEmailContextKey).(string); ok {
+				entry.ActorEmail = email
+				entry.APIKey = true
+			} else if claims, claimsErr := getClaims(c); claimsErr == nil {
+				entry.ActorEmail = claims.Email
+				entry.Impersonator = claims.Impersonator
+			} else if actor, ok := c.Get(auditActorContextKey).(*models.User); ok {
+				entry.ActorID = &actor.ID
+				entry.ActorEmail = actor.Email
+			}
+
+			if entry.ActorID == nil && entry.ActorEmail != "" {
+				var actorID string
+				if dbErr := db.Model(&models.User{}).Where("email = ?", entry.ActorEmail).Select("id").Scan(&actorID).Error; dbErr == nil && actorID != "" {
+					entry.ActorID = &actorID
+				}
+			}
+
+			if dbErr := db.Create(&entry).Error; dbErr != nil {
+				c.Logger().Error("Failed to write audit log entry: ", dbErr)
+			}
+
+			return err
+		}
+	}
+}
+
+// AdminAuditLog returns the page of audit log entries between the from and to days
+// (inclusive), newest first. They can be filtered by actor, matched against the ID
+// and the email, and by route. The total number of matching entries is returned
+// in the X-Total-Count header.
+func (h *AuthHandler) AdminAuditLog(c echo.Context) error {
+	from, to, err := parseDayRange(c, auditLogMaxDays)
+	if err != nil {
+		return err
+	}
+
+	page, err := parsePositiveQueryParam(c, "page", 1)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid page")
+	}
+
+	perPage, err := parsePositiveQueryParam(c, "per_page", adminMaxPerPage)
+	if err != nil || perPage > adminMaxPerPage {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("per_page must be between 1 and %d", adminMaxPerPage))
+	}
+
+	query := h.DB.Model(&models.AuditLogEntry{}).
+		Where("created_at >= ? AND created_at < ?", from, to.Add(24*time.Hour))
+	if actor := strings.TrimSpace(c.QueryParam("actor")); actor != "" {
+		query = query.Where("actor_id = ? OR actor_email = ?", actor, actor)
+	}
+	if route := c.QueryParam("route"); route != "" {
+		query = query.Where("route = ?", route)
+	}
+	query = query.Session(&gorm.Session{})
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get audit log")
+	}
+
+	var entries []models.AuditLogEntry
+	err = query.Order("created_at DESC").
+		Order("id DESC").
+		Limit(perPage).
+		Offset((page - 1) * perPage).
+		Find(&entries).Error
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get audit log")
+	}
+
+	c.Response().Header().Set("X-Total-Count", strconv.FormatInt(total, 10))
+
+	return c.JSON(http.StatusOK, entries)
+}
@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"hopp-backend/internal/common"
+	"hopp-backend/internal/messages"
+	"hopp-backend/internal/models"
+	"time"
+	"unicode/utf8"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// maxCallReactions is how many reactions a participant can send per reaction window,
+	// the rest are dropped
+	maxCallReactions   = 10
+	callReactionWindow = 10 * time.Second
+	// maxCallReactionLength fits emoji made of several code points, e.g. with skin tones
+	maxCallReactionLength = 16
+)
+
+// relayCallReaction sends the user's emoji reaction to the other participants of the call
+func relayCallReaction(ctx echo.Context, s *common.ServerState, user *models.User, payload messages.CallReactionPayload) error {
+	if payload.Emoji == "" || utf8.RuneCountInString(payload.Emoji) > maxCallReactionLength {
+		return errors.New("Invalid reaction")
+	}
+
+	if !allowCallReaction(ctx, s, user.ID) {
+		return errors.New("Too many reactions, slow down")
+	}
+
+	_, inCall, err := getRoomParticipant(s, payload.RoomName, user.ID)
+	if err != nil {
+		ctx.Logger().Error("Failed to get call state: ", err)
+		return errMessageFailed
+	}
+	if !inCall {
+		return errors.New("Call not found")
+	}
+
+	msgJSON, err := json.Marshal(messages.NewCallReactionMessage(payload.RoomName, payload.Emoji, user.ID, user.GetDisplayName()))
+	if err != nil {
+		ctx.Logger().Error(err)
+		return errMessageFailed
+	}
+
+	for _, participantID := range getRoomUsers(s, payload.RoomName) {
+		if participantID == user.ID {
+			continue
+		}
+		publishToUser(s, participantID, msgJSON)
+	}
+
+	return nil
+}
+
+// allowCallReaction counts the user's reaction in the current window and
+// checks it is within the limit
+func allowCallReaction(ctx echo.Context, s *common.ServerState, userID string) bool {
+	rdbCtx := context.Background()
+	key := common.GetCallReactionRateKey(userID)
+
+	count, err := s.Redis.Incr(rdbCtx, key).Result()
+	if err != nil {
+		ctx.Logger().Error("Failed to rate limit call reaction: ", err)
+		return true
+	}
+	if count == 1 {
+		s.Redis.Expire(rdbCtx, key, callReactionWindow)
+	}
+
+	return count <= maxCallReactions
+}
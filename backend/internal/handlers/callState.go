@@ -0,0 +1,209 @@
+package handlers
+
+import (
+	"context"
+	"hopp-backend/internal/common"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// callStatus is a step of the lifecycle of a call between two teammates:
+// ringing -> active -> ended, or ringing -> rejected, ended or timeout
+type callStatus string
+
+const (
+	callRinging  callStatus = "ringing"
+	callActive   callStatus = "active"
+	callEnded    callStatus = "ended"
+	callRejected callStatus = "rejected"
+	callTimeout  callStatus = "timeout"
+)
+
+// finishedCallTTL keeps the state of finished calls around, so late messages
+// about them are recognised and ignored
+const finishedCallTTL = 10 * time.Minute
+
+// callState is the server side state of a call, the call ID is also the LiveKit room name
+type callState struct {
+	ID       string
+	CallerID string
+	CalleeID string
+	Status   callStatus
+	// Participant that put the active call on hold, empty if it isn't on hold
+	HeldBy string
+	// Ring group the call is part of, when the caller rang several callees at once
+	RingGroupID string
+	// Devices the caller called from and the callee answered on, the call is theirs
+	CallerDevice string
+	CalleeDevice string
+	// When the call started ringing
+	RangAt time.Time
+}
+
+// transitionCallScript moves a call to a new status only if it is still in the expected one,
+// so only one of the racing messages about a call wins
+var transitionCallScript = redis.NewScript(`
+if redis.call("HGET", KEYS[1], "status") ~= ARGV[1] then
+	return 0
+end
+redis.call("HSET", KEYS[1], "status", ARGV[2])
+redis.call("PEXPIRE", KEYS[1], ARGV[3])
+return 1
+`)
+
+// createCall starts tracking a new ringing call from the caller's device to the callee,
+// calls of a ring group are linked so the first one answered stops the rest
+func createCall(s *common.ServerState, callerID, callerDevice, calleeID, ringGroupID string) (string, error) {
+	rdbCtx := context.Background()
+	callID := uuid.New().String()
+	key := common.GetCallStateKey(callID)
+
+	// The state outlives the ring timer, so the timer can tell if the call was picked up
+	ttl := 2 * s.Config.Calls.RingTimeout
+
+	pipe := s.Redis.TxPipeline()
+	pipe.HSet(rdbCtx, key, map[string]interface{}{
+		"caller_id":     callerID,
+		"caller_device": callerDevice,
+		"callee_id":     calleeID,
+		"status":        string(callRinging),
+		"ring_group":    ringGroupID,
+		"rang_at":       time.Now().UnixMilli(),
+	})
+	pipe.Expire(rdbCtx, key, ttl)
+	if ringGroupID != "" {
+		ringGroupKey := common.GetRingGroupKey(ringGroupID)
+		pipe.SAdd(rdbCtx, ringGroupKey, callID)
+		pipe.Expire(rdbCtx, ringGroupKey, ttl)
+	}
+	pipe.Set(rdbCtx, common.GetLatestCallKey(callerID, calleeID), callID, maxCallDuration)
+	if _, err := pipe.Exec(rdbCtx); err != nil {
+		return "", err
+	}
+
+	// Every step of the call is logged with its ID, to trace it across the servers of both users
+	s.Echo.Logger.Infof("Call %s ringing from %s on device %s to %s", callID, callerID, callerDevice, calleeID)
+
+	return callID, nil
+}
+
+// getCall returns the state of the call, nil if it is unknown or long finished
+func getCall(s *common.ServerState, callID string) (*callState, error) {
+	if callID == "" {
+		return nil, nil
+	}
+
+	values, err := s.Redis.HGetAll(context.Background(), common.GetCallStateKey(callID)).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(values) == 0 {
+		return nil, nil
+	}
+
+	var rangAt time.Time
+	if ms, err := strconv.ParseInt(values["rang_at"], 10, 64); err == nil {
+		rangAt = time.UnixMilli(ms)
+	}
+
+	return &callState{
+		ID:           callID,
+		CallerID:     values["caller_id"],
+		CalleeID:     values["callee_id"],
+		Status:       callStatus(values["status"]),
+		HeldBy:       values["held_by"],
+		RingGroupID:  values["ring_group"],
+		CallerDevice: values["caller_device"],
+		CalleeDevice: values["callee_device"],
+		RangAt:       rangAt,
+	}, nil
+}
+
+// resolveCallID returns the call a message is about. Clients that don't send the
+// call ID get the latest call between the two users, in either direction.
+func resolveCallID(s *common.ServerState, callID, userID, participantID string) string {
+	if callID != "" {
+		return callID
+	}
+
+	rdbCtx := context.Background()
+	for _, key := range []string{common.GetLatestCallKey(userID, participantID), common.GetLatestCallKey(participantID, userID)} {
+		latest, err := s.Redis.Get(rdbCtx, key).Result()
+		if err == nil {
+			return latest
+		}
+	}
+
+	return ""
+}
+
+// transitionCall moves the call from one status to another, it reports
+// whether the call was in the expected status
+func transitionCall(s *common.ServerState, callID string, from, to callStatus) bool {
+	ttl := finishedCallTTL
+	if to == callActive {
+		ttl = maxCallDuration
+	}
+
+	moved, err := transitionCallScript.Run(context.Background(), s.Redis,
+		[]string{common.GetCallStateKey(callID)}, string(from), string(to), ttl.Milliseconds()).Int()
+	if err != nil {
+		s.Echo.Logger.Error("Failed to update call state: ", err)
+		return false
+	}
+
+	if moved == 1 {
+		s.Echo.Logger.Infof("Call %s %s -> %s", callID, from, to)
+		if to == callActive {
+			activeCalls.Inc()
+		}
+		if from == callActive {
+			activeCalls.Dec()
+		}
+	}
+
+	return moved == 1
+}
+
+// isParticipant checks if the user is the caller or the callee of the call
+func (c *callState) isParticipant(userID string) bool {
+	return c.CallerID == userID || c.CalleeID == userID
+}
+
+// otherParticipant returns the participant of the call that isn't the user
+func (c *callState) otherParticipant(userID string) string {
+	if c.CallerID == userID {
+		return c.CalleeID
+	}
+	return c.CallerID
+}
+
+// getRingGroupCalls returns the other calls of the ring group of the call
+func getRingGroupCalls(s *common.ServerState, call *callState) []*callState {
+	if call.RingGroupID == "" {
+		return nil
+	}
+
+	callIDs, err := s.Redis.SMembers(context.Background(), common.GetRingGroupKey(call.RingGroupID)).Result()
+	if err != nil {
+		s.Echo.Logger.Error("Failed to get ring group: ", err)
+		return nil
+	}
+
+	var calls []*callState
+	for _, callID := range callIDs {
+		if callID == call.ID {
+			continue
+		}
+		sibling, err := getCall(s, callID)
+		if err != nil || sibling == nil {
+			continue
+		}
+		calls = append(calls, sibling)
+	}
+
+	return calls
+}
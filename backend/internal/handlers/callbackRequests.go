@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"hopp-backend/internal/common"
+	"hopp-backend/internal/messages"
+	"hopp-backend/internal/models"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// CreateCallbackRequest asks a teammate the user couldn't reach to call them back
+func (h *AuthHandler) CreateCallbackRequest(c echo.Context) error {
+	user, isAuthenticated := h.getAuthenticatedUserFromJWT(c)
+	if !isAuthenticated {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
+	}
+
+	if user.TeamID == nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "User is not part of any team")
+	}
+
+	type CallbackRequestRequest struct {
+		CalleeID string `json:"callee_id" validate:"required"`
+	}
+
+	req := new(CallbackRequestRequest)
+	if err := c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request")
+	}
+
+	if err := c.Validate(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	if req.CalleeID == user.ID {
+		return echo.NewHTTPError(http.StatusBadRequest, "You can't request a callback from yourself")
+	}
+
+	if !models.IsTeamMember(h.DB, req.CalleeID, *user.TeamID) {
+		return echo.NewHTTPError(http.StatusNotFound, "Teammate not found")
+	}
+
+	callbackRequest, err := models.CreateCallbackRequest(h.DB, user.ID, req.CalleeID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create callback request")
+	}
+	callbackRequest.Caller = user
+
+	// Teammates that are connected, but busy or in do not disturb, see it right away,
+	// the rest get it when they connect
+	msgJSON, err := json.Marshal(newCallbackRequestMessage(callbackRequest))
+	if err != nil {
+		c.Logger().Error(err)
+	} else {
+		publishToUser(&h.ServerState, req.CalleeID, msgJSON)
+	}
+
+	return c.JSON(http.StatusCreated, callbackRequest)
+}
+
+// ListCallbackRequests returns the pending callback requests left for the user
+func (h *AuthHandler) ListCallbackRequests(c echo.Context) error {
+	user, isAuthenticated := h.getAuthenticatedUserFromJWT(c)
+	if !isAuthenticated {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
+	}
+
+	callbackRequests, err := models.GetPendingCallbackRequests(h.DB, user.ID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get callback requests")
+	}
+
+	return c.JSON(http.StatusOK, callbackRequests)
+}
+
+// DismissCallbackRequest removes a callback request left for the user without calling back
+func (h *AuthHandler) DismissCallbackRequest(c echo.Context) error {
+	user, isAuthenticated := h.getAuthenticatedUserFromJWT(c)
+	if !isAuthenticated {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
+	}
+
+	var callbackRequest models.CallbackRequest
+	result := h.DB.Where("id = ? AND callee_id = ?", c.Param("id"), user.ID).First(&callbackRequest)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, "Callback request not found")
+	}
+	if result.Error != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get callback request")
+	}
+
+	if err := h.DB.Delete(&callbackRequest).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to dismiss callback request")
+	}
+
+	return c.NoContent(http.StatusOK)
+}
+
+// deliverCallbackRequests sends the pending callback requests to the user's freshly connected websocket
+func deliverCallbackRequests(c echo.Context, s *common.ServerState, client *wsClient, user *models.User) {
+	callbackRequests, err := models.GetPendingCallbackRequests(s.DB, user.ID)
+	if err != nil {
+		c.Logger().Error("Failed to get callback requests: ", err)
+		return
+	}
+
+	for i := range callbackRequests {
+		if !client.sendJSON(newCallbackRequestMessage(&callbackRequests[i])) {
+			c.Logger().Error("Failed to deliver callback request")
+			return
+		}
+	}
+}
+
+// ringBack calls back the caller of a callback request left for the user,
+// the request is completed once the call rings
+func ringBack(ctx echo.Context, s *common.ServerState, client *wsClient, userID string, message messages.CallbackRingBackMessage) error {
+	var callbackRequest models.CallbackRequest
+	err := s.DB.Where("id = ? AND callee_id = ?", message.Payload.CallbackRequestID, userID).First(&callbackRequest).Error
+	if err != nil {
+		return errors.New("Callback request not found")
+	}
+
+	return initiateCall(ctx, s, client, userID, callbackRequest.CallerID, "")
+}
+
+func newCallbackRequestMessage(callbackRequest *models.CallbackRequest) messages.CallbackRequestMessage {
+	callerName := ""
+	if callbackRequest.Caller != nil {
+		callerName = callbackRequest.Caller.GetDisplayName()
+	}
+
+	return messages.NewCallbackRequestMessage(callbackRequest.ID, callbackRequest.CallerID, callerName, callbackRequest.CreatedAt)
+}
@@ -0,0 +1,250 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"hopp-backend/internal/common"
+	"hopp-backend/internal/models"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// callHistoryMaxPerPage caps the page size of the call history, also used as the default
+const callHistoryMaxPerPage = 50
+
+// CallHistory returns the calls the user took part in, newest first
+func (h *AuthHandler) CallHistory(c echo.Context) error {
+	user, isAuthenticated := h.getAuthenticatedUserFromJWT(c)
+	if !isAuthenticated {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
+	}
+
+	return h.paginatedCalls(c, h.DB.Where("caller_id = ? OR callee_id = ?", user.ID, user.ID))
+}
+
+// TeamCallHistory returns the calls of the user's active team, newest first
+func (h *AuthHandler) TeamCallHistory(c echo.Context) error {
+	user, isAuthenticated := h.getAuthenticatedUserFromJWT(c)
+	if !isAuthenticated {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
+	}
+
+	if user.TeamID == nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "User is not part of any team")
+	}
+
+	return h.paginatedCalls(c, h.DB.Where("team_id = ?", *user.TeamID))
+}
+
+// paginatedCalls responds with the page of calls matching the query, the
+// total number of matching calls is returned in the X-Total-Count header
+func (h *AuthHandler) paginatedCalls(c echo.Context, query *gorm.DB) error {
+	page, err := parsePositiveQueryParam(c, "page", 1)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid page")
+	}
+
+	perPage, err := parsePositiveQueryParam(c, "per_page", callHistoryMaxPerPage)
+	if err != nil || perPage > callHistoryMaxPerPage {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("per_page must be between 1 and %d", callHistoryMaxPerPage))
+	}
+
+	query = query.Model(&models.Call{}).Session(&gorm.Session{})
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get calls")
+	}
+
+	participantFields := func(db *gorm.DB) *gorm.DB {
+		return db.Select("id, first_name, last_name, email, avatar_url")
+	}
+
+	var calls []models.Call
+	err = query.Preload("Caller", participantFields).
+		Preload("Callee", participantFields).
+		Order("started_at DESC").
+		Limit(perPage).
+		Offset((page - 1) * perPage).
+		Find(&calls).Error
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get calls")
+	}
+
+	c.Response().Header().Set("X-Total-Count", strconv.FormatInt(total, 10))
+
+	return c.JSON(http.StatusOK, calls)
+}
+
+// callStatsMaxDays caps the range of days of the call stats
+const callStatsMaxDays = 366
+
+// TeamCallStats returns the call counts and minutes of the user's active team
+// between the from and to days (inclusive), per day and per member
+func (h *AuthHandler) TeamCallStats(c echo.Context) error {
+	user, isAuthenticated := h.getAuthenticatedUserFromJWT(c)
+	if !isAuthenticated {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
+	}
+
+	if user.TeamID == nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "User is not part of any team")
+	}
+
+	from, to, err := parseDayRange(c, callStatsMaxDays)
+	if err != nil {
+		return err
+	}
+
+	query := h.DB.Model(&models.CallStats{}).
+		Where("call_stats.team_id = ? AND call_stats.day BETWEEN ? AND ?", *user.TeamID, from, to).
+		Session(&gorm.Session{})
+
+	type DayStats struct {
+		Day     time.Time `json:"-"`
+		Date    string    `gorm:"-" json:"day"`
+		Calls   int64     `json:"calls"`
+		Minutes int64     `json:"minutes"`
+	}
+
+	var days []DayStats
+	err = query.Select("day, SUM(outgoing_calls) AS calls, SUM(seconds) / 60 AS minutes").
+		Group("day").
+		Order("day").
+		Scan(&days).Error
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get call stats")
+	}
+
+	type MemberStats struct {
+		UserID    string `json:"user_id"`
+		FirstName string `json:"first_name"`
+		LastName  string `json:"last_name"`
+		Calls     int64  `json:"calls"`
+		Minutes   int64  `json:"minutes"`
+	}
+
+	var members []MemberStats
+	err = query.Select("call_stats.user_id, users.first_name, users.last_name, SUM(call_stats.calls) AS calls, SUM(call_stats.seconds) / 60 AS minutes").
+		Joins("JOIN users ON users.id = call_stats.user_id").
+		Group("call_stats.user_id, users.first_name, users.last_name").
+		Order("minutes DESC").
+		Scan(&members).Error
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get call stats")
+	}
+
+	var totalCalls, totalMinutes int64
+	for i := range days {
+		days[i].Date = days[i].Day.Format(time.DateOnly)
+		totalCalls += days[i].Calls
+		totalMinutes += days[i].Minutes
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"from":          from.Format(time.DateOnly),
+		"to":            to.Format(time.DateOnly),
+		"total_calls":   totalCalls,
+		"total_minutes": totalMinutes,
+		"days":          days,
+		"members":       members,
+	})
+}
+
+// parseDayRange parses the from and to days (inclusive) query parameters, the last
+// 30 days by default. The range can't be longer than maxDays.
+func parseDayRange(c echo.Context, maxDays int) (time.Time, time.Time, error) {
+	to := time.Now().UTC().Truncate(24 * time.Hour)
+	if raw := c.QueryParam("to"); raw != "" {
+		parsed, err := time.Parse(time.DateOnly, raw)
+		if err != nil {
+			return time.Time{}, time.Time{}, echo.NewHTTPError(http.StatusBadRequest, "Invalid to, expected YYYY-MM-DD")
+		}
+		to = parsed
+	}
+
+	from := to.AddDate(0, 0, -29)
+	if raw := c.QueryParam("from"); raw != "" {
+		parsed, err := time.Parse(time.DateOnly, raw)
+		if err != nil {
+			return time.Time{}, time.Time{}, echo.NewHTTPError(http.StatusBadRequest, "Invalid from, expected YYYY-MM-DD")
+		}
+		from = parsed
+	}
+
+	if from.After(to) {
+		return time.Time{}, time.Time{}, echo.NewHTTPError(http.StatusBadRequest, "from must not be after to")
+	}
+	if to.Sub(from) >= time.Duration(maxDays)*24*time.Hour {
+		return time.Time{}, time.Time{}, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("The range can't be longer than %d days", maxDays))
+	}
+
+	return from, to, nil
+}
+
+// RefreshCallTokens re-issues the LiveKit tokens of a call the user is in,
+// for calls that outlive the validity of their tokens
+func (h *AuthHandler) RefreshCallTokens(c echo.Context) error {
+	user, isAuthenticated := h.getAuthenticatedUserFromJWT(c)
+	if !isAuthenticated {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
+	}
+
+	type RefreshTokensRequest struct {
+		// Call ID of a call, or the room name of a group call
+		RoomName string `json:"room_name" validate:"required"`
+	}
+
+	req := new(RefreshTokensRequest)
+	if err := c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request")
+	}
+
+	if err := c.Validate(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	// Tokens are only given for rooms the server knows the user is in right now
+	participant, inCall, err := getRoomParticipant(&h.ServerState, req.RoomName, user.ID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get call state")
+	}
+	if !inCall {
+		return echo.NewHTTPError(http.StatusForbidden, "You are not in this call")
+	}
+
+	tokens, err := generateLiveKitTokens(&h.ServerState, req.RoomName, user)
+	if err != nil {
+		c.Logger().Error("Failed to refresh call tokens: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to generate tokens")
+	}
+	tokens.Participant = participant
+
+	return c.JSON(http.StatusOK, tokens)
+}
+
+// getRoomParticipant checks if the user is in the ongoing call of the room, along with
+// the participant of the tokens: the other participant of a call, the user in group calls
+func getRoomParticipant(s *common.ServerState, roomName, userID string) (string, bool, error) {
+	isGroupParticipant, err := s.Redis.SIsMember(context.Background(), common.GetGroupCallParticipantsKey(roomName), userID).Result()
+	if err != nil {
+		return "", false, err
+	}
+	if isGroupParticipant {
+		return userID, true, nil
+	}
+
+	call, err := getCall(s, roomName)
+	if err != nil {
+		return "", false, err
+	}
+	if call == nil || call.Status != callActive || !call.isParticipant(userID) {
+		return "", false, nil
+	}
+
+	return call.otherParticipant(userID), true, nil
+}
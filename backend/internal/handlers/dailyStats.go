@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"context"
+	"hopp-backend/internal/common"
+	"hopp-backend/internal/models"
+	"hopp-backend/internal/presence"
+	"time"
+)
+
+// dailyStatsInterval is how often the daily stats of the latest days are rolled up
+const dailyStatsInterval = 10 * time.Minute
+
+// StartDailyStatsRollup periodically rolls up the daily stats of the admin stats.
+// Invitations are accepted up to EmailInvitationTTL after they are sent, so the
+// days in that window are rolled up again until their conversions are final.
+func StartDailyStatsRollup(s *common.ServerState) {
+	go func() {
+		ticker := time.NewTicker(dailyStatsInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			rollupDailyStats(s)
+		}
+	}()
+}
+
+func rollupDailyStats(s *common.ServerState) {
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	for day := today.Add(-models.EmailInvitationTTL); !day.After(today); day = day.Add(24 * time.Hour) {
+		activeUsers, err := presence.CountActiveUsers(context.Background(), s.Redis, day)
+		if err != nil {
+			s.Echo.Logger.Error("Failed to count active users: ", err)
+		}
+
+		if err := models.RollupDailyStats(s.DB, day, activeUsers); err != nil {
+			s.Echo.Logger.Error("Failed to roll up daily stats: ", err)
+			return
+		}
+	}
+}
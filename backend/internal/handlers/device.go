@@ -0,0 +1,269 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hopp-backend/internal/common"
+	"hopp-backend/internal/models"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/redis/go-redis/v9"
+)
+
+// Device authorization flow (RFC 8628) for the desktop app:
+// 1. The app requests a device code and shows the user code to the user
+// 2. The user opens the verification URL in the browser and approves the code
+// 3. The app polls the token endpoint until the grant is approved or expires
+const (
+	deviceCodeTTL          = 10 * time.Minute
+	devicePollInterval     = 5 * time.Second
+	deviceUserCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ" // No vowels to avoid words, no ambiguous characters
+	deviceUserCodeLength   = 8
+)
+
+type deviceGrantStatus string
+
+const (
+	deviceGrantPending  deviceGrantStatus = "pending"
+	deviceGrantApproved deviceGrantStatus = "approved"
+	deviceGrantDenied   deviceGrantStatus = "denied"
+)
+
+// deviceGrant is the pending grant stored in Redis, keyed by device code
+type deviceGrant struct {
+	UserCode string            `json:"user_code"`
+	Status   deviceGrantStatus `json:"status"`
+	Email    string            `json:"email,omitempty"`
+}
+
+// DeviceCode issues a new device code and user code pair for the desktop app
+func (h *AuthHandler) DeviceCode(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	userCode, err := generateDeviceUserCode()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to generate user code")
+	}
+	deviceCode := rand.Text()
+
+	grant, err := json.Marshal(deviceGrant{
+		UserCode: userCode,
+		Status:   deviceGrantPending,
+	})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create device grant")
+	}
+
+	// Avoid collisions with another pending user code
+	ok, err := h.Redis.SetNX(ctx, common.GetDeviceUserCodeKey(userCode), deviceCode, deviceCodeTTL).Result()
+	if err != nil || !ok {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create device grant")
+	}
+
+	if err := h.Redis.Set(ctx, common.GetDeviceCodeKey(deviceCode), grant, deviceCodeTTL).Err(); err != nil {
+		c.Logger().Error("Failed to store device grant: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create device grant")
+	}
+
+	verificationURI := fmt.Sprintf("https://%s/device", h.Config.Server.DeployDomain)
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"device_code":               deviceCode,
+		"user_code":                 userCode,
+		"verification_uri":          verificationURI,
+		"verification_uri_complete": verificationURI + "?user_code=" + userCode,
+		"expires_in":                int(deviceCodeTTL.Seconds()),
+		"interval":                  int(devicePollInterval.Seconds()),
+	})
+}
+
+// DeviceToken is polled by the desktop app with its device code.
+// Errors follow the RFC 8628 error codes so clients can react to them.
+func (h *AuthHandler) DeviceToken(c echo.Context) error {
+	type DeviceTokenRequest struct {
+		DeviceCode string `json:"device_code" validate:"required"`
+	}
+
+	req := new(DeviceTokenRequest)
+	if err := c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request format")
+	}
+
+	if err := c.Validate(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	ctx := c.Request().Context()
+
+	grant, err := h.getDeviceGrant(ctx, req.DeviceCode)
+	if errors.Is(err, redis.Nil) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "expired_token"})
+	}
+	if err != nil {
+		c.Logger().Error("Failed to get device grant: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get device grant")
+	}
+
+	// Clients polling faster than the advertised interval are told to slow down
+	ok, err := h.Redis.SetNX(ctx, common.GetDevicePollKey(req.DeviceCode), 1, devicePollInterval).Result()
+	if err == nil && !ok {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "slow_down"})
+	}
+
+	switch grant.Status {
+	case deviceGrantPending:
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "authorization_pending"})
+	case deviceGrantDenied:
+		h.deleteDeviceGrant(ctx, req.DeviceCode, grant.UserCode)
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "access_denied"})
+	}
+
+	// The grant can only be redeemed once
+	h.deleteDeviceGrant(ctx, req.DeviceCode, grant.UserCode)
+
+	user, err := models.GetUserByEmail(h.DB, grant.Email)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "access_denied"})
+	}
+
+	token, err := h.issueToken(c, user)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to generate token")
+	}
+
+	h.recordAuditEvent(c, models.AuditEventTokenRefresh, user, "", map[string]interface{}{"flow": "device"})
+
+	return c.JSON(http.StatusOK, map[string]string{"token": token})
+}
+
+// ApproveDevice is called from the browser by the signed-in user
+// to approve the user code shown in the desktop app
+func (h *AuthHandler) ApproveDevice(c echo.Context) error {
+	return h.resolveDeviceGrant(c, deviceGrantApproved)
+}
+
+// DenyDevice is called from the browser by the signed-in user
+// to deny a user code they don't recognise
+func (h *AuthHandler) DenyDevice(c echo.Context) error {
+	return h.resolveDeviceGrant(c, deviceGrantDenied)
+}
+
+func (h *AuthHandler) resolveDeviceGrant(c echo.Context, status deviceGrantStatus) error {
+	user, isAuthenticated := h.getAuthenticatedUserFromJWT(c)
+	if !isAuthenticated {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
+	}
+
+	// The device gets a full session token, which would outlive an API key
+	// and ignore its scopes, or outlive an impersonation token
+	if isAPIKeyRequest(c) || isImpersonationRequest(c) {
+		return echo.NewHTTPError(http.StatusForbidden, "Forbidden")
+	}
+
+	type ResolveDeviceRequest struct {
+		UserCode string `json:"user_code" validate:"required"`
+	}
+
+	req := new(ResolveDeviceRequest)
+	if err := c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request format")
+	}
+
+	if err := c.Validate(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	ctx := c.Request().Context()
+	userCode := normalizeDeviceUserCode(req.UserCode)
+
+	deviceCode, err := h.Redis.Get(ctx, common.GetDeviceUserCodeKey(userCode)).Result()
+	if errors.Is(err, redis.Nil) {
+		return echo.NewHTTPError(http.StatusNotFound, "Code not found or has expired")
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get device grant")
+	}
+
+	grant, err := h.getDeviceGrant(ctx, deviceCode)
+	if errors.Is(err, redis.Nil) {
+		return echo.NewHTTPError(http.StatusNotFound, "Code not found or has expired")
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get device grant")
+	}
+
+	if grant.Status != deviceGrantPending {
+		return echo.NewHTTPError(http.StatusConflict, "Code has already been used")
+	}
+
+	grant.Status = status
+	if status == deviceGrantApproved {
+		grant.Email = user.Email
+	}
+
+	grantJSON, err := json.Marshal(grant)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to update device grant")
+	}
+
+	if err := h.Redis.Set(ctx, common.GetDeviceCodeKey(deviceCode), grantJSON, redis.KeepTTL).Err(); err != nil {
+		c.Logger().Error("Failed to update device grant: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to update device grant")
+	}
+
+	return c.NoContent(http.StatusOK)
+}
+
+func (h *AuthHandler) getDeviceGrant(ctx context.Context, deviceCode string) (*deviceGrant, error) {
+	data, err := h.Redis.Get(ctx, common.GetDeviceCodeKey(deviceCode)).Bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	var grant deviceGrant
+	if err := json.Unmarshal(data, &grant); err != nil {
+		return nil, err
+	}
+	return &grant, nil
+}
+
+func (h *AuthHandler) deleteDeviceGrant(ctx context.Context, deviceCode, userCode string) {
+	h.Redis.Del(ctx,
+		common.GetDeviceCodeKey(deviceCode),
+		common.GetDeviceUserCodeKey(userCode),
+		common.GetDevicePollKey(deviceCode))
+}
+
+// generateDeviceUserCode generates a user code in the format XXXX-XXXX
+func generateDeviceUserCode() (string, error) {
+	var sb strings.Builder
+	alphabetLen := big.NewInt(int64(len(deviceUserCodeAlphabet)))
+	for i := 0; i < deviceUserCodeLength; i++ {
+		if i == deviceUserCodeLength/2 {
+			sb.WriteByte('-')
+		}
+		n, err := rand.Int(rand.Reader, alphabetLen)
+		if err != nil {
+			return "", err
+		}
+		sb.WriteByte(deviceUserCodeAlphabet[n.Int64()])
+	}
+	return sb.String(), nil
+}
+
+// normalizeDeviceUserCode makes user input like "bcdf ghjk" match "BCDF-GHJK"
+func normalizeDeviceUserCode(code string) string {
+	code = strings.ToUpper(code)
+	code = strings.NewReplacer("-", "", " ", "").Replace(code)
+	if len(code) != deviceUserCodeLength {
+		return code
+	}
+	return code[:deviceUserCodeLength/2] + "-" + code[deviceUserCodeLength/2:]
+}
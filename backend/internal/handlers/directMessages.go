@@ -0,0 +1,280 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hopp-backend/internal/common"
+	"hopp-backend/internal/messages"
+	"hopp-backend/internal/models"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// directMessagesMaxPerPage caps the page size of ListDirectMessages, also used as the default
+const directMessagesMaxPerPage = 100
+
+// sendDirectMessage stores the text message of the user to a teammate and delivers it to the
+// connections of both, so the other devices of the sender show it too
+func sendDirectMessage(ctx echo.Context, s *common.ServerState, sender *models.User, payload messages.DirectMessagePayload) error {
+	body := strings.TrimSpace(payload.Body)
+	if body == "" {
+		return errors.New("Message can't be empty")
+	}
+
+	if payload.RecipientID == sender.ID || !models.AreTeammates(s.DB, sender.ID, payload.RecipientID) {
+		return errors.New("Teammate not found")
+	}
+
+	directMessage, err := models.CreateDirectMessage(s.DB, sender.ID, payload.RecipientID, body)
+	if err != nil {
+		ctx.Logger().Error("Failed to store direct message: ", err)
+		return errMessageFailed
+	}
+
+	msgJSON, err := json.Marshal(messages.NewDirectMessageReceivedMessage(directMessage.ID, sender.ID, sender.GetDisplayName(),
+		directMessage.RecipientID, directMessage.Body, directMessage.CreatedAt))
+	if err != nil {
+		ctx.Logger().Error(err)
+		return errMessageFailed
+	}
+
+	incrementUnread(s, common.GetUnreadMessagesKey(directMessage.RecipientID), sender.ID, 1)
+
+	publishToUser(s, directMessage.RecipientID, msgJSON)
+	publishToUser(s, sender.ID, msgJSON)
+	sendDirectMessageLinkPreview(s, sender.ID, directMessage.RecipientID, directMessage.ID, directMessage.Body)
+
+	return nil
+}
+
+// ListDirectMessages returns the messages between the user and a teammate, newest first,
+// paginated with page and per_page. The total number of messages is returned in the
+// X-Total-Count header.
+func (h *AuthHandler) ListDirectMessages(c echo.Context) error {
+	user, isAuthenticated := h.getAuthenticatedUserFromJWT(c)
+	if !isAuthenticated {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
+	}
+
+	teammateID := c.Param("id")
+	if !models.AreTeammates(h.DB, user.ID, teammateID) {
+		return echo.NewHTTPError(http.StatusNotFound, "Teammate not found")
+	}
+
+	page, err := parsePositiveQueryParam(c, "page", 1)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid page")
+	}
+
+	perPage, err := parsePositiveQueryParam(c, "per_page", directMessagesMaxPerPage)
+	if err != nil || perPage > directMessagesMaxPerPage {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("per_page must be between 1 and %d", directMessagesMaxPerPage))
+	}
+
+	var total int64
+	if err := models.ConversationQuery(h.DB, user.ID, teammateID).Count(&total).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get messages")
+	}
+
+	var directMessages []models.DirectMessage
+	err = models.ConversationQuery(h.DB, user.ID, teammateID).
+		Order("created_at DESC").
+		Limit(perPage).
+		Offset((page - 1) * perPage).
+		Find(&directMessages).Error
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get messages")
+	}
+
+	messageIDs := make([]uint, len(directMessages))
+	for i, directMessage := range directMessages {
+		messageIDs[i] = directMessage.ID
+	}
+
+	reactions, err := models.GetReactionCounts(h.DB, messageIDs)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get messages")
+	}
+
+	for i := range directMessages {
+		directMessages[i].Reactions = reactions[directMessages[i].ID]
+	}
+
+	c.Response().Header().Set("X-Total-Count", strconv.FormatInt(total, 10))
+
+	return c.JSON(http.StatusOK, directMessages)
+}
+
+// MarkDirectMessagesRead marks the messages a teammate sent to the user as read
+func (h *AuthHandler) MarkDirectMessagesRead(c echo.Context) error {
+	user, isAuthenticated := h.getAuthenticatedUserFromJWT(c)
+	if !isAuthenticated {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
+	}
+
+	teammateID := c.Param("id")
+	if !models.AreTeammates(h.DB, user.ID, teammateID) {
+		return echo.NewHTTPError(http.StatusNotFound, "Teammate not found")
+	}
+
+	read, err := models.MarkConversationRead(h.DB, user.ID, teammateID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to mark messages as read")
+	}
+
+	readDirectMessages(&h.ServerState, user.ID, read)
+	notifyDirectMessageState(&h.ServerState, user, read, models.DirectMessageRead)
+
+	return c.NoContent(http.StatusOK)
+}
+
+// acknowledgeDirectMessages marks the messages the user received as delivered or read,
+// as the client acknowledges them
+func acknowledgeDirectMessages(ctx echo.Context, s *common.ServerState, user *models.User, payload messages.DirectMessageAckPayload) error {
+	var (
+		updated []models.DirectMessage
+		err     error
+	)
+
+	state := models.DirectMessageState(payload.State)
+	if state == models.DirectMessageRead {
+		updated, err = models.MarkDirectMessagesRead(s.DB, user.ID, payload.MessageIDs)
+	} else {
+		updated, err = models.MarkDirectMessagesDelivered(s.DB, user.ID, payload.MessageIDs)
+	}
+	if err != nil {
+		ctx.Logger().Error("Failed to update direct messages: ", err)
+		return errMessageFailed
+	}
+
+	if state == models.DirectMessageRead {
+		readDirectMessages(s, user.ID, updated)
+	}
+
+	notifyDirectMessageState(s, user, updated, state)
+
+	return nil
+}
+
+// notifyDirectMessageState tells the senders of the messages that the recipient received or read them
+func notifyDirectMessageState(s *common.ServerState, recipient *models.User, updated []models.DirectMessage, state models.DirectMessageState) {
+	messageIDs := make(map[string][]uint)
+	for _, directMessage := range updated {
+		messageIDs[directMessage.SenderID] = append(messageIDs[directMessage.SenderID], directMessage.ID)
+	}
+
+	now := time.Now()
+	for senderID, ids := range messageIDs {
+		msgJSON, err := json.Marshal(messages.NewDirectMessageStateMessage(ids, recipient.ID, string(state), now))
+		if err != nil {
+			s.Echo.Logger.Error(err)
+			continue
+		}
+
+		publishToUser(s, senderID, msgJSON)
+	}
+}
+
+// AddDirectMessageReaction reacts to a message of a conversation of the user with an emoji
+func (h *AuthHandler) AddDirectMessageReaction(c echo.Context) error {
+	user, isAuthenticated := h.getAuthenticatedUserFromJWT(c)
+	if !isAuthenticated {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
+	}
+
+	type ReactionRequest struct {
+		Emoji string `json:"emoji" validate:"required,max=32"`
+	}
+
+	req := new(ReactionRequest)
+	if err := c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request format")
+	}
+
+	if err := c.Validate(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	directMessage, err := h.getConversationMessage(c, user)
+	if err != nil {
+		return err
+	}
+
+	if err := models.AddDirectMessageReaction(h.DB, directMessage.ID, user.ID, req.Emoji); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to add reaction")
+	}
+
+	return h.reactionsChanged(c, user, directMessage, req.Emoji, true)
+}
+
+// RemoveDirectMessageReaction removes the reaction of the user with the emoji query param from a message
+func (h *AuthHandler) RemoveDirectMessageReaction(c echo.Context) error {
+	user, isAuthenticated := h.getAuthenticatedUserFromJWT(c)
+	if !isAuthenticated {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
+	}
+
+	emoji := c.QueryParam("emoji")
+	if emoji == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "Missing emoji")
+	}
+
+	directMessage, err := h.getConversationMessage(c, user)
+	if err != nil {
+		return err
+	}
+
+	if err := models.RemoveDirectMessageReaction(h.DB, directMessage.ID, user.ID, emoji); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to remove reaction")
+	}
+
+	return h.reactionsChanged(c, user, directMessage, emoji, false)
+}
+
+// getConversationMessage returns the direct message of the id param, if the user sent or received it
+func (h *AuthHandler) getConversationMessage(c echo.Context, user *models.User) (*models.DirectMessage, error) {
+	var directMessage models.DirectMessage
+	result := h.DB.Where("id = ? AND (sender_id = ? OR recipient_id = ?)", c.Param("id"), user.ID, user.ID).First(&directMessage)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return nil, echo.NewHTTPError(http.StatusNotFound, "Message not found")
+	}
+	if result.Error != nil {
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "Failed to get message")
+	}
+
+	return &directMessage, nil
+}
+
+// reactionsChanged tells both sides of the conversation that the user reacted to the
+// message, or took the reaction back, and responds with the reactions of the message
+func (h *AuthHandler) reactionsChanged(c echo.Context, user *models.User, directMessage *models.DirectMessage, emoji string, added bool) error {
+	reactions, err := models.GetReactionCounts(h.DB, []uint{directMessage.ID})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get reactions")
+	}
+
+	count := 0
+	for _, reaction := range reactions[directMessage.ID] {
+		if reaction.Emoji == emoji {
+			count = reaction.Count
+		}
+	}
+
+	msgJSON, err := json.Marshal(messages.NewDirectMessageReactionMessage(directMessage.ID, user.ID, emoji, added, count))
+	if err != nil {
+		c.Logger().Error(err)
+	} else {
+		publishToUser(&h.ServerState, directMessage.OtherParticipant(user.ID), msgJSON)
+		publishToUser(&h.ServerState, user.ID, msgJSON)
+	}
+
+	directMessage.Reactions = reactions[directMessage.ID]
+
+	return c.JSON(http.StatusOK, directMessage)
+}
@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"context"
+	"hopp-backend/internal/common"
+	"hopp-backend/internal/models"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/redis/go-redis/v9"
+)
+
+// UpdateDoNotDisturb turns the do not disturb mode of the user on or off,
+// optionally turning it off by itself after a duration
+func (h *AuthHandler) UpdateDoNotDisturb(c echo.Context) error {
+	user, isAuthenticated := h.getAuthenticatedUserFromJWT(c)
+	if !isAuthenticated {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
+	}
+
+	type DoNotDisturbRequest struct {
+		Enabled bool `json:"enabled"`
+		// Minutes until do not disturb turns off, 0 keeps it on until it is turned off
+		DurationMinutes int `json:"duration_minutes" validate:"min=0,max=10080"`
+	}
+
+	req := new(DoNotDisturbRequest)
+	if err := c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request")
+	}
+
+	if err := c.Validate(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	var until *time.Time
+	if req.Enabled && req.DurationMinutes > 0 {
+		expiresAt := time.Now().Add(time.Duration(req.DurationMinutes) * time.Minute)
+		until = &expiresAt
+	}
+
+	err := h.DB.Model(user).Updates(map[string]interface{}{
+		"do_not_disturb":       req.Enabled,
+		"do_not_disturb_until": until,
+	}).Error
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to update do not disturb")
+	}
+	user.DoNotDisturb = req.Enabled
+	user.DoNotDisturbUntil = until
+
+	if err := setDoNotDisturb(h.Redis, user.ID, req.Enabled, until); err != nil {
+		c.Logger().Error("Failed to store do not disturb in Redis: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to update do not disturb")
+	}
+
+	emitTeamWebhook(&h.ServerState, user.TeamID, models.WebhookEventUserDND, dndWebhookData{
+		UserID:  user.ID,
+		Enabled: req.Enabled,
+		Until:   until,
+	})
+
+	return c.JSON(http.StatusOK, user)
+}
+
+// setDoNotDisturb mirrors the do not disturb mode of the user in Redis,
+// where it is checked on every call
+func setDoNotDisturb(rdb *redis.Client, userID string, enabled bool, until *time.Time) error {
+	key := common.GetDoNotDisturbKey(userID)
+	if !enabled {
+		return rdb.Del(context.Background(), key).Err()
+	}
+
+	if until == nil {
+		return rdb.Set(context.Background(), key, "", 0).Err()
+	}
+
+	return rdb.Set(context.Background(), key, until.Format(time.RFC3339), time.Until(*until)).Err()
+}
+
+// getDoNotDisturb checks if the user is in do not disturb mode, along with
+// when it turns off, nil if it doesn't turn off by itself
+func getDoNotDisturb(s *common.ServerState, userID string) (bool, *time.Time) {
+	value, err := s.Redis.Get(context.Background(), common.GetDoNotDisturbKey(userID)).Result()
+	if err == redis.Nil {
+		return false, nil
+	}
+	if err != nil {
+		s.Echo.Logger.Error("Failed to check do not disturb: ", err)
+		return false, nil
+	}
+
+	until, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return true, nil
+	}
+
+	return true, &until
+}
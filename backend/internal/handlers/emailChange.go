@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"hopp-backend/internal/models"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// emailChangeTTL is how long the confirmation links of an email change are valid
+const emailChangeTTL = 24 * time.Hour
+
+// RequestEmailChange starts an email change for the authenticated user.
+// A confirmation link is sent to both the current and the new address.
+func (h *AuthHandler) RequestEmailChange(c echo.Context) error {
+	user, isAuthenticated := h.getAuthenticatedUserFromJWT(c)
+	if !isAuthenticated {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
+	}
+
+	// The email is how the account is recovered, only the user themselves can move it
+	if isAPIKeyRequest(c) || isImpersonationRequest(c) {
+		return echo.NewHTTPError(http.StatusForbidden, "Forbidden")
+	}
+
+	type ChangeEmailRequest struct {
+		NewEmail string `json:"new_email" validate:"required,email"`
+	}
+
+	req := new(ChangeEmailRequest)
+	if err := c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request format")
+	}
+
+	if err := c.Validate(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid email address")
+	}
+
+	if req.NewEmail == user.Email {
+		return echo.NewHTTPError(http.StatusBadRequest, "New email is the same as the current one")
+	}
+
+	if !h.isEmailDomainAllowed(req.NewEmail) {
+		return echo.NewHTTPError(http.StatusForbidden, "Emails are restricted to specific domains on this instance")
+	}
+
+	if _, err := models.GetUserByEmail(h.DB, req.NewEmail); err == nil {
+		return echo.NewHTTPError(http.StatusConflict, "user with this email already exists")
+	}
+
+	oldToken, oldTokenHash := models.NewSecretToken()
+	newToken, newTokenHash := models.NewSecretToken()
+
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		// Only the latest request of the user is valid
+		if err := tx.Where("user_id = ?", user.ID).Delete(&models.EmailChange{}).Error; err != nil {
+			return err
+		}
+
+		return tx.Create(&models.EmailChange{
+			UserID:        user.ID,
+			NewEmail:      req.NewEmail,
+			OldEmailToken: oldTokenHash,
+			NewEmailToken: newTokenHash,
+			ExpiresAt:     time.Now().Add(emailChangeTTL),
+		}).Error
+	})
+	if err != nil {
+		c.Logger().Error("Failed to create email change: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to request email change")
+	}
+
+	if h.EmailClient != nil {
+		baseURL := fmt.Sprintf("https://%s/api/email-change/confirm?token=", h.Config.Server.DeployDomain)
+		h.EmailClient.SendEmailChangeConfirmation(user, req.NewEmail, baseURL+oldToken, user.Email)
+		h.EmailClient.SendEmailChangeConfirmation(user, req.NewEmail, baseURL+newToken, req.NewEmail)
+	}
+
+	return c.NoContent(http.StatusOK)
+}
+
+// ShowEmailChangeConfirmation is opened from the links of the confirmation
+// emails, the page it renders submits the token to ConfirmEmailChange
+func (h *AuthHandler) ShowEmailChangeConfirmation(c echo.Context) error {
+	return renderLinkConfirmation(c, "Confirm your email change",
+		"Confirm that you want to change the email address of your Hopp account.", "Confirm email change")
+}
+
+// ConfirmEmailChange confirms the email change for the address the token was sent to.
+// Once both addresses have confirmed, the email is swapped and all the
+// user's sessions are revoked as their JWTs carry the old email.
+func (h *AuthHandler) ConfirmEmailChange(c echo.Context) error {
+	token := c.FormValue("token")
+	if token == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "Missing token parameter")
+	}
+	tokenHash := models.HashToken(token)
+
+	var change models.EmailChange
+	result := h.DB.Preload("User").
+		Where("(old_email_token = ? OR new_email_token = ?) AND expires_at > ?", tokenHash, tokenHash, time.Now()).
+		First(&change)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return echo.NewHTTPError(http.StatusNotFound, "Email change not found or has expired")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to confirm email change")
+	}
+
+	now := time.Now()
+	if change.OldEmailToken == tokenHash {
+		change.OldEmailConfirmedAt = &now
+	} else {
+		change.NewEmailConfirmedAt = &now
+	}
+
+	if !change.IsConfirmed() {
+		if err := h.DB.Save(&change).Error; err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to confirm email change")
+		}
+		return c.Redirect(http.StatusFound, "/login?email_change=pending")
+	}
+
+	oldEmail := change.User.Email
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		// The new address confirmed the change, so the user owns it
+		err := tx.Model(&change.User).Updates(map[string]interface{}{
+			"email":             change.NewEmail,
+			"email_verified_at": now,
+		}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Delete(&change).Error
+	})
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		return echo.NewHTTPError(http.StatusConflict, "user with this email already exists")
+	}
+	if err != nil {
+		c.Logger().Error("Failed to swap user email: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to confirm email change")
+	}
+
+	setAuditActor(c, &change.User)
+	recordAuditChange(c, "email", oldEmail, change.NewEmail)
+
+	if err := h.revokeAllSessions(c, change.UserID); err != nil {
+		c.Logger().Error("Failed to revoke sessions after email change: ", err)
+	}
+
+	return c.Redirect(http.StatusFound, "/login?email_change=confirmed")
+}
@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"hopp-backend/internal/email"
+	"hopp-backend/internal/models"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// EmailPreferencesResponse is whether the user gets the emails of each category
+type EmailPreferencesResponse struct {
+	Categories map[models.EmailCategory]bool `json:"categories"`
+}
+
+// UnsubscribeEmail is opened from the unsubscribe links of the emails, and posted to
+// by mail clients for the one-click unsubscribe of the List-Unsubscribe header
+func (h *AuthHandler) UnsubscribeEmail(c echo.Context) error {
+	token := c.QueryParam("token")
+	if token == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "Missing token parameter")
+	}
+
+	links := email.NewUnsubscribeLinks(h.Config.Server.DeployDomain, h.Config.Resend.UnsubscribeSecret)
+	toEmail, category, err := links.Verify(token)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid unsubscribe link")
+	}
+
+	if err := models.UnsubscribeEmail(h.DB, toEmail, category); err != nil {
+		c.Logger().Error("Failed to unsubscribe email: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to unsubscribe")
+	}
+
+	if c.Request().Method == http.MethodPost {
+		return c.NoContent(http.StatusOK)
+	}
+
+	return c.Redirect(http.StatusFound, "/login?unsubscribed="+string(category))
+}
+
+// GetEmailPreferences returns the categories of emails the user gets
+func (h *AuthHandler) GetEmailPreferences(c echo.Context) error {
+	user, isAuthenticated := h.getAuthenticatedUserFromJWT(c)
+	if !isAuthenticated {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
+	}
+
+	preferences, err := getEmailPreferences(h.DB, user.Email)
+	if err != nil {
+		c.Logger().Error("Failed to get email preferences: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get email preferences")
+	}
+
+	return c.JSON(http.StatusOK, preferences)
+}
+
+// UpdateEmailPreferences subscribes the user to, or unsubscribes them from, categories
+// of emails. The categories left out of the request stay as they are.
+func (h *AuthHandler) UpdateEmailPreferences(c echo.Context) error {
+	user, isAuthenticated := h.getAuthenticatedUserFromJWT(c)
+	if !isAuthenticated {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
+	}
+
+	type EmailPreferencesRequest struct {
+		Categories map[models.EmailCategory]bool `json:"categories" validate:"required"`
+	}
+
+	req := new(EmailPreferencesRequest)
+	if err := c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request")
+	}
+
+	if err := c.Validate(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	for category := range req.Categories {
+		if !models.IsValidEmailCategory(category) {
+			return echo.NewHTTPError(http.StatusBadRequest, "Unknown email category: "+string(category))
+		}
+	}
+
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		for category, subscribed := range req.Categories {
+			var err error
+			if subscribed {
+				err = models.ResubscribeEmail(tx, user.Email, category)
+			} else {
+				err = models.UnsubscribeEmail(tx, user.Email, category)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		c.Logger().Error("Failed to update email preferences: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to update email preferences")
+	}
+
+	preferences, err := getEmailPreferences(h.DB, user.Email)
+	if err != nil {
+		c.Logger().Error("Failed to get email preferences: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get email preferences")
+	}
+
+	return c.JSON(http.StatusOK, preferences)
+}
+
+// getEmailPreferences returns whether the address gets the emails of each category
+func getEmailPreferences(db *gorm.DB, toEmail string) (*EmailPreferencesResponse, error) {
+	unsubscribed, err := models.GetUnsubscribedCategories(db, toEmail)
+	if err != nil {
+		return nil, err
+	}
+
+	preferences := &EmailPreferencesResponse{Categories: make(map[models.EmailCategory]bool, len(models.EmailCategories))}
+	for _, category := range models.EmailCategories {
+		preferences.Categories[category] = true
+	}
+	for _, category := range unsubscribed {
+		preferences.Categories[category] = false
+	}
+
+	return preferences, nil
+}
@@ -0,0 +1,190 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hopp-backend/internal/common"
+	"hopp-backend/internal/messages"
+	"hopp-backend/internal/models"
+	"io"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// maxFileTransferSize is the maximum size of a file or snippet sent to a teammate,
+	// they are for patches and logs, not for large files
+	maxFileTransferSize = 10 << 20 // 10MB
+	// fileTransferURLTTL is how long the download URL the recipient gets is valid,
+	// the file is deleted once it expires
+	fileTransferURLTTL = time.Hour
+	// fileTransferCleanupInterval is how often the expired file transfers are deleted
+	fileTransferCleanupInterval = 5 * time.Minute
+)
+
+// unsafeFileNameChars are the characters replaced in the names of the transferred files
+var unsafeFileNameChars = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// SendFileTransfer uploads a small file, or a code snippet, for a teammate and sends
+// them a file_transfer websocket message with a signed URL to download it from.
+// The multipart form has the recipient_id and either a file or a snippet field,
+// snippets are named after the optional filename field.
+func (h *AuthHandler) SendFileTransfer(c echo.Context) error {
+	user, isAuthenticated := h.getAuthenticatedUserFromJWT(c)
+	if !isAuthenticated {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
+	}
+
+	if h.PrivateStorage == nil {
+		return echo.NewHTTPError(http.StatusServiceUnavailable, "File transfers are not enabled")
+	}
+
+	recipientID := c.FormValue("recipient_id")
+	if recipientID == "" || recipientID == user.ID || !models.AreTeammates(h.DB, user.ID, recipientID) {
+		return echo.NewHTTPError(http.StatusNotFound, "Teammate not found")
+	}
+
+	var (
+		body        io.Reader
+		size        int64
+		fileName    string
+		contentType string
+	)
+
+	if fileHeader, err := c.FormFile("file"); err == nil {
+		if fileHeader.Size > maxFileTransferSize {
+			return echo.NewHTTPError(http.StatusRequestEntityTooLarge, "File must be smaller than 10MB")
+		}
+
+		file, err := fileHeader.Open()
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Failed to read file")
+		}
+		defer file.Close()
+
+		// Don't trust the client's content type, sniff it from the file
+		head := make([]byte, 512)
+		n, err := io.ReadFull(file, head)
+		if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+			return echo.NewHTTPError(http.StatusBadRequest, "Failed to read file")
+		}
+		contentType = http.DetectContentType(head[:n])
+
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to read file")
+		}
+
+		body = file
+		size = fileHeader.Size
+		fileName = fileHeader.Filename
+	} else {
+		snippet := c.FormValue("snippet")
+		if snippet == "" {
+			return echo.NewHTTPError(http.StatusBadRequest, "Missing file or snippet")
+		}
+		if len(snippet) > maxFileTransferSize {
+			return echo.NewHTTPError(http.StatusRequestEntityTooLarge, "Snippet must be smaller than 10MB")
+		}
+
+		body = strings.NewReader(snippet)
+		size = int64(len(snippet))
+		fileName = c.FormValue("filename")
+		if fileName == "" {
+			fileName = "snippet.txt"
+		}
+		contentType = "text/plain; charset=utf-8"
+	}
+
+	fileName = unsafeFileNameChars.ReplaceAllString(filepath.Base(fileName), "_")
+	if strings.Trim(fileName, ".") == "" {
+		fileName = "file"
+	}
+
+	transferID, err := uuid.NewV7()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to upload file")
+	}
+	key := fmt.Sprintf("transfers/%s/%s", transferID.String(), fileName)
+
+	// The checksum is computed while the file streams to the storage
+	hash := sha256.New()
+	if _, err := h.PrivateStorage.Upload(c.Request().Context(), key, contentType, io.TeeReader(body, hash), size); err != nil {
+		c.Logger().Error("Failed to upload file transfer: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to upload file")
+	}
+
+	expiresAt := time.Now().Add(fileTransferURLTTL)
+	if err := h.Redis.ZAdd(c.Request().Context(), common.GetFileTransfersKey(), redis.Z{
+		Score:  float64(expiresAt.Unix()),
+		Member: key,
+	}).Err(); err != nil {
+		c.Logger().Error("Failed to schedule file transfer cleanup: ", err)
+		if err := h.PrivateStorage.Delete(c.Request().Context(), key); err != nil {
+			c.Logger().Error("Failed to delete file transfer: ", err)
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to upload file")
+	}
+
+	downloadURL, err := h.PrivateStorage.PresignGet(key, fileTransferURLTTL)
+	if err != nil {
+		c.Logger().Error("Failed to sign file transfer URL: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to upload file")
+	}
+
+	msg := messages.NewFileTransferMessage(user.ID, user.GetDisplayName(), fileName, contentType, size,
+		hex.EncodeToString(hash.Sum(nil)), downloadURL, expiresAt)
+	if err := relayToUser(&h.ServerState, user, recipientID, msg); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to send file")
+	}
+
+	return c.JSON(http.StatusOK, msg.Payload)
+}
+
+// StartFileTransferCleanup periodically deletes the transferred files whose download URL expired
+func StartFileTransferCleanup(s *common.ServerState) {
+	if s.PrivateStorage == nil {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(fileTransferCleanupInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			cleanupFileTransfers(s)
+		}
+	}()
+}
+
+func cleanupFileTransfers(s *common.ServerState) {
+	ctx := context.Background()
+	keys, err := s.Redis.ZRangeByScore(ctx, common.GetFileTransfersKey(), &redis.ZRangeBy{
+		Min: "-inf",
+		Max: strconv.FormatInt(time.Now().Unix(), 10),
+	}).Result()
+	if err != nil {
+		s.Echo.Logger.Error("Failed to list expired file transfers: ", err)
+		return
+	}
+
+	for _, key := range keys {
+		if err := s.PrivateStorage.Delete(ctx, key); err != nil {
+			// Keep it in the set, the next run retries
+			s.Echo.Logger.Error("Failed to delete file transfer: ", err)
+			continue
+		}
+		if err := s.Redis.ZRem(ctx, common.GetFileTransfersKey(), key).Err(); err != nil {
+			s.Echo.Logger.Error("Failed to remove deleted file transfer: ", err)
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"hopp-backend/internal/common"
+	"hopp-backend/internal/messages"
+	"hopp-backend/internal/models"
+	"hopp-backend/internal/presence"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+)
+
+// groupCallTTL bounds how long the roster of an abandoned group call is kept
+const groupCallTTL = 24 * time.Hour
+
+// inviteToGroupCall invites the callees to a group call. Without a room name
+// a new group call is started with the caller as its first participant.
+func inviteToGroupCall(ctx echo.Context, s *common.ServerState, callerID string, message messages.GroupCallInviteMessage) error {
+	rdbCtx := context.Background()
+
+	roomName := message.Payload.RoomName
+	isNewCall := roomName == ""
+	if isNewCall {
+		roomName = "group-" + uuid.New().String()
+	} else {
+		isParticipant, err := s.Redis.SIsMember(rdbCtx, common.GetGroupCallParticipantsKey(roomName), callerID).Result()
+		if err != nil || !isParticipant {
+			return errors.New("Only participants can invite to the group call")
+		}
+	}
+
+	participantsKey := common.GetGroupCallParticipantsKey(roomName)
+	invitedKey := common.GetGroupCallInvitedKey(roomName)
+
+	var calleeIDs []string
+	pipe := s.Redis.TxPipeline()
+	if isNewCall {
+		pipe.SAdd(rdbCtx, participantsKey, callerID)
+	}
+	for _, calleeID := range message.Payload.CalleeIDs {
+		// Only teammates are invited, nobody else gets rung or a missed call
+		if !isTeammateRecipient(s, callerID, calleeID) {
+			continue
+		}
+
+		// Offline callees can't join, they get a missed call instead
+		online, err := presence.IsOnline(rdbCtx, s.Redis, calleeID)
+		if err != nil {
+			ctx.Logger().Error("Error checking presence: ", err)
+			continue
+		}
+		if !online {
+			recordMissedCall(s, callerID, calleeID, models.MissedCallCalleeOffline)
+			continue
+		}
+
+		if dnd, until := getDoNotDisturb(s, calleeID); dnd {
+			msgJSON, err := json.Marshal(messages.NewCalleeDNDMessage(calleeID, until))
+			if err != nil {
+				ctx.Logger().Error(err)
+				continue
+			}
+			publishToUser(s, callerID, msgJSON)
+			continue
+		}
+
+		if isInCall(s, calleeID) {
+			msgJSON, err := json.Marshal(messages.NewCalleeBusyMessage(calleeID))
+			if err != nil {
+				ctx.Logger().Error(err)
+				continue
+			}
+			publishToUser(s, callerID, msgJSON)
+			continue
+		}
+
+		pipe.SAdd(rdbCtx, invitedKey, calleeID)
+		calleeIDs = append(calleeIDs, calleeID)
+	}
+	pipe.Expire(rdbCtx, participantsKey, groupCallTTL)
+	pipe.Expire(rdbCtx, invitedKey, groupCallTTL)
+	if _, err := pipe.Exec(rdbCtx); err != nil {
+		ctx.Logger().Error("Failed to update group call: ", err)
+		return errors.New("Failed to start group call")
+	}
+
+	if isNewCall {
+		if err := sendGroupCallTokens(s, roomName, callerID); err != nil {
+			ctx.Logger().Error(err)
+			return errors.New("Failed to generate group call tokens")
+		}
+	}
+
+	participantIDs, invitedIDs, err := getGroupCallRoster(s, roomName)
+	if err != nil {
+		ctx.Logger().Error("Failed to get group call roster: ", err)
+		return errMessageFailed
+	}
+
+	msgJSON, err := json.Marshal(messages.NewIncomingGroupCallMessage(roomName, callerID, participantIDs))
+	if err != nil {
+		ctx.Logger().Error(err)
+		return errMessageFailed
+	}
+	for _, calleeID := range calleeIDs {
+		publishToUser(s, calleeID, msgJSON)
+	}
+
+	publishGroupCallRoster(ctx, s, roomName, participantIDs, invitedIDs)
+
+	return nil
+}
+
+// joinGroupCall adds an invited user to the group call and sends them their tokens
+func joinGroupCall(ctx echo.Context, s *common.ServerState, userID string, message messages.GroupCallJoinMessage) error {
+	rdbCtx := context.Background()
+	roomName := message.Payload.RoomName
+
+	// Removing the invitation makes sure it is only used once
+	removed, err := s.Redis.SRem(rdbCtx, common.GetGroupCallInvitedKey(roomName), userID).Result()
+	if err != nil || removed == 0 {
+		return errors.New("You are not invited to this group call")
+	}
+
+	if err := s.Redis.SAdd(rdbCtx, common.GetGroupCallParticipantsKey(roomName), userID).Err(); err != nil {
+		ctx.Logger().Error("Failed to join group call: ", err)
+		return errors.New("Failed to join group call")
+	}
+
+	if err := sendGroupCallTokens(s, roomName, userID); err != nil {
+		ctx.Logger().Error(err)
+		return errors.New("Failed to generate group call tokens")
+	}
+
+	participantIDs, invitedIDs, err := getGroupCallRoster(s, roomName)
+	if err != nil {
+		ctx.Logger().Error("Failed to get group call roster: ", err)
+		return errMessageFailed
+	}
+	publishGroupCallRoster(ctx, s, roomName, participantIDs, invitedIDs)
+
+	return nil
+}
+
+// leaveGroupCall removes the user from the group call, or declines the invitation
+func leaveGroupCall(ctx echo.Context, s *common.ServerState, userID string, message messages.GroupCallLeaveMessage) error {
+	rdbCtx := context.Background()
+	roomName := message.Payload.RoomName
+	participantsKey := common.GetGroupCallParticipantsKey(roomName)
+	invitedKey := common.GetGroupCallInvitedKey(roomName)
+
+	pipe := s.Redis.TxPipeline()
+	removedParticipant := pipe.SRem(rdbCtx, participantsKey, userID)
+	removedInvited := pipe.SRem(rdbCtx, invitedKey, userID)
+	if _, err := pipe.Exec(rdbCtx); err != nil {
+		ctx.Logger().Error("Failed to leave group call: ", err)
+		return errors.New("Failed to leave group call")
+	}
+	if removedParticipant.Val() == 0 && removedInvited.Val() == 0 {
+		return nil
+	}
+	if removedParticipant.Val() > 0 {
+		clearInCall(s, userID)
+	}
+
+	participantIDs, invitedIDs, err := getGroupCallRoster(s, roomName)
+	if err != nil {
+		ctx.Logger().Error("Failed to get group call roster: ", err)
+		return errMessageFailed
+	}
+
+	// The call is over once everyone left, the empty roster stops
+	// the invited users from ringing
+	if len(participantIDs) == 0 {
+		s.Redis.Del(rdbCtx, participantsKey, invitedKey)
+		removeDialIn(s, roomName)
+	}
+
+	publishGroupCallRoster(ctx, s, roomName, participantIDs, invitedIDs)
+
+	return nil
+}
+
+// sendGroupCallTokens generates the LiveKit tokens of a participant for the
+// shared group call room and publishes them to the participant
+func sendGroupCallTokens(s *common.ServerState, roomName, userID string) error {
+	user, err := models.GetUserByID(s.DB, userID)
+	if err != nil {
+		return err
+	}
+
+	tokens, err := generateLiveKitTokens(s, roomName, user)
+	if err != nil {
+		return err
+	}
+	tokens.Participant = userID
+
+	msgJSON, err := json.Marshal(messages.NewGroupCallTokensMessage(roomName, tokens))
+	if err != nil {
+		return err
+	}
+
+	if err := publishToUser(s, userID, msgJSON); err != nil {
+		return err
+	}
+	markInCall(s, roomName, userID)
+
+	return nil
+}
+
+// getGroupCallRoster returns the participants and the pending invitees of a group call
+func getGroupCallRoster(s *common.ServerState, roomName string) ([]string, []string, error) {
+	rdbCtx := context.Background()
+
+	participantIDs, err := s.Redis.SMembers(rdbCtx, common.GetGroupCallParticipantsKey(roomName)).Result()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	invitedIDs, err := s.Redis.SMembers(rdbCtx, common.GetGroupCallInvitedKey(roomName)).Result()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return participantIDs, invitedIDs, nil
+}
+
+// publishGroupCallRoster pushes the roster to everyone in or invited to the group call
+func publishGroupCallRoster(ctx echo.Context, s *common.ServerState, roomName string, participantIDs, invitedIDs []string) {
+	msgJSON, err := json.Marshal(messages.NewGroupCallRosterMessage(roomName, participantIDs, invitedIDs))
+	if err != nil {
+		ctx.Logger().Error(err)
+		return
+	}
+
+	for _, userID := range append(append([]string{}, participantIDs...), invitedIDs...) {
+		publishToUser(s, userID, msgJSON)
+	}
+}
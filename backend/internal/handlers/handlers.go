@@ -1,8 +1,8 @@
 package handlers
 
 import (
-	"context"
 	"crypto/rand"
+	"crypto/subtle"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -10,11 +10,15 @@ import (
 	"hopp-backend/internal/config"
 	"hopp-backend/internal/models"
 	"hopp-backend/internal/notifications"
+	"hopp-backend/internal/password"
+	"hopp-backend/internal/presence"
+	"math"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
-	"github.com/google/uuid"
 	"github.com/labstack/echo-contrib/session"
 	"github.com/labstack/echo/v4"
 	"github.com/markbates/goth/gothic"
@@ -43,24 +47,83 @@ func NewAuthHandler(db *gorm.DB, cfg *config.Config, jwt common.JWTIssuer, redis
 	}
 }
 
+var (
+	// errProviderEmailMissing is returned when signing up with a provider account without an email
+	errProviderEmailMissing = errors.New("provider account has no email")
+	// errProviderEmailTaken is returned when signing in with a provider account whose
+	// email belongs to another user, for providers that can't be trusted with emails
+	errProviderEmailTaken = errors.New("email belongs to another user")
+)
+
 func (h *AuthHandler) SocialLoginCallback(c echo.Context) error {
 	user, err := gothic.CompleteUserAuth(c.Response(), c.Request())
 	if err != nil {
 		return err
 	}
 
+	// Microsoft accounts are matched by their tenant and object IDs, never by email
+	if c.Param("provider") == "microsoft" {
+		accountID, err := getMicrosoftAccountID(user.AccessToken, user.UserID)
+		if err != nil {
+			c.Logger().Error("Failed to identify Microsoft account: ", err)
+			return echo.NewHTTPError(http.StatusBadRequest, "Failed to identify your Microsoft account")
+		}
+
+		// Identities linked before were keyed by the object ID alone
+		if err := models.RekeyIdentity(h.DB, "microsoft", user.UserID, accountID); err != nil {
+			c.Logger().Error("Failed to update Microsoft identity: ", err)
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to sign in")
+		}
+		user.UserID = accountID
+	}
+
+	// The user is linking a new provider to their existing account
+	if linkUserID, ok := h.getIdentityLinkUserID(c); ok {
+		return h.completeIdentityLink(c, linkUserID, user)
+	}
+
+	// Only new registrations are restricted, existing users keep their access
+	domainAllowed := h.isEmailDomainAllowed(user.Email)
+	if !domainAllowed || h.Config.Auth.InviteOnly {
+		_, identityErr := models.GetUserByIdentity(h.DB, c.Param("provider"), user.UserID)
+		_, emailErr := models.GetUserByEmail(h.DB, user.Email)
+		isNewRegistration := identityErr != nil && emailErr != nil
+
+		if isNewRegistration && !domainAllowed {
+			return echo.NewHTTPError(http.StatusForbidden, "Sign-ups are restricted to specific email domains on this instance")
+		}
+
+		if isNewRegistration && h.Config.Auth.InviteOnly {
+			var inviteUUID string
+			if sess, err := session.Get("session", c); err == nil {
+				inviteUUID, _ = sess.Values["team_invite_uuid"].(string)
+			}
+			if !h.hasInvitation(user.Email, inviteUUID) {
+				return echo.NewHTTPError(http.StatusForbidden, "Sign-ups are invite-only on this instance")
+			}
+		}
+	}
+
 	var u models.User
 	// Will be used to get Slack's team name in case its not an invite
 	var teamName string
 	providerName := c.Param("provider")
 	isNewUser := false // Flag to track if a new user was created
 
+	// Team the user joined with an invitation, if any
+	var joinedTeamID *uint
+
 	// Execute everything in a transaction
 	err = h.DB.Transaction(func(tx *gorm.DB) error {
-		// Check if user exists or not
-		result := tx.Where("email = ?", user.Email).First(&u)
+		// Match the user by a linked identity first, in case the provider
+		// email differs from the account email, then by email
+		if linkedUser, err := models.GetUserByIdentity(tx, providerName, user.UserID); err == nil {
+			u = *linkedUser
+		} else if result := tx.Where("email = ?", user.Email).First(&u); errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			if user.Email == "" {
+				return errProviderEmailMissing
+			}
 
-		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
 			isNewUser = true // Mark as new user
 			u = models.User{
 				FirstName: user.FirstName,
@@ -71,6 +134,11 @@ func (h *AuthHandler) SocialLoginCallback(c echo.Context) error {
 			if err := tx.Create(&u).Error; err != nil {
 				return fmt.Errorf("failed to create user: %w", err)
 			}
+		} else if result.Error != nil {
+			return fmt.Errorf("failed to get user: %w", result.Error)
+		} else if providerName == "microsoft" {
+			// Any tenant can claim the email, the owner of the account links Microsoft instead
+			return errProviderEmailTaken
 		}
 
 		// Provider-specific handling
@@ -112,21 +180,62 @@ func (h *AuthHandler) SocialLoginCallback(c echo.Context) error {
 
 		case "google":
 			c.Logger().Infof("Received Google auth request")
+
+			// Google knows whether the user owns the address
+			if verified, _ := user.RawData["verified_email"].(bool); verified && u.EmailVerifiedAt == nil && strings.EqualFold(user.Email, u.Email) {
+				now := time.Now()
+				u.EmailVerifiedAt = &now
+				if err := tx.Save(&u).Error; err != nil {
+					return fmt.Errorf("failed to update user: %w", err)
+				}
+			}
+
+		case "microsoft":
+			c.Logger().Infof("Received Microsoft auth request")
+
+			// Graph API photo URLs need an access token, so we store
+			// the photo inline as a data URL instead
+			photo, err := getMicrosoftProfilePhoto(user.AccessToken)
+			if err != nil {
+				c.Logger().Warnf("Failed to get Microsoft profile photo: %v", err)
+			}
+			if photo != "" {
+				u.AvatarURL = photo
+				if err := tx.Save(&u).Error; err != nil {
+					return fmt.Errorf("failed to update user: %w", err)
+				}
+			}
+
+		default:
+			// Generic OIDC provider configured by self-hosted deployments
+			c.Logger().Infof("Received %s auth request", providerName)
+		}
+
+		if _, err := models.LinkIdentity(tx, u.ID, providerName, user); err != nil {
+			return err
 		}
 
 		// Check if the user has a team invite UUID
 		sess, err := session.Get("session", c)
 		if err == nil {
-			inviteUUID := sess.Values["team_invite_uuid"]
-			// Find team that this invitation belongs to
-			var invitation models.TeamInvitation
-			tx.Where("unique_id = ?", inviteUUID).First(&invitation)
-			if invitation.ID != 0 {
-				teamID := uint(invitation.TeamID)
-				u.TeamID = &teamID
+			inviteUUID, _ := sess.Values["team_invite_uuid"].(string)
+			// Find team that this invitation belongs to, expired invitations
+			// and invitation emails sent to another address are ignored
+			team, emailInvitation, err := models.ResolveInvitation(tx, inviteUUID)
+			if err == nil && (emailInvitation == nil || strings.EqualFold(emailInvitation.Email, u.Email)) {
+				// Invitation emails only reach the owner of the address
+				if emailInvitation != nil && u.EmailVerifiedAt == nil {
+					now := time.Now()
+					u.EmailVerifiedAt = &now
+				}
+				u.TeamID = &team.ID
 				if err := tx.Save(&u).Error; err != nil {
 					return fmt.Errorf("failed to update user team: %w", err)
 				}
+				if err := models.AcceptEmailInvitations(tx, &u, team.ID); err != nil {
+					return fmt.Errorf("failed to accept invitations: %w", err)
+				}
+				joinedTeamID = &team.ID
 			}
 			// Clean up the session
 			delete(sess.Values, "team_invite_uuid")
@@ -155,22 +264,34 @@ func (h *AuthHandler) SocialLoginCallback(c echo.Context) error {
 		return nil
 	})
 
+	if errors.Is(err, errProviderEmailMissing) {
+		return echo.NewHTTPError(http.StatusBadRequest, "Your account has no email address")
+	}
+	if errors.Is(err, errProviderEmailTaken) {
+		return echo.NewHTTPError(http.StatusConflict, "An account with this email already exists, sign in to it and link this provider from the settings")
+	}
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
 	}
 
+	if joinedTeamID != nil {
+		broadcastMemberJoined(&h.ServerState, *joinedTeamID, &u)
+	}
+
 	// Send welcome email if a new user was created
 	if isNewUser && h.EmailClient != nil {
 		h.EmailClient.SendWelcomeEmail(&u)
 	}
 
 	// Create a JWT token
-	token, err := h.JwtIssuer.GenerateToken(u.Email)
+	token, err := h.issueToken(c, &u)
 	if err != nil {
 		return c.String(http.StatusInternalServerError, "Failed to generate token")
 	}
 
-	_ = notifications.SendTelegramNotification(fmt.Sprintf("New sign-in: %s", u.ID), h.Config)
+	h.recordAuditEvent(c, models.AuditEventSocialLogin, &u, "", map[string]interface{}{"new_user": isNewUser})
+
+	h.Notifier.Notify(notifications.NewSigninEvent(u.ID, u.TeamID))
 
 	// Redirect to the web app with the JWT token
 	return c.Redirect(http.StatusFound, fmt.Sprintf("/login?token=%s", token))
@@ -190,6 +311,23 @@ func (h *AuthHandler) SocialLogin(c echo.Context) error {
 		}
 	}
 
+	// Signed-in users linking a new provider, see LinkIdentity. The link token
+	// must be the one LinkIdentity stored in this browser session
+	linkToken := c.QueryParam("link_token")
+	sess, err := session.Get("session", c)
+	if linkToken != "" {
+		sessionToken, _ := sess.Values["identity_link_token"].(string)
+		if err != nil || sessionToken == "" || subtle.ConstantTimeCompare([]byte(sessionToken), []byte(linkToken)) != 1 {
+			return echo.NewHTTPError(http.StatusForbidden, "Link token was not issued to this browser")
+		}
+	} else if err == nil {
+		// A plain sign-in must not complete a link started earlier and abandoned
+		if _, ok := sess.Values["identity_link_token"]; ok {
+			delete(sess.Values, "identity_link_token")
+			sess.Save(c.Request(), c.Response())
+		}
+	}
+
 	req := c.Request()
 	// Set the provider in the query parameters for gothic to work
 	q := req.URL.Query()
@@ -203,10 +341,16 @@ func (h *AuthHandler) SocialLogin(c echo.Context) error {
 func (h *AuthHandler) ManualSignUp(c echo.Context) error {
 	c.Logger().Info("Received manual sign-up request")
 
+	// Only the fields a new user picks, the team comes from the invitation or
+	// the new team and the email is verified by invitations only
 	type SignUpRequest struct {
-		models.User
+		FirstName      string `json:"first_name" validate:"required"`
+		LastName       string `json:"last_name" validate:"required"`
+		Email          string `json:"email" validate:"required,email"`
+		Password       string `json:"password" validate:"required,min=8"`
 		TeamName       string `json:"team_name"`
 		TeamInviteUUID string `json:"team_invite_uuid"`
+		CaptchaToken   string `json:"captcha_token"`
 	}
 
 	req := new(SignUpRequest)
@@ -214,20 +358,55 @@ func (h *AuthHandler) ManualSignUp(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
 
-	u := &req.User
-	if err := c.Validate(u); err != nil {
+	if err := h.verifyCaptcha(c, req.CaptchaToken); err != nil {
+		return err
+	}
+
+	if err := c.Validate(req); err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
 
+	u := &models.User{
+		FirstName: req.FirstName,
+		LastName:  req.LastName,
+		Email:     req.Email,
+		Password:  req.Password,
+	}
+
+	if !h.isEmailDomainAllowed(u.Email) {
+		return echo.NewHTTPError(http.StatusForbidden, "Sign-ups are restricted to specific email domains on this instance")
+	}
+
+	if h.Config.Auth.InviteOnly && !h.hasInvitation(u.Email, req.TeamInviteUUID) {
+		return echo.NewHTTPError(http.StatusForbidden, "Sign-ups are invite-only on this instance")
+	}
+
+	if err := password.Validate(u.Password, h.Config); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	// Team the user joins with an invitation, if any
+	var joinedTeamID *uint
+
 	// Check if team invite UUID was provided
 	if req.TeamInviteUUID != "" {
-		// Find the team invitation
-		var invitation models.TeamInvitation
-		result := h.DB.Where("unique_id = ?", req.TeamInviteUUID).First(&invitation)
-		if result.Error == nil {
+		// Find the team invitation, either the team's link or an invitation email
+		team, emailInvitation, err := models.ResolveInvitation(h.DB, req.TeamInviteUUID)
+		if errors.Is(err, models.ErrInvitationExpired) {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invitation has expired, ask your team for a new one")
+		}
+		if emailInvitation != nil && !strings.EqualFold(emailInvitation.Email, u.Email) {
+			return echo.NewHTTPError(http.StatusForbidden, "This invitation was sent to a different email address")
+		}
+		if err == nil {
+			// Invitation emails only reach the owner of the address
+			if emailInvitation != nil {
+				now := time.Now()
+				u.EmailVerifiedAt = &now
+			}
 			// Set the user's team ID
-			teamID := uint(invitation.TeamID)
-			u.TeamID = &teamID
+			u.TeamID = &team.ID
+			joinedTeamID = &team.ID
 		}
 	}
 
@@ -238,31 +417,48 @@ func (h *AuthHandler) ManualSignUp(c echo.Context) error {
 		}
 		h.DB.Create(&team)
 		u.TeamID = &team.ID
+		joinedTeamID = nil
 	}
 
-	result := h.DB.Create(u)
-	if errors.Is(result.Error, gorm.ErrDuplicatedKey) {
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(u).Error; err != nil {
+			return err
+		}
+
+		if joinedTeamID == nil {
+			return nil
+		}
+
+		return models.AcceptEmailInvitations(tx, u, *joinedTeamID)
+	})
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
 		return echo.NewHTTPError(409, "user with this email already exists")
 	}
 
 	// Handle other potential errors during creation
-	if result.Error != nil {
-		c.Logger().Errorf("Failed to create user: %v", result.Error)
+	if err != nil {
+		c.Logger().Errorf("Failed to create user: %v", err)
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create user")
 	}
 
+	if joinedTeamID != nil {
+		broadcastMemberJoined(&h.ServerState, *joinedTeamID, u)
+	}
+
 	// Send welcome email after successful creation
 	if h.EmailClient != nil {
 		h.EmailClient.SendWelcomeEmail(u)
 	}
 
 	// Create a JWT token
-	token, err := h.JwtIssuer.GenerateToken(u.Email)
+	token, err := h.issueToken(c, u)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to generate token")
 	}
 
-	_ = notifications.SendTelegramNotification(fmt.Sprintf("New sign-up: %s", u.ID), h.Config)
+	h.recordAuditEvent(c, models.AuditEventSignUp, u, "", nil)
+
+	h.Notifier.Notify(notifications.NewSignupEvent(u.ID, u.TeamID))
 
 	return c.JSON(http.StatusCreated, map[string]string{"token": token})
 }
@@ -279,23 +475,43 @@ func (h *AuthHandler) ManualSignIn(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
 
+	ctx := c.Request().Context()
+	ip := c.RealIP()
+	limiter := newSignInLimiter(h.Redis)
+
+	retryAfter, err := limiter.lockedOut(ctx, ip, req.Email)
+	if err != nil {
+		c.Logger().Error("Failed to check sign-in lockout: ", err)
+	}
+	if retryAfter > 0 {
+		c.Response().Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		return echo.NewHTTPError(http.StatusTooManyRequests, "Too many failed sign-in attempts, try again later")
+	}
+
 	u := &models.User{}
 	result := h.DB.Where("email = ?", req.Email).First(u)
-	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) || !u.CheckPassword(req.Password) {
+		if err := limiter.recordFailure(ctx, ip, req.Email); err != nil {
+			c.Logger().Error("Failed to record failed sign-in: ", err)
+		}
+		// Don't link the event to a user so unknown emails are handled the same way
+		h.recordAuditEvent(c, models.AuditEventSignInFailed, nil, req.Email, nil)
 		return echo.NewHTTPError(http.StatusUnauthorized, "Invalid email or password")
 	}
 
-	if !u.CheckPassword(req.Password) {
-		return echo.NewHTTPError(http.StatusUnauthorized, "Invalid email or password")
+	if err := limiter.reset(ctx, req.Email); err != nil {
+		c.Logger().Error("Failed to reset sign-in failures: ", err)
 	}
 
 	// Create a JWT token
-	token, err := h.JwtIssuer.GenerateToken(u.Email)
+	token, err := h.issueToken(c, u)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to generate token")
 	}
 
-	_ = notifications.SendTelegramNotification(fmt.Sprintf("New sign-in: %s", u.ID), h.Config)
+	h.recordAuditEvent(c, models.AuditEventSignIn, u, "", nil)
+
+	h.Notifier.Notify(notifications.NewSigninEvent(u.ID, u.TeamID))
 
 	return c.JSON(http.StatusOK, map[string]string{"token": token})
 }
@@ -338,6 +554,9 @@ func (h *AuthHandler) UserPage(c echo.Context) error {
 
 // AuthenticateApp is an endpoint that will be create a
 // JWT token to be used by the app
+//
+// Deprecated: the desktop app should use the device authorization
+// flow (DeviceCode/DeviceToken), kept for older app versions.
 func (h *AuthHandler) AuthenticateApp(c echo.Context) error {
 
 	user, isAuthenticated := h.getAuthenticatedUserFromJWT(c)
@@ -346,15 +565,41 @@ func (h *AuthHandler) AuthenticateApp(c echo.Context) error {
 		return c.String(http.StatusUnauthorized, "Unauthorized request")
 	}
 
+	// API keys can't be exchanged for a token, it would outlive the key and
+	// ignore its scopes. Neither can impersonation tokens, it would outlive them.
+	if isAPIKeyRequest(c) || isImpersonationRequest(c) {
+		return c.String(http.StatusForbidden, "Forbidden")
+	}
+
 	// Create a JWT token
-	token, err := h.JwtIssuer.GenerateToken(user.Email)
+	token, err := h.issueToken(c, user)
 	if err != nil {
 		return c.String(http.StatusInternalServerError, "Failed to generate token")
 	}
 
+	h.recordAuditEvent(c, models.AuditEventTokenRefresh, user, "", nil)
+
 	return c.JSON(http.StatusOK, map[string]string{"token": token})
 }
 
+// Logout revokes the JWT used for the request so it can't be used again
+func (h *AuthHandler) Logout(c echo.Context) error {
+	if err := h.JwtIssuer.RevokeToken(c); err != nil {
+		c.Logger().Error("Failed to revoke token: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to logout")
+	}
+
+	if claims, err := getClaims(c); err == nil {
+		h.DB.Where("jti = ?", claims.ID).Delete(&models.UserSession{})
+	}
+
+	if user, isAuthenticated := h.getAuthenticatedUserFromJWT(c); isAuthenticated {
+		h.recordAuditEvent(c, models.AuditEventLogout, user, "", nil)
+	}
+
+	return c.NoContent(http.StatusOK)
+}
+
 func (h *AuthHandler) User(c echo.Context) error {
 	user, isAuthenticated := h.getAuthenticatedUserFromJWT(c)
 	if !isAuthenticated {
@@ -364,30 +609,90 @@ func (h *AuthHandler) User(c echo.Context) error {
 	return c.JSON(http.StatusOK, user)
 }
 
+// teammatesMaxPerPage caps the page size of Teammates, also used as the default
+const teammatesMaxPerPage = 100
+
+// Teammates returns the members of the user's active team. Supports
+// pagination (page, per_page), searching by name or email (search) and
+// sorting (sort=name|email|joined, order=asc|desc). The total number of
+// matching teammates is returned in the X-Total-Count header.
 func (h *AuthHandler) Teammates(c echo.Context) error {
 	user, isAuthenticated := h.getAuthenticatedUserFromJWT(c)
 	if !isAuthenticated {
 		return c.String(http.StatusUnauthorized, "Unauthorized request")
 	}
 
-	teammates, err := user.GetTeammates(h.DB)
+	page, err := parsePositiveQueryParam(c, "page", 1)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid page")
+	}
+
+	perPage, err := parsePositiveQueryParam(c, "per_page", teammatesMaxPerPage)
+	if err != nil || perPage > teammatesMaxPerPage {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("per_page must be between 1 and %d", teammatesMaxPerPage))
+	}
+
+	query := models.TeammatesQuery{
+		Search: strings.TrimSpace(c.QueryParam("search")),
+		Sort:   c.QueryParam("sort"),
+		Desc:   c.QueryParam("order") == "desc",
+		Limit:  perPage,
+		Offset: (page - 1) * perPage,
+	}
+	if query.Sort != "" && !models.IsValidTeammatesSort(query.Sort) {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid sort")
+	}
+
+	teammates, total, err := user.GetTeammates(h.DB, query)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
 	}
 
-	// Check Redis for active users
-	ctx := context.Background()
-	for i := range teammates {
-		// Check if user has an active Redis subscription
-		channelPattern := common.GetUserChannel(teammates[i].ID)
-		channels, err := h.Redis.PubSubChannels(ctx, channelPattern).Result()
+	// A user is active while they have a live connection
+	if len(teammates) > 0 {
+		teammateIDs := make([]string, len(teammates))
+		for i := range teammates {
+			teammateIDs[i] = teammates[i].ID
+		}
+
+		online, err := presence.BulkIsOnline(c.Request().Context(), h.Redis, teammateIDs)
 		if err != nil {
-			c.Logger().Error("Error checking Redis channels:", err)
-			continue
+			c.Logger().Error("Error checking presence: ", err)
+		}
+		for i := range teammates {
+			teammates[i].IsActive = online[teammates[i].ID]
+		}
+
+		statuses, err := presence.BulkGetStatus(c.Request().Context(), h.Redis, teammateIDs)
+		if err != nil {
+			c.Logger().Error("Error getting statuses: ", err)
+		}
+		for i := range teammates {
+			status, ok := statuses[teammates[i].ID]
+			if !ok {
+				status.Status = presence.StatusAvailable
+			}
+			teammates[i].Status = status.Status
+			teammates[i].StatusText = status.Text
+		}
+
+		activities, err := presence.BulkGetActivity(c.Request().Context(), h.Redis, teammateIDs)
+		if err != nil {
+			c.Logger().Error("Error getting activities: ", err)
+		}
+		for i := range teammates {
+			if teammates[i].IsActive {
+				teammates[i].Activity = activities[teammates[i].ID]
+			}
 		}
-		teammates[i].IsActive = len(channels) > 0
 	}
 
+	for i := range teammates {
+		teammates[i].IsDND = teammates[i].IsDoNotDisturbActive()
+	}
+
+	c.Response().Header().Set("X-Total-Count", strconv.FormatInt(total, 10))
+
 	return c.JSON(http.StatusOK, teammates)
 }
 
@@ -427,6 +732,9 @@ func (h *AuthHandler) UpdateName(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
 
+	recordAuditChange(c, "first_name", user.FirstName, req.FirstName)
+	recordAuditChange(c, "last_name", user.LastName, req.LastName)
+
 	user.FirstName = req.FirstName
 	user.LastName = req.LastName
 
@@ -450,31 +758,53 @@ func (h *AuthHandler) GetInviteUUID(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "User is not part of any team")
 	}
 
+	if err := h.checkInvitePolicy(user); err != nil {
+		return err
+	}
+
 	teamID := int(*user.TeamID)
 
-	// Check if there's an existing invitation for this team
-	var invitation models.TeamInvitation
-	result := h.DB.Where("team_id = ?", teamID).First(&invitation)
+	// Get the current invitation, or create a new one if none exists or if previous one was expired
+	invitation, err := models.GetOrCreateTeamInvitation(h.DB, teamID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create team invitation")
+	}
 
-	// Create a new invitation if none exists or if previous one was expired
-	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
-		// Generate a UUID for the invitation
-		inviteUUID, err := uuid.NewV7()
-		if err != nil {
-			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to generate invitation UUID")
-		}
+	// Get team name (only query for what we need)
+	var team models.Team
+	if err := h.DB.Select("name").Where("id = ?", teamID).First(&team).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get team information")
+	}
 
-		invitation = models.TeamInvitation{
-			TeamID:   teamID,
-			UniqueID: inviteUUID.String(),
-		}
+	return c.JSON(http.StatusOK, map[string]string{
+		"invite_uuid": invitation.UniqueID,
+		"team_name":   team.Name,
+	})
+}
 
-		if err := h.DB.Create(&invitation).Error; err != nil {
-			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create team invitation")
-		}
+// RegenerateInviteUUID revokes the current team invitation link and creates a new one
+func (h *AuthHandler) RegenerateInviteUUID(c echo.Context) error {
+	user, isAuthenticated := h.getAuthenticatedUserFromJWT(c)
+	if !isAuthenticated {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
+	}
+
+	// Check if user has a team
+	if user.TeamID == nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "User is not part of any team")
+	}
+
+	if err := h.checkInvitePolicy(user); err != nil {
+		return err
+	}
+
+	teamID := int(*user.TeamID)
+
+	invitation, err := models.RegenerateTeamInvitation(h.DB, teamID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create team invitation")
 	}
 
-	// Get team name (only query for what we need)
 	var team models.Team
 	if err := h.DB.Select("name").Where("id = ?", teamID).First(&team).Error; err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get team information")
@@ -486,6 +816,30 @@ func (h *AuthHandler) GetInviteUUID(c echo.Context) error {
 	})
 }
 
+// RevokeInviteUUID invalidates the current team invitation link,
+// a new one is only created when requested again
+func (h *AuthHandler) RevokeInviteUUID(c echo.Context) error {
+	user, isAuthenticated := h.getAuthenticatedUserFromJWT(c)
+	if !isAuthenticated {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
+	}
+
+	// Check if user has a team
+	if user.TeamID == nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "User is not part of any team")
+	}
+
+	if err := h.checkInvitePolicy(user); err != nil {
+		return err
+	}
+
+	if err := models.RevokeTeamInvitations(h.DB, int(*user.TeamID)); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to revoke team invitation")
+	}
+
+	return c.NoContent(http.StatusOK)
+}
+
 // GetInvitationDetails retrieves the team details for a given invitation UUID
 func (h *AuthHandler) GetInvitationDetails(c echo.Context) error {
 	uuid := c.Param("uuid")
@@ -493,18 +847,17 @@ func (h *AuthHandler) GetInvitationDetails(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "Invalid invitation UUID")
 	}
 
-	// Find the team invitation by UUID
-	var invitation models.TeamInvitation
-	result := h.DB.Where("unique_id = ?", uuid).Preload("Team").First(&invitation)
-	if result.Error != nil {
-		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+	// Find the team invitation by UUID, or by the token of an invitation email
+	team, _, err := models.ResolveInvitation(h.DB, uuid)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) || errors.Is(err, models.ErrInvitationExpired) {
 			return echo.NewHTTPError(http.StatusNotFound, "Invitation not found or has expired")
 		}
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to retrieve invitation details")
 	}
 
 	// Return team information with the invitation UUID for sign up
-	return c.JSON(http.StatusOK, invitation.Team)
+	return c.JSON(http.StatusOK, team)
 }
 
 // SendTeamInvites sends invitation emails to join a team
@@ -519,6 +872,10 @@ func (h *AuthHandler) SendTeamInvites(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "User is not part of any team")
 	}
 
+	if err := h.checkInvitePolicy(user); err != nil {
+		return err
+	}
+
 	teamID := int(*user.TeamID)
 
 	// Get the team name
@@ -529,7 +886,11 @@ func (h *AuthHandler) SendTeamInvites(c echo.Context) error {
 
 	// Parse request body
 	type InviteRequest struct {
-		Invitees []string `json:"invitees" validate:"required,dive,email"`
+		Invitees     []string `json:"invitees" validate:"required,dive,email"`
+		Role         string   `json:"role" validate:"omitempty,oneof=member admin"`
+		CaptchaToken string   `json:"captcha_token"`
+		// Lets admins re-send invitations within the cooldown period
+		Force bool `json:"force"`
 	}
 
 	req := new(InviteRequest)
@@ -541,31 +902,25 @@ func (h *AuthHandler) SendTeamInvites(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "Invalid email addresses")
 	}
 
-	// Ensure we have a valid team invitation UUID
-	var invitation models.TeamInvitation
-	result := h.DB.Where("team_id = ?", teamID).First(&invitation)
+	if err := h.verifyCaptcha(c, req.CaptchaToken); err != nil {
+		return err
+	}
 
-	// Create a new invitation if none exists
-	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
-		// Generate a UUID for the invitation
-		inviteUUID, err := uuid.NewV7()
-		if err != nil {
-			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to generate invitation UUID")
-		}
+	if req.Role == "" {
+		req.Role = models.TeamRoleMember
+	}
 
-		invitation = models.TeamInvitation{
-			TeamID:   teamID,
-			UniqueID: inviteUUID.String(),
-		}
+	// Only admins can invite other admins
+	if req.Role == models.TeamRoleAdmin && !user.IsAdmin {
+		return echo.NewHTTPError(http.StatusForbidden, "Only admins can invite admins")
+	}
 
-		if err := h.DB.Create(&invitation).Error; err != nil {
-			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create team invitation")
-		}
+	if req.Force && !user.IsAdmin {
+		return echo.NewHTTPError(http.StatusForbidden, "Only admins can force invitations")
 	}
 
 	// Process invitations in a goroutine to not block the response
 	baseURL := "https://" + h.Config.Server.DeployDomain
-	inviteLink := fmt.Sprintf("%s/invitation/%s", baseURL, invitation.UniqueID)
 	inviterName := user.FirstName + " " + user.LastName
 
 	// Limit also the user to 50 invites per day
@@ -579,34 +934,61 @@ func (h *AuthHandler) SendTeamInvites(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusTooManyRequests, "You have reached the maximum number of invites per day")
 	}
 
+	type SkippedInvitee struct {
+		Email string `json:"email"`
+		// One of daily_limit, recently_invited or failed
+		Reason string `json:"reason"`
+	}
+
+	sent := []string{}
+	skipped := []SkippedInvitee{}
+
 	for idx, email := range req.Invitees {
 		if (idx + int(invitesToday)) >= 50 {
 			c.Echo().Logger.Info("Skipping inviting more emails because of rate limit for user:", user.ID)
+			for _, remaining := range req.Invitees[idx:] {
+				skipped = append(skipped, SkippedInvitee{Email: remaining, Reason: "daily_limit"})
+			}
 			break
 		}
 		// Check if we can send an invitation to this email (rate limit check)
-		if !models.CanSendInvite(h.DB, email) {
-			// Skip this email silently
+		if !req.Force && !models.CanSendInvite(h.DB, email) {
 			c.Echo().Logger.Info("Skipping inviting email:", email)
+			skipped = append(skipped, SkippedInvitee{Email: email, Reason: "recently_invited"})
 			continue
 		}
 
-		// Record the invitation in the database
-		emailInvite := models.EmailInvitation{
-			TeamID: teamID,
-			Email:  email,
-			SentAt: time.Now(),
-			SentBy: user.ID,
+		// Record the invitation in the database, each invitation has
+		// its own link so we can track who accepted it
+		invitation, token, err := models.NewEmailInvitation(h.DB, teamID, email, user.ID, req.Role)
+		if err != nil {
+			c.Logger().Error("Failed to create email invitation: ", err)
+			skipped = append(skipped, SkippedInvitee{Email: email, Reason: "failed"})
+			continue
 		}
-		h.DB.Create(&emailInvite)
+		inviteLink := fmt.Sprintf("%s/invitation/%s", baseURL, token)
 
 		// Send the email if email client is available
 		if h.EmailClient != nil {
-			h.EmailClient.SendTeamInvitationEmail(inviterName, team.Name, inviteLink, email)
+			if outboundEmailID := h.EmailClient.SendTeamInvitationEmail(inviterName, team.Name, inviteLink, email, user.Locale); outboundEmailID != 0 {
+				h.DB.Model(invitation).Update("outbound_email_id", outboundEmailID)
+			}
 		}
+		sent = append(sent, email)
 	}
 
-	return c.NoContent(http.StatusOK)
+	if len(sent) > 0 {
+		if err := models.RecordTeamActivity(h.DB, uint(teamID), models.TeamActivityInviteSent, user.ID, map[string]interface{}{
+			"invitees": sent,
+		}); err != nil {
+			c.Logger().Error("Failed to record team activity: ", err)
+		}
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"sent":    sent,
+		"skipped": skipped,
+	})
 }
 
 // UpdateOnboardingFormStatus updates the user's metadata to mark the onboarding form as completed
@@ -653,7 +1035,7 @@ func (h *AuthHandler) Watercooler(c echo.Context) error {
 	}
 	tokens.Participant = user.ID
 
-	_ = notifications.SendTelegramNotification(fmt.Sprintf("User %s joined the watercooler room", user.ID), h.Config)
+	h.Notifier.Notify(notifications.NewWatercoolerJoinedEvent(user.ID, user.TeamID))
 
 	return c.JSON(http.StatusOK, tokens)
 }
@@ -675,12 +1057,22 @@ func (h *AuthHandler) WatercoolerAnonymous(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "User is not part of any team")
 	}
 
-	// Create custom claims for anonymous watercooler access
+	settings, err := models.GetTeamSettings(h.DB, *user.TeamID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get team settings")
+	}
+	if !settings.AllowAnonymousWatercooler {
+		return echo.NewHTTPError(http.StatusForbidden, "Anonymous watercooler links are disabled for this team")
+	}
+
+	// Create custom claims for anonymous watercooler access,
+	// viewer only links let guests watch and listen without taking part
 	claims := jwt.MapClaims{
-		"team_id": *user.TeamID,
-		"exp":     jwt.NewNumericDate(time.Now().Add(10 * time.Minute)), // 10-minute expiration
-		"iat":     jwt.NewNumericDate(time.Now()),                       // Issued at
-		"purpose": "anonymous_watercooler",                              // Purpose of the token
+		"team_id":     *user.TeamID,
+		"viewer_only": c.QueryParam("viewer_only") == "true",
+		"exp":         jwt.NewNumericDate(time.Now().Add(10 * time.Minute)), // 10-minute expiration
+		"iat":         jwt.NewNumericDate(time.Now()),                       // Issued at
+		"purpose":     "anonymous_watercooler",                              // Purpose of the token
 	}
 
 	// Create token with claims
@@ -754,6 +1146,15 @@ func (h *AuthHandler) WatercoolerMeetRedirect(c echo.Context) error {
 	}
 	teamID := uint(teamIDFloat)
 
+	// The team may have disabled anonymous access after the link was generated
+	settings, err := models.GetTeamSettings(h.DB, teamID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get team settings")
+	}
+	if !settings.AllowAnonymousWatercooler {
+		return echo.NewHTTPError(http.StatusForbidden, "Anonymous watercooler links are disabled for this team")
+	}
+
 	// Generate a room name for the watercooler room
 	roomName := fmt.Sprintf("team-%d-watercooler", teamID)
 
@@ -767,8 +1168,10 @@ func (h *AuthHandler) WatercoolerMeetRedirect(c echo.Context) error {
 		TeamID: &teamID,
 	}
 
+	viewerOnly, _ := claims["viewer_only"].(bool)
+
 	// Generate a token for the anonymous user to join the watercooler room
-	livekitToken, err := generateMeetRedirectToken(&h.ServerState, roomName, anonymousUser)
+	livekitToken, err := generateMeetRedirectToken(&h.ServerState, roomName, anonymousUser, viewerOnly)
 	if err != nil {
 		c.Logger().Error("Failed to generate watercooler tokens:", err)
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to generate tokens")
@@ -777,6 +1180,17 @@ func (h *AuthHandler) WatercoolerMeetRedirect(c echo.Context) error {
 	return c.Redirect(http.StatusFound, fmt.Sprintf("https://meet.livekit.io/custom?liveKitUrl=%s&token=%s", h.Config.Livekit.ServerURL, livekitToken))
 }
 
+// JWKS exposes the public keys used to sign the JWTs so other services
+// (e.g. a media service) can verify tokens without the shared secret
+func (h *AuthHandler) JWKS(c echo.Context) error {
+	jwtAuth, ok := h.JwtIssuer.(*JwtAuth)
+	if !ok {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to access JWT configuration")
+	}
+
+	return c.JSON(http.StatusOK, jwtAuth.JWKS())
+}
+
 func (h *AuthHandler) GetLivekitServerURL(c echo.Context) error {
 	_, isAuthenticated := h.getAuthenticatedUserFromJWT(c)
 	if !isAuthenticated {
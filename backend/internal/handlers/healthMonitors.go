@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"hopp-backend/internal/common"
+	"hopp-backend/internal/models"
+	"hopp-backend/internal/notifications"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// healthCheckInterval is how often the health checks run
+	healthCheckInterval = time.Minute
+	// liveKitTokenFailureThreshold is how many LiveKit token generations can fail in a row
+	// before it is an incident, a single failure can be a bad team setting
+	liveKitTokenFailureThreshold = 5
+	// deadEmailWindow is how far back dead-lettered emails keep the email queue check failing
+	deadEmailWindow = 15 * time.Minute
+)
+
+// liveKitTokenFailures counts the LiveKit token generations that failed in a row
+var liveKitTokenFailures atomic.Int64
+
+// recordLiveKitTokenResult keeps count of the LiveKit token generations failing in a row
+func recordLiveKitTokenResult(err error) {
+	if err != nil {
+		liveKitTokenFailures.Add(1)
+		return
+	}
+
+	liveKitTokenFailures.Store(0)
+}
+
+// healthCheck is a condition on-call is paged for while it fails
+type healthCheck struct {
+	name  string
+	check func(s *common.ServerState) error
+}
+
+var healthChecks = []healthCheck{
+	{name: "redis", check: checkRedis},
+	{name: "livekit_tokens", check: checkLiveKitTokens},
+	{name: "email_queue", check: checkEmailQueue},
+}
+
+// StartHealthMonitors periodically runs the health checks, and notifies a critical
+// event when a check starts failing and another when it recovers
+func StartHealthMonitors(s *common.ServerState) {
+	go func() {
+		failing := make(map[string]bool)
+
+		ticker := time.NewTicker(healthCheckInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			for _, hc := range healthChecks {
+				err := hc.check(s)
+				if (err != nil) == failing[hc.name] {
+					continue
+				}
+				failing[hc.name] = err != nil
+
+				if err != nil {
+					s.Echo.Logger.Errorf("Health check %s is failing: %v", hc.name, err)
+				} else {
+					s.Echo.Logger.Infof("Health check %s recovered", hc.name)
+				}
+				s.Notifier.Notify(notifications.NewHealthEvent(hc.name, err))
+			}
+		}
+	}()
+}
+
+func checkRedis(s *common.ServerState) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := s.Redis.Ping(ctx).Err(); err != nil {
+		return fmt.Errorf("redis is unreachable: %w", err)
+	}
+
+	return nil
+}
+
+func checkLiveKitTokens(s *common.ServerState) error {
+	if failures := liveKitTokenFailures.Load(); failures >= liveKitTokenFailureThreshold {
+		return fmt.Errorf("the last %d LiveKit token generations failed", failures)
+	}
+
+	return nil
+}
+
+func checkEmailQueue(s *common.ServerState) error {
+	dead, err := models.CountDeadEmailsSince(s.DB, time.Now().Add(-deadEmailWindow))
+	if err != nil {
+		return fmt.Errorf("failed to count dead-lettered emails: %w", err)
+	}
+
+	if dead > 0 {
+		return fmt.Errorf("%d emails were dead-lettered in the last %s", dead, deadEmailWindow)
+	}
+
+	return nil
+}
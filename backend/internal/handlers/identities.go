@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"hopp-backend/internal/common"
+	"hopp-backend/internal/models"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/labstack/echo-contrib/session"
+	"github.com/labstack/echo/v4"
+	"github.com/markbates/goth"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+)
+
+// identityLinkTTL is how long the user has to complete the OAuth flow
+// after requesting to link a new provider
+const identityLinkTTL = 10 * time.Minute
+
+// ListIdentities returns the OAuth providers linked to the authenticated user
+func (h *AuthHandler) ListIdentities(c echo.Context) error {
+	user, isAuthenticated := h.getAuthenticatedUserFromJWT(c)
+	if !isAuthenticated {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
+	}
+
+	var identities []models.UserIdentity
+	if err := h.DB.Where("user_id = ?", user.ID).Order("created_at").Find(&identities).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get identities")
+	}
+
+	return c.JSON(http.StatusOK, identities)
+}
+
+// LinkIdentity starts linking a new OAuth provider to the authenticated user.
+// The OAuth flow runs in the browser without the JWT, so a one-time
+// link token is returned as part of the URL the client needs to open.
+// The token is also kept in the browser session that requested it and only
+// works there, so a link URL sent to someone else can't attach their
+// provider account to this user.
+func (h *AuthHandler) LinkIdentity(c echo.Context) error {
+	user, isAuthenticated := h.getAuthenticatedUserFromJWT(c)
+	if !isAuthenticated {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
+	}
+
+	// A linked provider account signs in as the user, API keys and
+	// impersonation tokens must not be able to add one
+	if isAPIKeyRequest(c) || isImpersonationRequest(c) {
+		return echo.NewHTTPError(http.StatusForbidden, "Forbidden")
+	}
+
+	provider := c.Param("provider")
+	if _, err := goth.GetProvider(provider); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Unknown provider")
+	}
+
+	sess, err := session.Get("session", c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to start linking provider")
+	}
+
+	linkToken := rand.Text()
+	sess.Values["identity_link_token"] = linkToken
+	if err := sess.Save(c.Request(), c.Response()); err != nil {
+		c.Logger().Error("Failed to save identity link token: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to start linking provider")
+	}
+
+	if err := h.Redis.Set(c.Request().Context(), common.GetIdentityLinkKey(linkToken), user.ID, identityLinkTTL).Err(); err != nil {
+		c.Logger().Error("Failed to store identity link token: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to start linking provider")
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{
+		"url": fmt.Sprintf("/api/auth/social/%s?link_token=%s", url.PathEscape(provider), linkToken),
+	})
+}
+
+// UnlinkIdentity removes a linked OAuth provider from the authenticated user.
+// Users without a password need to keep at least one identity to sign in with.
+func (h *AuthHandler) UnlinkIdentity(c echo.Context) error {
+	user, isAuthenticated := h.getAuthenticatedUserFromJWT(c)
+	if !isAuthenticated {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
+	}
+
+	if isAPIKeyRequest(c) || isImpersonationRequest(c) {
+		return echo.NewHTTPError(http.StatusForbidden, "Forbidden")
+	}
+
+	var identity models.UserIdentity
+	result := h.DB.Where("id = ? AND user_id = ?", c.Param("id"), user.ID).First(&identity)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, "Identity not found")
+	}
+	if result.Error != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get identity")
+	}
+
+	if user.HashedPassword == "" {
+		var count int64
+		if err := h.DB.Model(&models.UserIdentity{}).Where("user_id = ?", user.ID).Count(&count).Error; err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get identities")
+		}
+		if count <= 1 {
+			return echo.NewHTTPError(http.StatusConflict, "Cannot unlink the only way to sign in")
+		}
+	}
+
+	// Hard delete so the provider account can be linked again, to any user
+	if err := h.DB.Unscoped().Delete(&identity).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to unlink identity")
+	}
+
+	return c.NoContent(http.StatusOK)
+}
+
+// getIdentityLinkUserID returns the ID of the user that started linking a
+// provider in this browser session, consuming the link token
+func (h *AuthHandler) getIdentityLinkUserID(c echo.Context) (string, bool) {
+	sess, err := session.Get("session", c)
+	if err != nil {
+		return "", false
+	}
+
+	linkToken, ok := sess.Values["identity_link_token"].(string)
+	if !ok || linkToken == "" {
+		return "", false
+	}
+	delete(sess.Values, "identity_link_token")
+	sess.Save(c.Request(), c.Response())
+
+	userID, err := h.Redis.GetDel(c.Request().Context(), common.GetIdentityLinkKey(linkToken)).Result()
+	if err != nil {
+		if !errors.Is(err, redis.Nil) {
+			c.Logger().Error("Failed to get identity link token: ", err)
+		}
+		return "", false
+	}
+
+	return userID, true
+}
+
+// completeIdentityLink links the provider account of a finished OAuth flow
+// to the user that requested it and sends them back to the settings page
+func (h *AuthHandler) completeIdentityLink(c echo.Context, userID string, gothUser goth.User) error {
+	provider := c.Param("provider")
+
+	_, err := models.LinkIdentity(h.DB, userID, provider, gothUser)
+	if errors.Is(err, models.ErrIdentityLinkedToAnotherUser) {
+		return c.Redirect(http.StatusFound, "/settings?link_error=already_linked")
+	}
+	if err != nil {
+		c.Logger().Error("Failed to link identity: ", err)
+		return c.Redirect(http.StatusFound, "/settings?link_error=failed")
+	}
+
+	return c.Redirect(http.StatusFound, fmt.Sprintf("/settings?linked=%s", url.QueryEscape(provider)))
+}
@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"errors"
+	"hopp-backend/internal/models"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// impersonationTokenTTL keeps impersonation tokens short-lived
+const impersonationTokenTTL = time.Hour
+
+// Impersonate issues a time-boxed token that lets a support admin act as
+// another user. Every request made with it is written to the audit log.
+func (h *AuthHandler) Impersonate(c echo.Context) error {
+	admin, isAuthenticated := h.getAuthenticatedUserFromJWT(c)
+	if !isAuthenticated {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
+	}
+
+	// Impersonation tokens can't be used to impersonate someone else
+	if isImpersonationRequest(c) {
+		return echo.NewHTTPError(http.StatusForbidden, "Forbidden")
+	}
+
+	if isAPIKeyRequest(c) || !slices.Contains(h.Config.Auth.SupportAdminEmails, admin.Email) {
+		return echo.NewHTTPError(http.StatusForbidden, "Forbidden")
+	}
+
+	type ImpersonateRequest struct {
+		UserID string `json:"user_id" validate:"required"`
+		// Why support needs to act as the user, kept in the audit log
+		Reason string `json:"reason" validate:"required"`
+	}
+
+	req := new(ImpersonateRequest)
+	if err := c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request format")
+	}
+
+	if err := c.Validate(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	var user models.User
+	if err := h.DB.Where("id = ?", req.UserID).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return echo.NewHTTPError(http.StatusNotFound, "User not found")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get user")
+	}
+
+	issued, err := h.JwtIssuer.IssueImpersonationToken(user.Email, admin.Email, impersonationTokenTTL)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to generate token")
+	}
+
+	// Listed among the user's sessions, so they and the admins can revoke it before it expires
+	session := models.UserSession{
+		UserID:       user.ID,
+		JTI:          issued.ID,
+		UserAgent:    c.Request().UserAgent(),
+		IPAddress:    c.RealIP(),
+		LastUsedAt:   time.Now(),
+		ExpiresAt:    issued.ExpiresAt,
+		Impersonator: admin.Email,
+	}
+	if err := h.DB.Create(&session).Error; err != nil {
+		c.Logger().Error("Failed to record impersonation session: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to generate token")
+	}
+
+	h.recordAuditEvent(c, models.AuditEventImpersonationStarted, &user, "", map[string]interface{}{
+		"impersonator": admin.Email,
+		"reason":       req.Reason,
+		"jti":          issued.ID,
+		"expires_at":   issued.ExpiresAt,
+	})
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"token":      issued.Token,
+		"expires_at": issued.ExpiresAt,
+	})
+}
+
+// isImpersonationRequest checks if the request is made with an impersonation token.
+// They are refused wherever tokens or credentials are minted, or the support admin
+// could keep acting as the user after the impersonation token expires.
+func isImpersonationRequest(c echo.Context) bool {
+	claims, err := getClaims(c)
+	return err == nil && claims.Impersonator != ""
+}
+
+// ImpersonationAuditMiddleware writes every request made with an
+// impersonation token to the audit log. Needs to run after the JWT middleware.
+func ImpersonationAuditMiddleware(db *gorm.DB) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if isAPIKeyRequest(c) {
+				return next(c)
+			}
+
+			claims, err := getClaims(c)
+			if err != nil || claims.Impersonator == "" {
+				return next(c)
+			}
+
+			err = next(c)
+
+			status := c.Response().Status
+			var httpErr *echo.HTTPError
+			if errors.As(err, &httpErr) {
+				status = httpErr.Code
+			}
+
+			auditEvent := models.AuditEvent{
+				Email:     claims.Email,
+				Event:     models.AuditEventImpersonatedAction,
+				IPAddress: c.RealIP(),
+				UserAgent: c.Request().UserAgent(),
+				Metadata: map[string]interface{}{
+					"impersonator": claims.Impersonator,
+					"jti":          claims.ID,
+					"method":       c.Request().Method,
+					"path":         c.Request().URL.Path,
+					"status":       status,
+				},
+			}
+			if dbErr := db.Create(&auditEvent).Error; dbErr != nil {
+				c.Logger().Error("Failed to record impersonated action: ", dbErr)
+			}
+
+			return err
+		}
+	}
+}
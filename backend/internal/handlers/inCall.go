@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"context"
+	"hopp-backend/internal/common"
+	"time"
+)
+
+// inCallTTL bounds how long a user stays busy when the end of their call is never seen
+const inCallTTL = 12 * time.Hour
+
+// markInCall marks the users as busy in the room, done when their call tokens are issued
+func markInCall(s *common.ServerState, roomName string, userIDs ...string) {
+	for _, userID := range userIDs {
+		if err := s.Redis.Set(context.Background(), common.GetInCallKey(userID), roomName, inCallTTL).Err(); err != nil {
+			s.Echo.Logger.Error("Failed to mark user as in call: ", err)
+		}
+	}
+}
+
+// clearInCall marks the users as available for calls again
+func clearInCall(s *common.ServerState, userIDs ...string) {
+	keys := make([]string, 0, len(userIDs))
+	for _, userID := range userIDs {
+		keys = append(keys, common.GetInCallKey(userID))
+	}
+
+	if err := s.Redis.Del(context.Background(), keys...).Err(); err != nil {
+		s.Echo.Logger.Error("Failed to clear in call state: ", err)
+	}
+}
+
+// isInCall checks if the user is currently in a call
+func isInCall(s *common.ServerState, userID string) bool {
+	exists, err := s.Redis.Exists(context.Background(), common.GetInCallKey(userID)).Result()
+	if err != nil {
+		s.Echo.Logger.Error("Failed to check in call state: ", err)
+		return false
+	}
+
+	return exists > 0
+}
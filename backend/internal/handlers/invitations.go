@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"errors"
+	"hopp-backend/internal/models"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// ListTeamInvitations returns the email invitations of the user's active team
+// to the members the invite policy lets invite people.
+// Only outstanding invitations are returned, unless a status filter is given.
+func (h *AuthHandler) ListTeamInvitations(c echo.Context) error {
+	user, isAuthenticated := h.getAuthenticatedUserFromJWT(c)
+	if !isAuthenticated {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
+	}
+
+	if user.TeamID == nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "User is not part of any team")
+	}
+
+	// Same people that can send the invitations
+	if err := h.checkInvitePolicy(user); err != nil {
+		return err
+	}
+
+	return h.listEmailInvitations(c, *user.TeamID)
+}
+
+// listEmailInvitations responds with the email invitations of the team
+// filtered by the status query parameter, outstanding ones by default
+func (h *AuthHandler) listEmailInvitations(c echo.Context, teamID uint) error {
+	query := h.DB.Where("team_id = ?", teamID)
+
+	// Pending invitations are only marked as expired once someone tries to use them
+	cutoff := time.Now().Add(-models.EmailInvitationTTL)
+	switch models.EmailInvitationStatus(c.QueryParam("status")) {
+	case "", models.EmailInvitationPending:
+		query = query.Where("status = ? AND sent_at > ?", models.EmailInvitationPending, cutoff)
+	case models.EmailInvitationExpired:
+		query = query.Where("status = ? OR (status = ? AND sent_at <= ?)",
+			models.EmailInvitationExpired, models.EmailInvitationPending, cutoff)
+	case models.EmailInvitationAccepted:
+		query = query.Where("status = ?", models.EmailInvitationAccepted)
+	case "all":
+	default:
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid status")
+	}
+
+	var invitations []models.EmailInvitation
+	if err := query.Order("sent_at DESC").Find(&invitations).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get invitations")
+	}
+
+	return c.JSON(http.StatusOK, invitations)
+}
+
+// CancelTeamInvitation cancels an email invitation of the user's active team,
+// its link stops working right away
+func (h *AuthHandler) CancelTeamInvitation(c echo.Context) error {
+	user, isAuthenticated := h.getAuthenticatedUserFromJWT(c)
+	if !isAuthenticated {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
+	}
+
+	if user.TeamID == nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "User is not part of any team")
+	}
+
+	// Same people that can send the invitations
+	if err := h.checkInvitePolicy(user); err != nil {
+		return err
+	}
+
+	var invitation models.EmailInvitation
+	result := h.DB.Where("id = ? AND team_id = ?", c.Param("id"), *user.TeamID).First(&invitation)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, "Invitation not found")
+	}
+	if result.Error != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get invitation")
+	}
+
+	if invitation.Status == models.EmailInvitationAccepted {
+		return echo.NewHTTPError(http.StatusConflict, "Invitation has already been accepted")
+	}
+
+	if err := h.DB.Delete(&invitation).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to cancel invitation")
+	}
+
+	return c.NoContent(http.StatusOK)
+}
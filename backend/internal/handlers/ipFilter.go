@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"fmt"
+	"hopp-backend/internal/common"
+	"hopp-backend/internal/models"
+	"net"
+	"net/http"
+	"slices"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// ipFilterExemptPaths are reachable from anywhere, load balancer health checks
+// and webhooks of external services that are authenticated by their signature
+var ipFilterExemptPaths = []string{
+	"/api/health",
+	"/api/livekit/webhook",
+	"/api/resend/webhook",
+}
+
+// ParseCIDRs parses a list of CIDRs, single addresses are accepted as well
+func ParseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid CIDR %q", cidr)
+			}
+			network = &net.IPNet{IP: ip, Mask: net.CIDRMask(len(ip)*8, len(ip)*8)}
+		}
+		networks = append(networks, network)
+	}
+
+	return networks, nil
+}
+
+// containsIP checks if the address is in any of the networks
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	return slices.ContainsFunc(networks, func(network *net.IPNet) bool {
+		return network.Contains(ip)
+	})
+}
+
+// IPFilterMiddleware refuses the requests from outside the allowed networks, when there
+// are any, and from the denied ones. The client address is c.RealIP(), so the server
+// has to be told which proxies to trust when it runs behind any.
+func IPFilterMiddleware(allowed, denied []*net.IPNet) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if slices.Contains(ipFilterExemptPaths, c.Request().URL.Path) {
+				return next(c)
+			}
+
+			ip := net.ParseIP(c.RealIP())
+			if ip == nil || containsIP(denied, ip) || (len(allowed) > 0 && !containsIP(allowed, ip)) {
+				return echo.NewHTTPError(http.StatusForbidden, "Access from your network is not allowed")
+			}
+
+			return next(c)
+		}
+	}
+}
+
+// TeamIPAllowlistMiddleware refuses the requests of members of teams with an IP
+// allowlist made from outside of it. The allowlists of all the teams of the user
+// apply, whichever is active, as requests can reach any of them.
+// Needs to run after the authentication middlewares.
+func TeamIPAllowlistMiddleware(db *gorm.DB) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			email, ok := c.Get(common.APIKeyEmailContextKey).(string)
+			if !ok {
+				claims, err := getClaims(c)
+				if err != nil {
+					return next(c)
+				}
+				email = claims.Email
+			}
+
+			// Teams that never changed their settings have no allowlist
+			var teamSettings []models.TeamSettings
+			err := db.Joins("JOIN team_memberships ON team_memberships.team_id = team_settings.team_id AND team_memberships.deleted_at IS NULL").
+				Joins("JOIN users ON users.id = team_memberships.user_id").
+				Where("users.email = ?", email).
+				Find(&teamSettings).Error
+			if err != nil {
+				c.Logger().Error("Failed to get team IP allowlists: ", err)
+				return echo.NewHTTPError(http.StatusInternalServerError, "Failed to check team IP allowlist")
+			}
+
+			for _, settings := range teamSettings {
+				if !isIPAllowed(settings.IPAllowlist, c.RealIP()) {
+					return echo.NewHTTPError(http.StatusForbidden, "Your team doesn't allow access from your network")
+				}
+			}
+
+			return next(c)
+		}
+	}
+}
+
+// isIPAllowed checks if the address is in the IP allowlist of a team, every
+// address is allowed if the list is empty
+func isIPAllowed(allowlist []string, address string) bool {
+	if len(allowlist) == 0 {
+		return true
+	}
+
+	networks, err := ParseCIDRs(allowlist)
+	if err != nil {
+		return false
+	}
+
+	ip := net.ParseIP(address)
+	return ip != nil && containsIP(networks, ip)
+}
@@ -0,0 +1,280 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hopp-backend/internal/messages"
+	"hopp-backend/internal/models"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// publicEmailDomains are shared by unrelated people, so teams can't be
+// discovered through them
+var publicEmailDomains = map[string]bool{
+	"gmail.com":      true,
+	"googlemail.com": true,
+	"outlook.com":    true,
+	"hotmail.com":    true,
+	"live.com":       true,
+	"yahoo.com":      true,
+	"icloud.com":     true,
+	"me.com":         true,
+	"aol.com":        true,
+	"proton.me":      true,
+	"protonmail.com": true,
+	"gmx.com":        true,
+}
+
+// likeEscaper escapes the wildcards of a LIKE pattern, with the default escape character
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// discoverableTeams selects the teams with members from the user's email domain that
+// the user isn't part of yet. Only verified emails count, on both sides, or anyone could
+// sign up with an address of the domain. It is nil when the user can't discover teams.
+func (h *AuthHandler) discoverableTeams(user *models.User) *gorm.DB {
+	if user.EmailVerifiedAt == nil {
+		return nil
+	}
+
+	at := strings.LastIndex(user.Email, "@")
+	if at == -1 {
+		return nil
+	}
+	domain := strings.ToLower(user.Email[at+1:])
+	if publicEmailDomains[domain] {
+		return nil
+	}
+
+	domainMembers := h.DB.Model(&models.TeamMembership{}).
+		Select("team_memberships.team_id").
+		Joins("JOIN users ON users.id = team_memberships.user_id").
+		Where("LOWER(users.email) LIKE ? AND users.email_verified_at IS NOT NULL", "%@"+likeEscaper.Replace(domain))
+	userTeams := h.DB.Model(&models.TeamMembership{}).
+		Select("team_id").
+		Where("user_id = ?", user.ID)
+
+	return h.DB.Model(&models.Team{}).Where("id IN (?) AND id NOT IN (?)", domainMembers, userTeams)
+}
+
+// DiscoverTeams returns the teams with members from the user's email domain
+// that the user isn't part of yet
+func (h *AuthHandler) DiscoverTeams(c echo.Context) error {
+	user, isAuthenticated := h.getAuthenticatedUserFromJWT(c)
+	if !isAuthenticated {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
+	}
+
+	teams := []models.Team{}
+
+	query := h.discoverableTeams(user)
+	if query == nil {
+		return c.JSON(http.StatusOK, teams)
+	}
+
+	if err := query.Find(&teams).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get teams")
+	}
+
+	return c.JSON(http.StatusOK, teams)
+}
+
+// CreateJoinRequest asks the admins of a team to let the user join it,
+// only the teams DiscoverTeams returns can be asked
+func (h *AuthHandler) CreateJoinRequest(c echo.Context) error {
+	user, isAuthenticated := h.getAuthenticatedUserFromJWT(c)
+	if !isAuthenticated {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
+	}
+
+	teamID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid team ID")
+	}
+
+	if models.IsTeamMember(h.DB, user.ID, uint(teamID)) {
+		return echo.NewHTTPError(http.StatusConflict, "User is already a member of this team")
+	}
+
+	if user.EmailVerifiedAt == nil {
+		return echo.NewHTTPError(http.StatusForbidden, "Verify your email address to ask to join teams")
+	}
+
+	query := h.discoverableTeams(user)
+	if query == nil {
+		return echo.NewHTTPError(http.StatusNotFound, "Team not found")
+	}
+
+	var team models.Team
+	result := query.Where("id = ?", teamID).First(&team)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, "Team not found")
+	}
+	if result.Error != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get team")
+	}
+
+	var existing int64
+	h.DB.Model(&models.JoinRequest{}).
+		Where("user_id = ? AND team_id = ? AND status = ?", user.ID, team.ID, models.JoinRequestPending).
+		Count(&existing)
+	if existing > 0 {
+		return echo.NewHTTPError(http.StatusConflict, "A join request for this team is already pending")
+	}
+
+	joinRequest := models.JoinRequest{
+		UserID: user.ID,
+		TeamID: team.ID,
+		Status: models.JoinRequestPending,
+	}
+	if err := h.DB.Create(&joinRequest).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create join request")
+	}
+
+	h.notifyTeamAdmins(c, user, &team, &joinRequest)
+
+	return c.JSON(http.StatusCreated, joinRequest)
+}
+
+// notifyTeamAdmins lets the admins of the team know about a new join request,
+// both in the app and by email
+func (h *AuthHandler) notifyTeamAdmins(c echo.Context, requester *models.User, team *models.Team, joinRequest *models.JoinRequest) {
+	admins, err := models.GetTeamAdmins(h.DB, team.ID)
+	if err != nil {
+		c.Logger().Error("Failed to get team admins: ", err)
+		return
+	}
+
+	msg := messages.NewJoinRequestMessage(joinRequest.ID, team.ID, requester.ID, requester.GetDisplayName())
+	msgJSON, err := json.Marshal(msg)
+	if err != nil {
+		c.Logger().Error(err)
+		return
+	}
+
+	reviewLink := fmt.Sprintf("https://%s/teammates?join_request=%d", h.Config.Server.DeployDomain, joinRequest.ID)
+
+	for i := range admins {
+		publishToUser(&h.ServerState, admins[i].ID, msgJSON)
+
+		if h.EmailClient != nil {
+			h.EmailClient.SendJoinRequestEmail(&admins[i], requester, team.Name, reviewLink)
+		}
+	}
+}
+
+// ListJoinRequests returns the pending join requests of the user's active team
+func (h *AuthHandler) ListJoinRequests(c echo.Context) error {
+	user, isAuthenticated := h.getAuthenticatedUserFromJWT(c)
+	if !isAuthenticated {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
+	}
+
+	if user.TeamID == nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "User is not part of any team")
+	}
+
+	if !user.IsAdmin {
+		return echo.NewHTTPError(http.StatusForbidden, "Only team admins can review join requests")
+	}
+
+	var joinRequests []models.JoinRequest
+	err := h.DB.Preload("User").
+		Where("team_id = ? AND status = ?", *user.TeamID, models.JoinRequestPending).
+		Order("created_at").
+		Find(&joinRequests).Error
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get join requests")
+	}
+
+	return c.JSON(http.StatusOK, joinRequests)
+}
+
+// ApproveJoinRequest adds the requesting user to the team
+func (h *AuthHandler) ApproveJoinRequest(c echo.Context) error {
+	return h.reviewJoinRequest(c, models.JoinRequestApproved)
+}
+
+// RejectJoinRequest turns down a join request
+func (h *AuthHandler) RejectJoinRequest(c echo.Context) error {
+	return h.reviewJoinRequest(c, models.JoinRequestRejected)
+}
+
+func (h *AuthHandler) reviewJoinRequest(c echo.Context, status models.JoinRequestStatus) error {
+	user, isAuthenticated := h.getAuthenticatedUserFromJWT(c)
+	if !isAuthenticated {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
+	}
+
+	if user.TeamID == nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "User is not part of any team")
+	}
+
+	if !user.IsAdmin {
+		return echo.NewHTTPError(http.StatusForbidden, "Only team admins can review join requests")
+	}
+
+	var joinRequest models.JoinRequest
+	result := h.DB.Where("id = ? AND team_id = ?", c.Param("id"), *user.TeamID).First(&joinRequest)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, "Join request not found")
+	}
+	if result.Error != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get join request")
+	}
+
+	if joinRequest.Status != models.JoinRequestPending {
+		return echo.NewHTTPError(http.StatusConflict, "Join request has already been reviewed")
+	}
+
+	now := time.Now()
+	joinRequest.Status = status
+	joinRequest.ReviewedBy = &user.ID
+	joinRequest.ReviewedAt = &now
+
+	// Whether the team became the requester's active team
+	activated := false
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&joinRequest).Error; err != nil {
+			return err
+		}
+
+		if status != models.JoinRequestApproved {
+			return nil
+		}
+
+		if err := models.AddTeamMembership(tx, joinRequest.UserID, joinRequest.TeamID); err != nil {
+			return err
+		}
+
+		// Users without a team start using the one they just joined
+		result := tx.Model(&models.User{}).
+			Where("id = ? AND team_id IS NULL", joinRequest.UserID).
+			Update("team_id", joinRequest.TeamID)
+		activated = result.RowsAffected > 0
+		return result.Error
+	})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to review join request")
+	}
+
+	if activated {
+		publishActiveTeamChanged(&h.ServerState, joinRequest.UserID, &joinRequest.TeamID)
+	}
+
+	if status == models.JoinRequestApproved {
+		if member, err := models.GetUserByID(h.DB, joinRequest.UserID); err != nil {
+			c.Logger().Error("Failed to get new team member: ", err)
+		} else {
+			broadcastMemberJoined(&h.ServerState, joinRequest.TeamID, member)
+		}
+	}
+
+	return c.JSON(http.StatusOK, joinRequest)
+}
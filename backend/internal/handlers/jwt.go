@@ -1,72 +1,307 @@
 package handlers
 
 import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"hopp-backend/internal/common"
+	"hopp-backend/internal/config"
+	"math/big"
+	"net/http"
+	"os"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 	echojwt "github.com/labstack/echo-jwt/v4"
 	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"github.com/redis/go-redis/v9"
 )
 
 type JwtAuth struct {
 	common.JwtAuth
 }
 
-func NewJwtAuth(secret string) *JwtAuth {
-	return &JwtAuth{
+func NewJwtAuth(cfg *config.Config, redis *redis.Client) (*JwtAuth, error) {
+	j := &JwtAuth{
 		common.JwtAuth{
-			Secret: secret,
+			Secret:        cfg.Auth.SessionSecret,
+			Redis:         redis,
+			SigningMethod: jwt.SigningMethodHS256,
+			SigningKey:    []byte(cfg.Auth.SessionSecret),
+			VerifyKey:     []byte(cfg.Auth.SessionSecret),
+			// Tokens in the query are still accepted for older clients
+			AllowQueryToken: cfg.JWT.AllowQueryToken,
 		},
 	}
+
+	if cfg.JWT.Algorithm == jwt.SigningMethodHS256.Alg() {
+		return j, nil
+	}
+
+	keyPEM, err := os.ReadFile(cfg.JWT.PrivateKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("reading JWT private key: %w", err)
+	}
+
+	var publicKey crypto.PublicKey
+	switch cfg.JWT.Algorithm {
+	case jwt.SigningMethodRS256.Alg():
+		key, err := jwt.ParseRSAPrivateKeyFromPEM(keyPEM)
+		if err != nil {
+			return nil, fmt.Errorf("parsing RSA private key: %w", err)
+		}
+		j.SigningMethod = jwt.SigningMethodRS256
+		j.SigningKey = key
+		publicKey = key.Public()
+	case jwt.SigningMethodEdDSA.Alg():
+		key, err := jwt.ParseEdPrivateKeyFromPEM(keyPEM)
+		if err != nil {
+			return nil, fmt.Errorf("parsing Ed25519 private key: %w", err)
+		}
+		j.SigningMethod = jwt.SigningMethodEdDSA
+		j.SigningKey = key
+		publicKey = key.(ed25519.PrivateKey).Public()
+	default:
+		return nil, fmt.Errorf("unsupported JWT algorithm: %s", cfg.JWT.Algorithm)
+	}
+	j.VerifyKey = publicKey
+
+	j.KeyID = cfg.JWT.KeyID
+	if j.KeyID == "" {
+		// Derive a stable key ID from the public key
+		der, err := x509.MarshalPKIXPublicKey(publicKey)
+		if err != nil {
+			return nil, fmt.Errorf("marshalling public key: %w", err)
+		}
+		hash := sha256.Sum256(der)
+		j.KeyID = hex.EncodeToString(hash[:8])
+	}
+
+	return j, nil
 }
 
 func (j JwtAuth) GenerateToken(email string) (string, error) {
+	issued, err := j.IssueToken(email)
+	if err != nil {
+		return "", err
+	}
+
+	return issued.Token, nil
+}
+
+// IssueToken generates a JWT and also returns its JTI and expiration
+// so the caller can keep track of it
+func (j JwtAuth) IssueToken(email string) (common.IssuedToken, error) {
+	return j.issue(email, "", time.Hour*24*365) // 1 year expiration
+}
+
+// IssueImpersonationToken generates a short-lived JWT for email that is
+// marked with the support admin acting as the user
+func (j JwtAuth) IssueImpersonationToken(email, impersonator string, ttl time.Duration) (common.IssuedToken, error) {
+	return j.issue(email, impersonator, ttl)
+}
+
+func (j JwtAuth) issue(email, impersonator string, ttl time.Duration) (common.IssuedToken, error) {
+	// JTI is used to identify the token in case it needs to be revoked
+	jti, err := uuid.NewV7()
+	if err != nil {
+		return common.IssuedToken{}, err
+	}
+
+	expiresAt := time.Now().Add(ttl)
 	claims := common.JwtCustomClaims{
-		Email: email,
+		Email:        email,
+		Impersonator: impersonator,
 		RegisteredClaims: jwt.RegisteredClaims{
+			ID: jti.String(),
 			// IssuedAt:  jwt.NewNumericDate(time.Now()), // Not required
 			// NotBefore: jwt.NewNumericDate(time.Now()), // Not required
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour * 24 * 365)), // 1 year expiration
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
 		},
 	}
 	// Create token with claims
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	token := jwt.NewWithClaims(j.SigningMethod, claims)
+	if j.KeyID != "" {
+		token.Header["kid"] = j.KeyID
+	}
 
 	// Generate encoded token and send it as response.
-	t, err := token.SignedString([]byte(j.Secret))
+	t, err := token.SignedString(j.SigningKey)
 	if err != nil {
-		return "", err
+		return common.IssuedToken{}, err
 	}
 
-	return t, nil
+	return common.IssuedToken{
+		Token:     t,
+		ID:        jti.String(),
+		ExpiresAt: expiresAt,
+	}, nil
 }
 
 func (j JwtAuth) Middleware() echo.MiddlewareFunc {
+	tokenLookup := "header:Authorization:Bearer "
+	if j.AllowQueryToken {
+		tokenLookup += ",query:token"
+	}
+
 	config := echojwt.Config{
 		NewClaimsFunc: func(c echo.Context) jwt.Claims {
 			return new(common.JwtCustomClaims)
 		},
-		TokenLookup:   "header:Authorization:Bearer ,query:token",
-		SigningKey:    []byte(j.Secret),
-		SigningMethod: jwt.SigningMethodHS256.Name,
+		TokenLookup: tokenLookup,
+		// Browsers can't set headers on websockets, they send the token as a subprotocol
+		TokenLookupFuncs: []middleware.ValuesExtractor{wsSubprotocolToken},
+		SigningKey:       j.VerifyKey,
+		SigningMethod:    j.SigningMethod.Alg(),
+		// Requests already authenticated with an API key don't carry a JWT
+		Skipper: isAPIKeyRequest,
 	}
 
-	return echojwt.WithConfig(config)
+	jwtMiddleware := echojwt.WithConfig(config)
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		// Check the denylist only after the token signature was validated
+		return jwtMiddleware(func(c echo.Context) error {
+			if isAPIKeyRequest(c) {
+				return next(c)
+			}
+
+			claims, err := getClaims(c)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusUnauthorized, "invalid or expired jwt")
+			}
+
+			// The query is only looked at when neither the header nor a subprotocol has a token
+			if j.AllowQueryToken && c.QueryParam("token") != "" &&
+				c.Request().Header.Get(echo.HeaderAuthorization) == "" && c.Request().Header.Get("Sec-WebSocket-Protocol") == "" {
+				c.Logger().Warn("Deprecated token query parameter accepted on: ", c.Path())
+			}
+
+			revoked, err := j.isRevoked(c.Request().Context(), claims.ID)
+			if err != nil {
+				c.Logger().Error("Failed to check token denylist: ", err)
+				return echo.NewHTTPError(http.StatusInternalServerError, "Failed to validate token")
+			}
+			if revoked {
+				return echo.NewHTTPError(http.StatusUnauthorized, "invalid or expired jwt")
+			}
+
+			return next(c)
+		})
+	}
 }
 
 func (j JwtAuth) GetUserEmail(c echo.Context) (string, error) {
+	if email, ok := c.Get(common.APIKeyEmailContextKey).(string); ok {
+		return email, nil
+	}
+
+	claims, err := getClaims(c)
+	if err != nil {
+		return "", err
+	}
+
+	return claims.Email, nil
+}
+
+// RevokeToken adds the token of the current request to the Redis denylist.
+func (j JwtAuth) RevokeToken(c echo.Context) error {
+	claims, err := getClaims(c)
+	if err != nil {
+		return err
+	}
+
+	// Tokens issued before JTIs were introduced cannot be revoked individually
+	if claims.ID == "" {
+		return errors.New("token has no JTI and cannot be revoked")
+	}
+
+	expiresAt := time.Now().Add(24 * time.Hour * 365)
+	if claims.ExpiresAt != nil {
+		expiresAt = claims.ExpiresAt.Time
+	}
+
+	return j.RevokeTokenID(c.Request().Context(), claims.ID, expiresAt)
+}
+
+// RevokeTokenID adds a token to the Redis denylist by its JTI.
+// The denylist entry lives until the token would have expired anyway.
+func (j JwtAuth) RevokeTokenID(ctx context.Context, jti string, expiresAt time.Time) error {
+	ttl := time.Until(expiresAt)
+	if ttl <= 0 {
+		return nil
+	}
+
+	return j.Redis.Set(ctx, common.GetRevokedTokenKey(jti), 1, ttl).Err()
+}
+
+func (j JwtAuth) isRevoked(ctx context.Context, jti string) (bool, error) {
+	if jti == "" || j.Redis == nil {
+		return false, nil
+	}
+
+	exists, err := j.Redis.Exists(ctx, common.GetRevokedTokenKey(jti)).Result()
+	if err != nil {
+		return false, err
+	}
+
+	return exists > 0, nil
+}
+
+func isAPIKeyRequest(c echo.Context) bool {
+	return c.Get(common.APIKeyEmailContextKey) != nil
+}
+
+func getClaims(c echo.Context) (*common.JwtCustomClaims, error) {
 	// Get claims from context
 	u, ok := c.Get("user").(*jwt.Token)
 	if !ok {
-		return "", fmt.Errorf("failed to get token from context")
+		return nil, fmt.Errorf("failed to get token from context")
 	}
 
 	claims, ok := u.Claims.(*common.JwtCustomClaims)
 	if !ok {
-		return "", fmt.Errorf("failed to parse JWT claims")
+		return nil, fmt.Errorf("failed to parse JWT claims")
 	}
 
-	return claims.Email, nil
+	return claims, nil
+}
+
+// JWKS returns the public keys that can be used to verify the issued tokens
+// in the JSON Web Key Set format. The set is empty when tokens are signed
+// with the shared HMAC secret.
+func (j JwtAuth) JWKS() map[string]interface{} {
+	keys := []map[string]string{}
+
+	switch key := j.VerifyKey.(type) {
+	case *rsa.PublicKey:
+		keys = append(keys, map[string]string{
+			"kty": "RSA",
+			"use": "sig",
+			"alg": j.SigningMethod.Alg(),
+			"kid": j.KeyID,
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		})
+	case ed25519.PublicKey:
+		keys = append(keys, map[string]string{
+			"kty": "OKP",
+			"crv": "Ed25519",
+			"use": "sig",
+			"alg": j.SigningMethod.Alg(),
+			"kid": j.KeyID,
+			"x":   base64.RawURLEncoding.EncodeToString(key),
+		})
+	}
+
+	return map[string]interface{}{"keys": keys}
 }
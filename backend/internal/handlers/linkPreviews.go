@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"hopp-backend/internal/common"
+	"hopp-backend/internal/linkpreview"
+	"hopp-backend/internal/messages"
+	"time"
+)
+
+// linkPreviewTimeout bounds fetching a preview, cache round trips included
+const linkPreviewTimeout = 10 * time.Second
+
+// Kinds of chat messages a link preview is for
+const (
+	linkPreviewDirectMessage = "direct_message"
+	linkPreviewTeamChat      = "team_chat"
+)
+
+// sendLinkPreview fetches the preview of the first link of a chat message in the
+// background, and hands it to send once it has it. Messages without a link, or
+// with a link that has no preview, get nothing.
+func sendLinkPreview(s *common.ServerState, body string, send func(preview *linkpreview.Preview)) {
+	link := linkpreview.FindURL(body)
+	if link == "" {
+		return
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), linkPreviewTimeout)
+		defer cancel()
+
+		preview, err := linkpreview.Get(ctx, s.Redis, link)
+		if err != nil {
+			if !errors.Is(err, linkpreview.ErrNoPreview) {
+				s.Echo.Logger.Error("Failed to get link preview: ", err)
+			}
+			return
+		}
+
+		send(preview)
+	}()
+}
+
+// newLinkPreviewMessage creates the link_preview message of a chat message
+func newLinkPreviewMessage(messageKind string, messageID uint, preview *linkpreview.Preview) messages.LinkPreviewMessage {
+	return messages.NewLinkPreviewMessage(messageKind, messageID, preview.URL, preview.Title,
+		preview.Description, preview.ImageURL, preview.SiteName)
+}
+
+// sendDirectMessageLinkPreview sends the preview of the link in a direct message to both sides of the conversation
+func sendDirectMessageLinkPreview(s *common.ServerState, senderID, recipientID string, messageID uint, body string) {
+	sendLinkPreview(s, body, func(preview *linkpreview.Preview) {
+		msgJSON, err := json.Marshal(newLinkPreviewMessage(linkPreviewDirectMessage, messageID, preview))
+		if err != nil {
+			s.Echo.Logger.Error(err)
+			return
+		}
+
+		publishToUser(s, recipientID, msgJSON)
+		publishToUser(s, senderID, msgJSON)
+	})
+}
+
+// sendTeamChatLinkPreview sends the preview of the link in a team chat message to the team
+func sendTeamChatLinkPreview(s *common.ServerState, teamID, messageID uint, body string) {
+	sendLinkPreview(s, body, func(preview *linkpreview.Preview) {
+		publishToTeam(s, teamID, newLinkPreviewMessage(linkPreviewTeamChat, messageID, preview))
+	})
+}
@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"hopp-backend/internal/email"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// UpdateLocale sets the locale of the emails sent to the user
+func (h *AuthHandler) UpdateLocale(c echo.Context) error {
+	user, isAuthenticated := h.getAuthenticatedUserFromJWT(c)
+	if !isAuthenticated {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
+	}
+
+	type LocaleRequest struct {
+		Locale string `json:"locale" validate:"required,max=35"`
+	}
+
+	req := new(LocaleRequest)
+	if err := c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request")
+	}
+
+	if err := c.Validate(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	// Regional locales like el-GR use the translations of their language
+	locale, ok := email.ParseLocale(req.Locale)
+	if !ok {
+		return echo.NewHTTPError(http.StatusBadRequest, "Unsupported locale")
+	}
+
+	if err := h.DB.Model(user).Update("locale", locale).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to update locale")
+	}
+	user.Locale = locale
+
+	return c.JSON(http.StatusOK, user)
+}
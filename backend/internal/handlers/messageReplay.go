@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"hopp-backend/internal/common"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// replayBufferSize is how many of the latest messages of a user are kept for replay
+	replayBufferSize = 100
+	// replayBufferTTL is how long after the last message the buffer is kept,
+	// long enough to cover a network blip or a laptop waking up
+	replayBufferTTL = 10 * time.Minute
+)
+
+// publishUserMessageScript numbers the message, keeps it in the user's replay buffer
+// and publishes it in one step, so the sequence numbers reach the user in order.
+// The sequence number is spliced in as the first field of the encoded message.
+// Messages nobody received are queued for the user's next connection when asked to.
+var publishUserMessageScript = redis.NewScript(`
+local sequence = redis.call("INCR", KEYS[1])
+local message = '{"seq":' .. sequence .. ',' .. string.sub(ARGV[1], 2)
+redis.call("ZADD", KEYS[2], sequence, message)
+redis.call("ZREMRANGEBYRANK", KEYS[2], 0, -(tonumber(ARGV[2]) + 1))
+redis.call("PEXPIRE", KEYS[2], ARGV[3])
+local receivers = redis.call("PUBLISH", ARGV[4], message)
+if receivers == 0 and ARGV[5] == "1" then
+	redis.call("RPUSH", KEYS[3], message)
+	redis.call("LTRIM", KEYS[3], -tonumber(ARGV[6]), -1)
+	redis.call("PEXPIRE", KEYS[3], ARGV[7])
+end
+return sequence
+`)
+
+// publishToUser pushes the message, a JSON object, to all the connections of the user
+// with the next sequence number of the user
+func publishToUser(s *common.ServerState, userID string, msgJSON []byte) error {
+	return publishUserMessage(s, userID, common.GetUserChannel(userID), msgJSON, isQueuedWhileOffline(msgJSON))
+}
+
+// publishToDevice pushes the message only to the connections of one device of the user,
+// to all of them if the device isn't known
+func publishToDevice(s *common.ServerState, userID, deviceID string, msgJSON []byte) error {
+	if deviceID == "" {
+		return publishToUser(s, userID, msgJSON)
+	}
+
+	return publishUserMessage(s, userID, common.GetUserDeviceChannel(userID, deviceID), msgJSON, false)
+}
+
+// publishUserMessage publishes the message to the channel with the next sequence number of the user
+func publishUserMessage(s *common.ServerState, userID, channel string, msgJSON []byte, queueOffline bool) error {
+	queue := "0"
+	if queueOffline {
+		queue = "1"
+	}
+
+	err := publishUserMessageScript.Run(context.Background(), s.Redis,
+		[]string{common.GetUserSequenceKey(userID), common.GetUserReplayKey(userID), common.GetOfflineQueueKey(userID)},
+		string(msgJSON), replayBufferSize, replayBufferTTL.Milliseconds(), channel,
+		queue, offlineQueueSize, offlineQueueTTL.Milliseconds()).Err()
+	if err != nil {
+		pubsubErrors.WithLabelValues("publish").Inc()
+		s.Echo.Logger.Error("Failed to publish message: ", err)
+	}
+
+	return err
+}
+
+// resumeMessages sends the connection the messages published to the user after the
+// last one the client saw. Messages that already left the buffer are reported,
+// the client has to fetch the current state instead.
+func resumeMessages(ctx echo.Context, s *common.ServerState, client *wsClient, userID string, lastSeq int64) error {
+	rdbCtx := context.Background()
+
+	pipe := s.Redis.Pipeline()
+	current := pipe.Get(rdbCtx, common.GetUserSequenceKey(userID))
+	buffered := pipe.ZRangeByScoreWithScores(rdbCtx, common.GetUserReplayKey(userID), &redis.ZRangeBy{
+		Min: "(" + strconv.FormatInt(lastSeq, 10),
+		Max: "+inf",
+	})
+	if _, err := pipe.Exec(rdbCtx); err != nil && !errors.Is(err, redis.Nil) {
+		ctx.Logger().Error("Failed to get replay buffer: ", err)
+		return errMessageFailed
+	}
+
+	for _, msg := range buffered.Val() {
+		if !client.queue([]byte(msg.Member.(string))) {
+			return errMessageFailed
+		}
+	}
+
+	latest, _ := current.Int64()
+	missed := latest > lastSeq
+	if len(buffered.Val()) > 0 {
+		missed = int64(buffered.Val()[0].Score) > lastSeq+1
+	}
+	if missed {
+		return errors.New("Some messages are no longer available")
+	}
+
+	return nil
+}
@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hopp-backend/internal/common"
+	"hopp-backend/internal/messages"
+	"hopp-backend/internal/models"
+	"hopp-backend/internal/presence"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// startRinging starts the ring timer of the call, if nobody answers or rejects it
+// before the ring timeout it is cancelled on both sides and recorded as a missed call
+func startRinging(s *common.ServerState, callID, callerID, calleeID string) {
+	time.AfterFunc(s.Config.Calls.RingTimeout, func() {
+		if !transitionCall(s, callID, callRinging, callTimeout) {
+			return
+		}
+
+		msgJSON, err := json.Marshal(messages.NewCallUnansweredMessage(callID, callerID, calleeID))
+		if err != nil {
+			s.Echo.Logger.Error(err)
+		} else {
+			publishToUser(s, callerID, msgJSON)
+			publishToUser(s, calleeID, msgJSON)
+		}
+
+		recordMissedCall(s, callerID, calleeID, models.MissedCallNoAnswer)
+	})
+}
+
+// recordMissedCall stores the missed call and lets the callee know, right away
+// if they are connected or with an email if they are offline
+func recordMissedCall(s *common.ServerState, callerID, calleeID string, reason models.MissedCallReason) {
+	missedCall, err := models.RecordMissedCall(s.DB, callerID, calleeID, reason)
+	if err != nil {
+		s.Echo.Logger.Error("Failed to record missed call: ", err)
+		return
+	}
+	incrementUnread(s, common.GetUnreadMissedCallsKey(calleeID), callerID, 1)
+
+	caller, err := models.GetUserByID(s.DB, callerID)
+	if err != nil {
+		s.Echo.Logger.Error("Failed to get caller of missed call: ", err)
+		return
+	}
+	missedCall.Caller = caller
+
+	emitTeamWebhook(s, caller.TeamID, models.WebhookEventCallMissed, newMissedCallWebhookData(missedCall))
+
+	calleeOnline, err := presence.IsOnline(context.Background(), s.Redis, calleeID)
+	if err != nil {
+		s.Echo.Logger.Error("Error checking presence: ", err)
+		return
+	}
+
+	if calleeOnline {
+		msgJSON, err := json.Marshal(newMissedCallMessage(missedCall))
+		if err != nil {
+			s.Echo.Logger.Error(err)
+			return
+		}
+		publishToUser(s, calleeID, msgJSON)
+
+		if err := models.MarkMissedCallsNotified(s.DB, []uint{missedCall.ID}); err != nil {
+			s.Echo.Logger.Error("Failed to mark missed call as notified: ", err)
+		}
+		return
+	}
+
+	// The callee gets the missed call when they connect again,
+	// the email is for the ones that don't have the app open
+	if !s.Config.Calls.MissedCallEmails || s.EmailClient == nil {
+		return
+	}
+
+	callee, err := models.GetUserByID(s.DB, calleeID)
+	if err != nil {
+		s.Echo.Logger.Error("Failed to get callee of missed call: ", err)
+		return
+	}
+
+	appLink := fmt.Sprintf("https://%s/", s.Config.Server.DeployDomain)
+	s.EmailClient.SendMissedCallEmail(callee, caller, missedCall.CreatedAt, appLink)
+}
+
+// deliverMissedCalls sends the missed calls the user wasn't told about
+// to their freshly connected websocket
+func deliverMissedCalls(c echo.Context, s *common.ServerState, client *wsClient, user *models.User) {
+	missedCalls, err := models.GetUnnotifiedMissedCalls(s.DB, user.ID)
+	if err != nil {
+		c.Logger().Error("Failed to get missed calls: ", err)
+		return
+	}
+
+	delivered := make([]uint, 0, len(missedCalls))
+	for i := range missedCalls {
+		if !client.sendJSON(newMissedCallMessage(&missedCalls[i])) {
+			c.Logger().Error("Failed to deliver missed call")
+			break
+		}
+		delivered = append(delivered, missedCalls[i].ID)
+	}
+
+	if err := models.MarkMissedCallsNotified(s.DB, delivered); err != nil {
+		c.Logger().Error("Failed to mark missed calls as notified: ", err)
+	}
+}
+
+func newMissedCallMessage(missedCall *models.MissedCall) messages.MissedCallMessage {
+	callerName := ""
+	if missedCall.Caller != nil {
+		callerName = missedCall.Caller.GetDisplayName()
+	}
+
+	return messages.NewMissedCallMessage(missedCall.ID, missedCall.CallerID, callerName, string(missedCall.Reason), missedCall.CreatedAt)
+}
+
+// ListMissedCalls returns the calls the user missed and hasn't cleared yet
+func (h *AuthHandler) ListMissedCalls(c echo.Context) error {
+	user, isAuthenticated := h.getAuthenticatedUserFromJWT(c)
+	if !isAuthenticated {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
+	}
+
+	var missedCalls []models.MissedCall
+	err := h.DB.Preload("Caller", func(db *gorm.DB) *gorm.DB {
+		return db.Select("id, first_name, last_name, email, avatar_url")
+	}).
+		Where("callee_id = ?", user.ID).
+		Order("created_at DESC").
+		Find(&missedCalls).Error
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get missed calls")
+	}
+
+	return c.JSON(http.StatusOK, missedCalls)
+}
+
+// ClearMissedCalls clears all the missed calls of the user
+func (h *AuthHandler) ClearMissedCalls(c echo.Context) error {
+	user, isAuthenticated := h.getAuthenticatedUserFromJWT(c)
+	if !isAuthenticated {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
+	}
+
+	if err := h.DB.Where("callee_id = ?", user.ID).Delete(&models.MissedCall{}).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to clear missed calls")
+	}
+
+	// Seeded again from the database on the next read
+	if err := h.Redis.Del(c.Request().Context(), common.GetUnreadMissedCallsKey(user.ID)).Err(); err != nil {
+		c.Logger().Error("Failed to reset missed call counters: ", err)
+	}
+
+	return c.NoContent(http.StatusOK)
+}
+
+// ClearMissedCall clears a single missed call of the user
+func (h *AuthHandler) ClearMissedCall(c echo.Context) error {
+	user, isAuthenticated := h.getAuthenticatedUserFromJWT(c)
+	if !isAuthenticated {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
+	}
+
+	var missedCall models.MissedCall
+	result := h.DB.Where("id = ? AND callee_id = ?", c.Param("id"), user.ID).First(&missedCall)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, "Missed call not found")
+	}
+	if result.Error != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get missed call")
+	}
+
+	if err := h.DB.Delete(&missedCall).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to clear missed call")
+	}
+	incrementUnread(&h.ServerState, common.GetUnreadMissedCallsKey(user.ID), missedCall.CallerID, -1)
+
+	return c.NoContent(http.StatusOK)
+}
@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"hopp-backend/internal/common"
+	"hopp-backend/internal/messages"
+	"hopp-backend/internal/models"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// offlineQueueSize is how many messages are kept for an offline user, the oldest are dropped
+	offlineQueueSize = 200
+	// offlineQueueTTL is how long the queued messages of an offline user are kept
+	offlineQueueTTL = 7 * 24 * time.Hour
+)
+
+// offlineQueuedMessageTypes are the messages still worth delivering once an offline user
+// connects. Messages about calls are only useful while they ring, and missed calls and
+// callback requests are delivered from the database.
+var offlineQueuedMessageTypes = map[messages.MessageType]bool{
+	messages.MessageTypeJoinRequest:    true,
+	messages.MessageTypeTeammateLeft:   true,
+	messages.MessageTypeTeammateStatus: true,
+}
+
+// isQueuedWhileOffline checks if the encoded message is kept for users that are offline
+func isQueuedWhileOffline(msgJSON []byte) bool {
+	var base messages.BaseMessage
+	if err := json.Unmarshal(msgJSON, &base); err != nil {
+		return false
+	}
+
+	return offlineQueuedMessageTypes[base.Type]
+}
+
+// deliverQueuedMessages sends the messages published while the user was offline
+// to the user's freshly connected websocket
+func deliverQueuedMessages(c echo.Context, s *common.ServerState, client *wsClient, user *models.User) {
+	rdbCtx := context.Background()
+	key := common.GetOfflineQueueKey(user.ID)
+
+	pipe := s.Redis.TxPipeline()
+	queued := pipe.LRange(rdbCtx, key, 0, -1)
+	pipe.Del(rdbCtx, key)
+	if _, err := pipe.Exec(rdbCtx); err != nil {
+		c.Logger().Error("Failed to get queued messages: ", err)
+		return
+	}
+
+	for _, msg := range queued.Val() {
+		if !client.queue([]byte(msg)) {
+			c.Logger().Error("Failed to deliver queued message")
+			return
+		}
+	}
+}
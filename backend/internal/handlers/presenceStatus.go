@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"encoding/json"
+	"hopp-backend/internal/messages"
+	"hopp-backend/internal/presence"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// UpdatePresenceStatus sets the status the user shows to their teammates,
+// along with an optional text, and lets the teammates know about it
+func (h *AuthHandler) UpdatePresenceStatus(c echo.Context) error {
+	user, isAuthenticated := h.getAuthenticatedUserFromJWT(c)
+	if !isAuthenticated {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
+	}
+
+	type PresenceStatusRequest struct {
+		Status     string `json:"status" validate:"required,oneof=available away focus in_meeting"`
+		StatusText string `json:"status_text" validate:"max=100"`
+	}
+
+	req := new(PresenceStatusRequest)
+	if err := c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request")
+	}
+
+	if err := c.Validate(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	status := presence.Status{Status: req.Status, Text: strings.TrimSpace(req.StatusText)}
+	if err := presence.SetStatus(c.Request().Context(), h.Redis, user.ID, status); err != nil {
+		c.Logger().Error("Failed to store status: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to update status")
+	}
+
+	teammates, err := user.GetAllTeammates(h.DB)
+	if err != nil {
+		c.Logger().Error(err)
+		return c.JSON(http.StatusOK, status)
+	}
+
+	msgJSON, err := json.Marshal(messages.NewTeammateStatusMessage(user.ID, status.Status, status.Text))
+	if err != nil {
+		c.Logger().Error(err)
+		return c.JSON(http.StatusOK, status)
+	}
+
+	for _, teammate := range teammates {
+		publishToUser(&h.ServerState, teammate.ID, msgJSON)
+	}
+
+	return c.JSON(http.StatusOK, status)
+}
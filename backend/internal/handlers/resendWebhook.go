@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"hopp-backend/internal/models"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// resendWebhookTolerance is how old a signed webhook can be, older ones could be replayed
+const resendWebhookTolerance = 5 * time.Minute
+
+// resendDeliveryStatuses are the Resend events that change the delivery state of an email
+var resendDeliveryStatuses = map[string]models.EmailDeliveryStatus{
+	"email.delivered":        models.EmailDelivered,
+	"email.delivery_delayed": models.EmailDeliveryDelayed,
+	"email.bounced":          models.EmailBounced,
+	"email.complained":       models.EmailComplained,
+}
+
+// resendWebhookEvent is the part of the Resend webhook events the backend reads
+type resendWebhookEvent struct {
+	Type      string    `json:"type"`
+	CreatedAt time.Time `json:"created_at"`
+	Data      struct {
+		EmailID string   `json:"email_id"`
+		To      []string `json:"to"`
+		Bounce  *struct {
+			Type string `json:"type"`
+		} `json:"bounce"`
+	} `json:"data"`
+}
+
+// ResendWebhook records the delivery events Resend reports for the sent emails. Addresses
+// that hard-bounce or complain are suppressed, no email is sent to them anymore.
+func (h *AuthHandler) ResendWebhook(c echo.Context) error {
+	if h.Config.Resend.WebhookSecret == "" {
+		return echo.NewHTTPError(http.StatusServiceUnavailable, "Email webhooks are not enabled")
+	}
+
+	body, err := io.ReadAll(c.Request().Body)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request")
+	}
+
+	if err := verifyResendWebhook(h.Config.Resend.WebhookSecret, c.Request().Header, body, time.Now()); err != nil {
+		c.Logger().Warn("Invalid Resend webhook: ", err)
+		return echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
+	}
+
+	var event resendWebhookEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request")
+	}
+
+	status, ok := resendDeliveryStatuses[event.Type]
+	if !ok || event.Data.EmailID == "" {
+		return c.NoContent(http.StatusOK)
+	}
+
+	if event.CreatedAt.IsZero() {
+		event.CreatedAt = time.Now()
+	}
+
+	if _, err := models.UpdateEmailDeliveryStatus(h.DB, event.Data.EmailID, status, event.CreatedAt); err != nil {
+		c.Logger().Error("Failed to update email delivery status: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to update email")
+	}
+
+	var reason models.EmailSuppressionReason
+	switch {
+	// Soft bounces, e.g. a full mailbox, are worth trying again later
+	case status == models.EmailBounced && (event.Data.Bounce == nil || strings.EqualFold(event.Data.Bounce.Type, "Permanent")):
+		reason = models.EmailSuppressionBounced
+	case status == models.EmailComplained:
+		reason = models.EmailSuppressionComplained
+	default:
+		return c.NoContent(http.StatusOK)
+	}
+
+	for _, address := range event.Data.To {
+		if err := models.SuppressEmail(h.DB, address, reason); err != nil {
+			c.Logger().Error("Failed to suppress email address: ", err)
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to suppress email")
+		}
+		c.Logger().Infof("Suppressed emails to %s, %s", address, reason)
+	}
+
+	return c.NoContent(http.StatusOK)
+}
+
+// verifyResendWebhook checks the Svix signature Resend signs its webhooks with: an HMAC of
+// the message ID, timestamp and body with the secret of the webhook endpoint
+func verifyResendWebhook(secret string, headers http.Header, body []byte, now time.Time) error {
+	id := headers.Get("svix-id")
+	timestamp := headers.Get("svix-timestamp")
+	signatures := headers.Get("svix-signature")
+	if id == "" || timestamp == "" || signatures == "" {
+		return fmt.Errorf("missing signature headers")
+	}
+
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid timestamp: %w", err)
+	}
+	if age := now.Sub(time.Unix(seconds, 0)); age > resendWebhookTolerance || age < -resendWebhookTolerance {
+		return fmt.Errorf("timestamp out of tolerance")
+	}
+
+	key, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(secret, "whsec_"))
+	if err != nil {
+		return fmt.Errorf("invalid webhook secret: %w", err)
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(id + "." + timestamp + "."))
+	mac.Write(body)
+	expected := mac.Sum(nil)
+
+	// The header has space separated signatures, one per active secret, e.g. "v1,<base64>"
+	for _, versioned := range strings.Fields(signatures) {
+		version, signature, found := strings.Cut(versioned, ",")
+		if !found || version != "v1" {
+			continue
+		}
+
+		decoded, err := base64.StdEncoding.DecodeString(signature)
+		if err == nil && hmac.Equal(decoded, expected) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("no matching signature")
+}
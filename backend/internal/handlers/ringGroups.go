@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"hopp-backend/internal/common"
+	"hopp-backend/internal/messages"
+	"hopp-backend/internal/models"
+	"hopp-backend/internal/presence"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+)
+
+// ringGroup rings all the callees at once. Every callee gets their own call, linked
+// in a ring group: the first callee to accept takes the call and the rest stop ringing.
+func ringGroup(ctx echo.Context, s *common.ServerState, client *wsClient, callerID string, calleeIDs []string) error {
+	if len(calleeIDs) == 0 {
+		return errors.New("No callees to ring")
+	}
+
+	// Nobody is rung unless all the callees are teammates
+	for _, calleeID := range calleeIDs {
+		if calleeID != callerID && !isTeammateRecipient(s, callerID, calleeID) {
+			return errNotTeammate
+		}
+	}
+
+	ringGroupID := uuid.New().String()
+	rung := make(map[string]bool, len(calleeIDs))
+	for _, calleeID := range calleeIDs {
+		if calleeID == callerID || rung[calleeID] {
+			continue
+		}
+		rung[calleeID] = true
+
+		// Offline, busy and do not disturb callees are reported to the caller
+		// one by one, the rest of the group still rings
+		if err := initiateCall(ctx, s, client, callerID, calleeID, ringGroupID); err != nil {
+			sendWSErrorMessage(client, err.Error())
+		}
+	}
+
+	return nil
+}
+
+// stopRingGroup stops the other calls of the ring group from ringing,
+// once one of its callees accepted the call
+func stopRingGroup(s *common.ServerState, call *callState) {
+	for _, sibling := range getRingGroupCalls(s, call) {
+		if !transitionCall(s, sibling.ID, callRinging, callEnded) {
+			continue
+		}
+
+		msgJSON, err := json.Marshal(messages.NewCallAnsweredElsewhereMessage(sibling.ID, sibling.CallerID, call.CalleeID))
+		if err != nil {
+			s.Echo.Logger.Error(err)
+			continue
+		}
+		publishToUser(s, sibling.CalleeID, msgJSON)
+	}
+}
+
+// stopOtherDevices stops the callee's other devices from ringing, once the callee
+// accepted the call on one of them
+func stopOtherDevices(s *common.ServerState, call *callState) {
+	deviceIDs, err := presence.Devices(context.Background(), s.Redis, call.CalleeID)
+	if err != nil {
+		s.Echo.Logger.Error("Failed to get devices: ", err)
+		return
+	}
+
+	msgJSON, err := json.Marshal(messages.NewCallAnsweredElsewhereMessage(call.ID, call.CallerID, call.CalleeID))
+	if err != nil {
+		s.Echo.Logger.Error(err)
+		return
+	}
+
+	for _, deviceID := range deviceIDs {
+		if deviceID != call.CalleeDevice {
+			publishToDevice(s, call.CalleeID, deviceID, msgJSON)
+		}
+	}
+}
+
+// cancelRingGroup stops the other calls of the ring group from ringing,
+// once the caller hung up before anyone answered
+func cancelRingGroup(s *common.ServerState, call *callState) {
+	for _, sibling := range getRingGroupCalls(s, call) {
+		if !transitionCall(s, sibling.ID, callRinging, callEnded) {
+			continue
+		}
+
+		publishCallEnd(s, sibling.CalleeID, sibling.CallerID, sibling.ID)
+		recordMissedCall(s, sibling.CallerID, sibling.CalleeID, models.MissedCallNoAnswer)
+	}
+}
@@ -0,0 +1,20 @@
+package handlers
+
+import (
+	"hopp-backend/internal/seed"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// SeedDemoData fills the database with a demo team, its users, invitations and
+// call history for local development. Only registered with the debug endpoints.
+func (h *AuthHandler) SeedDemoData(c echo.Context) error {
+	result, err := seed.Run(h.DB)
+	if err != nil {
+		c.Logger().Error("Failed to seed demo data: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to seed demo data")
+	}
+
+	return c.JSON(http.StatusOK, result)
+}
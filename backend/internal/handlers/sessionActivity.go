@@ -0,0 +1,38 @@
+package handlers
+
+import (
+	"encoding/json"
+	"hopp-backend/internal/common"
+	"hopp-backend/internal/messages"
+	"hopp-backend/internal/models"
+	"hopp-backend/internal/presence"
+
+	"github.com/labstack/echo/v4"
+)
+
+// setSessionActivity stores what the user is doing, e.g. sharing their screen, and lets
+// the teammates know. Only the kind of activity is shared, not the content or who with.
+func setSessionActivity(ctx echo.Context, s *common.ServerState, user *models.User, payload messages.SessionActivityPayload) error {
+	if err := presence.SetActivity(ctx.Request().Context(), s.Redis, user.ID, payload.Activity); err != nil {
+		ctx.Logger().Error("Failed to store activity: ", err)
+		return errMessageFailed
+	}
+
+	teammates, err := user.GetAllTeammates(s.DB)
+	if err != nil {
+		ctx.Logger().Error(err)
+		return errMessageFailed
+	}
+
+	msgJSON, err := json.Marshal(messages.NewTeammateActivityMessage(user.ID, payload.Activity))
+	if err != nil {
+		ctx.Logger().Error(err)
+		return errMessageFailed
+	}
+
+	for _, teammate := range teammates {
+		publishToUser(s, teammate.ID, msgJSON)
+	}
+
+	return nil
+}
@@ -0,0 +1,226 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"hopp-backend/internal/models"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// sessionActivityInterval throttles how often the last usage of a session is written
+const sessionActivityInterval = 5 * time.Minute
+
+// issueToken generates a JWT for the user and tracks it as
+// a session of the device that made the request
+func (h *AuthHandler) issueToken(c echo.Context, user *models.User) (string, error) {
+	issued, err := h.JwtIssuer.IssueToken(user.Email)
+	if err != nil {
+		return "", err
+	}
+
+	session := models.UserSession{
+		UserID:     user.ID,
+		JTI:        issued.ID,
+		UserAgent:  c.Request().UserAgent(),
+		IPAddress:  c.RealIP(),
+		LastUsedAt: time.Now(),
+		ExpiresAt:  issued.ExpiresAt,
+	}
+
+	newDevice := h.isNewDevice(c, user, &session)
+	var revokeToken string
+	if newDevice {
+		var revokeTokenHash string
+		revokeToken, revokeTokenHash = models.NewSecretToken()
+		session.RevokeTokenHash = &revokeTokenHash
+	}
+
+	if err := h.DB.Create(&session).Error; err != nil {
+		return "", err
+	}
+
+	if newDevice && h.EmailClient != nil {
+		revokeLink := fmt.Sprintf("https://%s/api/sessions/revoke?token=%s", h.Config.Server.DeployDomain, revokeToken)
+		device := session.UserAgent
+		if device == "" {
+			device = "Unknown device"
+		}
+		h.EmailClient.SendNewDeviceAlert(user, device, session.IPAddress, session.LastUsedAt, revokeLink)
+	}
+
+	return issued.Token, nil
+}
+
+// isNewDevice reports whether the user has signed in before, but never
+// from the IP address and user agent combination of the session
+func (h *AuthHandler) isNewDevice(c echo.Context, user *models.User, session *models.UserSession) bool {
+	// Include revoked sessions, signing out doesn't make a device unknown.
+	// The devices of support admins impersonating the user don't count.
+	var total, known int64
+	if err := h.DB.Unscoped().Model(&models.UserSession{}).Where("user_id = ? AND impersonator = ''", user.ID).Count(&total).Error; err != nil {
+		c.Logger().Error("Failed to count sessions: ", err)
+		return false
+	}
+	if total == 0 {
+		return false
+	}
+
+	err := h.DB.Unscoped().Model(&models.UserSession{}).
+		Where("user_id = ? AND impersonator = '' AND ip_address = ? AND user_agent = ?", user.ID, session.IPAddress, session.UserAgent).
+		Count(&known).Error
+	if err != nil {
+		c.Logger().Error("Failed to count sessions: ", err)
+		return false
+	}
+
+	return known == 0
+}
+
+// ShowRevokeSessionConfirmation is opened from the "this wasn't me" link in the
+// new device email, the page it renders submits the token to RevokeSessionFromEmail
+func (h *AuthHandler) ShowRevokeSessionConfirmation(c echo.Context) error {
+	return renderLinkConfirmation(c, "Sign out this device",
+		"If you don't recognize the new sign-in to your Hopp account, sign the device out.", "Sign out device")
+}
+
+// RevokeSessionFromEmail signs out the device of the "this wasn't me" link
+// in the new device email. It works without being signed in.
+func (h *AuthHandler) RevokeSessionFromEmail(c echo.Context) error {
+	token := c.FormValue("token")
+	if token == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "Missing token")
+	}
+
+	var session models.UserSession
+	result := h.DB.Where("revoke_token_hash = ?", models.HashToken(token)).First(&session)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		// Already signed out
+		return c.Redirect(http.StatusFound, "/login?session_revoked=true")
+	}
+	if result.Error != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get session")
+	}
+
+	if err := h.revokeSession(c, &session); err != nil {
+		c.Logger().Error("Failed to revoke session: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to revoke session")
+	}
+
+	var user models.User
+	if err := h.DB.Where("id = ?", session.UserID).First(&user).Error; err == nil {
+		h.recordAuditEvent(c, models.AuditEventLogout, &user, "", map[string]interface{}{"reason": "new_device_alert"})
+	}
+
+	return c.Redirect(http.StatusFound, "/login?session_revoked=true")
+}
+
+// SessionActivityMiddleware updates the last usage of the session
+// behind the request's JWT. Needs to run after the JWT middleware.
+func SessionActivityMiddleware(db *gorm.DB) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if isAPIKeyRequest(c) {
+				return next(c)
+			}
+
+			claims, err := getClaims(c)
+			if err == nil && claims.ID != "" {
+				now := time.Now()
+				err := db.Model(&models.UserSession{}).
+					Where("jti = ? AND last_used_at < ?", claims.ID, now.Add(-sessionActivityInterval)).
+					Updates(map[string]interface{}{"last_used_at": now, "ip_address": c.RealIP()}).Error
+				if err != nil {
+					c.Logger().Error("Failed to update session activity: ", err)
+				}
+			}
+
+			return next(c)
+		}
+	}
+}
+
+// ListSessions returns the devices the authenticated user is signed in with
+func (h *AuthHandler) ListSessions(c echo.Context) error {
+	user, isAuthenticated := h.getAuthenticatedUserFromJWT(c)
+	if !isAuthenticated {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
+	}
+
+	var sessions []models.UserSession
+	err := h.DB.Where("user_id = ? AND expires_at > ?", user.ID, time.Now()).
+		Order("last_used_at DESC").
+		Find(&sessions).Error
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get sessions")
+	}
+
+	// Let the client know which session is the one making the request
+	currentJTI := ""
+	if claims, err := getClaims(c); err == nil {
+		currentJTI = claims.ID
+	}
+
+	type SessionResponse struct {
+		models.UserSession
+		IsCurrent bool `json:"is_current"`
+	}
+
+	response := make([]SessionResponse, len(sessions))
+	for i, session := range sessions {
+		response[i] = SessionResponse{
+			UserSession: session,
+			IsCurrent:   session.JTI == currentJTI,
+		}
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
+
+// RevokeSession signs out one of the authenticated user's devices
+func (h *AuthHandler) RevokeSession(c echo.Context) error {
+	user, isAuthenticated := h.getAuthenticatedUserFromJWT(c)
+	if !isAuthenticated {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
+	}
+
+	var session models.UserSession
+	result := h.DB.Where("id = ? AND user_id = ?", c.Param("id"), user.ID).First(&session)
+	if result.Error != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "Session not found")
+	}
+
+	if err := h.revokeSession(c, &session); err != nil {
+		c.Logger().Error("Failed to revoke session: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to revoke session")
+	}
+
+	return c.NoContent(http.StatusOK)
+}
+
+func (h *AuthHandler) revokeSession(c echo.Context, session *models.UserSession) error {
+	if err := h.JwtIssuer.RevokeTokenID(c.Request().Context(), session.JTI, session.ExpiresAt); err != nil {
+		return err
+	}
+
+	return h.DB.Delete(session).Error
+}
+
+// revokeAllSessions signs out every device of the user
+func (h *AuthHandler) revokeAllSessions(c echo.Context, userID string) error {
+	var sessions []models.UserSession
+	if err := h.DB.Where("user_id = ?", userID).Find(&sessions).Error; err != nil {
+		return err
+	}
+
+	for i := range sessions {
+		if err := h.revokeSession(c, &sessions[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"context"
+	"hopp-backend/internal/common"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Failed sign-ins are counted per IP and per account. After a subject
+// reaches its threshold every further failure locks it out for
+// exponentially longer, until signInMaxLockout.
+const (
+	signInFailuresWindow     = 15 * time.Minute
+	signInAccountMaxFailures = 5
+	signInIPMaxFailures      = 20
+	signInBaseLockout        = 30 * time.Second
+	signInMaxLockout         = time.Hour
+)
+
+type signInLimiter struct {
+	redis *redis.Client
+}
+
+func newSignInLimiter(redis *redis.Client) *signInLimiter {
+	return &signInLimiter{redis: redis}
+}
+
+func ipSubject(ip string) string {
+	return "ip-" + ip
+}
+
+func accountSubject(email string) string {
+	return "account-" + strings.ToLower(email)
+}
+
+// lockedOut returns how long the IP or the account is still locked out for,
+// zero if neither is locked
+func (l *signInLimiter) lockedOut(ctx context.Context, ip, email string) (time.Duration, error) {
+	var retryAfter time.Duration
+	for _, subject := range []string{ipSubject(ip), accountSubject(email)} {
+		ttl, err := l.redis.TTL(ctx, common.GetSignInLockoutKey(subject)).Result()
+		if err != nil {
+			return 0, err
+		}
+		// Negative TTLs mean the key doesn't exist
+		if ttl > retryAfter {
+			retryAfter = ttl
+		}
+	}
+	return retryAfter, nil
+}
+
+// recordFailure counts a failed sign-in and locks out the IP and/or
+// the account if they went over their threshold
+func (l *signInLimiter) recordFailure(ctx context.Context, ip, email string) error {
+	if err := l.recordSubjectFailure(ctx, ipSubject(ip), signInIPMaxFailures); err != nil {
+		return err
+	}
+	return l.recordSubjectFailure(ctx, accountSubject(email), signInAccountMaxFailures)
+}
+
+func (l *signInLimiter) recordSubjectFailure(ctx context.Context, subject string, maxFailures int64) error {
+	failuresKey := common.GetSignInFailuresKey(subject)
+
+	failures, err := l.redis.Incr(ctx, failuresKey).Result()
+	if err != nil {
+		return err
+	}
+	if err := l.redis.Expire(ctx, failuresKey, signInFailuresWindow).Err(); err != nil {
+		return err
+	}
+
+	if failures < maxFailures {
+		return nil
+	}
+
+	// 30s, 1m, 2m, 4m ... up to an hour
+	exponent := float64(failures - maxFailures)
+	lockout := time.Duration(float64(signInBaseLockout) * math.Pow(2, exponent))
+	if lockout > signInMaxLockout || lockout <= 0 {
+		lockout = signInMaxLockout
+	}
+
+	return l.redis.Set(ctx, common.GetSignInLockoutKey(subject), failures, lockout).Err()
+}
+
+// reset clears the account's failures after a successful sign-in.
+// The IP counter is left alone so one valid account can't be used
+// to reset the limit while guessing others.
+func (l *signInLimiter) reset(ctx context.Context, email string) error {
+	subject := accountSubject(email)
+	return l.redis.Del(ctx, common.GetSignInFailuresKey(subject), common.GetSignInLockoutKey(subject)).Err()
+}
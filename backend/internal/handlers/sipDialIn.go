@@ -0,0 +1,293 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"hopp-backend/internal/common"
+	"hopp-backend/internal/messages"
+	"io"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/livekit/protocol/auth"
+	"github.com/livekit/protocol/livekit"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// dialInPinDigits is the length of the PIN callers type to enter a call by phone
+const dialInPinDigits = 6
+
+// DialIn is the phone number and PIN to join a call by phone
+type DialIn struct {
+	PhoneNumber string `json:"phone_number"`
+	Pin         string `json:"pin"`
+}
+
+// CreateCallDialIn provisions a PIN on the dial-in number that puts callers
+// into the room of a call the user is in. The same PIN is returned while the call goes on.
+func (h *AuthHandler) CreateCallDialIn(c echo.Context) error {
+	user, isAuthenticated := h.getAuthenticatedUserFromJWT(c)
+	if !isAuthenticated {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
+	}
+
+	if h.Config.Livekit.SIPDialInNumber == "" {
+		return echo.NewHTTPError(http.StatusNotImplemented, "Phone dial-in is not configured")
+	}
+
+	roomName := c.Param("room")
+	_, inCall, err := getRoomParticipant(&h.ServerState, roomName, user.ID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get call state")
+	}
+	if !inCall {
+		return echo.NewHTTPError(http.StatusForbidden, "You are not in this call")
+	}
+
+	ctx := c.Request().Context()
+	dialInKey := common.GetSIPDialInKey(roomName)
+
+	pin, err := h.Redis.HGet(ctx, dialInKey, "pin").Result()
+	if err == nil {
+		return c.JSON(http.StatusOK, DialIn{PhoneNumber: h.Config.Livekit.SIPDialInNumber, Pin: pin})
+	}
+
+	pin, err = generateDialInPin()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to generate PIN")
+	}
+
+	request := &livekit.CreateSIPDispatchRuleRequest{
+		Rule: &livekit.SIPDispatchRule{
+			Rule: &livekit.SIPDispatchRule_DispatchRuleDirect{
+				DispatchRuleDirect: &livekit.SIPDispatchRuleDirect{
+					RoomName: roomName,
+					Pin:      pin,
+				},
+			},
+		},
+		InboundNumbers: []string{h.Config.Livekit.SIPDialInNumber},
+		Name:           "hopp-" + roomName,
+	}
+	if h.Config.Livekit.SIPTrunkID != "" {
+		request.TrunkIds = []string{h.Config.Livekit.SIPTrunkID}
+	}
+
+	rule, err := newSIPClient(&h.ServerState).CreateSIPDispatchRule(ctx, request)
+	if err != nil {
+		c.Logger().Error("Failed to create SIP dispatch rule: ", err)
+		return echo.NewHTTPError(http.StatusBadGateway, "Failed to provision phone dial-in")
+	}
+
+	pipe := h.Redis.TxPipeline()
+	pipe.HSet(ctx, dialInKey, map[string]interface{}{
+		"rule_id": rule.GetSipDispatchRuleId(),
+		"pin":     pin,
+	})
+	pipe.Expire(ctx, dialInKey, maxCallDuration)
+	if _, err := pipe.Exec(ctx); err != nil {
+		c.Logger().Error("Failed to store phone dial-in: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to provision phone dial-in")
+	}
+
+	return c.JSON(http.StatusCreated, DialIn{PhoneNumber: h.Config.Livekit.SIPDialInNumber, Pin: pin})
+}
+
+// DeleteCallDialIn stops new callers from dialing in to a call the user is in
+func (h *AuthHandler) DeleteCallDialIn(c echo.Context) error {
+	user, isAuthenticated := h.getAuthenticatedUserFromJWT(c)
+	if !isAuthenticated {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
+	}
+
+	roomName := c.Param("room")
+	_, inCall, err := getRoomParticipant(&h.ServerState, roomName, user.ID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get call state")
+	}
+	if !inCall {
+		return echo.NewHTTPError(http.StatusForbidden, "You are not in this call")
+	}
+
+	removeDialIn(&h.ServerState, roomName)
+
+	return c.NoContent(http.StatusOK)
+}
+
+// LiveKitWebhook receives the room events of the LiveKit server, it keeps
+// track of the phone participants of the calls
+func (h *AuthHandler) LiveKitWebhook(c echo.Context) error {
+	body, err := io.ReadAll(c.Request().Body)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request")
+	}
+
+	if err := verifyLiveKitWebhook(&h.ServerState, c.Request().Header.Get("Authorization"), body); err != nil {
+		c.Logger().Warn("Invalid LiveKit webhook: ", err)
+		return echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
+	}
+
+	event := &livekit.WebhookEvent{}
+	if err := protojson.Unmarshal(body, event); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request")
+	}
+
+	participant := event.GetParticipant()
+	if participant == nil || participant.GetKind() != livekit.ParticipantInfo_SIP {
+		return c.NoContent(http.StatusOK)
+	}
+
+	roomName := event.GetRoom().GetName()
+	ctx := c.Request().Context()
+	participantsKey := common.GetSIPParticipantsKey(roomName)
+
+	var joined bool
+	switch event.GetEvent() {
+	case "participant_joined":
+		joined = true
+		pipe := h.Redis.TxPipeline()
+		pipe.SAdd(ctx, participantsKey, participant.GetIdentity())
+		pipe.Expire(ctx, participantsKey, maxCallDuration)
+		_, err = pipe.Exec(ctx)
+	case "participant_left":
+		err = h.Redis.SRem(ctx, participantsKey, participant.GetIdentity()).Err()
+	default:
+		return c.NoContent(http.StatusOK)
+	}
+	if err != nil {
+		c.Logger().Error("Failed to update phone participants: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to update phone participants")
+	}
+
+	msgJSON, err := json.Marshal(messages.NewPhoneParticipantMessage(roomName, participant.GetIdentity(), participant.GetName(), joined))
+	if err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusOK)
+	}
+
+	for _, userID := range getRoomUsers(&h.ServerState, roomName) {
+		publishToUser(&h.ServerState, userID, msgJSON)
+	}
+
+	return c.NoContent(http.StatusOK)
+}
+
+// removeDialIn deletes the dispatch rule of the call's dial-in, if it has one.
+// It is called once the call is over, so the PIN can't be used anymore.
+func removeDialIn(s *common.ServerState, roomName string) {
+	rdbCtx := context.Background()
+	dialInKey := common.GetSIPDialInKey(roomName)
+
+	ruleID, err := s.Redis.HGet(rdbCtx, dialInKey, "rule_id").Result()
+	if err != nil {
+		return
+	}
+
+	_, err = newSIPClient(s).DeleteSIPDispatchRule(rdbCtx, &livekit.DeleteSIPDispatchRuleRequest{
+		SipDispatchRuleId: ruleID,
+	})
+	if err != nil {
+		s.Echo.Logger.Error("Failed to delete SIP dispatch rule: ", err)
+		return
+	}
+
+	s.Redis.Del(rdbCtx, dialInKey, common.GetSIPParticipantsKey(roomName))
+}
+
+// getRoomUsers returns the app users in the call of the room: the caller and
+// the callee of a call, or the participants of a group call
+func getRoomUsers(s *common.ServerState, roomName string) []string {
+	call, err := getCall(s, roomName)
+	if err != nil {
+		s.Echo.Logger.Error("Failed to get call state: ", err)
+	}
+	if call != nil {
+		return []string{call.CallerID, call.CalleeID}
+	}
+
+	participantIDs, err := s.Redis.SMembers(context.Background(), common.GetGroupCallParticipantsKey(roomName)).Result()
+	if err != nil {
+		s.Echo.Logger.Error("Failed to get group call participants: ", err)
+		return nil
+	}
+
+	return participantIDs
+}
+
+// generateDialInPin returns a random numeric PIN
+func generateDialInPin() (string, error) {
+	limit := new(big.Int).Exp(big.NewInt(10), big.NewInt(dialInPinDigits), nil)
+	n, err := rand.Int(rand.Reader, limit)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%0*d", dialInPinDigits, n), nil
+}
+
+// verifyLiveKitWebhook checks that the webhook was signed by the LiveKit
+// server with our API key and that the body wasn't tampered with
+func verifyLiveKitWebhook(s *common.ServerState, authHeader string, body []byte) error {
+	token := strings.TrimPrefix(authHeader, "Bearer ")
+	if token == "" {
+		return fmt.Errorf("missing authorization header")
+	}
+
+	verifier, err := auth.ParseAPIToken(token)
+	if err != nil {
+		return err
+	}
+	if verifier.APIKey() != s.Config.Livekit.APIKey {
+		return fmt.Errorf("unknown API key")
+	}
+
+	claims, err := verifier.Verify(s.Config.Livekit.Secret)
+	if err != nil {
+		return err
+	}
+
+	sum := sha256.Sum256(body)
+	if base64.StdEncoding.EncodeToString(sum[:]) != claims.Sha256 {
+		return fmt.Errorf("body checksum mismatch")
+	}
+
+	return nil
+}
+
+// liveKitAPIClient authenticates the requests to the LiveKit server API
+type liveKitAPIClient struct {
+	token string
+}
+
+func (c *liveKitAPIClient) Do(req *http.Request) (*http.Response, error) {
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	return http.DefaultClient.Do(req)
+}
+
+// newSIPClient returns a client of the SIP service of the LiveKit server
+func newSIPClient(s *common.ServerState) livekit.SIP {
+	token, err := auth.NewAccessToken(s.Config.Livekit.APIKey, s.Config.Livekit.Secret).
+		SetSIPGrant(&auth.SIPGrant{Admin: true}).
+		SetValidFor(time.Minute).
+		ToJWT()
+	if err != nil {
+		s.Echo.Logger.Error("Failed to generate LiveKit API token: ", err)
+	}
+
+	return livekit.NewSIPProtobufClient(liveKitAPIURL(s.Config.Livekit.ServerURL), &liveKitAPIClient{token: token})
+}
+
+// liveKitAPIURL turns the websocket URL of the LiveKit server into the URL of its API
+func liveKitAPIURL(serverURL string) string {
+	if strings.HasPrefix(serverURL, "ws") {
+		return "http" + strings.TrimPrefix(serverURL, "ws")
+	}
+	return serverURL
+}
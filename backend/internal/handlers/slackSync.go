@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"encoding/json"
+	"hopp-backend/internal/common"
+	"hopp-backend/internal/models"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/tidwall/gjson"
+)
+
+// slackSyncDelay spaces out users.list calls to stay within Slack's rate limits
+const slackSyncDelay = 3 * time.Second
+
+// StartSlackMemberSync periodically refreshes the Slack workspace members
+// stored in the social metadata of the users that signed in with Slack
+func StartSlackMemberSync(s *common.ServerState) {
+	interval := s.Config.Auth.SlackSyncInterval
+	if interval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			syncSlackMembers(s)
+		}
+	}()
+}
+
+func syncSlackMembers(s *common.ServerState) {
+	var identities []models.UserIdentity
+	if err := s.DB.Where("provider = ? AND access_token <> ''", "slack").Find(&identities).Error; err != nil {
+		s.Echo.Logger.Error("Failed to get Slack identities: ", err)
+		return
+	}
+
+	for i, identity := range identities {
+		if i > 0 {
+			time.Sleep(slackSyncDelay)
+		}
+
+		resp, err := getTeamMembersRawJSON(identity.AccessToken)
+		if err != nil {
+			s.Echo.Logger.Errorf("Failed to get Slack members of user %s: %v", identity.UserID, err)
+			continue
+		}
+
+		// Tokens of users that removed the app stop working, keep the last dump
+		if !gjson.GetBytes(resp, "ok").Bool() {
+			s.Echo.Logger.Warnf("Slack members sync failed for user %s: %s", identity.UserID, gjson.GetBytes(resp, "error").String())
+			continue
+		}
+
+		var result map[string]interface{}
+		if err := json.Unmarshal(resp, &result); err != nil {
+			s.Echo.Logger.Errorf("Failed to parse Slack members of user %s: %v", identity.UserID, err)
+			continue
+		}
+
+		err = s.DB.Model(&models.User{}).
+			Where("id = ?", identity.UserID).
+			Select("social_metadata").
+			Updates(&models.User{SocialMetadata: result}).Error
+		if err != nil {
+			s.Echo.Logger.Errorf("Failed to update Slack members of user %s: %v", identity.UserID, err)
+		}
+	}
+}
+
+// SlackMembersNotOnHopp returns the members of the user's Slack workspace
+// that don't have a Hopp account yet, so they can be invited in one click
+func (h *AuthHandler) SlackMembersNotOnHopp(c echo.Context) error {
+	user, isAuthenticated := h.getAuthenticatedUserFromJWT(c)
+	if !isAuthenticated {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
+	}
+
+	type SlackMember struct {
+		SlackID   string `json:"slack_id"`
+		Name      string `json:"name"`
+		Email     string `json:"email"`
+		AvatarURL string `json:"avatar_url"`
+		// Whether the member has a pending invitation to the user's team
+		Invited bool `json:"invited"`
+	}
+
+	members := []SlackMember{}
+	if user.SocialMetadata == nil {
+		return c.JSON(http.StatusOK, members)
+	}
+
+	rawMetadata, err := json.Marshal(user.SocialMetadata)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to read Slack members")
+	}
+
+	var emails []string
+	gjson.GetBytes(rawMetadata, "members").ForEach(func(_, member gjson.Result) bool {
+		email := strings.ToLower(member.Get("profile.email").String())
+		if email == "" || email == strings.ToLower(user.Email) ||
+			member.Get("deleted").Bool() || member.Get("is_bot").Bool() || member.Get("id").String() == "USLACKBOT" {
+			return true
+		}
+
+		name := member.Get("real_name").String()
+		if name == "" {
+			name = member.Get("name").String()
+		}
+
+		members = append(members, SlackMember{
+			SlackID:   member.Get("id").String(),
+			Name:      name,
+			Email:     email,
+			AvatarURL: member.Get("profile.image_192").String(),
+		})
+		emails = append(emails, email)
+		return true
+	})
+
+	if len(members) == 0 {
+		return c.JSON(http.StatusOK, members)
+	}
+
+	var hoppEmails []string
+	if err := h.DB.Model(&models.User{}).Where("LOWER(email) IN ?", emails).Pluck("LOWER(email)", &hoppEmails).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to match Slack members")
+	}
+
+	var invitedEmails []string
+	if user.TeamID != nil {
+		err := h.DB.Model(&models.EmailInvitation{}).
+			Where("team_id = ? AND LOWER(email) IN ? AND status = ? AND sent_at > ?",
+				*user.TeamID, emails, models.EmailInvitationPending, time.Now().Add(-models.EmailInvitationTTL)).
+			Pluck("LOWER(email)", &invitedEmails).Error
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to match Slack members")
+		}
+	}
+
+	onHopp := make(map[string]bool, len(hoppEmails))
+	for _, email := range hoppEmails {
+		onHopp[email] = true
+	}
+	invited := make(map[string]bool, len(invitedEmails))
+	for _, email := range invitedEmails {
+		invited[email] = true
+	}
+
+	notOnHopp := []SlackMember{}
+	for _, member := range members {
+		if onHopp[member.Email] {
+			continue
+		}
+		member.Invited = invited[member.Email]
+		notOnHopp = append(notOnHopp, member)
+	}
+
+	return c.JSON(http.StatusOK, notOnHopp)
+}
@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"hopp-backend/internal/common"
+	"hopp-backend/internal/messages"
+	"hopp-backend/internal/models"
+	"hopp-backend/internal/presence"
+	"time"
+)
+
+// maxCallDuration matches the validity of the LiveKit tokens,
+// calls still ongoing after it can't have anyone left in them
+const maxCallDuration = 24 * time.Hour
+
+// StartStaleCallSweep periodically ends the calls whose participants
+// disconnected without a call_end
+func StartStaleCallSweep(s *common.ServerState) {
+	interval := s.Config.Calls.StaleCallSweepInterval
+	if interval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			sweepStaleCalls(s, interval)
+		}
+	}()
+}
+
+// sweepStaleCalls ends the calls that a participant is disconnected from. A call
+// is only ended when it is found stale on two sweeps in a row, so participants
+// that reconnect right away keep their call.
+func sweepStaleCalls(s *common.ServerState, interval time.Duration) {
+	rdbCtx := context.Background()
+
+	calls, err := models.GetOngoingCalls(s.DB)
+	if err != nil {
+		s.Echo.Logger.Error("Failed to get ongoing calls: ", err)
+		return
+	}
+
+	for i := range calls {
+		call := &calls[i]
+		online, err := presence.BulkIsOnline(rdbCtx, s.Redis, []string{call.CallerID, call.CalleeID})
+		if err != nil {
+			s.Echo.Logger.Error("Error checking presence: ", err)
+			return
+		}
+		callerOnline := online[call.CallerID]
+		calleeOnline := online[call.CalleeID]
+
+		staleKey := common.GetStaleCallKey(call.ID)
+		tooLong := time.Since(call.StartedAt) > maxCallDuration
+		if callerOnline && calleeOnline && !tooLong {
+			s.Redis.Del(rdbCtx, staleKey)
+			continue
+		}
+
+		if !tooLong {
+			// First time the call is found stale, give it until the next sweep
+			firstSeen, err := s.Redis.SetNX(rdbCtx, staleKey, time.Now().Unix(), 2*interval).Result()
+			if err != nil {
+				s.Echo.Logger.Error("Failed to mark call as stale: ", err)
+				continue
+			}
+			if firstSeen {
+				continue
+			}
+		}
+
+		// The room name is the ID of the call state, a participant may have just ended it
+		if state, err := getCall(s, call.RoomName); err == nil && state != nil {
+			if !transitionCall(s, state.ID, callActive, callEnded) {
+				continue
+			}
+		}
+
+		s.Echo.Logger.Infof("Call %s ending stale call %d between %s and %s", call.RoomName, call.ID, call.CallerID, call.CalleeID)
+
+		if err := call.End(s.DB); err != nil {
+			s.Echo.Logger.Error("Failed to end stale call: ", err)
+			continue
+		}
+		clearInCall(s, call.CallerID, call.CalleeID)
+		emitTeamWebhook(s, call.TeamID, models.WebhookEventCallEnded, newCallWebhookData(call))
+		removeDialIn(s, call.RoomName)
+		s.Redis.Del(rdbCtx, staleKey)
+
+		// Let the participant that is still around know the call is over
+		if callerOnline {
+			publishCallEnd(s, call.CallerID, call.CalleeID, call.RoomName)
+		}
+		if calleeOnline {
+			publishCallEnd(s, call.CalleeID, call.CallerID, call.RoomName)
+		}
+	}
+}
+
+// publishCallEnd tells the user that the call with the participant ended
+func publishCallEnd(s *common.ServerState, userID, participantID, callID string) {
+	msgJSON, err := json.Marshal(messages.NewCallEndMessage(participantID, callID))
+	if err != nil {
+		s.Echo.Logger.Error(err)
+		return
+	}
+
+	publishToUser(s, userID, msgJSON)
+}
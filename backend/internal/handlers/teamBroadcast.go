@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"hopp-backend/internal/common"
+	"hopp-backend/internal/messages"
+	"hopp-backend/internal/models"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// publishToTeam pushes the message to every connected member of the team with one publish.
+// Team messages aren't numbered nor kept for replay or offline members, they are for
+// news the members can also fetch, e.g. from the team settings or the teammates list.
+func publishToTeam(s *common.ServerState, teamID uint, msg interface{}) error {
+	msgJSON, err := json.Marshal(msg)
+	if err != nil {
+		s.Echo.Logger.Error(err)
+		return err
+	}
+
+	if err := s.Redis.Publish(context.Background(), common.GetTeamChannel(teamID), msgJSON).Err(); err != nil {
+		pubsubErrors.WithLabelValues("publish").Inc()
+		s.Echo.Logger.Error("Failed to publish team message: ", err)
+		return err
+	}
+
+	return nil
+}
+
+// publishActiveTeamChanged tells the connections of the user that their active team
+// changed, the hubs move them to the channel of the new team
+func publishActiveTeamChanged(s *common.ServerState, userID string, teamID *uint) {
+	msgJSON, err := json.Marshal(messages.NewActiveTeamChangedMessage(teamID))
+	if err != nil {
+		s.Echo.Logger.Error(err)
+		return
+	}
+
+	publishToUser(s, userID, msgJSON)
+}
+
+// broadcastMemberJoined lets the team know the user just joined it
+func broadcastMemberJoined(s *common.ServerState, teamID uint, member *models.User) {
+	publishToTeam(s, teamID, messages.NewTeamMemberJoinedMessage(teamID, member.ID, member.GetDisplayName()))
+}
+
+// PostTeamAnnouncement sends an announcement of an admin to the connected members of their active team
+func (h *AuthHandler) PostTeamAnnouncement(c echo.Context) error {
+	user, isAuthenticated := h.getAuthenticatedUserFromJWT(c)
+	if !isAuthenticated {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
+	}
+
+	if user.TeamID == nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "User is not part of any team")
+	}
+
+	if !user.IsAdmin {
+		return echo.NewHTTPError(http.StatusForbidden, "Only team admins can post announcements")
+	}
+
+	type TeamAnnouncementRequest struct {
+		Message string `json:"message" validate:"required,max=500"`
+	}
+
+	req := new(TeamAnnouncementRequest)
+	if err := c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request format")
+	}
+
+	if err := c.Validate(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	message := strings.TrimSpace(req.Message)
+	if message == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "Announcement can't be empty")
+	}
+
+	msg := messages.NewTeamAnnouncementMessage(*user.TeamID, message, user.ID, user.GetDisplayName())
+	if err := publishToTeam(&h.ServerState, *user.TeamID, msg); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to send announcement")
+	}
+
+	return c.NoContent(http.StatusOK)
+}
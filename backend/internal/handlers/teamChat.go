@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"fmt"
+	"hopp-backend/internal/messages"
+	"hopp-backend/internal/models"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// teamChatMaxPerPage caps the page size of TeamChatHistory, also used as the default
+const teamChatMaxPerPage = 100
+
+// SendTeamChatMessage stores a message in the chat of the user's active team and
+// sends it to the connected members, the sender included
+func (h *AuthHandler) SendTeamChatMessage(c echo.Context) error {
+	user, isAuthenticated := h.getAuthenticatedUserFromJWT(c)
+	if !isAuthenticated {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
+	}
+
+	if user.TeamID == nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "User is not part of any team")
+	}
+
+	type TeamChatMessageRequest struct {
+		Body string `json:"body" validate:"required,max=2000"`
+	}
+
+	req := new(TeamChatMessageRequest)
+	if err := c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request format")
+	}
+
+	if err := c.Validate(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	body := strings.TrimSpace(req.Body)
+	if body == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "Message can't be empty")
+	}
+
+	chatMessage, err := models.CreateTeamChatMessage(h.DB, *user.TeamID, user.ID, body)
+	if err != nil {
+		c.Logger().Error("Failed to store team chat message: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to send message")
+	}
+
+	// The message is stored, members that miss the publish get it from the history
+	publishToTeam(&h.ServerState, *user.TeamID, messages.NewTeamChatMessageMessage(chatMessage.ID, *user.TeamID,
+		user.ID, user.GetDisplayName(), chatMessage.Body, chatMessage.CreatedAt))
+	sendTeamChatLinkPreview(&h.ServerState, *user.TeamID, chatMessage.ID, chatMessage.Body)
+
+	return c.JSON(http.StatusCreated, chatMessage)
+}
+
+// TeamChatHistory returns the chat messages of the user's active team, newest first,
+// paginated with page and per_page. The total number of messages is returned in the
+// X-Total-Count header.
+func (h *AuthHandler) TeamChatHistory(c echo.Context) error {
+	user, isAuthenticated := h.getAuthenticatedUserFromJWT(c)
+	if !isAuthenticated {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
+	}
+
+	if user.TeamID == nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "User is not part of any team")
+	}
+
+	page, err := parsePositiveQueryParam(c, "page", 1)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid page")
+	}
+
+	perPage, err := parsePositiveQueryParam(c, "per_page", teamChatMaxPerPage)
+	if err != nil || perPage > teamChatMaxPerPage {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("per_page must be between 1 and %d", teamChatMaxPerPage))
+	}
+
+	query := h.DB.Model(&models.TeamChatMessage{}).Where("team_id = ?", *user.TeamID)
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get messages")
+	}
+
+	var chatMessages []models.TeamChatMessage
+	err = h.DB.Where("team_id = ?", *user.TeamID).
+		Preload("Sender", func(db *gorm.DB) *gorm.DB {
+			return db.Select("id, first_name, last_name, email, avatar_url")
+		}).
+		Order("created_at DESC").
+		Limit(perPage).
+		Offset((page - 1) * perPage).
+		Find(&chatMessages).Error
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get messages")
+	}
+
+	c.Response().Header().Set("X-Total-Count", strconv.FormatInt(total, 10))
+
+	return c.JSON(http.StatusOK, chatMessages)
+}
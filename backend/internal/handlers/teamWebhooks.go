@@ -0,0 +1,311 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hopp-backend/internal/common"
+	"hopp-backend/internal/linkpreview"
+	"hopp-backend/internal/models"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+)
+
+// webhookAttempts is how many times a delivery is tried before it is dropped,
+// waiting webhookRetryDelay more after every failed attempt
+const (
+	webhookAttempts   = 3
+	webhookRetryDelay = 5 * time.Second
+)
+
+// webhookMaxRedirects is how many redirects a delivery follows
+const webhookMaxRedirects = 3
+
+// webhookClient delivers the webhooks of teams. Any team admin picks the URLs, so
+// every connection, redirects included, is refused when the address isn't public.
+var webhookClient = &http.Client{
+	Timeout: 10 * time.Second,
+	Transport: &http.Transport{
+		// Proxies would connect on our behalf without the address check
+		Proxy: nil,
+		DialContext: (&net.Dialer{
+			Timeout: 5 * time.Second,
+			Control: linkpreview.CheckAddress,
+		}).DialContext,
+		TLSHandshakeTimeout:   5 * time.Second,
+		ResponseHeaderTimeout: 5 * time.Second,
+		MaxIdleConns:          10,
+		IdleConnTimeout:       30 * time.Second,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) >= webhookMaxRedirects {
+			return errors.New("too many redirects")
+		}
+		if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+			return fmt.Errorf("unsupported scheme: %s", req.URL.Scheme)
+		}
+		return nil
+	},
+}
+
+// webhookEvent is the body of a webhook delivery
+type webhookEvent struct {
+	ID        string      `json:"id"`
+	Event     string      `json:"event"`
+	TeamID    uint        `json:"team_id"`
+	CreatedAt time.Time   `json:"created_at"`
+	Data      interface{} `json:"data"`
+}
+
+// callWebhookData describes the call of call.started and call.ended events
+type callWebhookData struct {
+	CallID    uint       `json:"call_id"`
+	RoomName  string     `json:"room_name"`
+	CallerID  string     `json:"caller_id"`
+	CalleeID  string     `json:"callee_id"`
+	StartedAt time.Time  `json:"started_at"`
+	EndedAt   *time.Time `json:"ended_at,omitempty"`
+	// Duration of the call in seconds, set once the call ended
+	Duration int64 `json:"duration,omitempty"`
+}
+
+// missedCallWebhookData describes the missed call of call.missed events
+type missedCallWebhookData struct {
+	CallerID string                  `json:"caller_id"`
+	CalleeID string                  `json:"callee_id"`
+	Reason   models.MissedCallReason `json:"reason"`
+	MissedAt time.Time               `json:"missed_at"`
+}
+
+// presenceWebhookData describes the user of user.online and user.offline events
+type presenceWebhookData struct {
+	UserID string    `json:"user_id"`
+	At     time.Time `json:"at"`
+}
+
+// dndWebhookData describes the do not disturb mode of the user of user.dnd events
+type dndWebhookData struct {
+	UserID  string     `json:"user_id"`
+	Enabled bool       `json:"enabled"`
+	Until   *time.Time `json:"until,omitempty"`
+}
+
+// ListTeamWebhooks returns the webhooks of the user's active team
+func (h *AuthHandler) ListTeamWebhooks(c echo.Context) error {
+	user, isAuthenticated := h.getAuthenticatedUserFromJWT(c)
+	if !isAuthenticated {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
+	}
+
+	if user.TeamID == nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "User is not part of any team")
+	}
+
+	if !user.IsAdmin {
+		return echo.NewHTTPError(http.StatusForbidden, "Only team admins can manage webhooks")
+	}
+
+	webhooks, err := models.GetTeamWebhooks(h.DB, *user.TeamID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get webhooks")
+	}
+
+	return c.JSON(http.StatusOK, webhooks)
+}
+
+// CreateTeamWebhook adds a webhook to the user's active team.
+// The signing secret is only returned in this response.
+func (h *AuthHandler) CreateTeamWebhook(c echo.Context) error {
+	user, isAuthenticated := h.getAuthenticatedUserFromJWT(c)
+	if !isAuthenticated {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
+	}
+
+	if user.TeamID == nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "User is not part of any team")
+	}
+
+	if !user.IsAdmin {
+		return echo.NewHTTPError(http.StatusForbidden, "Only team admins can manage webhooks")
+	}
+
+	type CreateTeamWebhookRequest struct {
+		URL    string   `json:"url" validate:"required,url"`
+		Events []string `json:"events"`
+	}
+
+	req := new(CreateTeamWebhookRequest)
+	if err := c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request format")
+	}
+
+	if err := c.Validate(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	webhookURL, err := url.Parse(req.URL)
+	if err != nil || (webhookURL.Scheme != "https" && webhookURL.Scheme != "http") {
+		return echo.NewHTTPError(http.StatusBadRequest, "Webhook URL must be an http or https URL")
+	}
+
+	for _, event := range req.Events {
+		if !slices.Contains(models.WebhookEvents, event) {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid event: "+event)
+		}
+	}
+
+	webhook := models.NewTeamWebhook(*user.TeamID, req.URL, req.Events)
+	if err := h.DB.Create(webhook).Error; err != nil {
+		c.Logger().Error("Failed to create webhook: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create webhook")
+	}
+
+	return c.JSON(http.StatusCreated, map[string]interface{}{
+		"webhook": webhook,
+		"secret":  webhook.Secret,
+	})
+}
+
+// DeleteTeamWebhook removes a webhook of the user's active team
+func (h *AuthHandler) DeleteTeamWebhook(c echo.Context) error {
+	user, isAuthenticated := h.getAuthenticatedUserFromJWT(c)
+	if !isAuthenticated {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
+	}
+
+	if user.TeamID == nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "User is not part of any team")
+	}
+
+	if !user.IsAdmin {
+		return echo.NewHTTPError(http.StatusForbidden, "Only team admins can manage webhooks")
+	}
+
+	result := h.DB.Where("id = ? AND team_id = ?", c.Param("id"), *user.TeamID).Delete(&models.TeamWebhook{})
+	if result.Error != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to delete webhook")
+	}
+	if result.RowsAffected == 0 {
+		return echo.NewHTTPError(http.StatusNotFound, "Webhook not found")
+	}
+
+	return c.NoContent(http.StatusOK)
+}
+
+// emitTeamWebhook sends the event to the webhooks of the team that subscribe to it,
+// in the background so calls never wait on the team's endpoints
+func emitTeamWebhook(s *common.ServerState, teamID *uint, event string, data interface{}) {
+	if teamID == nil {
+		return
+	}
+
+	webhooks, err := models.GetTeamWebhooks(s.DB, *teamID)
+	if err != nil {
+		s.Echo.Logger.Error("Failed to get team webhooks: ", err)
+		return
+	}
+
+	body, err := json.Marshal(webhookEvent{
+		ID:        uuid.New().String(),
+		Event:     event,
+		TeamID:    *teamID,
+		CreatedAt: time.Now(),
+		Data:      data,
+	})
+	if err != nil {
+		s.Echo.Logger.Error(err)
+		return
+	}
+
+	for i := range webhooks {
+		webhook := webhooks[i]
+		if !webhook.Subscribes(event) {
+			continue
+		}
+		go deliverWebhook(s, &webhook, event, body)
+	}
+}
+
+// emitPresenceWebhook sends the user.online or user.offline event of the user to the
+// webhooks of their team. Users whose connections time out without closing, e.g. when
+// a server goes away, drop offline without an event.
+func emitPresenceWebhook(s *common.ServerState, user *models.User, event string) {
+	emitTeamWebhook(s, user.TeamID, event, presenceWebhookData{UserID: user.ID, At: time.Now()})
+}
+
+// deliverWebhook posts the event to the webhook, retrying failed attempts
+func deliverWebhook(s *common.ServerState, webhook *models.TeamWebhook, event string, body []byte) {
+	for attempt := 1; attempt <= webhookAttempts; attempt++ {
+		err := postWebhook(webhook, event, body)
+		if err == nil {
+			return
+		}
+
+		s.Echo.Logger.Warnf("Webhook %d delivery attempt %d failed: %v", webhook.ID, attempt, err)
+		if attempt < webhookAttempts {
+			time.Sleep(time.Duration(attempt) * webhookRetryDelay)
+		}
+	}
+}
+
+// postWebhook posts the event to the webhook once. The Hopp-Signature header holds
+// the HMAC-SHA256 of "<timestamp>.<body>" with the webhook secret, so receivers
+// can check the event came from us and reject replays of old ones.
+func postWebhook(webhook *models.TeamWebhook, event string, body []byte) error {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(webhook.Secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+
+	req, err := http.NewRequest(http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set("Hopp-Event", event)
+	req.Header.Set("Hopp-Signature", fmt.Sprintf("t=%s,v1=%s", timestamp, hex.EncodeToString(mac.Sum(nil))))
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+func newCallWebhookData(call *models.Call) callWebhookData {
+	return callWebhookData{
+		CallID:    call.ID,
+		RoomName:  call.RoomName,
+		CallerID:  call.CallerID,
+		CalleeID:  call.CalleeID,
+		StartedAt: call.StartedAt,
+		EndedAt:   call.EndedAt,
+		Duration:  call.Duration,
+	}
+}
+
+func newMissedCallWebhookData(missedCall *models.MissedCall) missedCallWebhookData {
+	return missedCallWebhookData{
+		CallerID: missedCall.CallerID,
+		CalleeID: missedCall.CalleeID,
+		Reason:   missedCall.Reason,
+		MissedAt: missedCall.CreatedAt,
+	}
+}
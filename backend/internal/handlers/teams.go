@@ -0,0 +1,407 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"hopp-backend/internal/messages"
+	"hopp-backend/internal/models"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// maxTeamLogoSize is the maximum size of an uploaded team logo
+const maxTeamLogoSize = 2 << 20 // 2MB
+
+// teamLogoExtensions are the accepted logo content types and their file extension
+var teamLogoExtensions = map[string]string{
+	"image/png":  "png",
+	"image/jpeg": "jpg",
+	"image/webp": "webp",
+}
+
+// ListTeams returns all the teams the authenticated user is a member of
+func (h *AuthHandler) ListTeams(c echo.Context) error {
+	user, isAuthenticated := h.getAuthenticatedUserFromJWT(c)
+	if !isAuthenticated {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
+	}
+
+	var memberships []models.TeamMembership
+	if err := h.DB.Preload("Team").Where("user_id = ?", user.ID).Order("created_at").Find(&memberships).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get teams")
+	}
+
+	type TeamResponse struct {
+		models.Team
+		IsActive bool `json:"is_active"`
+	}
+
+	response := make([]TeamResponse, len(memberships))
+	for i, membership := range memberships {
+		response[i] = TeamResponse{
+			Team:     membership.Team,
+			IsActive: user.TeamID != nil && *user.TeamID == membership.TeamID,
+		}
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
+
+// SwitchActiveTeam changes the team the app shows teammates,
+// watercooler and invitations for
+func (h *AuthHandler) SwitchActiveTeam(c echo.Context) error {
+	user, isAuthenticated := h.getAuthenticatedUserFromJWT(c)
+	if !isAuthenticated {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
+	}
+
+	type SwitchTeamRequest struct {
+		TeamID uint `json:"team_id" validate:"required"`
+	}
+
+	req := new(SwitchTeamRequest)
+	if err := c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request format")
+	}
+
+	if err := c.Validate(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	if !models.IsTeamMember(h.DB, user.ID, req.TeamID) {
+		return echo.NewHTTPError(http.StatusForbidden, "User is not a member of this team")
+	}
+
+	if err := h.DB.Model(user).Update("team_id", req.TeamID).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to switch team")
+	}
+
+	publishActiveTeamChanged(&h.ServerState, user.ID, &req.TeamID)
+
+	return c.JSON(http.StatusOK, user)
+}
+
+// LeaveTeam removes the user from their active team. Another team of the
+// user becomes active, if any. The last admin of a team with other members
+// can't leave before making someone else an admin.
+func (h *AuthHandler) LeaveTeam(c echo.Context) error {
+	user, isAuthenticated := h.getAuthenticatedUserFromJWT(c)
+	if !isAuthenticated {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
+	}
+
+	if user.TeamID == nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "User is not part of any team")
+	}
+	teamID := *user.TeamID
+
+	teammates, _, err := user.GetTeammates(h.DB, models.TeammatesQuery{})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get teammates")
+	}
+
+	if user.IsAdmin && len(teammates) > 0 {
+		admins, err := models.GetTeamAdmins(h.DB, teamID)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get team admins")
+		}
+		if len(admins) <= 1 {
+			return echo.NewHTTPError(http.StatusConflict, "Make another teammate an admin before leaving the team")
+		}
+	}
+
+	err = h.DB.Transaction(func(tx *gorm.DB) error {
+		// Hard delete so the user can join the team again later
+		if err := tx.Unscoped().Where("user_id = ? AND team_id = ?", user.ID, teamID).
+			Delete(&models.TeamMembership{}).Error; err != nil {
+			return err
+		}
+
+		var nextTeamID *uint
+		var next models.TeamMembership
+		result := tx.Where("user_id = ?", user.ID).Order("created_at").Limit(1).Find(&next)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected > 0 {
+			nextTeamID = &next.TeamID
+		}
+
+		// Set the new team on the model too, the AfterSave hook would
+		// otherwise add the user back to the team they left
+		user.TeamID = nextTeamID
+		user.Team = nil
+		return tx.Model(user).Update("team_id", nextTeamID).Error
+	})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to leave team")
+	}
+
+	publishActiveTeamChanged(&h.ServerState, user.ID, user.TeamID)
+
+	msg := messages.NewTeammateLeftMessage(user.ID, teamID)
+	msgJSON, err := json.Marshal(msg)
+	if err != nil {
+		c.Logger().Error(err)
+	} else {
+		for _, teammate := range teammates {
+			publishToUser(&h.ServerState, teammate.ID, msgJSON)
+		}
+	}
+
+	return c.JSON(http.StatusOK, user)
+}
+
+// teamActivityMaxPerPage caps the page size of TeamActivityFeed, also used as the default
+const teamActivityMaxPerPage = 50
+
+// TeamActivityFeed returns the latest events of the user's active team,
+// paginated with page and per_page. The total number of events is
+// returned in the X-Total-Count header.
+func (h *AuthHandler) TeamActivityFeed(c echo.Context) error {
+	user, isAuthenticated := h.getAuthenticatedUserFromJWT(c)
+	if !isAuthenticated {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
+	}
+
+	if user.TeamID == nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "User is not part of any team")
+	}
+
+	page, err := parsePositiveQueryParam(c, "page", 1)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid page")
+	}
+
+	perPage, err := parsePositiveQueryParam(c, "per_page", teamActivityMaxPerPage)
+	if err != nil || perPage > teamActivityMaxPerPage {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("per_page must be between 1 and %d", teamActivityMaxPerPage))
+	}
+
+	query := h.DB.Model(&models.TeamActivity{}).Where("team_id = ?", *user.TeamID)
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get team activity")
+	}
+
+	var activities []models.TeamActivity
+	err = h.DB.Where("team_id = ?", *user.TeamID).
+		Preload("Actor", func(db *gorm.DB) *gorm.DB {
+			return db.Select("id, first_name, last_name, email, avatar_url")
+		}).
+		Order("created_at DESC").
+		Limit(perPage).
+		Offset((page - 1) * perPage).
+		Find(&activities).Error
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get team activity")
+	}
+
+	c.Response().Header().Set("X-Total-Count", strconv.FormatInt(total, 10))
+
+	return c.JSON(http.StatusOK, activities)
+}
+
+// UploadTeamLogo stores the logo of the user's active team in object storage,
+// so invitations can be branded with it
+func (h *AuthHandler) UploadTeamLogo(c echo.Context) error {
+	user, isAuthenticated := h.getAuthenticatedUserFromJWT(c)
+	if !isAuthenticated {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
+	}
+
+	if user.TeamID == nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "User is not part of any team")
+	}
+
+	if !user.IsAdmin {
+		return echo.NewHTTPError(http.StatusForbidden, "Only team admins can change the team logo")
+	}
+
+	if h.Storage == nil {
+		return echo.NewHTTPError(http.StatusServiceUnavailable, "Uploads are not enabled")
+	}
+
+	fileHeader, err := c.FormFile("logo")
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Missing logo file")
+	}
+
+	if fileHeader.Size > maxTeamLogoSize {
+		return echo.NewHTTPError(http.StatusRequestEntityTooLarge, "Logo must be smaller than 2MB")
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Failed to read logo file")
+	}
+	defer file.Close()
+
+	// Don't trust the client's content type, sniff it from the file
+	head := make([]byte, 512)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return echo.NewHTTPError(http.StatusBadRequest, "Failed to read logo file")
+	}
+	contentType := http.DetectContentType(head[:n])
+	extension, ok := teamLogoExtensions[contentType]
+	if !ok {
+		return echo.NewHTTPError(http.StatusBadRequest, "Logo must be a PNG, JPEG or WebP image")
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to read logo file")
+	}
+
+	// Unique key per upload so CDNs don't serve a stale logo
+	logoID, err := uuid.NewV7()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to upload logo")
+	}
+	key := fmt.Sprintf("teams/%d/logo-%s.%s", *user.TeamID, logoID.String(), extension)
+
+	logoURL, err := h.Storage.Upload(c.Request().Context(), key, contentType, file, fileHeader.Size)
+	if err != nil {
+		c.Logger().Error("Failed to upload team logo: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to upload logo")
+	}
+
+	var team models.Team
+	if err := h.DB.First(&team, *user.TeamID).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get team information")
+	}
+
+	if err := h.DB.Model(&team).Update("logo_url", logoURL).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to update team logo")
+	}
+
+	return c.JSON(http.StatusOK, team)
+}
+
+// GetTeamSettings returns the policies of the user's active team
+func (h *AuthHandler) GetTeamSettings(c echo.Context) error {
+	user, isAuthenticated := h.getAuthenticatedUserFromJWT(c)
+	if !isAuthenticated {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
+	}
+
+	if user.TeamID == nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "User is not part of any team")
+	}
+
+	settings, err := models.GetTeamSettings(h.DB, *user.TeamID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get team settings")
+	}
+
+	return c.JSON(http.StatusOK, settings)
+}
+
+// UpdateTeamSettings changes the policies of the user's active team,
+// only the fields present in the request are updated
+func (h *AuthHandler) UpdateTeamSettings(c echo.Context) error {
+	user, isAuthenticated := h.getAuthenticatedUserFromJWT(c)
+	if !isAuthenticated {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
+	}
+
+	if user.TeamID == nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "User is not part of any team")
+	}
+
+	if !user.IsAdmin {
+		return echo.NewHTTPError(http.StatusForbidden, "Only team admins can change team settings")
+	}
+
+	type UpdateTeamSettingsRequest struct {
+		AllowAnonymousWatercooler *bool   `json:"allow_anonymous_watercooler"`
+		InvitePolicy              *string `json:"invite_policy" validate:"omitempty,oneof=everyone admins"`
+		DefaultDNDStart           *string `json:"default_dnd_start"`
+		DefaultDNDEnd             *string `json:"default_dnd_end"`
+		RecordingPolicy           *string `json:"recording_policy" validate:"omitempty,oneof=disabled allowed"`
+		// CIDRs or addresses, an empty list lets members connect from anywhere
+		IPAllowlist *[]string `json:"ip_allowlist" validate:"omitempty,max=100"`
+	}
+
+	req := new(UpdateTeamSettingsRequest)
+	if err := c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request format")
+	}
+
+	if err := c.Validate(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	settings, err := models.GetTeamSettings(h.DB, *user.TeamID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get team settings")
+	}
+
+	before := *settings
+
+	if req.AllowAnonymousWatercooler != nil {
+		settings.AllowAnonymousWatercooler = *req.AllowAnonymousWatercooler
+	}
+	if req.InvitePolicy != nil {
+		settings.InvitePolicy = models.TeamInvitePolicy(*req.InvitePolicy)
+	}
+	if req.RecordingPolicy != nil {
+		settings.RecordingPolicy = models.TeamRecordingPolicy(*req.RecordingPolicy)
+	}
+	if req.IPAllowlist != nil {
+		if _, err := ParseCIDRs(*req.IPAllowlist); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		// Admins would lock themselves out along with everyone else
+		if !isIPAllowed(*req.IPAllowlist, c.RealIP()) {
+			return echo.NewHTTPError(http.StatusBadRequest, "The IP allowlist has to include your own address")
+		}
+		settings.IPAllowlist = *req.IPAllowlist
+	}
+	if settings.DefaultDNDStart, err = updateDNDHour(settings.DefaultDNDStart, req.DefaultDNDStart); err != nil {
+		return err
+	}
+	if settings.DefaultDNDEnd, err = updateDNDHour(settings.DefaultDNDEnd, req.DefaultDNDEnd); err != nil {
+		return err
+	}
+
+	if (settings.DefaultDNDStart == nil) != (settings.DefaultDNDEnd == nil) {
+		return echo.NewHTTPError(http.StatusBadRequest, "Both do not disturb start and end are required")
+	}
+
+	recordAuditChange(c, "allow_anonymous_watercooler", before.AllowAnonymousWatercooler, settings.AllowAnonymousWatercooler)
+	recordAuditChange(c, "invite_policy", before.InvitePolicy, settings.InvitePolicy)
+	recordAuditChange(c, "recording_policy", before.RecordingPolicy, settings.RecordingPolicy)
+	recordAuditChange(c, "ip_allowlist", before.IPAllowlist, settings.IPAllowlist)
+
+	if err := h.DB.Save(settings).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to update team settings")
+	}
+
+	publishToTeam(&h.ServerState, *user.TeamID, messages.NewTeamSettingsChangedMessage(*user.TeamID))
+
+	return c.JSON(http.StatusOK, settings)
+}
+
+// updateDNDHour applies an optional "HH:MM" update to a do not disturb hour,
+// an empty string clears it
+func updateDNDHour(current, update *string) (*string, error) {
+	if update == nil {
+		return current, nil
+	}
+	if *update == "" {
+		return nil, nil
+	}
+	if _, err := time.Parse("15:04", *update); err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "Do not disturb hours must be in HH:MM format")
+	}
+
+	return update, nil
+}
@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"hopp-backend/internal/email"
+	"net/http"
+	"slices"
+
+	"github.com/labstack/echo/v4"
+)
+
+// SendTestEmail renders an email template with sample data and sends it to an address,
+// for support admins to check the email setup of a deployment and template changes
+func (h *AuthHandler) SendTestEmail(c echo.Context) error {
+	admin, isAuthenticated := h.getAuthenticatedUserFromJWT(c)
+	if !isAuthenticated {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
+	}
+
+	if isImpersonationRequest(c) {
+		return echo.NewHTTPError(http.StatusForbidden, "Forbidden")
+	}
+
+	if isAPIKeyRequest(c) || !slices.Contains(h.Config.Auth.SupportAdminEmails, admin.Email) {
+		return echo.NewHTTPError(http.StatusForbidden, "Forbidden")
+	}
+
+	type TestEmailRequest struct {
+		Template string `json:"template" validate:"required"`
+		To       string `json:"to" validate:"required,email"`
+		// Locale the email is rendered in, the default locale when empty
+		Locale string `json:"locale"`
+	}
+
+	req := new(TestEmailRequest)
+	if err := c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request format")
+	}
+
+	if err := c.Validate(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	if !slices.Contains(email.TemplateNames(), req.Template) {
+		return echo.NewHTTPError(http.StatusBadRequest, "Unknown email template")
+	}
+
+	if h.EmailClient == nil {
+		return echo.NewHTTPError(http.StatusServiceUnavailable, "Emails are not enabled")
+	}
+
+	id, err := h.EmailClient.SendTestEmail(req.To, req.Locale, req.Template)
+	if err != nil {
+		c.Logger().Error("Failed to send test email: ", err)
+		return echo.NewHTTPError(http.StatusBadGateway, "Failed to send test email: "+err.Error())
+	}
+
+	c.Logger().Infof("Support admin %s sent a test %s email to %s", admin.Email, req.Template, req.To)
+
+	return c.JSON(http.StatusOK, map[string]string{
+		"id": id,
+	})
+}
+
+// ListEmailTemplates returns the names of the email templates test emails can be sent of
+func (h *AuthHandler) ListEmailTemplates(c echo.Context) error {
+	admin, isAuthenticated := h.getAuthenticatedUserFromJWT(c)
+	if !isAuthenticated {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
+	}
+
+	if isAPIKeyRequest(c) || !slices.Contains(h.Config.Auth.SupportAdminEmails, admin.Email) {
+		return echo.NewHTTPError(http.StatusForbidden, "Forbidden")
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"templates": email.TemplateNames(),
+		"locales":   email.SupportedLocales,
+	})
+}
@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"context"
+	"hopp-backend/internal/common"
+	"hopp-backend/internal/models"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// unreadSeededField marks a counters hash that was seeded from the database,
+	// counters are only updated incrementally once it is
+	unreadSeededField = "_seeded"
+	// unreadCountsTTL is how long the counters live before they are seeded again,
+	// so a counter that drifted heals by itself
+	unreadCountsTTL = 24 * time.Hour
+)
+
+// incrementUnreadScript adds to the counter of a conversation, if the hash was seeded.
+// Counters that drop to zero are removed.
+var incrementUnreadScript = redis.NewScript(`
+if redis.call("HEXISTS", KEYS[1], ARGV[1]) == 0 then
+	return 0
+end
+local count = redis.call("HINCRBY", KEYS[1], ARGV[2], ARGV[3])
+if count <= 0 then
+	redis.call("HDEL", KEYS[1], ARGV[2])
+end
+return count
+`)
+
+// ConversationUnreadCount is what the user hasn't seen yet from a teammate
+type ConversationUnreadCount struct {
+	UserID         string `json:"user_id"`
+	UnreadMessages int64  `json:"unread_messages"`
+	MissedCalls    int64  `json:"missed_calls"`
+}
+
+// UnreadCountsResponse holds the unread counters of the user, per conversation and in total
+type UnreadCountsResponse struct {
+	Conversations       []ConversationUnreadCount `json:"conversations"`
+	TotalUnreadMessages int64                     `json:"total_unread_messages"`
+	TotalMissedCalls    int64                     `json:"total_missed_calls"`
+}
+
+// incrementUnread adds delta to the counter of the user's conversation with the other user
+func incrementUnread(s *common.ServerState, key, otherID string, delta int64) {
+	err := incrementUnreadScript.Run(context.Background(), s.Redis, []string{key}, unreadSeededField, otherID, delta).Err()
+	if err != nil {
+		s.Echo.Logger.Error("Failed to update unread counter: ", err)
+	}
+}
+
+// readDirectMessages takes the messages the recipient just read off their unread counters
+func readDirectMessages(s *common.ServerState, recipientID string, read []models.DirectMessage) {
+	perSender := make(map[string]int64)
+	for _, directMessage := range read {
+		perSender[directMessage.SenderID]++
+	}
+
+	for senderID, count := range perSender {
+		incrementUnread(s, common.GetUnreadMessagesKey(recipientID), senderID, -count)
+	}
+}
+
+// seedUnreadCounts counts the unread messages and missed calls of the user in the
+// database and stores them as the counters to update from now on.
+// An update that lands between the count and the store can be lost, it heals when the counters expire.
+func seedUnreadCounts(ctx context.Context, s *common.ServerState, userID string) (map[string]string, map[string]string, error) {
+	type conversationCount struct {
+		OtherID string
+		Count   int64
+	}
+
+	var unreadMessages []conversationCount
+	err := s.DB.Model(&models.DirectMessage{}).
+		Select("sender_id AS other_id, COUNT(*) AS count").
+		Where("recipient_id = ? AND read_at IS NULL", userID).
+		Group("sender_id").
+		Scan(&unreadMessages).Error
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var missedCalls []conversationCount
+	err = s.DB.Model(&models.MissedCall{}).
+		Select("caller_id AS other_id, COUNT(*) AS count").
+		Where("callee_id = ?", userID).
+		Group("caller_id").
+		Scan(&missedCalls).Error
+	if err != nil {
+		return nil, nil, err
+	}
+
+	toHash := func(counts []conversationCount) map[string]string {
+		hash := map[string]string{unreadSeededField: "1"}
+		for _, count := range counts {
+			hash[count.OtherID] = strconv.FormatInt(count.Count, 10)
+		}
+		return hash
+	}
+	messagesHash, callsHash := toHash(unreadMessages), toHash(missedCalls)
+
+	pipe := s.Redis.TxPipeline()
+	for key, hash := range map[string]map[string]string{
+		common.GetUnreadMessagesKey(userID):    messagesHash,
+		common.GetUnreadMissedCallsKey(userID): callsHash,
+	} {
+		pipe.Del(ctx, key)
+		pipe.HSet(ctx, key, hash)
+		pipe.Expire(ctx, key, unreadCountsTTL)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, nil, err
+	}
+
+	return messagesHash, callsHash, nil
+}
+
+// UnreadCounts returns the unread direct messages and the missed calls of the user
+// per teammate, so the app can show its badges without paging through the history
+func (h *AuthHandler) UnreadCounts(c echo.Context) error {
+	user, isAuthenticated := h.getAuthenticatedUserFromJWT(c)
+	if !isAuthenticated {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
+	}
+
+	ctx := c.Request().Context()
+
+	pipe := h.Redis.Pipeline()
+	messagesCmd := pipe.HGetAll(ctx, common.GetUnreadMessagesKey(user.ID))
+	callsCmd := pipe.HGetAll(ctx, common.GetUnreadMissedCallsKey(user.ID))
+	if _, err := pipe.Exec(ctx); err != nil {
+		c.Logger().Error("Failed to get unread counters: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get unread counts")
+	}
+
+	messagesHash, callsHash := messagesCmd.Val(), callsCmd.Val()
+	if messagesHash[unreadSeededField] == "" || callsHash[unreadSeededField] == "" {
+		var err error
+		messagesHash, callsHash, err = seedUnreadCounts(ctx, &h.ServerState, user.ID)
+		if err != nil {
+			c.Logger().Error("Failed to seed unread counters: ", err)
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get unread counts")
+		}
+	}
+
+	response := UnreadCountsResponse{Conversations: []ConversationUnreadCount{}}
+	conversations := make(map[string]*ConversationUnreadCount)
+	conversation := func(otherID string) *ConversationUnreadCount {
+		if _, ok := conversations[otherID]; !ok {
+			conversations[otherID] = &ConversationUnreadCount{UserID: otherID}
+		}
+		return conversations[otherID]
+	}
+
+	for otherID, value := range messagesHash {
+		count, _ := strconv.ParseInt(value, 10, 64)
+		if otherID == unreadSeededField || count <= 0 {
+			continue
+		}
+		conversation(otherID).UnreadMessages = count
+		response.TotalUnreadMessages += count
+	}
+
+	for otherID, value := range callsHash {
+		count, _ := strconv.ParseInt(value, 10, 64)
+		if otherID == unreadSeededField || count <= 0 {
+			continue
+		}
+		conversation(otherID).MissedCalls = count
+		response.TotalMissedCalls += count
+	}
+
+	for _, count := range conversations {
+		response.Conversations = append(response.Conversations, *count)
+	}
+	sort.Slice(response.Conversations, func(i, j int) bool {
+		return response.Conversations[i].UserID < response.Conversations[j].UserID
+	})
+
+	return c.JSON(http.StatusOK, response)
+}
@@ -1,15 +1,23 @@
 package handlers
 
 import (
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"hopp-backend/internal/captcha"
 	"hopp-backend/internal/common"
 	"hopp-backend/internal/models"
 	"io"
 	"net/http"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/labstack/echo/v4"
 	"github.com/livekit/protocol/auth"
+	"github.com/livekit/protocol/livekit"
 )
 
 func getTeamInfoRawJSON(accessToken string) ([]byte, error) {
@@ -73,30 +81,150 @@ func getTeamMembersRawJSON(accessToken string) ([]byte, error) {
 	return body, nil
 }
 
-func generateLiveKitTokens(s *common.ServerState, roomName string, participant *models.User) (common.LivekitTokenSet, error) {
+// getMicrosoftAccountID identifies a Microsoft account by the ID of its tenant and
+// its object ID. The admins of any tenant can set any email or user principal name
+// on their accounts, so neither identifies the account (the nOAuth attack).
+// The access token comes straight from the token endpoint, only its claims are read.
+func getMicrosoftAccountID(accessToken, objectID string) (string, error) {
+	claims := jwt.MapClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(accessToken, claims); err != nil {
+		return "", fmt.Errorf("parsing access token: %w", err)
+	}
+
+	tenantID, _ := claims["tid"].(string)
+	if tenantID == "" || objectID == "" {
+		return "", errors.New("missing tenant or object ID")
+	}
+
+	return tenantID + ":" + objectID, nil
+}
+
+// getMicrosoftProfilePhoto fetches the user's profile photo from the Graph API
+// and returns it as a data URL. Returns an empty string if the user has no photo.
+func getMicrosoftProfilePhoto(accessToken string) (string, error) {
+	// 240x240 is more than enough for avatars and keeps the data URL small
+	req, err := http.NewRequest("GET", "https://graph.microsoft.com/v1.0/me/photos/240x240/$value", nil)
+	if err != nil {
+		return "", fmt.Errorf("creating request: %w", err)
+	}
+
+	// Add authorization header
+	req.Header.Add("Authorization", "Bearer "+accessToken)
+
+	// Make the request
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("making request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// Users without a photo get a 404
+	if resp.StatusCode == http.StatusNotFound {
+		return "", nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("reading response: %w", err)
+	}
+
+	contentType := resp.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = "image/jpeg"
+	}
+
+	return fmt.Sprintf("data:%s;base64,%s", contentType, base64.StdEncoding.EncodeToString(body)), nil
+}
+
+// liveKitGrantOptions scopes what a LiveKit identity can do in its room
+type liveKitGrantOptions struct {
+	CanPublish     bool
+	CanSubscribe   bool
+	CanPublishData bool
+	// Track sources the identity can publish, any source when empty
+	PublishSources []livekit.TrackSource
+	// Hidden identities aren't visible to the other participants
+	Hidden bool
+}
+
+var (
+	// The audio identity only carries the microphone
+	audioGrantOptions = liveKitGrantOptions{
+		CanPublish:     true,
+		CanSubscribe:   true,
+		PublishSources: []livekit.TrackSource{livekit.TrackSource_MICROPHONE},
+	}
+	// The video identity shares the screen and sends the remote control events
+	videoGrantOptions = liveKitGrantOptions{
+		CanPublish:     true,
+		CanSubscribe:   true,
+		CanPublishData: true,
+		PublishSources: []livekit.TrackSource{livekit.TrackSource_SCREEN_SHARE, livekit.TrackSource_SCREEN_SHARE_AUDIO},
+	}
+	// Guests can talk, but can't send data to control the screens of others
+	guestGrantOptions = liveKitGrantOptions{
+		CanPublish:   true,
+		CanSubscribe: true,
+	}
+	// Viewers can only follow the room
+	viewerGrantOptions = liveKitGrantOptions{
+		CanSubscribe: true,
+	}
+)
+
+// newLiveKitGrant creates the grant to join the room with explicit permissions,
+// LiveKit grants every permission when none is set
+func newLiveKitGrant(roomName string, canRecord bool, options liveKitGrantOptions) *auth.VideoGrant {
+	grant := &auth.VideoGrant{
+		RoomJoin:   true,
+		Room:       roomName,
+		RoomRecord: canRecord,
+		Hidden:     options.Hidden,
+	}
+	grant.SetCanPublish(options.CanPublish)
+	grant.SetCanSubscribe(options.CanSubscribe)
+	grant.SetCanPublishData(options.CanPublishData)
+	if options.CanPublish && len(options.PublishSources) > 0 {
+		grant.SetCanPublishSources(options.PublishSources)
+	}
+
+	return grant
+}
+
+func generateLiveKitTokens(s *common.ServerState, roomName string, participant *models.User) (tokens common.LivekitTokenSet, err error) {
+	defer func() { recordLiveKitTokenResult(err) }()
+
 	// Create an access token (make sure these are loaded from your config)
 	videoID := fmt.Sprintf("room:%s:%s:video", roomName, participant.ID)
 	audioID := fmt.Sprintf("room:%s:%s:audio", roomName, participant.ID)
 
+	// Participants can only start recordings if their team allows it
+	canRecord := false
+	if participant.TeamID != nil {
+		settings, err := models.GetTeamSettings(s.DB, *participant.TeamID)
+		if err != nil {
+			return common.LivekitTokenSet{}, fmt.Errorf("getting team settings: %w", err)
+		}
+		canRecord = settings.RecordingPolicy == models.TeamRecordingAllowed
+	}
+
 	video := auth.
 		NewAccessToken(s.Config.Livekit.APIKey, s.Config.Livekit.Secret).
 		SetIdentity(videoID).
 		SetValidFor(24 * time.Hour).
 		SetName(participant.GetDisplayName() + " " + "video").
-		SetVideoGrant(&auth.VideoGrant{
-			RoomJoin: true,
-			Room:     roomName,
-		})
+		SetVideoGrant(newLiveKitGrant(roomName, canRecord, videoGrantOptions))
 
 	audio := auth.
 		NewAccessToken(s.Config.Livekit.APIKey, s.Config.Livekit.Secret).
 		SetIdentity(audioID).
 		SetValidFor(24 * time.Hour).
 		SetName(participant.GetDisplayName() + " " + "audio").
-		SetVideoGrant(&auth.VideoGrant{
-			RoomJoin: true,
-			Room:     roomName,
-		})
+		SetVideoGrant(newLiveKitGrant(roomName, canRecord, audioGrantOptions))
 
 	videoToken, err := video.ToJWT()
 	if err != nil {
@@ -115,17 +243,21 @@ func generateLiveKitTokens(s *common.ServerState, roomName string, participant *
 	}, nil
 }
 
-func generateMeetRedirectToken(s *common.ServerState, roomName string, participant *models.User) (string, error) {
+// generateMeetRedirectToken generates the token of a guest joining from LiveKit Meet,
+// viewers can only watch and listen to the room
+func generateMeetRedirectToken(s *common.ServerState, roomName string, participant *models.User, viewerOnly bool) (string, error) {
 	audioID := fmt.Sprintf("room:%s:%s:audio", roomName, participant.ID)
 
+	grantOptions := guestGrantOptions
+	if viewerOnly {
+		grantOptions = viewerGrantOptions
+	}
+
 	audio := auth.
 		NewAccessToken(s.Config.Livekit.APIKey, s.Config.Livekit.Secret).
 		SetIdentity(audioID).
 		SetValidFor(3 * time.Hour).
-		SetVideoGrant(&auth.VideoGrant{
-			RoomJoin: true,
-			Room:     roomName,
-		})
+		SetVideoGrant(newLiveKitGrant(roomName, false, grantOptions))
 
 	audioToken, err := audio.ToJWT()
 	if err != nil {
@@ -147,10 +279,109 @@ func (h *AuthHandler) getAuthenticatedUserFromJWT(c echo.Context) (*models.User,
 
 	// Fetch user from database
 	user := &models.User{}
-	result := h.DB.Where("email = ?", email).First(user)
+	result := h.DB.Scopes(models.WithActiveTeamRole).Where("users.email = ?", email).First(user)
 	if result.Error != nil || user.ID == "" {
 		return nil, false
 	}
 
 	return user, true
 }
+
+// verifyCaptcha returns an HTTP error if the request didn't pass the configured captcha
+func (h *AuthHandler) verifyCaptcha(c echo.Context, token string) error {
+	err := captcha.Verify(token, c.RealIP(), h.Config)
+	if err == nil {
+		return nil
+	}
+
+	if errors.Is(err, captcha.ErrFailed) {
+		return echo.NewHTTPError(http.StatusBadRequest, "Captcha verification failed")
+	}
+
+	c.Logger().Error("Failed to verify captcha: ", err)
+	return echo.NewHTTPError(http.StatusServiceUnavailable, "Captcha verification unavailable")
+}
+
+// isEmailDomainAllowed checks the email against the domains self-hosted
+// instances can restrict registration to
+func (h *AuthHandler) isEmailDomainAllowed(email string) bool {
+	allowed := h.Config.Auth.AllowedEmailDomains
+	if len(allowed) == 0 {
+		return true
+	}
+
+	at := strings.LastIndex(email, "@")
+	if at == -1 {
+		return false
+	}
+
+	return slices.Contains(allowed, strings.ToLower(email[at+1:]))
+}
+
+// checkInvitePolicy returns an error if the invite policy of the user's
+// active team doesn't let them invite people
+func (h *AuthHandler) checkInvitePolicy(user *models.User) error {
+	settings, err := models.GetTeamSettings(h.DB, *user.TeamID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get team settings")
+	}
+
+	if !settings.CanInvite(user) {
+		return echo.NewHTTPError(http.StatusForbidden, "Only team admins can invite people to this team")
+	}
+
+	return nil
+}
+
+// hasInvitation reports whether the email was invited to a team, either
+// with a valid team invitation link or an invitation email
+func (h *AuthHandler) hasInvitation(email, teamInviteUUID string) bool {
+	if teamInviteUUID != "" {
+		_, emailInvitation, err := models.ResolveInvitation(h.DB, teamInviteUUID)
+		if err == nil && (emailInvitation == nil || strings.EqualFold(emailInvitation.Email, email)) {
+			return true
+		}
+	}
+
+	return models.HasPendingEmailInvitation(h.DB, email)
+}
+
+// parsePositiveQueryParam parses an optional positive integer query parameter
+func parsePositiveQueryParam(c echo.Context, name string, defaultValue int) (int, error) {
+	raw := c.QueryParam(name)
+	if raw == "" {
+		return defaultValue, nil
+	}
+
+	value, err := strconv.Atoi(raw)
+	if err != nil {
+		return 0, err
+	}
+	if value < 1 {
+		return 0, fmt.Errorf("%s must be positive", name)
+	}
+
+	return value, nil
+}
+
+// renderLinkConfirmation renders the page the GET links of emails open, it POSTs
+// the token back to the same path once the user submits it. Mail scanners
+// prefetch the links, so following one must not change anything.
+func renderLinkConfirmation(c echo.Context, title, message, button string) error {
+	token := c.QueryParam("token")
+	if token == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "Missing token parameter")
+	}
+
+	// Keep the token out of caches and of the Referer of anything the page loads
+	c.Response().Header().Set("Cache-Control", "no-store")
+	c.Response().Header().Set("Referrer-Policy", "no-referrer")
+
+	return c.Render(http.StatusOK, "confirm-link.html", map[string]string{
+		"Title":   title,
+		"Message": message,
+		"Button":  button,
+		"Action":  c.Request().URL.Path,
+		"Token":   token,
+	})
+}
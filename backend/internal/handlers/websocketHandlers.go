@@ -3,23 +3,46 @@ package handlers
 import (
 	"context"
 	"encoding/json"
-	"fmt"
+	"errors"
 	"hopp-backend/internal/common"
 	"hopp-backend/internal/messages"
 	"hopp-backend/internal/models"
 	"hopp-backend/internal/notifications"
+	"hopp-backend/internal/presence"
 	"net/http"
+	"strings"
+	"time"
 
-	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 	"github.com/labstack/echo/v4"
-	"github.com/redis/go-redis/v9"
 )
 
 // https://github.com/gorilla/websocket/blob/main/examples/chat/client.go#L35
 var wsUpgrader = websocket.Upgrader{
 	ReadBufferSize:  1024,
 	WriteBufferSize: 1024,
+	// The token subprotocol is never picked, it would echo the token back
+	Subprotocols: []string{wsSubprotocol},
+}
+
+const (
+	// wsSubprotocol is the subprotocol clients that authenticate with a subprotocol
+	// ask for next to the token, browsers drop connections without a picked subprotocol
+	wsSubprotocol = "hopp"
+	// wsTokenSubprotocolPrefix marks the subprotocol carrying the token, e.g. bearer.<jwt>
+	wsTokenSubprotocolPrefix = "bearer."
+)
+
+// wsSubprotocolToken extracts the token of websocket requests from the
+// Sec-WebSocket-Protocol header, for clients that can't set the Authorization header
+func wsSubprotocolToken(c echo.Context) ([]string, error) {
+	for _, protocol := range websocket.Subprotocols(c.Request()) {
+		if token, found := strings.CutPrefix(protocol, wsTokenSubprotocolPrefix); found && token != "" {
+			return []string{token}, nil
+		}
+	}
+
+	return nil, errors.New("missing token subprotocol")
 }
 
 func init() {
@@ -29,13 +52,22 @@ func init() {
 	}
 }
 
-func CreateWSHandler(server *common.ServerState) echo.HandlerFunc {
+// maxDeviceIDLength bounds the device IDs clients send, they end up in Redis keys
+const maxDeviceIDLength = 64
+
+// CreateWSHandler returns the handler that upgrades requests to websocket connections of the hub
+func CreateWSHandler(hub *Hub) echo.HandlerFunc {
+	server := hub.server
+
+	upgrader := wsUpgrader
+	upgrader.EnableCompression = server.Config.WebSocket.Compression
+
 	return func(c echo.Context) error {
-		ws, err := wsUpgrader.Upgrade(c.Response(), c.Request(), nil)
-		if err != nil {
-			return err
+		// The websocket places calls and sends messages as the user, which no
+		// API key is scoped for, read-only keys only get through as it is a GET
+		if isAPIKeyRequest(c) {
+			return echo.NewHTTPError(http.StatusForbidden, "API keys can't open a websocket")
 		}
-		defer ws.Close()
 
 		// Get user from context
 		email, err := server.JwtIssuer.GetUserEmail(c)
@@ -48,272 +80,398 @@ func CreateWSHandler(server *common.ServerState) echo.HandlerFunc {
 			return err
 		}
 
-		// Create a cancellable context that will be used to cleanup resources
-		ctx, cancel := context.WithCancel(c.Request().Context())
-		defer cancel()
-
-		// Subscribe to Redis channel for user updates
-		pubsub := server.Redis.Subscribe(ctx, user.GetRedisChannel())
-		defer func() {
-			pubsub.Close()
-			cancel()
-		}()
-
-		// Successful connection message
-		success := messages.NewSuccessMessage("Successful connection for user: " + user.FirstName)
-
-		s, err := json.Marshal(success)
+		// Clients ask for the protocol versions they speak, e.g. protocol=1,2
+		clientVersions, err := messages.ParseProtocolVersions(c.QueryParam("protocol"))
 		if err != nil {
-			c.Logger().Error(err)
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid protocol versions")
 		}
-		err = ws.WriteMessage(websocket.TextMessage, s)
+		protocolVersion, supported := messages.NegotiateProtocolVersion(clientVersions)
+
+		// Clients identify the device they run on, so calls are routed to the device that answers
+		deviceID := c.QueryParam("device_id")
+		if len(deviceID) > maxDeviceIDLength {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid device ID")
+		}
+
+		ws, err := upgrader.Upgrade(c.Response(), c.Request(), nil)
 		if err != nil {
-			c.Logger().Errorf("Error writing initial websocket message: %v", err)
 			return err
 		}
+		if server.Config.WebSocket.Compression {
+			if err := ws.SetCompressionLevel(server.Config.WebSocket.CompressionLevel); err != nil {
+				c.Logger().Warn("Invalid websocket compression level: ", err)
+			}
+		}
 
-		// Use done channel to signal when the connection is closed
-		done := make(chan struct{})
+		if !supported {
+			c.Logger().Warn("Rejecting websocket of user with unsupported protocol versions: ", user.ID, " ", clientVersions)
+			rejectProtocol(ws)
+			return nil
+		}
 
-		// Send user online message to teammates on connection
-		teammates, err := user.GetTeammates(server.DB)
-		if err != nil {
-			c.Logger().Error(err)
-		} else {
-			for _, teammate := range teammates {
-				// Check if teammate is online
-				channels, err := server.Redis.PubSubChannels(ctx, common.GetUserChannel(teammate.ID)).Result()
-				if err != nil {
-					c.Logger().Error(err)
-					continue
-				}
-				if len(channels) > 0 {
-					c.Logger().Info("Notify teammate: ", teammate.ID, " that user: ", user.ID, " is online")
-					publishTeammateOnlineMessage(c, server, user.ID, teammate.ID)
-				}
-			}
+		if code, reason := checkConnectionLimits(c, server, user); code != 0 {
+			c.Logger().Warn("Rejecting websocket of user over the connection limits: ", user.ID, " ", reason)
+			rejectConnection(ws, code, reason)
+			return nil
 		}
 
-		// Websocket read loop
-		go func() {
-			defer func() {
-				close(done)
-				cancel() // Cancel context when websocket closes
-			}()
-			for {
-				messageType, msg, err := ws.ReadMessage()
-				if err != nil {
-					if websocket.IsCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure, websocket.CloseNoStatusReceived) {
-						c.Logger().Debug("WebSocket connection closed normally")
-					} else {
-						c.Logger().Error("WebSocket read error: ", err)
-					}
-					done <- struct{}{}
-					return
-				}
+		client := newWSClient(hub, c, ws, user, deviceID, protocolVersion)
+		if claims, err := getClaims(c); err == nil && claims.ExpiresAt != nil {
+			client.tokenExpiresAt = claims.ExpiresAt.Time
+		}
+		if err := hub.register(client); err != nil {
+			c.Logger().Error("Failed to register websocket connection: ", err)
+			// Connections arriving during shutdown are told to come back to another server
+			ws.WriteControl(websocket.CloseMessage, hub.closeMessage(), time.Now().Add(wsWriteWait))
+			ws.Close()
+			return nil
+		}
+		go client.writePump()
 
-				if messageType != websocket.TextMessage {
-					c.Logger().Warn("Received non-text message in websocket")
-					continue
-				}
+		// Successful connection message
+		client.sendJSON(messages.NewSuccessMessage("Successful connection for user: "+user.FirstName, protocolVersion, wsPingPeriod, wsReconnectPolicy))
 
-				parsedMessage, err := messages.ParseMessage(msg)
-				if err != nil {
-					sendWSErrorMessage(ws, err.Error())
-					continue
-				}
+		// Let the user know about the calls they missed, the callbacks asked
+		// and the rest of what happened while offline
+		deliverMissedCalls(c, server, client, user)
+		deliverCallbackRequests(c, server, client, user)
+		deliverQueuedMessages(c, server, client, user)
 
-				switch {
-				case parsedMessage.CallRequest != nil:
-					// Handle call request
-					c.Logger().Info("Received call request")
-					initiateCall(c, server, ws, pubsub, user.ID, parsedMessage.CallRequest.Payload.CalleeID)
-				case parsedMessage.AcceptCallMessage != nil:
-					// Handle call accept
-					c.Logger().Info("Accepting call")
-					acceptCall(c, server, user.ID, *parsedMessage.AcceptCallMessage)
-				case parsedMessage.RejectCallMessage != nil:
-					// Handle call end
-					c.Logger().Info("Rejecting call")
-					rejectCall(c, server, *parsedMessage.RejectCallMessage)
-				case parsedMessage.CallEnd != nil:
-					// Handle call end
-					c.Logger().Info("Ending call")
-					endCall(c, server, *parsedMessage.CallEnd)
-				case parsedMessage.Ping != nil:
-					// Handle ping message
-					c.Logger().Debug("Received ping")
-					pong := messages.NewPongMessage()
-					pongJSON, err := json.Marshal(pong)
-					if err != nil {
-						c.Logger().Error(err)
-						return
-					}
-					err = ws.WriteMessage(websocket.TextMessage, pongJSON)
-					if err != nil {
-						c.Logger().Error(err)
-						return
-					}
-				case parsedMessage.TeammateOnlineMessage != nil:
-					// Handle user online message
-					c.Logger().Info("Received user online message ", parsedMessage.TeammateOnlineMessage.Payload.TeammateID, " ", user.ID)
-					publishTeammateOnlineMessage(c, server, user.ID, parsedMessage.TeammateOnlineMessage.Payload.TeammateID)
-				default:
-					c.Logger().Warn("Unknown message type")
-				}
+		// Send user online message to the teammates of all the user's teams
+		teammates, err := user.GetAllTeammates(server.DB)
+		if err != nil {
+			c.Logger().Error(err)
+		} else {
+			teammateIDs := make([]string, len(teammates))
+			for i, teammate := range teammates {
+				teammateIDs[i] = teammate.ID
+			}
 
+			online, err := presence.BulkIsOnline(c.Request().Context(), server.Redis, teammateIDs)
+			if err != nil {
+				c.Logger().Error("Error checking presence: ", err)
 			}
-		}()
-
-		// Redis message loop
-		go func() {
-			defer cancel() // Ensure context is cancelled if this goroutine exits first
-			for {
-				select {
-				case <-ctx.Done():
-					return
-				case <-done:
-					c.Logger().Warnf("Redis subscription closed for user: %s\n", user.FirstName)
-					return
-				default:
-					msg, err := pubsub.ReceiveMessage(ctx)
-					if err != nil {
-						select {
-						case <-ctx.Done():
-							// Context was cancelled, this is normal shutdown
-							return
-						default:
-							if err == redis.ErrClosed {
-								done <- struct{}{}
-								return
-							}
-							// Only log truly unexpected errors
-							if err.Error() != "use of closed network connection" {
-								c.Logger().Error("Unexpected Redis error: ", err)
-							}
-							done <- struct{}{}
-							return
-						}
-					}
-
-					parsedMessage, err := messages.ParseMessage([]byte(msg.Payload))
-					if err != nil {
-						c.Logger().Error(err)
-						continue
-					}
-
-					switch {
-					case parsedMessage.IncomingCall != nil:
-						// Forward incoming call message to the callee
-						err = ws.WriteMessage(websocket.TextMessage, []byte(msg.Payload))
-						if err != nil {
-							c.Logger().Error(err)
-						}
-					case parsedMessage.RejectCallMessage != nil:
-						err = ws.WriteMessage(websocket.TextMessage, []byte(msg.Payload))
-						if err != nil {
-							c.Logger().Error(err)
-						}
-					case parsedMessage.AcceptCallMessage != nil:
-						err = ws.WriteMessage(websocket.TextMessage, []byte(msg.Payload))
-						if err != nil {
-							c.Logger().Error(err)
-						}
-					case parsedMessage.CallTokensMessage != nil:
-						err = ws.WriteMessage(websocket.TextMessage, []byte(msg.Payload))
-						if err != nil {
-							c.Logger().Error(err)
-						}
-					case parsedMessage.CallEnd != nil:
-						// Handle call end
-						c.Logger().Info("Received call end")
-						err = ws.WriteMessage(websocket.TextMessage, []byte(msg.Payload))
-						if err != nil {
-							c.Logger().Error(err)
-						}
-					case parsedMessage.TeammateOnlineMessage != nil:
-						// Handle user online message
-						err = ws.WriteMessage(websocket.TextMessage, []byte(msg.Payload))
-						if err != nil {
-							c.Logger().Error(err)
-						}
-					default:
-						c.Logger().Warn("Unknown message type")
-					}
+			for _, teammateID := range teammateIDs {
+				if online[teammateID] {
+					c.Logger().Info("Notify teammate: ", teammateID, " that user: ", user.ID, " is online")
+					publishTeammateOnlineMessage(c, server, user.ID, teammateID)
 				}
 			}
-		}()
+		}
 
 		// Wait for connection to close
-		<-done
+		client.readPump()
 		return nil
 	}
 }
 
-func sendWSErrorMessage(ws *websocket.Conn, message string) {
-	msg := messages.NewErrorMessage(message)
-	msgJSON, err := json.Marshal(msg)
-	if err != nil {
+// rejectProtocol tells the client which protocol versions the server speaks and closes the connection
+func rejectProtocol(ws *websocket.Conn) {
+	defer ws.Close()
+
+	ws.SetWriteDeadline(time.Now().Add(wsWriteWait))
+	if err := ws.WriteJSON(messages.NewProtocolUnsupportedMessage()); err != nil {
 		return
 	}
-	ws.WriteMessage(websocket.TextMessage, msgJSON)
+	closeMsg := websocket.FormatCloseMessage(wsCloseUnsupportedProtocol, "Unsupported protocol version")
+	ws.WriteControl(websocket.CloseMessage, closeMsg, time.Now().Add(wsWriteWait))
+}
+
+// checkConnectionLimits checks if the user, or their team, already has as many connections as
+// allowed. It returns the close code and reason for the new connection, a zero code if it is
+// within the limits. The limits are best effort, connections opening at once can overshoot them.
+func checkConnectionLimits(c echo.Context, s *common.ServerState, user *models.User) (int, string) {
+	rdbCtx := c.Request().Context()
+
+	if limit := s.Config.WebSocket.MaxConnectionsPerUser; limit > 0 {
+		count, err := presence.CountConnections(rdbCtx, s.Redis, []string{user.ID})
+		if err != nil {
+			c.Logger().Error("Failed to count connections: ", err)
+		} else if count >= int64(limit) {
+			return wsCloseUserConnectionLimit, "Too many connections for the user"
+		}
+	}
+
+	if limit := s.Config.WebSocket.MaxConnectionsPerTeam; limit > 0 && user.TeamID != nil {
+		memberIDs, err := models.GetTeamMemberIDs(s.DB, *user.TeamID)
+		if err != nil {
+			c.Logger().Error("Failed to get team members: ", err)
+			return 0, ""
+		}
+
+		count, err := presence.CountConnections(rdbCtx, s.Redis, memberIDs)
+		if err != nil {
+			c.Logger().Error("Failed to count connections: ", err)
+		} else if count >= int64(limit) {
+			return wsCloseTeamConnectionLimit, "Too many connections for the team"
+		}
+	}
+
+	return 0, ""
+}
+
+// rejectConnection closes a freshly upgraded connection with the code and reason
+func rejectConnection(ws *websocket.Conn, code int, reason string) {
+	defer ws.Close()
+
+	closeMsg := websocket.FormatCloseMessage(code, reason)
+	ws.WriteControl(websocket.CloseMessage, closeMsg, time.Now().Add(wsWriteWait))
+}
+
+// errMessageFailed is the reason given for client messages that failed on the server side
+var errMessageFailed = errors.New("Failed to process message")
+
+// errNotTeammate is the reason given for messages to users that aren't teammates of the sender
+var errNotTeammate = errors.New("Recipient is not a teammate")
+
+// isTeammateRecipient checks if the sender can reach the recipient with a message
+// or a call, users only reach the members of their teams
+func isTeammateRecipient(s *common.ServerState, senderID, recipientID string) bool {
+	return recipientID != "" && recipientID != senderID && models.AreTeammates(s.DB, senderID, recipientID)
 }
 
-func initiateCall(ctx echo.Context, s *common.ServerState, ws *websocket.Conn, rdb *redis.PubSub, callerId, calleeID string) {
+// dispatch handles a message the user sent on the connection, the error
+// is the reason the message couldn't be processed
+func (c *wsClient) dispatch(parsedMessage *messages.ParsedMessage) error {
+	ctx, server, user := c.ctx, c.hub.server, c.user
+
+	switch {
+	case parsedMessage.CallRequest != nil:
+		// Handle call request
+		ctx.Logger().Info("Received call request")
+		return initiateCall(ctx, server, c, user.ID, parsedMessage.CallRequest.Payload.CalleeID, "")
+	case parsedMessage.CallRequestGroup != nil:
+		ctx.Logger().Info("Received group call request")
+		return ringGroup(ctx, server, c, user.ID, parsedMessage.CallRequestGroup.Payload.CalleeIDs)
+	case parsedMessage.AcceptCallMessage != nil:
+		// Handle call accept
+		ctx.Logger().Info("Accepting call")
+		return acceptCall(ctx, server, c, *parsedMessage.AcceptCallMessage)
+	case parsedMessage.RejectCallMessage != nil:
+		// Handle call end
+		ctx.Logger().Info("Rejecting call")
+		return rejectCall(ctx, server, user, *parsedMessage.RejectCallMessage)
+	case parsedMessage.CallEnd != nil:
+		// Handle call end
+		ctx.Logger().Info("Ending call")
+		return endCall(ctx, server, user, *parsedMessage.CallEnd)
+	case parsedMessage.GroupCallInvite != nil:
+		ctx.Logger().Info("Received group call invite")
+		return inviteToGroupCall(ctx, server, user.ID, *parsedMessage.GroupCallInvite)
+	case parsedMessage.GroupCallJoin != nil:
+		ctx.Logger().Info("Joining group call")
+		return joinGroupCall(ctx, server, user.ID, *parsedMessage.GroupCallJoin)
+	case parsedMessage.GroupCallLeave != nil:
+		ctx.Logger().Info("Leaving group call")
+		return leaveGroupCall(ctx, server, user.ID, *parsedMessage.GroupCallLeave)
+	case parsedMessage.CallHold != nil:
+		ctx.Logger().Info("Putting call on hold")
+		return holdCall(ctx, server, user, parsedMessage.CallHold.Payload, true)
+	case parsedMessage.CallResume != nil:
+		ctx.Logger().Info("Resuming call")
+		return holdCall(ctx, server, user, parsedMessage.CallResume.Payload, false)
+	case parsedMessage.CallReaction != nil:
+		return relayCallReaction(ctx, server, user, parsedMessage.CallReaction.Payload)
+	case parsedMessage.AnnotationStrokeStart != nil:
+		return relayAnnotation(ctx, server, user.ID, parsedMessage.AnnotationStrokeStart.Type, parsedMessage.AnnotationStrokeStart.Payload)
+	case parsedMessage.AnnotationPoints != nil:
+		return relayAnnotation(ctx, server, user.ID, parsedMessage.AnnotationPoints.Type, parsedMessage.AnnotationPoints.Payload)
+	case parsedMessage.AnnotationClear != nil:
+		return relayAnnotation(ctx, server, user.ID, parsedMessage.AnnotationClear.Type, parsedMessage.AnnotationClear.Payload)
+	case parsedMessage.CallbackRingBack != nil:
+		ctx.Logger().Info("Ringing back callback request")
+		return ringBack(ctx, server, c, user.ID, *parsedMessage.CallbackRingBack)
+	case parsedMessage.DirectMessage != nil:
+		return sendDirectMessage(ctx, server, user, parsedMessage.DirectMessage.Payload)
+	case parsedMessage.DirectMessageAck != nil:
+		return acknowledgeDirectMessages(ctx, server, user, parsedMessage.DirectMessageAck.Payload)
+	case parsedMessage.SessionActivity != nil:
+		return setSessionActivity(ctx, server, user, parsedMessage.SessionActivity.Payload)
+	case parsedMessage.Resume != nil:
+		ctx.Logger().Info("Resuming messages after: ", parsedMessage.Resume.Payload.LastSeq)
+		return resumeMessages(ctx, server, c, user.ID, parsedMessage.Resume.Payload.LastSeq)
+	case parsedMessage.Ping != nil:
+		// Older clients keep the connection alive with JSON pings instead of answering
+		// the ping control frames, they count as a heartbeat all the same
+		ctx.Logger().Debug("Received ping")
+		c.refreshPresence()
+		c.sendJSON(messages.NewPongMessage())
+		return nil
+	case parsedMessage.TeammateOnlineMessage != nil:
+		// Handle user online message
+		ctx.Logger().Info("Received user online message ", parsedMessage.TeammateOnlineMessage.Payload.TeammateID, " ", user.ID)
+		return relayToUser(server, user, parsedMessage.TeammateOnlineMessage.Payload.TeammateID, messages.NewTeammateOnlineMessage(user.ID))
+	default:
+		ctx.Logger().Warn("Unknown message type")
+		return errors.New("Unknown message type")
+	}
+}
+
+// reply tells the client how its message went: messages with an ID are acked or nacked,
+// messages without one only hear back when they fail
+func (c *wsClient) reply(messageID string, err error) {
+	switch {
+	case messageID == "":
+		if err != nil {
+			sendWSErrorMessage(c, err.Error())
+		}
+	case err != nil:
+		nack := messages.NewNackMessage(messageID, err.Error())
+		var invalid *messages.InvalidMessageError
+		if errors.As(err, &invalid) {
+			nack.Payload.Fields = invalid.Fields
+		}
+		c.sendJSON(nack)
+	default:
+		c.sendJSON(messages.NewAckMessage(messageID))
+	}
+}
+
+func sendWSErrorMessage(client *wsClient, message string) {
+	client.sendJSON(messages.NewErrorMessage(message))
+}
+
+func initiateCall(ctx echo.Context, s *common.ServerState, client *wsClient, callerId, calleeID, ringGroupID string) error {
 	rdbCtx := context.Background()
-	calleeChannelID := common.GetUserChannel(calleeID)
+
+	// Strangers can't be rung, or get missed calls and their emails and webhooks
+	if !isTeammateRecipient(s, callerId, calleeID) {
+		return errNotTeammate
+	}
 
 	// Check first if the callee online
-	channels, err := s.Redis.PubSubChannels(rdbCtx, calleeChannelID).Result()
+	online, err := presence.IsOnline(rdbCtx, s.Redis, calleeID)
 	if err != nil {
-		ctx.Logger().Error("Error getting channels: %v", err)
-		return
+		ctx.Logger().Error("Error checking presence: ", err)
+		return errMessageFailed
 	}
 
-	if len(channels) == 0 {
-		msg := messages.NewCalleeOfflineMessage(calleeID)
-		msgJSON, err := json.Marshal(msg)
-		if err != nil {
-			ctx.Logger().Error("Error marshalling message: %v", err)
-			return
-		}
-		ws.WriteMessage(websocket.TextMessage, msgJSON)
-		return
+	if !online {
+		client.sendJSON(messages.NewCalleeOfflineMessage(calleeID))
+
+		recordMissedCall(s, callerId, calleeID, models.MissedCallCalleeOffline)
+		return nil
+	}
+
+	if dnd, until := getDoNotDisturb(s, calleeID); dnd {
+		client.sendJSON(messages.NewCalleeDNDMessage(calleeID, until))
+		return nil
+	}
+
+	// Don't ring someone that is in the middle of another call
+	if isInCall(s, calleeID) {
+		client.sendJSON(messages.NewCalleeBusyMessage(calleeID))
+		return nil
+	}
+
+	callID, err := createCall(s, callerId, client.deviceID, calleeID, ringGroupID)
+	if err != nil {
+		ctx.Logger().Error("Failed to create call: ", err)
+		return errors.New("Failed to start call")
 	}
 
 	// User is online ping the callee
 	// Publish a message to the callee channel
-	msg := messages.NewIncomingCallMessage(callerId)
+	msg := messages.NewIncomingCallMessage(callerId, callID)
 	msgJSON, err := json.Marshal(msg)
 	if err != nil {
 		ctx.Logger().Error(err)
-		return
+		return errMessageFailed
 	}
 
-	s.Redis.Publish(rdbCtx, calleeChannelID, msgJSON)
+	publishToUser(s, calleeID, msgJSON)
+
+	// Let the caller know the ID of the call
+	client.sendJSON(messages.NewCallRingingMessage(callID, calleeID))
+
+	startRinging(s, callID, callerId, calleeID)
+
+	// Calling back a teammate completes the callback requests they left
+	if err := models.CompleteCallbackRequests(s.DB, calleeID, callerId); err != nil {
+		ctx.Logger().Error("Failed to complete callback requests: ", err)
+	}
+
+	return nil
 }
 
-// TODO: Add a method that "forwards" messages from WS (client 1) -> Redis -> WS (client 2)
-// that all it does is serialise the message and publish to the destination user's channel
-func rejectCall(ctx echo.Context, s *common.ServerState, message messages.RejectCallMessage) {
-	// Publish a message to the caller
-	payloadJSON, err := json.Marshal(message)
+// relayToUser forwards a message the sender sent on their connection to another user,
+// who has to be a teammate of the sender
+func relayToUser(s *common.ServerState, sender *models.User, recipientID string, msg interface{}) error {
+	return relayToDevice(s, sender, recipientID, "", msg)
+}
+
+// relayToDevice forwards a message the sender sent on their connection to one device
+// of another user, or all of them if the device isn't known, see relayToUser
+func relayToDevice(s *common.ServerState, sender *models.User, recipientID, deviceID string, msg interface{}) error {
+	if !isTeammateRecipient(s, sender.ID, recipientID) {
+		return errNotTeammate
+	}
+
+	msgJSON, err := json.Marshal(msg)
 	if err != nil {
-		ctx.Logger().Error(err)
-		return
+		s.Echo.Logger.Error(err)
+		return errMessageFailed
+	}
+
+	if err := publishToDevice(s, recipientID, deviceID, msgJSON); err != nil {
+		return errMessageFailed
 	}
 
-	s.Redis.Publish(context.Background(), common.GetUserChannel(message.Payload.CallerID), payloadJSON)
+	return nil
 }
 
-func acceptCall(ctx echo.Context, s *common.ServerState, calleeID string, message messages.AcceptCallMessage) {
-	// Publish a message to the caller for acceptance
-	payloadJSON, err := json.Marshal(message)
-	if err != nil {
-		ctx.Logger().Error(err)
-		return
+func rejectCall(ctx echo.Context, s *common.ServerState, callee *models.User, message messages.RejectCallMessage) error {
+	calleeID := callee.ID
+	callID := resolveCallID(s, message.Payload.CallID, calleeID, message.Payload.CallerID)
+	call, err := getCall(s, callID)
+	if err != nil || call == nil || call.CalleeID != calleeID {
+		ctx.Logger().Warn("Ignoring reject of unknown call: ", callID)
+		return errors.New("Call not found")
+	}
+
+	// The call was already accepted, cancelled or timed out
+	if !transitionCall(s, call.ID, callRinging, callRejected) {
+		return nil
+	}
+	message.Payload.CallID = call.ID
+	message.Payload.CallerID = call.CallerID
+
+	return relayToDevice(s, callee, call.CallerID, call.CallerDevice, message)
+}
+
+// acceptCall starts the call on the device that accepted it, the caller's device
+// and the accepting device get the tokens of the call
+func acceptCall(ctx echo.Context, s *common.ServerState, client *wsClient, message messages.AcceptCallMessage) error {
+	calleeID := client.user.ID
+	callID := resolveCallID(s, message.Payload.CallID, calleeID, message.Payload.CallerID)
+	call, err := getCall(s, callID)
+	if err != nil || call == nil || call.CalleeID != calleeID {
+		return errors.New("Call not found")
+	}
+
+	// Only the first accept of a call still ringing starts it, late accepts after
+	// the caller hung up or the ring timed out, and double accepts, are turned down
+	if !transitionCall(s, call.ID, callRinging, callActive) {
+		return errors.New("Call is no longer ringing")
+	}
+	if !call.RangAt.IsZero() {
+		observeRingToAccept(call.ID, time.Since(call.RangAt))
+	}
+	message.Payload.CallID = call.ID
+	message.Payload.CallerID = call.CallerID
+
+	call.CalleeDevice = client.deviceID
+	if err := s.Redis.HSet(context.Background(), common.GetCallStateKey(call.ID), "callee_device", call.CalleeDevice).Err(); err != nil {
+		ctx.Logger().Error("Failed to store callee device: ", err)
+	}
+
+	stopRingGroup(s, call)
+	stopOtherDevices(s, call)
+
+	// Let the caller know the call was accepted
+	if err := relayToDevice(s, client.user, call.CallerID, call.CallerDevice, message); err != nil {
+		return err
 	}
-	s.Redis.Publish(context.Background(), common.GetUserChannel(message.Payload.CallerID), payloadJSON)
 
 	// Next steps after accepting call
 	// 1. Create a room with the two participants
@@ -323,32 +481,32 @@ func acceptCall(ctx echo.Context, s *common.ServerState, calleeID string, messag
 	caller, err := models.GetUserByID(s.DB, callerID)
 	if err != nil {
 		ctx.Logger().Error(err)
-		sendCommonErrorMessage(s, "Failed to get caller", callerID, calleeID)
-		return
+		sendCommonErrorMessage(s, "Failed to get caller", callerID)
+		return errors.New("Failed to get caller")
 	}
 
 	callee, err := models.GetUserByID(s.DB, calleeID)
 	if err != nil {
 		ctx.Logger().Error(err)
-		sendCommonErrorMessage(s, "Failed to get callee", callerID, calleeID)
-		return
+		sendCommonErrorMessage(s, "Failed to get callee", callerID)
+		return errors.New("Failed to get callee")
 	}
 
-	roomName := uuid.New().String()
-	ctx.Logger().Info("Creating room: ", roomName, " for users ", callerID, " ", calleeID)
+	roomName := call.ID
+	ctx.Logger().Infof("Call %s accepted on device %s, creating room for users %s %s", call.ID, call.CalleeDevice, callerID, calleeID)
 
 	calleeTokens, err := generateLiveKitTokens(s, roomName, callee)
 	if err != nil {
 		ctx.Logger().Error(err)
-		sendCommonErrorMessage(s, "Failed to generate callee tokens", callerID, calleeID)
-		return
+		sendCommonErrorMessage(s, "Failed to generate callee tokens", callerID)
+		return errors.New("Failed to generate callee tokens")
 	}
 
 	callerTokens, err := generateLiveKitTokens(s, roomName, caller)
 	if err != nil {
 		ctx.Logger().Error(err)
-		sendCommonErrorMessage(s, "Failed to generate caller tokens", callerID, calleeID)
-		return
+		sendCommonErrorMessage(s, "Failed to generate caller tokens", callerID)
+		return errors.New("Failed to generate caller tokens")
 	}
 
 	// Publish a message to the caller and the callee
@@ -357,29 +515,49 @@ func acceptCall(ctx echo.Context, s *common.ServerState, calleeID string, messag
 		AudioToken:  calleeTokens.AudioToken,
 		VideoToken:  calleeTokens.VideoToken,
 		Participant: callerID,
-	})
+	}, call.ID)
 	calleeMsgJSON, err := json.Marshal(calleeMsg)
 	if err != nil {
 		ctx.Logger().Error(err)
-		return
+		return errMessageFailed
 	}
 
 	callerMsg := messages.NewCallTokens(common.LivekitTokenSet{
 		AudioToken:  callerTokens.AudioToken,
 		VideoToken:  callerTokens.VideoToken,
 		Participant: calleeID,
-	})
+	}, call.ID)
 	callerMsgJSON, err := json.Marshal(callerMsg)
 	if err != nil {
 		ctx.Logger().Error(err)
-		return
+		return errMessageFailed
+	}
+
+	// Publish the LiveKit tokens to the devices of the caller and the callee in the call
+	publishToDevice(s, message.Payload.CallerID, call.CallerDevice, callerMsgJSON)
+	publishToDevice(s, calleeID, call.CalleeDevice, calleeMsgJSON)
+	markInCall(s, roomName, callerID, calleeID)
+
+	record, err := models.StartCall(s.DB, roomName, caller, callee)
+	if err != nil {
+		ctx.Logger().Error("Failed to record call: ", err)
+	} else {
+		emitTeamWebhook(s, record.TeamID, models.WebhookEventCallStarted, newCallWebhookData(record))
+	}
+
+	if caller.TeamID != nil {
+		metadata := map[string]interface{}{"callee_id": callee.ID}
+		if record != nil {
+			metadata["call_id"] = record.ID
+		}
+		if err := models.RecordTeamActivity(s.DB, *caller.TeamID, models.TeamActivityCall, caller.ID, metadata); err != nil {
+			ctx.Logger().Error("Failed to record team activity: ", err)
+		}
 	}
 
-	// Publish the LiveKit tokens to the caller and the callee
-	s.Redis.Publish(context.Background(), common.GetUserChannel(message.Payload.CallerID), callerMsgJSON)
-	s.Redis.Publish(context.Background(), common.GetUserChannel(calleeID), calleeMsgJSON)
+	s.Notifier.Notify(notifications.NewCallStartedEvent(caller.ID, caller.TeamID, callee.ID))
 
-	_ = notifications.SendTelegramNotification(fmt.Sprintf("Call started: %s -> %s", caller.ID, callee.ID), s.Config)
+	return nil
 }
 
 func sendCommonErrorMessage(s *common.ServerState, err string, userIDs ...string) {
@@ -389,19 +567,100 @@ func sendCommonErrorMessage(s *common.ServerState, err string, userIDs ...string
 		if err != nil {
 			return
 		}
-		s.Redis.Publish(context.Background(), common.GetUserChannel(userID), msgJSON)
+		publishToUser(s, userID, msgJSON)
 	}
 }
 
-func endCall(ctx echo.Context, s *common.ServerState, message messages.CallEndMessage) {
-	// Publish a message to the other participant
-	payloadJSON, err := json.Marshal(message)
+func endCall(ctx echo.Context, s *common.ServerState, user *models.User, message messages.CallEndMessage) error {
+	userID := user.ID
+	callID := resolveCallID(s, message.Payload.CallID, userID, message.Payload.ParticipantID)
+	call, err := getCall(s, callID)
 	if err != nil {
-		ctx.Logger().Error(err)
-		return
+		ctx.Logger().Error("Failed to get call state: ", err)
+	}
+
+	// Calls without a known state are older than the state itself, they are just ended
+	wasRinging := false
+	if call != nil {
+		if !call.isParticipant(userID) {
+			return errors.New("Call not found")
+		}
+
+		switch {
+		case transitionCall(s, call.ID, callRinging, callEnded):
+			wasRinging = true
+		case transitionCall(s, call.ID, callActive, callEnded):
+		default:
+			// The call is already over, e.g. both participants hung up at the same time
+			return nil
+		}
+
+		message.Payload.CallID = call.ID
+		message.Payload.ParticipantID = call.otherParticipant(userID)
+	}
+
+	// Let the other participant know the call is over
+	if err := relayToUser(s, user, message.Payload.ParticipantID, message); err != nil {
+		return err
+	}
+
+	if wasRinging {
+		// The caller hung up before the callee answered
+		if userID == call.CallerID {
+			recordMissedCall(s, userID, call.CalleeID, models.MissedCallNoAnswer)
+			cancelRingGroup(s, call)
+		}
+		return nil
+	}
+
+	clearInCall(s, userID, message.Payload.ParticipantID)
+	if call != nil {
+		removeDialIn(s, call.ID)
+	}
+
+	endedCall, err := models.EndOngoingCall(s.DB, userID, message.Payload.ParticipantID)
+	if err != nil {
+		ctx.Logger().Error("Failed to record call end: ", err)
+	} else if endedCall != nil {
+		emitTeamWebhook(s, endedCall.TeamID, models.WebhookEventCallEnded, newCallWebhookData(endedCall))
+	}
+
+	return nil
+}
+
+// holdCall puts the active call on hold, or resumes it, and relays it to the
+// other participant so both ends pause their media and show the same state
+func holdCall(ctx echo.Context, s *common.ServerState, user *models.User, payload messages.CallHoldPayload, hold bool) error {
+	userID := user.ID
+	callID := resolveCallID(s, payload.CallID, userID, payload.ParticipantID)
+	call, err := getCall(s, callID)
+	if err != nil || call == nil || call.Status != callActive || !call.isParticipant(userID) {
+		return errors.New("Call not found")
+	}
+
+	rdbCtx := context.Background()
+	key := common.GetCallStateKey(call.ID)
+	participantID := call.otherParticipant(userID)
+
+	var msg interface{}
+	if hold {
+		err = s.Redis.HSet(rdbCtx, key, "held_by", userID).Err()
+		msg = messages.NewCallHoldMessage(participantID, call.ID, userID)
+	} else {
+		// Only the participant that put the call on hold can resume it
+		if call.HeldBy != userID {
+			return errors.New("Call wasn't put on hold by you")
+		}
+		err = s.Redis.HDel(rdbCtx, key, "held_by").Err()
+		msg = messages.NewCallResumeMessage(participantID, call.ID, userID)
+	}
+	if err != nil {
+		ctx.Logger().Error("Failed to update call hold state: ", err)
+		return errors.New("Failed to update call")
 	}
+	ctx.Logger().Infof("Call %s hold %t by %s", call.ID, hold, userID)
 
-	s.Redis.Publish(context.Background(), common.GetUserChannel(message.Payload.ParticipantID), payloadJSON)
+	return relayToUser(s, user, participantID, msg)
 }
 
 func publishTeammateOnlineMessage(ctx echo.Context, s *common.ServerState, userID, teammateID string) {
@@ -413,5 +672,5 @@ func publishTeammateOnlineMessage(ctx echo.Context, s *common.ServerState, userI
 		return
 	}
 
-	s.Redis.Publish(context.Background(), common.GetUserChannel(teammateID), msgJSON)
+	publishToUser(s, teammateID, msgJSON)
 }
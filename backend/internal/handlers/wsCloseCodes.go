@@ -0,0 +1,25 @@
+package handlers
+
+import "github.com/gorilla/websocket"
+
+// Close codes the server closes websocket connections with. Standard codes are used
+// where one fits, the reasons of our own have codes in the 4000-4999 range.
+const (
+	// The connection was closed without anything going wrong
+	wsCloseNormal = websocket.CloseNormalClosure
+	// The server is shutting down, reconnect as the server_shutdown message says
+	wsCloseShuttingDown = websocket.CloseServiceRestart
+	// None of the protocol versions of the client are spoken, it needs an update
+	wsCloseUnsupportedProtocol = websocket.CloseProtocolError
+	// A message was bigger than wsMaxMessageSize, written by the websocket library itself
+	wsCloseMessageTooBig = websocket.CloseMessageTooBig
+	// The user, or their team, already has as many connections as allowed
+	wsCloseUserConnectionLimit = 4001
+	wsCloseTeamConnectionLimit = 4002
+	// The client kept sending messages over its rate limits
+	wsCloseRateLimited = 4003
+	// The token the connection was opened with expired, reconnect with a fresh one
+	wsCloseAuthExpired = 4004
+	// The device opened a newer connection, the old one shouldn't reconnect
+	wsCloseTakenOver = 4005
+)
@@ -0,0 +1,551 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"hopp-backend/internal/common"
+	"hopp-backend/internal/messages"
+	"hopp-backend/internal/models"
+	"hopp-backend/internal/presence"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/labstack/echo/v4"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// wsSendBuffer is how many outgoing messages are queued for a connection,
+	// connections that fall further behind are dropped
+	wsSendBuffer = 256
+	// wsWriteWait is how long writing a message to a connection may take
+	wsWriteWait = 10 * time.Second
+	// wsPongWait is how long a connection may stay silent before it is considered dead,
+	// so half-open connections of sleeping laptops are dropped in seconds
+	wsPongWait = 20 * time.Second
+	// wsPingPeriod is how often the server pings the connection, shorter than
+	// wsPongWait so a live connection always answers in time
+	wsPingPeriod = wsPongWait * 2 / 5
+	// wsMaxMessageSize is the biggest message a client can send, bigger ones close the connection
+	wsMaxMessageSize = 64 * 1024
+	// wsMaxReconnectDelay bounds the random delay clients are told to reconnect after on shutdown
+	wsMaxReconnectDelay = 5 * time.Second
+	// wsMinReconnectBackoff and wsMaxReconnectBackoff bound the backoff of clients
+	// between failed reconnects, so a backend that is down isn't hammered
+	wsMinReconnectBackoff = time.Second
+	wsMaxReconnectBackoff = time.Minute
+)
+
+// wsReconnectPolicy is how clients are told to reconnect, they can resume
+// for as long as the replay buffer of the user is kept
+var wsReconnectPolicy = messages.NewReconnectPolicy(wsMinReconnectBackoff, wsMaxReconnectBackoff, replayBufferTTL)
+
+// forwardedMessageTypes are the messages published to a user's Redis channel
+// that are sent on to the user's connections
+var forwardedMessageTypes = map[messages.MessageType]bool{
+	messages.MessageTypeIncomingCall:          true,
+	messages.MessageTypeCallReject:            true,
+	messages.MessageTypeCallAccept:            true,
+	messages.MessageTypeNewCallTokens:         true,
+	messages.MessageTypeCallEnd:               true,
+	messages.MessageTypeCallUnanswered:        true,
+	messages.MessageTypeCallHold:              true,
+	messages.MessageTypeCallResume:            true,
+	messages.MessageTypeCallReaction:          true,
+	messages.MessageTypeCallAnsweredElsewhere: true,
+	messages.MessageTypeCalleeBusy:            true,
+	messages.MessageTypeCalleeDND:             true,
+	messages.MessageTypeMissedCall:            true,
+	messages.MessageTypeCallbackRequest:       true,
+	messages.MessageTypeTeammateOnline:        true,
+	messages.MessageTypeJoinRequest:           true,
+	messages.MessageTypeTeammateLeft:          true,
+	messages.MessageTypeTeammateStatus:        true,
+	messages.MessageTypeTeammateActivity:      true,
+	messages.MessageTypeDirectMessageReceived: true,
+	messages.MessageTypeDirectMessageReaction: true,
+	messages.MessageTypeFileTransfer:          true,
+	messages.MessageTypeDirectMessageState:    true,
+	messages.MessageTypeLinkPreview:           true,
+	messages.MessageTypeTeamAnnouncement:      true,
+	messages.MessageTypeTeamMemberJoined:      true,
+	messages.MessageTypeTeamSettingsChanged:   true,
+	messages.MessageTypeTeamChatMessage:       true,
+	messages.MessageTypeActiveTeamChanged:     true,
+	messages.MessageTypeIncomingGroupCall:     true,
+	messages.MessageTypeGroupCallTokens:       true,
+	messages.MessageTypeGroupCallRoster:       true,
+	messages.MessageTypePhoneParticipant:      true,
+	messages.MessageTypeAnnotationStrokeStart: true,
+	messages.MessageTypeAnnotationPoints:      true,
+	messages.MessageTypeAnnotationClear:       true,
+	messages.MessageTypeError:                 true,
+}
+
+// Hub owns the websocket connections of this server. Connections are grouped by
+// the Redis channel of their user, so a user can be connected from several devices:
+// the hub subscribes to the channel while the user has a connection and forwards
+// every message published to it to all of them. Connections are also grouped by the
+// channel of their device, for the messages that only one device should get, and by
+// the channel of their team, for the messages the whole team gets.
+type Hub struct {
+	server *common.ServerState
+	pubsub *redis.PubSub
+
+	mu           sync.Mutex
+	clients      map[string]map[*wsClient]struct{}
+	shuttingDown bool
+	// Running write pumps, waited for on shutdown so the queued messages are written
+	pumps sync.WaitGroup
+}
+
+// wsClient is a websocket connection of a user. Everything sent to the
+// connection goes through its send channel, written out by its write pump.
+type wsClient struct {
+	id   string
+	hub  *Hub
+	ctx  echo.Context
+	conn *websocket.Conn
+	user *models.User
+	send chan []byte
+	// sendMu guards send against being closed while a message is queued,
+	// closed is set once it is
+	sendMu sync.Mutex
+	closed bool
+	// Device the connection comes from, a reconnecting device keeps its ID
+	deviceID string
+	// Limits the messages the user sends on the connection
+	limiter *wsRateLimiter
+	// Version of the message format negotiated with the client
+	protocolVersion int
+	// When the token the connection was opened with expires, zero if it doesn't
+	tokenExpiresAt time.Time
+	// Team whose channel the connection is in, guarded by the hub's lock
+	teamID *uint
+}
+
+// NewHub creates the hub and starts forwarding the messages of its Redis subscription
+func NewHub(server *common.ServerState) *Hub {
+	hub := &Hub{
+		server:  server,
+		pubsub:  server.Redis.Subscribe(context.Background()),
+		clients: make(map[string]map[*wsClient]struct{}),
+	}
+	go hub.run()
+
+	return hub
+}
+
+func (h *Hub) run() {
+	for msg := range h.pubsub.Channel() {
+		h.forward(msg.Channel, []byte(msg.Payload))
+	}
+}
+
+// register adds the connection to the hub, subscribing to the user's and the
+// device's channels if it is their first connection
+func (h *Hub) register(client *wsClient) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.shuttingDown {
+		return errors.New("server is shutting down")
+	}
+
+	for _, channel := range client.channels() {
+		if err := h.addToChannel(client, channel); err != nil {
+			h.removeClient(client)
+			return err
+		}
+	}
+	h.pumps.Add(1)
+	wsConnections.Inc()
+	if err := presence.Claim(context.Background(), h.server.Redis, client.user.ID, client.deviceID, client.id); err != nil {
+		h.server.Echo.Logger.Error("Failed to claim device: ", err)
+	}
+	client.refreshPresence()
+
+	return nil
+}
+
+// unregister removes the connection from the hub and stops its write pump
+func (h *Hub) unregister(client *wsClient) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := h.clients[client.user.GetRedisChannel()][client]; !ok {
+		return
+	}
+	h.removeClient(client)
+	client.closeSend()
+	wsConnections.Dec()
+
+	wentOffline, err := presence.Disconnect(context.Background(), h.server.Redis, client.user.ID, client.id)
+	if err != nil {
+		h.server.Echo.Logger.Error("Failed to update presence: ", err)
+	} else if wentOffline {
+		go emitPresenceWebhook(h.server, client.user, models.WebhookEventUserOffline)
+	}
+}
+
+// removeClient removes the connection from its channels, unsubscribing from the
+// channels it was the last connection of. The caller must hold the lock.
+func (h *Hub) removeClient(client *wsClient) {
+	for _, channel := range client.channels() {
+		h.removeFromChannel(client, channel)
+	}
+}
+
+// addToChannel adds the connection to the channel, subscribing to it if it is
+// the channel's first connection. The caller must hold the lock.
+func (h *Hub) addToChannel(client *wsClient, channel string) error {
+	if len(h.clients[channel]) == 0 {
+		if err := h.pubsub.Subscribe(context.Background(), channel); err != nil {
+			pubsubErrors.WithLabelValues("subscribe").Inc()
+			return err
+		}
+		h.clients[channel] = make(map[*wsClient]struct{})
+	}
+	h.clients[channel][client] = struct{}{}
+	if channel == client.user.GetRedisChannel() && len(h.clients[channel]) == 1 {
+		wsConnectedUsers.Inc()
+	}
+
+	return nil
+}
+
+// removeFromChannel removes the connection from the channel, unsubscribing from it
+// if it was the channel's last connection. The caller must hold the lock.
+func (h *Hub) removeFromChannel(client *wsClient, channel string) {
+	if _, ok := h.clients[channel][client]; !ok {
+		return
+	}
+	delete(h.clients[channel], client)
+
+	if len(h.clients[channel]) == 0 {
+		delete(h.clients, channel)
+		if channel == client.user.GetRedisChannel() {
+			wsConnectedUsers.Dec()
+		}
+		if err := h.pubsub.Unsubscribe(context.Background(), channel); err != nil {
+			pubsubErrors.WithLabelValues("unsubscribe").Inc()
+			h.server.Echo.Logger.Error("Failed to unsubscribe from channel: ", err)
+		}
+	}
+}
+
+// switchTeam moves the connections of the user's channel from the channel of their
+// previous active team to the one of the new team, so they stop getting the messages
+// of a team they left. The caller must hold the lock.
+func (h *Hub) switchTeam(userChannel string, teamID *uint) {
+	for client := range h.clients[userChannel] {
+		// Only the user's own channel says which team is active
+		if client.user.GetRedisChannel() != userChannel {
+			continue
+		}
+		if client.teamID != nil && teamID != nil && *client.teamID == *teamID {
+			continue
+		}
+
+		if client.teamID != nil {
+			h.removeFromChannel(client, common.GetTeamChannel(*client.teamID))
+		}
+		client.teamID = teamID
+		if teamID == nil {
+			continue
+		}
+		if err := h.addToChannel(client, common.GetTeamChannel(*teamID)); err != nil {
+			h.server.Echo.Logger.Error("Failed to subscribe to team channel: ", err)
+			client.teamID = nil
+		}
+	}
+}
+
+// Shutdown tells every connection that the server is going away and when to reconnect,
+// then closes them once their queued messages are written and takes them offline.
+// It waits for the connections to close until the context is done.
+func (h *Hub) Shutdown(ctx context.Context) {
+	h.mu.Lock()
+	h.shuttingDown = true
+	var clients []*wsClient
+	for channel, channelClients := range h.clients {
+		for client := range channelClients {
+			// Every connection is in its user's channel, and in the channel of its device
+			if channel == client.user.GetRedisChannel() {
+				clients = append(clients, client)
+			}
+		}
+	}
+	h.mu.Unlock()
+
+	for _, client := range clients {
+		reconnectAfter := time.Duration(rand.Int63n(int64(wsMaxReconnectDelay)))
+		client.sendJSON(messages.NewServerShutdownMessage(reconnectAfter, wsReconnectPolicy))
+		h.unregister(client)
+	}
+
+	drained := make(chan struct{})
+	go func() {
+		h.pumps.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		h.server.Echo.Logger.Warn("Timed out closing websocket connections")
+	}
+
+	if err := h.pubsub.Close(); err != nil {
+		h.server.Echo.Logger.Error("Failed to close Redis subscription: ", err)
+	}
+}
+
+// closeMessage is the close frame written to connections that are unregistered
+func (h *Hub) closeMessage() []byte {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.shuttingDown {
+		return websocket.FormatCloseMessage(wsCloseShuttingDown, "Server is shutting down")
+	}
+	return websocket.FormatCloseMessage(wsCloseNormal, "")
+}
+
+// forward sends a message published to the channel to all the connections of its user, device or team
+func (h *Hub) forward(channel string, payload []byte) {
+	var base messages.BaseMessage
+	if err := json.Unmarshal(payload, &base); err != nil {
+		h.server.Echo.Logger.Error(err)
+		return
+	}
+	if !forwardedMessageTypes[base.Type] {
+		h.server.Echo.Logger.Warn("Unknown message type: ", base.Type)
+		return
+	}
+	wsMessages.WithLabelValues("sent", string(base.Type)).Inc()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if base.Type == messages.MessageTypeActiveTeamChanged {
+		var msg messages.ActiveTeamChangedMessage
+		if err := json.Unmarshal(payload, &msg); err != nil {
+			h.server.Echo.Logger.Error(err)
+		} else {
+			h.switchTeam(channel, msg.Payload.TeamID)
+		}
+	}
+
+	for client := range h.clients[channel] {
+		client.queue(payload)
+	}
+}
+
+// newWSClient creates the connection of the user, connections without a device ID are a device of their own
+func newWSClient(hub *Hub, c echo.Context, conn *websocket.Conn, user *models.User, deviceID string, protocolVersion int) *wsClient {
+	id := uuid.New().String()
+	if deviceID == "" {
+		deviceID = id
+	}
+
+	var teamID *uint
+	if user.TeamID != nil {
+		activeTeamID := *user.TeamID
+		teamID = &activeTeamID
+	}
+
+	return &wsClient{
+		id:              id,
+		hub:             hub,
+		ctx:             c,
+		conn:            conn,
+		user:            user,
+		send:            make(chan []byte, wsSendBuffer),
+		deviceID:        deviceID,
+		limiter:         newWSRateLimiter(),
+		protocolVersion: protocolVersion,
+		teamID:          teamID,
+	}
+}
+
+// channels returns the Redis channels the connection gets its messages from,
+// the team channel follows the user's active team. The caller must hold the hub's lock.
+func (c *wsClient) channels() []string {
+	channels := []string{c.user.GetRedisChannel(), common.GetUserDeviceChannel(c.user.ID, c.deviceID)}
+	if c.teamID != nil {
+		channels = append(channels, common.GetTeamChannel(*c.teamID))
+	}
+
+	return channels
+}
+
+// queue adds the message, in the protocol version of the connection, to the connection's
+// outgoing messages. A connection that can't keep up is closed instead of holding up the rest.
+func (c *wsClient) queue(msg []byte) bool {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+
+	// The read pump, or another goroutine, can still reply after the hub closed the connection
+	if c.closed {
+		return false
+	}
+
+	select {
+	case c.send <- messages.TranslateForVersion(c.protocolVersion, msg):
+		return true
+	default:
+		c.ctx.Logger().Warn("WebSocket send buffer full, closing connection of user: ", c.user.ID)
+		c.conn.Close()
+		return false
+	}
+}
+
+// closeSend closes the outgoing messages, the write pump closes the connection once
+// it wrote the queued ones. Messages queued afterwards are dropped.
+func (c *wsClient) closeSend() {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+
+	c.closed = true
+	close(c.send)
+}
+
+// sendJSON queues the message on the connection, it reports whether it was queued
+func (c *wsClient) sendJSON(msg interface{}) bool {
+	msgJSON, err := json.Marshal(msg)
+	if err != nil {
+		c.ctx.Logger().Error(err)
+		return false
+	}
+
+	return c.queue(msgJSON)
+}
+
+// writePump writes the queued messages to the connection until it is unregistered,
+// pinging it in between so the read pump notices when the other end is gone
+func (c *wsClient) writePump() {
+	ticker := time.NewTicker(wsPingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+		c.hub.pumps.Done()
+	}()
+
+	for {
+		select {
+		case msg, ok := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if !ok {
+				c.conn.WriteMessage(websocket.CloseMessage, c.hub.closeMessage())
+				return
+			}
+			// Only compress messages big enough for it to pay off, it has no
+			// effect unless the client negotiated compression
+			c.conn.EnableWriteCompression(len(msg) >= c.hub.server.Config.WebSocket.CompressionThreshold)
+			if err := c.conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+				c.ctx.Logger().Error("WebSocket write error: ", err)
+				return
+			}
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if !c.tokenExpiresAt.IsZero() && time.Now().After(c.tokenExpiresAt) {
+				c.ctx.Logger().Info("Closing websocket with an expired token of user: ", c.user.ID)
+				c.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(wsCloseAuthExpired, "Token expired"))
+				return
+			}
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				c.ctx.Logger().Debug("WebSocket ping failed: ", err)
+				return
+			}
+		}
+	}
+}
+
+// refreshPresence keeps the user online while the connection answers heartbeats
+func (c *wsClient) refreshPresence() {
+	cameOnline, err := presence.Refresh(context.Background(), c.hub.server.Redis, c.user.ID, c.deviceID, c.id)
+	if errors.Is(err, presence.ErrTakenOver) {
+		// The read pump unregisters the connection once it is closed
+		c.ctx.Logger().Info("Closing websocket taken over by a newer connection of the device of user: ", c.user.ID)
+		closeMsg := websocket.FormatCloseMessage(wsCloseTakenOver, "Device connected again")
+		c.conn.WriteControl(websocket.CloseMessage, closeMsg, time.Now().Add(wsWriteWait))
+		c.conn.Close()
+	} else if err != nil {
+		c.ctx.Logger().Error("Failed to update presence: ", err)
+	} else if cameOnline {
+		go emitPresenceWebhook(c.hub.server, c.user, models.WebhookEventUserOnline)
+	}
+}
+
+// readPump handles the messages of the connection until it closes, or until
+// nothing, not even a pong, was heard from it for wsPongWait
+func (c *wsClient) readPump() {
+	defer c.hub.unregister(c)
+
+	c.conn.SetReadLimit(wsMaxMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	c.conn.SetPongHandler(func(string) error {
+		c.refreshPresence()
+		return c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+	// Clients that ping the server themselves are alive too, the pong is a control
+	// frame so it can be written alongside the write pump
+	c.conn.SetPingHandler(func(appData string) error {
+		c.refreshPresence()
+		c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+		err := c.conn.WriteControl(websocket.PongMessage, []byte(appData), time.Now().Add(wsWriteWait))
+		if errors.Is(err, websocket.ErrCloseSent) {
+			return nil
+		}
+		return err
+	})
+
+	for {
+		messageType, msg, err := c.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure, websocket.CloseNoStatusReceived) {
+				c.ctx.Logger().Debug("WebSocket connection closed normally")
+			} else if errors.Is(err, websocket.ErrReadLimit) {
+				c.ctx.Logger().Warn("Closing websocket of user that sent a message over the size limit: ", c.user.ID)
+			} else if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				c.ctx.Logger().Info("WebSocket heartbeat timed out for user: ", c.user.ID)
+			} else {
+				c.ctx.Logger().Error("WebSocket read error: ", err)
+			}
+			return
+		}
+
+		c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+
+		if messageType != websocket.TextMessage {
+			c.ctx.Logger().Warn("Received non-text message in websocket")
+			continue
+		}
+
+		parsedMessage, err := messages.ParseMessage(msg)
+		if err != nil {
+			c.reply(messages.MessageID(msg), err)
+			continue
+		}
+		wsMessages.WithLabelValues("received", string(parsedMessage.Type)).Inc()
+
+		if allowed, retryAfter := c.limiter.allow(parsedMessage.Type); !allowed {
+			if c.limiter.exhausted() {
+				c.ctx.Logger().Warn("Closing websocket of user over its rate limits: ", c.user.ID)
+				closeMsg := websocket.FormatCloseMessage(wsCloseRateLimited, "Rate limit exceeded")
+				c.conn.WriteControl(websocket.CloseMessage, closeMsg, time.Now().Add(wsWriteWait))
+				return
+			}
+			c.sendJSON(messages.NewRateLimitedMessage(parsedMessage.ID, parsedMessage.Type, retryAfter))
+			continue
+		}
+
+		c.reply(parsedMessage.ID, c.dispatch(parsedMessage))
+	}
+}
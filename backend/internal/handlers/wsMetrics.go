@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Metrics of the realtime layer, exported with the HTTP metrics on /api/metrics.
+// Connection gauges are per server, sum them over the servers for the total.
+var (
+	wsConnections = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "renkey_backend",
+		Subsystem: "websocket",
+		Name:      "connections",
+		Help:      "Open websocket connections on this server.",
+	})
+	wsConnectedUsers = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "renkey_backend",
+		Subsystem: "websocket",
+		Name:      "connected_users",
+		Help:      "Users with at least one websocket connection on this server.",
+	})
+	wsMessages = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "renkey_backend",
+		Subsystem: "websocket",
+		Name:      "messages_total",
+		Help:      "Websocket messages received from clients and forwarded to them, by type.",
+	}, []string{"direction", "type"})
+	pubsubErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "renkey_backend",
+		Subsystem: "pubsub",
+		Name:      "errors_total",
+		Help:      "Failed Redis pubsub operations, by operation.",
+	}, []string{"operation"})
+	callRingToAccept = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "renkey_backend",
+		Subsystem: "calls",
+		Name:      "ring_to_accept_seconds",
+		Help:      "Time from a call starting to ring until it is accepted.",
+		Buckets:   []float64{0.5, 1, 2, 3, 5, 8, 13, 20, 30, 45, 60},
+	})
+	// Calls are started and ended by any server, the gauge of a single server can go negative
+	activeCalls = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "renkey_backend",
+		Subsystem: "calls",
+		Name:      "active",
+		Help:      "Calls started minus calls ended by this server.",
+	})
+)
+
+// observeRingToAccept records how long the call rang, with the call ID as an
+// exemplar so the logs of slow answers can be found
+func observeRingToAccept(callID string, rang time.Duration) {
+	callRingToAccept.(prometheus.ExemplarObserver).ObserveWithExemplar(rang.Seconds(), prometheus.Labels{"call_id": callID})
+}
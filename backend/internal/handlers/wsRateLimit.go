@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"hopp-backend/internal/messages"
+	"math"
+	"time"
+)
+
+// wsMaxRateLimitViolations is how many messages in a row a connection can send over
+// its limits before it is closed, clients that keep going after being told are misbehaving
+const wsMaxRateLimitViolations = 20
+
+// rateLimit is the rate of a token bucket: messages per second, and how many can be sent at once
+type rateLimit struct {
+	rate  float64
+	burst float64
+}
+
+// wsDefaultRateLimit applies to the message types without a limit of their own
+var wsDefaultRateLimit = rateLimit{rate: 20, burst: 40}
+
+// wsRateLimits are the limits of the message types that are expensive or
+// bother other users, per connection
+var wsRateLimits = map[messages.MessageType]rateLimit{
+	messages.MessageTypeCallRequest:      {rate: 0.5, burst: 5},
+	messages.MessageTypeCallRequestGroup: {rate: 0.2, burst: 2},
+	messages.MessageTypeGroupCallInvite:  {rate: 0.5, burst: 5},
+	messages.MessageTypeCallbackRingBack: {rate: 0.5, burst: 5},
+	messages.MessageTypeTeammateOnline:   {rate: 0.2, burst: 5},
+	messages.MessageTypeResume:           {rate: 0.2, burst: 2},
+	// Fanned out to all the teammates of the user
+	messages.MessageTypeSessionActivity: {rate: 0.5, burst: 5},
+	messages.MessageTypeDirectMessage:   {rate: 1, burst: 10},
+	// Drawing clients send the points of a stroke in quick batches
+	messages.MessageTypeAnnotationStrokeStart: {rate: 10, burst: 20},
+	messages.MessageTypeAnnotationPoints:      {rate: 60, burst: 120},
+	messages.MessageTypeAnnotationClear:       {rate: 2, burst: 5},
+}
+
+// tokenBucket holds the tokens left for a message type, refilled at the rate of its limit
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// wsRateLimiter limits the messages a connection sends, with a token bucket per message type.
+// It is only used by the read pump of the connection, so it isn't safe for concurrent use.
+type wsRateLimiter struct {
+	buckets    map[messages.MessageType]*tokenBucket
+	violations int
+}
+
+func newWSRateLimiter() *wsRateLimiter {
+	return &wsRateLimiter{buckets: make(map[messages.MessageType]*tokenBucket)}
+}
+
+// allow takes a token for a message of the type. When there is none left it
+// returns how long until there is one.
+func (l *wsRateLimiter) allow(messageType messages.MessageType) (bool, time.Duration) {
+	limit, ok := wsRateLimits[messageType]
+	if !ok {
+		limit = wsDefaultRateLimit
+	}
+
+	now := time.Now()
+	bucket, ok := l.buckets[messageType]
+	if !ok {
+		bucket = &tokenBucket{tokens: limit.burst, last: now}
+		l.buckets[messageType] = bucket
+	}
+
+	bucket.tokens = math.Min(limit.burst, bucket.tokens+now.Sub(bucket.last).Seconds()*limit.rate)
+	bucket.last = now
+
+	if bucket.tokens < 1 {
+		l.violations++
+		return false, time.Duration((1 - bucket.tokens) / limit.rate * float64(time.Second))
+	}
+
+	bucket.tokens--
+	l.violations = 0
+	return true, 0
+}
+
+// exhausted reports whether the connection kept sending over its limits for too long
+func (l *wsRateLimiter) exhausted() bool {
+	return l.violations >= wsMaxRateLimitViolations
+}
@@ -0,0 +1,260 @@
+// Package linkpreview fetches the OpenGraph metadata of the links shared in chat messages.
+//
+// The pages are fetched by the server, so the fetches must not reach the internal
+// network: every connection, redirects included, is checked after DNS resolution and
+// refused when the address isn't public. Previews are cached in Redis, failed ones too,
+// so a link shared many times is fetched once.
+package linkpreview
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hopp-backend/internal/common"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/net/html"
+)
+
+const (
+	// maxPageSize is how much of a page is read, the metadata is in the head
+	maxPageSize = 512 << 10 // 512KB
+	// maxRedirects is how many redirects a fetch follows
+	maxRedirects = 3
+	// cacheTTL is how long a preview is kept
+	cacheTTL = 24 * time.Hour
+	// failureCacheTTL is how long a link without a preview isn't fetched again
+	failureCacheTTL = time.Hour
+)
+
+// ErrNoPreview is returned for links that don't have a preview, or couldn't be fetched
+var ErrNoPreview = errors.New("link has no preview")
+
+// errForbiddenAddress is returned when a link resolves to an address that isn't public
+var errForbiddenAddress = errors.New("address is not public")
+
+// urlPattern finds http and https links in a message
+var urlPattern = regexp.MustCompile(`https?://[^\s<>"]+`)
+
+// Preview is the OpenGraph metadata of a link
+type Preview struct {
+	URL         string `json:"url"`
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	ImageURL    string `json:"image_url,omitempty"`
+	SiteName    string `json:"site_name,omitempty"`
+}
+
+var fetchClient = &http.Client{
+	Timeout: 5 * time.Second,
+	Transport: &http.Transport{
+		// Proxies would connect on our behalf without the address check
+		Proxy: nil,
+		DialContext: (&net.Dialer{
+			Timeout: 3 * time.Second,
+			Control: CheckAddress,
+		}).DialContext,
+		TLSHandshakeTimeout:   3 * time.Second,
+		ResponseHeaderTimeout: 3 * time.Second,
+		MaxIdleConns:          10,
+		IdleConnTimeout:       30 * time.Second,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) >= maxRedirects {
+			return errors.New("too many redirects")
+		}
+		if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+			return fmt.Errorf("unsupported scheme: %s", req.URL.Scheme)
+		}
+		return nil
+	},
+}
+
+// CheckAddress refuses connections to loopback, private, link-local and other
+// addresses that aren't on the public internet. It is meant as the Control of a
+// net.Dialer, so it runs after DNS resolution and a host can't resolve to a public
+// address when checked and a private one when used.
+func CheckAddress(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return errForbiddenAddress
+	}
+
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return errForbiddenAddress
+	}
+
+	// Carrier-grade NAT, used by some cloud providers for internal services
+	if ip4 := ip.To4(); ip4 != nil && ip4[0] == 100 && ip4[1]&0xc0 == 64 {
+		return errForbiddenAddress
+	}
+
+	return nil
+}
+
+// FindURL returns the first link of the text, or an empty string if it has none
+func FindURL(text string) string {
+	link := urlPattern.FindString(text)
+	// Punctuation that ends a sentence isn't part of the link
+	return strings.TrimRight(link, ".,;:!?)]}'")
+}
+
+// Get returns the preview of the link, from the cache or by fetching the page
+func Get(ctx context.Context, rdb *redis.Client, link string) (*Preview, error) {
+	hash := sha256.Sum256([]byte(link))
+	key := common.GetLinkPreviewKey(hex.EncodeToString(hash[:]))
+
+	cached, err := rdb.Get(ctx, key).Result()
+	if err == nil {
+		if cached == "" {
+			return nil, ErrNoPreview
+		}
+
+		var preview Preview
+		if err := json.Unmarshal([]byte(cached), &preview); err == nil {
+			return &preview, nil
+		}
+	} else if !errors.Is(err, redis.Nil) {
+		return nil, err
+	}
+
+	preview, fetchErr := fetch(ctx, link)
+	if fetchErr != nil {
+		// Remembered as an empty value, so the page isn't fetched for every message
+		if err := rdb.Set(ctx, key, "", failureCacheTTL).Err(); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: %v", ErrNoPreview, fetchErr)
+	}
+
+	encoded, err := json.Marshal(preview)
+	if err != nil {
+		return nil, err
+	}
+	if err := rdb.Set(ctx, key, encoded, cacheTTL).Err(); err != nil {
+		return nil, err
+	}
+
+	return preview, nil
+}
+
+// fetch downloads the page of the link and reads its metadata
+func fetch(ctx context.Context, link string) (*Preview, error) {
+	parsed, err := url.Parse(link)
+	if err != nil {
+		return nil, err
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported scheme: %s", parsed.Scheme)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, parsed.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "HoppLinkPreview/1.0")
+	req.Header.Set("Accept", "text/html")
+
+	resp, err := fetchClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("page returned status %d", resp.StatusCode)
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType != "text/html" {
+		return nil, fmt.Errorf("unsupported content type: %s", mediaType)
+	}
+
+	preview := parse(io.LimitReader(resp.Body, maxPageSize), resp.Request.URL)
+	if preview.Title == "" {
+		return nil, errors.New("page has no title")
+	}
+	preview.URL = link
+
+	return preview, nil
+}
+
+// parse reads the OpenGraph tags of the page, falling back to its title
+func parse(body io.Reader, pageURL *url.URL) *Preview {
+	preview := &Preview{}
+	title := ""
+
+	tokenizer := html.NewTokenizer(body)
+	for {
+		tokenType := tokenizer.Next()
+		switch tokenType {
+		case html.ErrorToken:
+			if preview.Title == "" {
+				preview.Title = title
+			}
+			return preview
+		case html.StartTagToken, html.SelfClosingTagToken:
+			token := tokenizer.Token()
+			switch token.Data {
+			case "title":
+				if tokenizer.Next() == html.TextToken {
+					title = strings.TrimSpace(tokenizer.Token().Data)
+				}
+			case "meta":
+				property, content := "", ""
+				for _, attr := range token.Attr {
+					switch attr.Key {
+					case "property", "name":
+						property = attr.Val
+					case "content":
+						content = strings.TrimSpace(attr.Val)
+					}
+				}
+
+				switch property {
+				case "og:title":
+					preview.Title = content
+				case "og:description":
+					preview.Description = content
+				case "description":
+					if preview.Description == "" {
+						preview.Description = content
+					}
+				case "og:site_name":
+					preview.SiteName = content
+				case "og:image":
+					// Relative images are resolved against the page, after redirects
+					if imageURL, err := pageURL.Parse(content); err == nil && (imageURL.Scheme == "http" || imageURL.Scheme == "https") {
+						preview.ImageURL = imageURL.String()
+					}
+				}
+			}
+		case html.EndTagToken:
+			// The metadata is in the head, the body isn't worth reading
+			if tokenizer.Token().Data == "head" {
+				if preview.Title == "" {
+					preview.Title = title
+				}
+				return preview
+			}
+		}
+	}
+}
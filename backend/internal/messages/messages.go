@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"hopp-backend/internal/common"
+	"time"
 )
 
 // MessageType represents the type of WebSocket message
@@ -13,12 +14,37 @@ type MessageType string
 const (
 	// Server -> Client: Success message when websocket connection is established
 	MessageTypeSuccess MessageType = "success"
+	// Server -> Client: The server speaks none of the protocol versions the client asked for, the connection is closed
+	MessageTypeProtocolUnsupported MessageType = "protocol_unsupported"
 	// Client -> Server: Call request from caller to callee (with callee id)
 	MessageTypeCallRequest MessageType = "call_request"
+	// Client -> Server: Ring several callees at once, the first to accept takes the call
+	MessageTypeCallRequestGroup MessageType = "call_request_group"
+	// Server -> Client: The call was accepted by another callee of its ring group, or on
+	// another device of the callee, it stops ringing
+	MessageTypeCallAnsweredElsewhere MessageType = "call_answered_elsewhere"
 	// Server -> Client: Call request from caller (with caller id)
 	MessageTypeIncomingCall MessageType = "incoming_call"
+	// Client -> Server -> Client: Put the call on hold, relayed to the other participant
+	MessageTypeCallHold MessageType = "call_hold"
+	// Client -> Server -> Client: Resume the call on hold, relayed to the other participant
+	MessageTypeCallResume MessageType = "call_resume"
+	// Client -> Server -> Client: Emoji reaction during a call, relayed to the other participants
+	MessageTypeCallReaction MessageType = "call_reaction"
+	// Client -> Server -> Client: A participant started drawing a stroke on the shared screen
+	MessageTypeAnnotationStrokeStart MessageType = "annotation_stroke_start"
+	// Client -> Server -> Client: Points added to a stroke on the shared screen
+	MessageTypeAnnotationPoints MessageType = "annotation_points"
+	// Client -> Server -> Client: A participant cleared the annotations of the shared screen
+	MessageTypeAnnotationClear MessageType = "annotation_clear"
+	// Server -> Client: The callee's app is ringing (call id)
+	MessageTypeCallRinging MessageType = "call_ringing"
 	// Server -> Client: Callee is offline
 	MessageTypeCalleeOffline MessageType = "callee_offline"
+	// Server -> Client: Callee is already in a call
+	MessageTypeCalleeBusy MessageType = "callee_busy"
+	// Server -> Client: Callee is in do not disturb mode
+	MessageTypeCalleeDND MessageType = "callee_dnd"
 	// Client -> Server: Reject call request (caller id)
 	MessageTypeCallReject MessageType = "call_reject"
 	// Client -> Server: Accept call request (caller id)
@@ -28,20 +54,101 @@ const (
 
 	// Server -> Client: Error message when something goes wrong
 	MessageTypeError MessageType = "error"
+	// Server -> Client: A client message that carried an ID was processed
+	MessageTypeAck MessageType = "ack"
+	// Server -> Client: A client message that carried an ID couldn't be processed, with the reason
+	MessageTypeNack MessageType = "nack"
+	// Server -> Client: A client message was dropped, the connection sent too many of its type
+	MessageTypeRateLimited MessageType = "rate_limited"
+	// Server -> Client: The server is shutting down and closes the connection, with when to reconnect
+	MessageTypeServerShutdown MessageType = "server_shutdown"
 
 	MessageTypeCallEnd MessageType = "call_end"
-	// Client -> Server: Ping message
+	// Client -> Server: Ping message, legacy keepalive of clients that don't
+	// answer the ping control frames of the server
 	MessageTypePing MessageType = "ping"
 	// Server -> Client: Pong message
 	MessageTypePong MessageType = "pong"
 
 	// Client -> Server and Server -> Client: User has become online
 	MessageTypeTeammateOnline MessageType = "teammate_online"
+
+	// Server -> Client: A user requested to join the admin's team
+	MessageTypeJoinRequest MessageType = "join_request"
+
+	// Server -> Client: A teammate left the team
+	MessageTypeTeammateLeft MessageType = "teammate_left"
+	// Server -> Client: An admin posted an announcement to the team
+	MessageTypeTeamAnnouncement MessageType = "team_announcement"
+	// Server -> Client: A new member joined the team
+	MessageTypeTeamMemberJoined MessageType = "team_member_joined"
+	// Server -> Client: An admin changed the settings of the team
+	MessageTypeTeamSettingsChanged MessageType = "team_settings_changed"
+	// Server -> Client: A member sent a message to the team chat
+	MessageTypeTeamChatMessage MessageType = "team_chat_message"
+	// Server -> Client: The user switched or left their active team, the connections
+	// move to the channel of the new active team
+	MessageTypeActiveTeamChanged MessageType = "active_team_changed"
+
+	// Server -> Client: A teammate changed their status
+	MessageTypeTeammateStatus MessageType = "teammate_status"
+	// Client -> Server: The user started or stopped sharing their screen or pairing
+	MessageTypeSessionActivity MessageType = "session_activity"
+	// Server -> Client: A teammate started or stopped sharing their screen or pairing
+	MessageTypeTeammateActivity MessageType = "teammate_activity"
+	// Client -> Server: Text message to a teammate
+	MessageTypeDirectMessage MessageType = "direct_message"
+	// Server -> Client: Text message between the user and a teammate, sent by either of them
+	MessageTypeDirectMessageReceived MessageType = "direct_message_received"
+	// Server -> Client: The user or a teammate reacted to a direct message, or took the reaction back
+	MessageTypeDirectMessageReaction MessageType = "direct_message_reaction"
+	// Client -> Server: The app of the user received or the user read direct messages
+	MessageTypeDirectMessageAck MessageType = "direct_message_ack"
+	// Server -> Client: A teammate received or read direct messages of the user
+	MessageTypeDirectMessageState MessageType = "direct_message_state"
+	// Server -> Client: A teammate sent the user a file or a code snippet
+	MessageTypeFileTransfer MessageType = "file_transfer"
+	// Server -> Client: Preview of the link in a chat message, sent after the message
+	MessageTypeLinkPreview MessageType = "link_preview"
+
+	// Client -> Server: Replay the messages published after the last sequence number the client saw
+	MessageTypeResume MessageType = "resume"
+
+	// Server -> Client: Nobody answered the call before the ring timed out, sent to both sides
+	MessageTypeCallUnanswered MessageType = "call_unanswered"
+
+	// Server -> Client: The user missed a call
+	MessageTypeMissedCall MessageType = "missed_call"
+
+	// Client -> Server: Invite teammates to a new or an ongoing group call
+	MessageTypeGroupCallInvite MessageType = "group_call_invite"
+	// Server -> Client: The user was invited to a group call
+	MessageTypeIncomingGroupCall MessageType = "incoming_group_call"
+	// Client -> Server: Join a group call the user was invited to
+	MessageTypeGroupCallJoin MessageType = "group_call_join"
+	// Client -> Server: Leave or decline a group call
+	MessageTypeGroupCallLeave MessageType = "group_call_leave"
+	// Server -> Client: LiveKit tokens of the user for the group call room
+	MessageTypeGroupCallTokens MessageType = "group_call_tokens"
+	// Server -> Client: The participants of a group call changed
+	MessageTypeGroupCallRoster MessageType = "group_call_roster"
+
+	// Server -> Client: A teammate asked the user to call them back
+	MessageTypeCallbackRequest MessageType = "callback_request"
+	// Client -> Server: Call back the teammate of a callback request
+	MessageTypeCallbackRingBack MessageType = "callback_ring_back"
+
+	// Server -> Client: Someone dialed in to, or hung up from, the call by phone
+	MessageTypePhoneParticipant MessageType = "phone_participant"
 )
 
 // BaseMessage represents the common structure of all WebSocket messages
 type BaseMessage struct {
 	Type MessageType `json:"type" validate:"required"`
+	// Optional ID of a client message, the server answers messages with an ID with an ack or a nack
+	ID string `json:"id,omitempty"`
+	// Sequence number of the messages published to a user, increasing across all their connections
+	Seq int64 `json:"seq,omitempty"`
 	// Using RawMessage to delay JSON parsing until we know the correct type
 	RawPayload json.RawMessage `json:"payload"`
 }
@@ -49,6 +156,25 @@ type BaseMessage struct {
 // SuccessPayload represents the payload for success messages
 type SuccessPayload struct {
 	Message string `json:"message"`
+	// Protocol version negotiated for the connection, and the versions the server speaks
+	ProtocolVersion   int   `json:"protocol_version"`
+	SupportedVersions []int `json:"supported_versions"`
+	// How often the server sends ping control frames, clients don't need pings of their own
+	PingIntervalMs int64 `json:"ping_interval_ms"`
+	// How to reconnect once the connection drops
+	Reconnect ReconnectPolicy `json:"reconnect"`
+}
+
+// ReconnectPolicy tells clients how to reconnect, so they back off instead of
+// all reconnecting at once, e.g. after a deploy
+type ReconnectPolicy struct {
+	// Clients wait a random time up to the backoff before every attempt, the
+	// backoff doubles after every failed attempt from the min up to the max
+	MinBackoffMs int64 `json:"min_backoff_ms"`
+	MaxBackoffMs int64 `json:"max_backoff_ms"`
+	// How long after the last message the client can resume from its sequence number,
+	// clients away for longer fetch the current state instead
+	ResumeWindowMs int64 `json:"resume_window_ms"`
 }
 
 // SuccessMessage is a complete success message
@@ -57,6 +183,17 @@ type SuccessMessage struct {
 	Payload SuccessPayload `json:"payload"`
 }
 
+// ProtocolUnsupportedPayload represents the payload for protocol unsupported messages
+type ProtocolUnsupportedPayload struct {
+	SupportedVersions []int `json:"supported_versions"`
+}
+
+// ProtocolUnsupportedMessage tells an outdated, or too new, client which protocol versions the server speaks
+type ProtocolUnsupportedMessage struct {
+	Type    MessageType                `json:"type"`
+	Payload ProtocolUnsupportedPayload `json:"payload"`
+}
+
 // CallRequestPayload represents the payload for call request messages
 type CallRequestPayload struct {
 	CalleeID string `json:"callee_id" validate:"required"`
@@ -71,6 +208,7 @@ type CallRequestMessage struct {
 // CallEndPayload represents the payload for call end messages
 type CallEndPayload struct {
 	ParticipantID string `json:"participant_id" validate:"required"`
+	CallID        string `json:"call_id,omitempty"`
 }
 
 // CallEndMessage is a complete call end message
@@ -82,6 +220,7 @@ type CallEndMessage struct {
 // IncomingCallPayload represents the payload for an incoming call by another user
 type IncomingCallPayload struct {
 	CallerID string `json:"caller_id" validate:"required"`
+	CallID   string `json:"call_id"`
 }
 
 // IncomingCallMessage is a complete call request message
@@ -95,6 +234,7 @@ type AcceptCallMessage struct {
 	Type    MessageType `json:"type"`
 	Payload struct {
 		CallerID string `json:"caller_id" validate:"required"`
+		CallID   string `json:"call_id"`
 	} `json:"payload"`
 }
 
@@ -103,6 +243,7 @@ type CallTokensMessage struct {
 	Type    MessageType `json:"type"`
 	Payload struct {
 		common.LivekitTokenSet
+		CallID string `json:"call_id"`
 	} `json:"payload"`
 }
 
@@ -111,9 +252,66 @@ type RejectCallMessage struct {
 	Type    MessageType `json:"type"`
 	Payload struct {
 		CallerID string `json:"caller_id" validate:"required"`
+		CallID   string `json:"call_id"`
 	} `json:"payload"`
 }
 
+// CallHoldPayload represents the payload for call hold and resume messages
+type CallHoldPayload struct {
+	ParticipantID string `json:"participant_id" validate:"required"`
+	CallID        string `json:"call_id,omitempty"`
+	// Set by the server to the participant that put the call on hold or resumed it
+	HeldBy string `json:"held_by,omitempty"`
+}
+
+// CallHoldMessage puts the call on hold, both ends pause their media
+type CallHoldMessage struct {
+	Type    MessageType     `json:"type"`
+	Payload CallHoldPayload `json:"payload"`
+}
+
+// CallResumeMessage resumes a call that was on hold
+type CallResumeMessage struct {
+	Type    MessageType     `json:"type"`
+	Payload CallHoldPayload `json:"payload"`
+}
+
+// CallRingingPayload represents the payload for call ringing messages
+type CallRingingPayload struct {
+	CallID   string `json:"call_id"`
+	CalleeID string `json:"callee_id"`
+}
+
+// CallRequestGroupPayload represents the payload for group call request messages
+type CallRequestGroupPayload struct {
+	CalleeIDs []string `json:"callee_ids" validate:"required,min=1,max=20"`
+}
+
+// CallRequestGroupMessage rings every callee at once, each callee gets their own call
+type CallRequestGroupMessage struct {
+	Type    MessageType             `json:"type"`
+	Payload CallRequestGroupPayload `json:"payload"`
+}
+
+// CallAnsweredElsewherePayload represents the payload for call answered elsewhere messages
+type CallAnsweredElsewherePayload struct {
+	CallID     string `json:"call_id"`
+	CallerID   string `json:"caller_id"`
+	AnsweredBy string `json:"answered_by"`
+}
+
+// CallAnsweredElsewhereMessage tells a callee of a ring group that another callee took the call
+type CallAnsweredElsewhereMessage struct {
+	Type    MessageType                  `json:"type"`
+	Payload CallAnsweredElsewherePayload `json:"payload"`
+}
+
+// CallRingingMessage tells the caller the ID of the call that rings the callee
+type CallRingingMessage struct {
+	Type    MessageType        `json:"type"`
+	Payload CallRingingPayload `json:"payload"`
+}
+
 type ErrorPayload struct {
 	Error string `json:"error" validate:"required"`
 }
@@ -125,6 +323,59 @@ type ErrorMessage struct {
 	Payload ErrorPayload `json:"payload"`
 }
 
+// AckPayload represents the payload for ack messages
+type AckPayload struct {
+	ID string `json:"id"`
+}
+
+// AckMessage confirms to the client that its message with the ID was processed
+type AckMessage struct {
+	Type    MessageType `json:"type"`
+	Payload AckPayload  `json:"payload"`
+}
+
+// NackPayload represents the payload for nack messages
+type NackPayload struct {
+	ID    string `json:"id"`
+	Error string `json:"error"`
+	// Fields of the message that are missing or malformed, when that is why it was turned down
+	Fields []InvalidField `json:"fields,omitempty"`
+}
+
+// NackMessage tells the client that its message with the ID wasn't processed and why
+type NackMessage struct {
+	Type    MessageType `json:"type"`
+	Payload NackPayload `json:"payload"`
+}
+
+// RateLimitedPayload represents the payload for rate limited messages
+type RateLimitedPayload struct {
+	// ID of the dropped message, if it had one
+	ID           string      `json:"id,omitempty"`
+	MessageType  MessageType `json:"message_type"`
+	RetryAfterMs int64       `json:"retry_after_ms"`
+}
+
+// RateLimitedMessage tells the client that its message was dropped and when it can send another one
+type RateLimitedMessage struct {
+	Type    MessageType        `json:"type"`
+	Payload RateLimitedPayload `json:"payload"`
+}
+
+// ServerShutdownPayload represents the payload for server shutdown messages
+type ServerShutdownPayload struct {
+	// How long to wait before reconnecting, spread out so clients don't all reconnect at once
+	ReconnectAfterMs int64 `json:"reconnect_after_ms"`
+	// How to reconnect if the first attempt fails
+	Reconnect ReconnectPolicy `json:"reconnect"`
+}
+
+// ServerShutdownMessage tells the client the server is going away and when to reconnect
+type ServerShutdownMessage struct {
+	Type    MessageType           `json:"type"`
+	Payload ServerShutdownPayload `json:"payload"`
+}
+
 // PingPayload represents the payload for ping messages
 type PingPayload struct {
 	Message string `json:"message"`
@@ -158,6 +409,30 @@ type CalleeOfflineMessage struct {
 	Payload CalleeOfflinePayload `json:"payload"`
 }
 
+// CalleeBusyPayload represents the payload for callee busy messages
+type CalleeBusyPayload struct {
+	CalleeID string `json:"callee_id"`
+}
+
+// CalleeBusyMessage is a complete callee busy message
+type CalleeBusyMessage struct {
+	Type    MessageType       `json:"type"`
+	Payload CalleeBusyPayload `json:"payload"`
+}
+
+// CalleeDNDPayload represents the payload for callee do not disturb messages
+type CalleeDNDPayload struct {
+	CalleeID string `json:"callee_id"`
+	// Empty when the callee enabled do not disturb without an expiry
+	Until *time.Time `json:"until,omitempty"`
+}
+
+// CalleeDNDMessage is a complete callee do not disturb message
+type CalleeDNDMessage struct {
+	Type    MessageType      `json:"type"`
+	Payload CalleeDNDPayload `json:"payload"`
+}
+
 // UserOnlinePayload represents the payload for user online messages
 type TeammateOnlinePayload struct {
 	TeammateID string `json:"teammate_id"`
@@ -169,6 +444,441 @@ type TeammateOnlineMessage struct {
 	Payload TeammateOnlinePayload `json:"payload"`
 }
 
+// TeammateLeftPayload represents the payload for teammate left messages
+type TeammateLeftPayload struct {
+	TeammateID string `json:"teammate_id"`
+	TeamID     uint   `json:"team_id"`
+}
+
+// TeammateLeftMessage is the message to notify that a user has left the team
+type TeammateLeftMessage struct {
+	Type    MessageType         `json:"type"`
+	Payload TeammateLeftPayload `json:"payload"`
+}
+
+// TeamAnnouncementPayload represents the payload for team announcement messages
+type TeamAnnouncementPayload struct {
+	TeamID     uint   `json:"team_id"`
+	Message    string `json:"message"`
+	AuthorID   string `json:"author_id"`
+	AuthorName string `json:"author_name"`
+}
+
+// TeamAnnouncementMessage is the message with an announcement to the whole team
+type TeamAnnouncementMessage struct {
+	Type    MessageType             `json:"type"`
+	Payload TeamAnnouncementPayload `json:"payload"`
+}
+
+// TeamMemberJoinedPayload represents the payload for team member joined messages
+type TeamMemberJoinedPayload struct {
+	TeamID     uint   `json:"team_id"`
+	MemberID   string `json:"member_id"`
+	MemberName string `json:"member_name"`
+}
+
+// TeamMemberJoinedMessage is the message to notify the team that a new member joined
+type TeamMemberJoinedMessage struct {
+	Type    MessageType             `json:"type"`
+	Payload TeamMemberJoinedPayload `json:"payload"`
+}
+
+// TeamChatMessagePayload represents the payload for team chat messages
+type TeamChatMessagePayload struct {
+	MessageID  uint      `json:"message_id"`
+	TeamID     uint      `json:"team_id"`
+	SenderID   string    `json:"sender_id"`
+	SenderName string    `json:"sender_name"`
+	Body       string    `json:"body"`
+	SentAt     time.Time `json:"sent_at"`
+}
+
+// TeamChatMessageMessage is the message with a new message of the team chat
+type TeamChatMessageMessage struct {
+	Type    MessageType            `json:"type"`
+	Payload TeamChatMessagePayload `json:"payload"`
+}
+
+// TeamSettingsChangedPayload represents the payload for team settings changed messages
+type TeamSettingsChangedPayload struct {
+	TeamID uint `json:"team_id"`
+}
+
+// TeamSettingsChangedMessage is the message to notify the team that its settings changed,
+// clients fetch the new settings
+type TeamSettingsChangedMessage struct {
+	Type    MessageType                `json:"type"`
+	Payload TeamSettingsChangedPayload `json:"payload"`
+}
+
+// ActiveTeamChangedPayload represents the payload for active team changed messages
+type ActiveTeamChangedPayload struct {
+	// Nil when the user left their last team
+	TeamID *uint `json:"team_id"`
+}
+
+// ActiveTeamChangedMessage is the message to notify the user's connections that
+// their active team changed, clients fetch the teammates of the new team
+type ActiveTeamChangedMessage struct {
+	Type    MessageType              `json:"type"`
+	Payload ActiveTeamChangedPayload `json:"payload"`
+}
+
+// TeammateStatusPayload represents the payload for teammate status messages
+type TeammateStatusPayload struct {
+	TeammateID string `json:"teammate_id"`
+	Status     string `json:"status"`
+	StatusText string `json:"status_text"`
+}
+
+// TeammateStatusMessage is the message to notify that a teammate changed their status
+type TeammateStatusMessage struct {
+	Type    MessageType           `json:"type"`
+	Payload TeammateStatusPayload `json:"payload"`
+}
+
+// SessionActivityPayload represents the payload for session activity messages
+type SessionActivityPayload struct {
+	// sharing, pairing or empty once the session is over
+	Activity string `json:"activity" validate:"omitempty,oneof=sharing pairing"`
+}
+
+// SessionActivityMessage tells the server what the user is doing, for the teammates to see
+type SessionActivityMessage struct {
+	Type    MessageType            `json:"type"`
+	Payload SessionActivityPayload `json:"payload"`
+}
+
+// TeammateActivityPayload represents the payload for teammate activity messages
+type TeammateActivityPayload struct {
+	TeammateID string `json:"teammate_id"`
+	Activity   string `json:"activity"`
+}
+
+// TeammateActivityMessage is the message to notify that a teammate started or stopped a session activity
+type TeammateActivityMessage struct {
+	Type    MessageType             `json:"type"`
+	Payload TeammateActivityPayload `json:"payload"`
+}
+
+// DirectMessagePayload represents the payload for direct messages
+type DirectMessagePayload struct {
+	RecipientID string `json:"recipient_id" validate:"required"`
+	Body        string `json:"body" validate:"required,max=2000"`
+}
+
+// DirectMessageMessage is a text message the user sends to a teammate
+type DirectMessageMessage struct {
+	Type    MessageType          `json:"type"`
+	Payload DirectMessagePayload `json:"payload"`
+}
+
+// DirectMessageReceivedPayload represents the payload for direct message received messages
+type DirectMessageReceivedPayload struct {
+	MessageID   uint      `json:"message_id"`
+	SenderID    string    `json:"sender_id"`
+	SenderName  string    `json:"sender_name"`
+	RecipientID string    `json:"recipient_id"`
+	Body        string    `json:"body"`
+	SentAt      time.Time `json:"sent_at"`
+}
+
+// DirectMessageReceivedMessage is the message with a text message between the user and a teammate
+type DirectMessageReceivedMessage struct {
+	Type    MessageType                  `json:"type"`
+	Payload DirectMessageReceivedPayload `json:"payload"`
+}
+
+// DirectMessageReactionPayload represents the payload for direct message reaction messages
+type DirectMessageReactionPayload struct {
+	MessageID uint   `json:"message_id"`
+	UserID    string `json:"user_id"`
+	Emoji     string `json:"emoji"`
+	// False when the user took the reaction back
+	Added bool `json:"added"`
+	// How many users reacted to the message with the emoji now
+	Count int `json:"count"`
+}
+
+// DirectMessageReactionMessage is the message with a change of the reactions to a direct message
+type DirectMessageReactionMessage struct {
+	Type    MessageType                  `json:"type"`
+	Payload DirectMessageReactionPayload `json:"payload"`
+}
+
+// DirectMessageAckPayload represents the payload for direct message ack messages
+type DirectMessageAckPayload struct {
+	MessageIDs []uint `json:"message_ids" validate:"required,min=1,max=100"`
+	State      string `json:"state" validate:"required,oneof=delivered read"`
+}
+
+// DirectMessageAckMessage acknowledges that the user received or read direct messages
+type DirectMessageAckMessage struct {
+	Type    MessageType             `json:"type"`
+	Payload DirectMessageAckPayload `json:"payload"`
+}
+
+// DirectMessageStatePayload represents the payload for direct message state messages
+type DirectMessageStatePayload struct {
+	MessageIDs  []uint    `json:"message_ids"`
+	RecipientID string    `json:"recipient_id"`
+	State       string    `json:"state"`
+	At          time.Time `json:"at"`
+}
+
+// DirectMessageStateMessage tells the sender that direct messages were delivered or read
+type DirectMessageStateMessage struct {
+	Type    MessageType               `json:"type"`
+	Payload DirectMessageStatePayload `json:"payload"`
+}
+
+// FileTransferPayload represents the payload for file transfer messages
+type FileTransferPayload struct {
+	SenderID    string `json:"sender_id"`
+	SenderName  string `json:"sender_name"`
+	FileName    string `json:"file_name"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+	// Hex encoded SHA-256 of the file, to check the download against
+	SHA256       string    `json:"sha256"`
+	URL          string    `json:"url"`
+	URLExpiresAt time.Time `json:"url_expires_at"`
+}
+
+// FileTransferMessage is the message with a file or a code snippet a teammate sent the user
+type FileTransferMessage struct {
+	Type    MessageType         `json:"type"`
+	Payload FileTransferPayload `json:"payload"`
+}
+
+// LinkPreviewPayload represents the payload for link preview messages
+type LinkPreviewPayload struct {
+	// direct_message or team_chat
+	MessageKind string `json:"message_kind"`
+	MessageID   uint   `json:"message_id"`
+	URL         string `json:"url"`
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	ImageURL    string `json:"image_url,omitempty"`
+	SiteName    string `json:"site_name,omitempty"`
+}
+
+// LinkPreviewMessage is the message with the preview of the link in a chat message
+type LinkPreviewMessage struct {
+	Type    MessageType        `json:"type"`
+	Payload LinkPreviewPayload `json:"payload"`
+}
+
+// ResumePayload represents the payload for resume messages
+type ResumePayload struct {
+	LastSeq int64 `json:"last_seq"`
+}
+
+// ResumeMessage asks for the messages the client missed while it was reconnecting
+type ResumeMessage struct {
+	Type    MessageType   `json:"type"`
+	Payload ResumePayload `json:"payload"`
+}
+
+// CallUnansweredPayload represents the payload for call unanswered messages
+type CallUnansweredPayload struct {
+	CallID   string `json:"call_id"`
+	CallerID string `json:"caller_id"`
+	CalleeID string `json:"callee_id"`
+}
+
+// CallUnansweredMessage lets the caller stop ringing and the callee stop showing the incoming call
+type CallUnansweredMessage struct {
+	Type    MessageType           `json:"type"`
+	Payload CallUnansweredPayload `json:"payload"`
+}
+
+// MissedCallPayload represents the payload for missed call messages
+type MissedCallPayload struct {
+	MissedCallID uint      `json:"missed_call_id"`
+	CallerID     string    `json:"caller_id"`
+	CallerName   string    `json:"caller_name"`
+	Reason       string    `json:"reason"`
+	MissedAt     time.Time `json:"missed_at"`
+}
+
+// MissedCallMessage notifies the callee about a call they missed
+type MissedCallMessage struct {
+	Type    MessageType       `json:"type"`
+	Payload MissedCallPayload `json:"payload"`
+}
+
+// GroupCallInvitePayload represents the payload for group call invite messages,
+// an empty room name starts a new group call
+type GroupCallInvitePayload struct {
+	RoomName  string   `json:"room_name"`
+	CalleeIDs []string `json:"callee_ids" validate:"required,min=1,max=20"`
+}
+
+// GroupCallInviteMessage invites teammates to a group call
+type GroupCallInviteMessage struct {
+	Type    MessageType            `json:"type"`
+	Payload GroupCallInvitePayload `json:"payload"`
+}
+
+// GroupCallRoomPayload represents the payload for messages about a single group call
+type GroupCallRoomPayload struct {
+	RoomName string `json:"room_name" validate:"required"`
+}
+
+// GroupCallJoinMessage is sent by an invited user to join the group call
+type GroupCallJoinMessage struct {
+	Type    MessageType          `json:"type"`
+	Payload GroupCallRoomPayload `json:"payload"`
+}
+
+// GroupCallLeaveMessage is sent by a participant to leave the group call,
+// or by an invited user to decline it
+type GroupCallLeaveMessage struct {
+	Type    MessageType          `json:"type"`
+	Payload GroupCallRoomPayload `json:"payload"`
+}
+
+// IncomingGroupCallPayload represents the payload for incoming group call messages
+type IncomingGroupCallPayload struct {
+	RoomName       string   `json:"room_name"`
+	CallerID       string   `json:"caller_id"`
+	ParticipantIDs []string `json:"participant_ids"`
+}
+
+// IncomingGroupCallMessage notifies a user that they were invited to a group call
+type IncomingGroupCallMessage struct {
+	Type    MessageType              `json:"type"`
+	Payload IncomingGroupCallPayload `json:"payload"`
+}
+
+// GroupCallTokensPayload represents the payload for group call tokens messages
+type GroupCallTokensPayload struct {
+	common.LivekitTokenSet
+	RoomName string `json:"room_name"`
+}
+
+// GroupCallTokensMessage sends a participant their LiveKit tokens for the group call room
+type GroupCallTokensMessage struct {
+	Type    MessageType            `json:"type"`
+	Payload GroupCallTokensPayload `json:"payload"`
+}
+
+// GroupCallRosterPayload represents the payload for group call roster messages
+type GroupCallRosterPayload struct {
+	RoomName       string   `json:"room_name"`
+	ParticipantIDs []string `json:"participant_ids"`
+	// Users that were invited but haven't joined yet
+	InvitedIDs []string `json:"invited_ids"`
+}
+
+// GroupCallRosterMessage lets the participants and invited users of a group call know who is in it
+type GroupCallRosterMessage struct {
+	Type    MessageType            `json:"type"`
+	Payload GroupCallRosterPayload `json:"payload"`
+}
+
+// CallbackRequestPayload represents the payload for callback request messages
+type CallbackRequestPayload struct {
+	CallbackRequestID uint      `json:"callback_request_id"`
+	CallerID          string    `json:"caller_id"`
+	CallerName        string    `json:"caller_name"`
+	RequestedAt       time.Time `json:"requested_at"`
+}
+
+// CallbackRequestMessage notifies the callee that a caller asked to be called back
+type CallbackRequestMessage struct {
+	Type    MessageType            `json:"type"`
+	Payload CallbackRequestPayload `json:"payload"`
+}
+
+// CallbackRingBackPayload represents the payload for callback ring back messages
+type CallbackRingBackPayload struct {
+	CallbackRequestID uint `json:"callback_request_id" validate:"required"`
+}
+
+// CallbackRingBackMessage is sent by the callee to call back the caller of a callback request
+type CallbackRingBackMessage struct {
+	Type    MessageType             `json:"type"`
+	Payload CallbackRingBackPayload `json:"payload"`
+}
+
+// CallReactionPayload represents the payload for call reaction messages
+type CallReactionPayload struct {
+	// Call ID of a call, or the room name of a group call
+	RoomName string `json:"room_name" validate:"required"`
+	Emoji    string `json:"emoji" validate:"required"`
+	// Set by the server to the participant that reacted
+	SenderID   string `json:"sender_id,omitempty"`
+	SenderName string `json:"sender_name,omitempty"`
+}
+
+// CallReactionMessage is an emoji reaction of a participant during a call
+type CallReactionMessage struct {
+	Type    MessageType         `json:"type"`
+	Payload CallReactionPayload `json:"payload"`
+}
+
+// AnnotationPoint is a point of a stroke, relative to the size of the shared screen
+type AnnotationPoint struct {
+	X float64 `json:"x" validate:"min=0,max=1"`
+	Y float64 `json:"y" validate:"min=0,max=1"`
+}
+
+// AnnotationPayload represents the payload for the annotation messages,
+// only the fields of the message type are set
+type AnnotationPayload struct {
+	// Call ID of a call, or the room name of a group call
+	RoomName string `json:"room_name" validate:"required"`
+	// Stroke the message is about, picked by the participant that draws it
+	StrokeID string            `json:"stroke_id,omitempty"`
+	Color    string            `json:"color,omitempty"`
+	Width    float64           `json:"width,omitempty"`
+	Points   []AnnotationPoint `json:"points,omitempty"`
+	// Set by the server to the participant that drew
+	SenderID string `json:"sender_id,omitempty"`
+	// Set by the server, increases with every annotation message of the room
+	// so participants can apply them in order
+	Sequence int64 `json:"sequence,omitempty"`
+}
+
+// AnnotationMessage starts a stroke on the shared screen, adds points to it,
+// or clears all the strokes, depending on its type
+type AnnotationMessage struct {
+	Type    MessageType       `json:"type"`
+	Payload AnnotationPayload `json:"payload"`
+}
+
+// PhoneParticipantPayload represents the payload for phone participant messages
+type PhoneParticipantPayload struct {
+	RoomName string `json:"room_name"`
+	// LiveKit identity of the phone participant
+	Identity string `json:"identity"`
+	Name     string `json:"name"`
+	// Whether the phone participant joined or left the call
+	Joined bool `json:"joined"`
+}
+
+// PhoneParticipantMessage tells the participants of a call that a phone participant joined or left it
+type PhoneParticipantMessage struct {
+	Type    MessageType             `json:"type"`
+	Payload PhoneParticipantPayload `json:"payload"`
+}
+
+// JoinRequestPayload represents the payload for join request messages
+type JoinRequestPayload struct {
+	JoinRequestID uint   `json:"join_request_id"`
+	TeamID        uint   `json:"team_id"`
+	UserID        string `json:"user_id"`
+	UserName      string `json:"user_name"`
+}
+
+// JoinRequestMessage notifies team admins about a new join request
+type JoinRequestMessage struct {
+	Type    MessageType        `json:"type"`
+	Payload JoinRequestPayload `json:"payload"`
+}
+
 // NewCalleeOfflineMessage creates a new callee offline message
 func NewCalleeOfflineMessage(calleeID string) *CalleeOfflineMessage {
 	return &CalleeOfflineMessage{
@@ -179,30 +889,108 @@ func NewCalleeOfflineMessage(calleeID string) *CalleeOfflineMessage {
 	}
 }
 
+// NewCalleeBusyMessage creates a new callee busy message
+func NewCalleeBusyMessage(calleeID string) *CalleeBusyMessage {
+	return &CalleeBusyMessage{
+		Type: MessageTypeCalleeBusy,
+		Payload: CalleeBusyPayload{
+			CalleeID: calleeID,
+		},
+	}
+}
+
+// NewCalleeDNDMessage creates a new callee do not disturb message
+func NewCalleeDNDMessage(calleeID string, until *time.Time) *CalleeDNDMessage {
+	return &CalleeDNDMessage{
+		Type: MessageTypeCalleeDND,
+		Payload: CalleeDNDPayload{
+			CalleeID: calleeID,
+			Until:    until,
+		},
+	}
+}
+
 // ParsedMessage is a union type of all possible message types
 type ParsedMessage struct {
+	// ID the client sent along with the message, empty if it didn't ask for an ack
+	ID                    string
+	Type                  MessageType
 	Success               *SuccessMessage
 	Pong                  *PongMessage
 	Ping                  *PingMessage
 	CallRequest           *CallRequestMessage
 	CallEnd               *CallEndMessage
+	CallRinging           *CallRingingMessage
+	CallRequestGroup      *CallRequestGroupMessage
+	CallAnsweredElsewhere *CallAnsweredElsewhereMessage
+	CallHold              *CallHoldMessage
+	CallResume            *CallResumeMessage
 	CalleeOffline         *CalleeOfflineMessage
+	CalleeBusy            *CalleeBusyMessage
+	CalleeDND             *CalleeDNDMessage
 	IncomingCall          *IncomingCallMessage
 	AcceptCallMessage     *AcceptCallMessage
 	RejectCallMessage     *RejectCallMessage
 	CallTokensMessage     *CallTokensMessage
 	TeammateOnlineMessage *TeammateOnlineMessage
+	JoinRequestMessage    *JoinRequestMessage
+	TeammateLeftMessage   *TeammateLeftMessage
+	TeamAnnouncement      *TeamAnnouncementMessage
+	TeamMemberJoined      *TeamMemberJoinedMessage
+	TeamChatMessage       *TeamChatMessageMessage
+	TeamSettingsChanged   *TeamSettingsChangedMessage
+	ActiveTeamChanged     *ActiveTeamChangedMessage
+	TeammateStatus        *TeammateStatusMessage
+	Resume                *ResumeMessage
+	SessionActivity       *SessionActivityMessage
+	TeammateActivity      *TeammateActivityMessage
+	DirectMessage         *DirectMessageMessage
+	DirectMessageReceived *DirectMessageReceivedMessage
+	DirectMessageReaction *DirectMessageReactionMessage
+	FileTransfer          *FileTransferMessage
+	DirectMessageAck      *DirectMessageAckMessage
+	DirectMessageState    *DirectMessageStateMessage
+	LinkPreview           *LinkPreviewMessage
+	CallUnanswered        *CallUnansweredMessage
+	MissedCallMessage     *MissedCallMessage
+	GroupCallInvite       *GroupCallInviteMessage
+	IncomingGroupCall     *IncomingGroupCallMessage
+	GroupCallJoin         *GroupCallJoinMessage
+	GroupCallLeave        *GroupCallLeaveMessage
+	GroupCallTokens       *GroupCallTokensMessage
+	GroupCallRoster       *GroupCallRosterMessage
+	CallbackRequest       *CallbackRequestMessage
+	CallbackRingBack      *CallbackRingBackMessage
+	PhoneParticipant      *PhoneParticipantMessage
+	CallReaction          *CallReactionMessage
+	AnnotationStrokeStart *AnnotationMessage
+	AnnotationPoints      *AnnotationMessage
+	AnnotationClear       *AnnotationMessage
 	Error                 *ErrorMessage
 }
 
-// ParseMessage parses a raw message into a ParsedMessage
+// ParseMessage parses a raw message into a ParsedMessage, messages that miss
+// required fields or have fields of the wrong type are an InvalidMessageError
 func ParseMessage(data []byte) (*ParsedMessage, error) {
+	parsed, err := parseMessage(data)
+	if err != nil {
+		return nil, malformedMessageError(err)
+	}
+
+	if err := validateMessage(parsed); err != nil {
+		return nil, err
+	}
+
+	return parsed, nil
+}
+
+func parseMessage(data []byte) (*ParsedMessage, error) {
 	var base BaseMessage
 	if err := json.Unmarshal(data, &base); err != nil {
 		return nil, fmt.Errorf("failed to parse base message: %w", err)
 	}
 
-	parsed := &ParsedMessage{}
+	parsed := &ParsedMessage{ID: base.ID, Type: base.Type}
 
 	switch base.Type {
 	case MessageTypeCallRequest:
@@ -217,12 +1005,42 @@ func ParseMessage(data []byte) (*ParsedMessage, error) {
 			return nil, err
 		}
 		parsed.IncomingCall = &msg
+	case MessageTypeCallHold:
+		var msg CallHoldMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return nil, err
+		}
+		parsed.CallHold = &msg
+	case MessageTypeCallResume:
+		var msg CallResumeMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return nil, err
+		}
+		parsed.CallResume = &msg
+	case MessageTypeCallRinging:
+		var msg CallRingingMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return nil, err
+		}
+		parsed.CallRinging = &msg
 	case MessageTypeCalleeOffline:
 		var msg CalleeOfflineMessage
 		if err := json.Unmarshal(data, &msg); err != nil {
 			return nil, err
 		}
 		parsed.CalleeOffline = &msg
+	case MessageTypeCalleeBusy:
+		var msg CalleeBusyMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return nil, err
+		}
+		parsed.CalleeBusy = &msg
+	case MessageTypeCalleeDND:
+		var msg CalleeDNDMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return nil, err
+		}
+		parsed.CalleeDND = &msg
 	case MessageTypeCallReject:
 		var msg RejectCallMessage
 		if err := json.Unmarshal(data, &msg); err != nil {
@@ -259,6 +1077,216 @@ func ParseMessage(data []byte) (*ParsedMessage, error) {
 			return nil, err
 		}
 		parsed.TeammateOnlineMessage = &msg
+	case MessageTypeJoinRequest:
+		var msg JoinRequestMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return nil, err
+		}
+		parsed.JoinRequestMessage = &msg
+	case MessageTypeTeammateLeft:
+		var msg TeammateLeftMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return nil, err
+		}
+		parsed.TeammateLeftMessage = &msg
+	case MessageTypeTeamAnnouncement:
+		var msg TeamAnnouncementMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return nil, err
+		}
+		parsed.TeamAnnouncement = &msg
+	case MessageTypeTeamMemberJoined:
+		var msg TeamMemberJoinedMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return nil, err
+		}
+		parsed.TeamMemberJoined = &msg
+	case MessageTypeTeamChatMessage:
+		var msg TeamChatMessageMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return nil, err
+		}
+		parsed.TeamChatMessage = &msg
+	case MessageTypeTeamSettingsChanged:
+		var msg TeamSettingsChangedMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return nil, err
+		}
+		parsed.TeamSettingsChanged = &msg
+	case MessageTypeActiveTeamChanged:
+		var msg ActiveTeamChangedMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return nil, err
+		}
+		parsed.ActiveTeamChanged = &msg
+	case MessageTypeCallUnanswered:
+		var msg CallUnansweredMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return nil, err
+		}
+		parsed.CallUnanswered = &msg
+	case MessageTypeMissedCall:
+		var msg MissedCallMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return nil, err
+		}
+		parsed.MissedCallMessage = &msg
+	case MessageTypeGroupCallInvite:
+		var msg GroupCallInviteMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return nil, err
+		}
+		parsed.GroupCallInvite = &msg
+	case MessageTypeIncomingGroupCall:
+		var msg IncomingGroupCallMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return nil, err
+		}
+		parsed.IncomingGroupCall = &msg
+	case MessageTypeGroupCallJoin:
+		var msg GroupCallJoinMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return nil, err
+		}
+		parsed.GroupCallJoin = &msg
+	case MessageTypeGroupCallLeave:
+		var msg GroupCallLeaveMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return nil, err
+		}
+		parsed.GroupCallLeave = &msg
+	case MessageTypeGroupCallTokens:
+		var msg GroupCallTokensMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return nil, err
+		}
+		parsed.GroupCallTokens = &msg
+	case MessageTypeGroupCallRoster:
+		var msg GroupCallRosterMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return nil, err
+		}
+		parsed.GroupCallRoster = &msg
+	case MessageTypeCallbackRequest:
+		var msg CallbackRequestMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return nil, err
+		}
+		parsed.CallbackRequest = &msg
+	case MessageTypeCallbackRingBack:
+		var msg CallbackRingBackMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return nil, err
+		}
+		parsed.CallbackRingBack = &msg
+	case MessageTypePhoneParticipant:
+		var msg PhoneParticipantMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return nil, err
+		}
+		parsed.PhoneParticipant = &msg
+	case MessageTypeCallReaction:
+		var msg CallReactionMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return nil, err
+		}
+		parsed.CallReaction = &msg
+	case MessageTypeAnnotationStrokeStart:
+		var msg AnnotationMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return nil, err
+		}
+		parsed.AnnotationStrokeStart = &msg
+	case MessageTypeAnnotationPoints:
+		var msg AnnotationMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return nil, err
+		}
+		parsed.AnnotationPoints = &msg
+	case MessageTypeAnnotationClear:
+		var msg AnnotationMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return nil, err
+		}
+		parsed.AnnotationClear = &msg
+	case MessageTypeCallRequestGroup:
+		var msg CallRequestGroupMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return nil, err
+		}
+		parsed.CallRequestGroup = &msg
+	case MessageTypeCallAnsweredElsewhere:
+		var msg CallAnsweredElsewhereMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return nil, err
+		}
+		parsed.CallAnsweredElsewhere = &msg
+	case MessageTypeTeammateStatus:
+		var msg TeammateStatusMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return nil, err
+		}
+		parsed.TeammateStatus = &msg
+	case MessageTypeResume:
+		var msg ResumeMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return nil, err
+		}
+		parsed.Resume = &msg
+	case MessageTypeSessionActivity:
+		var msg SessionActivityMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return nil, err
+		}
+		parsed.SessionActivity = &msg
+	case MessageTypeTeammateActivity:
+		var msg TeammateActivityMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return nil, err
+		}
+		parsed.TeammateActivity = &msg
+	case MessageTypeDirectMessage:
+		var msg DirectMessageMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return nil, err
+		}
+		parsed.DirectMessage = &msg
+	case MessageTypeDirectMessageReceived:
+		var msg DirectMessageReceivedMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return nil, err
+		}
+		parsed.DirectMessageReceived = &msg
+	case MessageTypeDirectMessageReaction:
+		var msg DirectMessageReactionMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return nil, err
+		}
+		parsed.DirectMessageReaction = &msg
+	case MessageTypeFileTransfer:
+		var msg FileTransferMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return nil, err
+		}
+		parsed.FileTransfer = &msg
+	case MessageTypeDirectMessageAck:
+		var msg DirectMessageAckMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return nil, err
+		}
+		parsed.DirectMessageAck = &msg
+	case MessageTypeDirectMessageState:
+		var msg DirectMessageStateMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return nil, err
+		}
+		parsed.DirectMessageState = &msg
+	case MessageTypeLinkPreview:
+		var msg LinkPreviewMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return nil, err
+		}
+		parsed.LinkPreview = &msg
 	}
 
 	return parsed, nil
@@ -267,11 +1295,25 @@ func ParseMessage(data []byte) (*ParsedMessage, error) {
 // Helper functions to create typed messages
 
 // NewSuccessMessage creates a new success message
-func NewSuccessMessage(message string) SuccessMessage {
+func NewSuccessMessage(message string, protocolVersion int, pingInterval time.Duration, reconnect ReconnectPolicy) SuccessMessage {
 	return SuccessMessage{
 		Type: MessageTypeSuccess,
 		Payload: SuccessPayload{
-			Message: message,
+			Message:           message,
+			ProtocolVersion:   protocolVersion,
+			SupportedVersions: SupportedProtocolVersions,
+			PingIntervalMs:    pingInterval.Milliseconds(),
+			Reconnect:         reconnect,
+		},
+	}
+}
+
+// NewProtocolUnsupportedMessage creates a new protocol unsupported message
+func NewProtocolUnsupportedMessage() ProtocolUnsupportedMessage {
+	return ProtocolUnsupportedMessage{
+		Type: MessageTypeProtocolUnsupported,
+		Payload: ProtocolUnsupportedPayload{
+			SupportedVersions: SupportedProtocolVersions,
 		},
 	}
 }
@@ -287,20 +1329,33 @@ func NewCallRequestMessage(calleeID string) CallRequestMessage {
 }
 
 // NewCallEndMessage creates a new call end message
-func NewCallEndMessage(callID string) CallEndMessage {
+func NewCallEndMessage(participantID, callID string) CallEndMessage {
 	return CallEndMessage{
 		Type: MessageTypeCallEnd,
 		Payload: CallEndPayload{
-			ParticipantID: callID,
+			ParticipantID: participantID,
+			CallID:        callID,
 		},
 	}
 }
 
-func NewIncomingCallMessage(callerID string) IncomingCallMessage {
+func NewIncomingCallMessage(callerID, callID string) IncomingCallMessage {
 	return IncomingCallMessage{
 		Type: MessageTypeIncomingCall,
 		Payload: IncomingCallPayload{
 			CallerID: callerID,
+			CallID:   callID,
+		},
+	}
+}
+
+// NewCallRingingMessage creates a new call ringing message
+func NewCallRingingMessage(callID, calleeID string) CallRingingMessage {
+	return CallRingingMessage{
+		Type: MessageTypeCallRinging,
+		Payload: CallRingingPayload{
+			CallID:   callID,
+			CalleeID: calleeID,
 		},
 	}
 }
@@ -314,15 +1369,76 @@ func NewErrorMessage(err string) ErrorMessage {
 	}
 }
 
-func NewCallTokens(tokens common.LivekitTokenSet) CallTokensMessage {
-	return CallTokensMessage{
-		Type: MessageTypeNewCallTokens,
-		Payload: struct{ common.LivekitTokenSet }{
-			LivekitTokenSet: tokens,
+// NewAckMessage creates a new ack message for the client message with the ID
+func NewAckMessage(id string) AckMessage {
+	return AckMessage{
+		Type: MessageTypeAck,
+		Payload: AckPayload{
+			ID: id,
+		},
+	}
+}
+
+// NewNackMessage creates a new nack message for the client message with the ID
+func NewNackMessage(id, err string) NackMessage {
+	return NackMessage{
+		Type: MessageTypeNack,
+		Payload: NackPayload{
+			ID:    id,
+			Error: err,
+		},
+	}
+}
+
+// NewRateLimitedMessage creates a new rate limited message for the dropped client message
+func NewRateLimitedMessage(id string, messageType MessageType, retryAfter time.Duration) RateLimitedMessage {
+	return RateLimitedMessage{
+		Type: MessageTypeRateLimited,
+		Payload: RateLimitedPayload{
+			ID:           id,
+			MessageType:  messageType,
+			RetryAfterMs: retryAfter.Milliseconds(),
+		},
+	}
+}
+
+// NewServerShutdownMessage creates a new server shutdown message
+func NewServerShutdownMessage(reconnectAfter time.Duration, reconnect ReconnectPolicy) ServerShutdownMessage {
+	return ServerShutdownMessage{
+		Type: MessageTypeServerShutdown,
+		Payload: ServerShutdownPayload{
+			ReconnectAfterMs: reconnectAfter.Milliseconds(),
+			Reconnect:        reconnect,
 		},
 	}
 }
 
+// NewReconnectPolicy creates a new reconnect policy
+func NewReconnectPolicy(minBackoff, maxBackoff, resumeWindow time.Duration) ReconnectPolicy {
+	return ReconnectPolicy{
+		MinBackoffMs:   minBackoff.Milliseconds(),
+		MaxBackoffMs:   maxBackoff.Milliseconds(),
+		ResumeWindowMs: resumeWindow.Milliseconds(),
+	}
+}
+
+// MessageID returns the ID of a raw client message, empty if it has none or can't be parsed
+func MessageID(data []byte) string {
+	var base BaseMessage
+	if err := json.Unmarshal(data, &base); err != nil {
+		return ""
+	}
+
+	return base.ID
+}
+
+func NewCallTokens(tokens common.LivekitTokenSet, callID string) CallTokensMessage {
+	msg := CallTokensMessage{Type: MessageTypeNewCallTokens}
+	msg.Payload.LivekitTokenSet = tokens
+	msg.Payload.CallID = callID
+	return msg
+}
+
 // NewPongMessage creates a new pong message
 func NewPongMessage() PongMessage {
 	return PongMessage{
@@ -345,3 +1461,329 @@ func NewTeammateOnlineMessage(teammateID string) TeammateOnlineMessage {
 		},
 	}
 }
+
+// NewJoinRequestMessage creates a new join request message
+func NewJoinRequestMessage(joinRequestID, teamID uint, userID, userName string) JoinRequestMessage {
+	return JoinRequestMessage{
+		Type: MessageTypeJoinRequest,
+		Payload: JoinRequestPayload{
+			JoinRequestID: joinRequestID,
+			TeamID:        teamID,
+			UserID:        userID,
+			UserName:      userName,
+		},
+	}
+}
+
+// NewTeammateLeftMessage creates a new teammate left message
+func NewTeammateLeftMessage(teammateID string, teamID uint) TeammateLeftMessage {
+	return TeammateLeftMessage{
+		Type: MessageTypeTeammateLeft,
+		Payload: TeammateLeftPayload{
+			TeammateID: teammateID,
+			TeamID:     teamID,
+		},
+	}
+}
+
+// NewMissedCallMessage creates a new missed call message
+func NewMissedCallMessage(missedCallID uint, callerID, callerName, reason string, missedAt time.Time) MissedCallMessage {
+	return MissedCallMessage{
+		Type: MessageTypeMissedCall,
+		Payload: MissedCallPayload{
+			MissedCallID: missedCallID,
+			CallerID:     callerID,
+			CallerName:   callerName,
+			Reason:       reason,
+			MissedAt:     missedAt,
+		},
+	}
+}
+
+// NewIncomingGroupCallMessage creates a new incoming group call message
+func NewIncomingGroupCallMessage(roomName, callerID string, participantIDs []string) IncomingGroupCallMessage {
+	return IncomingGroupCallMessage{
+		Type: MessageTypeIncomingGroupCall,
+		Payload: IncomingGroupCallPayload{
+			RoomName:       roomName,
+			CallerID:       callerID,
+			ParticipantIDs: participantIDs,
+		},
+	}
+}
+
+// NewGroupCallTokensMessage creates a new group call tokens message
+func NewGroupCallTokensMessage(roomName string, tokens common.LivekitTokenSet) GroupCallTokensMessage {
+	return GroupCallTokensMessage{
+		Type: MessageTypeGroupCallTokens,
+		Payload: GroupCallTokensPayload{
+			LivekitTokenSet: tokens,
+			RoomName:        roomName,
+		},
+	}
+}
+
+// NewGroupCallRosterMessage creates a new group call roster message
+func NewGroupCallRosterMessage(roomName string, participantIDs, invitedIDs []string) GroupCallRosterMessage {
+	return GroupCallRosterMessage{
+		Type: MessageTypeGroupCallRoster,
+		Payload: GroupCallRosterPayload{
+			RoomName:       roomName,
+			ParticipantIDs: participantIDs,
+			InvitedIDs:     invitedIDs,
+		},
+	}
+}
+
+// NewCallbackRequestMessage creates a new callback request message
+func NewCallbackRequestMessage(callbackRequestID uint, callerID, callerName string, requestedAt time.Time) CallbackRequestMessage {
+	return CallbackRequestMessage{
+		Type: MessageTypeCallbackRequest,
+		Payload: CallbackRequestPayload{
+			CallbackRequestID: callbackRequestID,
+			CallerID:          callerID,
+			CallerName:        callerName,
+			RequestedAt:       requestedAt,
+		},
+	}
+}
+
+// NewCallUnansweredMessage creates a new call unanswered message
+func NewCallUnansweredMessage(callID, callerID, calleeID string) CallUnansweredMessage {
+	return CallUnansweredMessage{
+		Type: MessageTypeCallUnanswered,
+		Payload: CallUnansweredPayload{
+			CallID:   callID,
+			CallerID: callerID,
+			CalleeID: calleeID,
+		},
+	}
+}
+
+// NewCallHoldMessage creates a new call hold message
+func NewCallHoldMessage(participantID, callID, heldBy string) CallHoldMessage {
+	return CallHoldMessage{
+		Type: MessageTypeCallHold,
+		Payload: CallHoldPayload{
+			ParticipantID: participantID,
+			CallID:        callID,
+			HeldBy:        heldBy,
+		},
+	}
+}
+
+// NewCallResumeMessage creates a new call resume message
+func NewCallResumeMessage(participantID, callID, heldBy string) CallResumeMessage {
+	return CallResumeMessage{
+		Type: MessageTypeCallResume,
+		Payload: CallHoldPayload{
+			ParticipantID: participantID,
+			CallID:        callID,
+			HeldBy:        heldBy,
+		},
+	}
+}
+
+// NewPhoneParticipantMessage creates a new phone participant message
+func NewPhoneParticipantMessage(roomName, identity, name string, joined bool) PhoneParticipantMessage {
+	return PhoneParticipantMessage{
+		Type: MessageTypePhoneParticipant,
+		Payload: PhoneParticipantPayload{
+			RoomName: roomName,
+			Identity: identity,
+			Name:     name,
+			Joined:   joined,
+		},
+	}
+}
+
+// NewCallReactionMessage creates a new call reaction message
+func NewCallReactionMessage(roomName, emoji, senderID, senderName string) CallReactionMessage {
+	return CallReactionMessage{
+		Type: MessageTypeCallReaction,
+		Payload: CallReactionPayload{
+			RoomName:   roomName,
+			Emoji:      emoji,
+			SenderID:   senderID,
+			SenderName: senderName,
+		},
+	}
+}
+
+// NewAnnotationMessage creates a new annotation message of the given type
+func NewAnnotationMessage(messageType MessageType, payload AnnotationPayload) AnnotationMessage {
+	return AnnotationMessage{
+		Type:    messageType,
+		Payload: payload,
+	}
+}
+
+// NewCallAnsweredElsewhereMessage creates a new call answered elsewhere message
+func NewCallAnsweredElsewhereMessage(callID, callerID, answeredBy string) CallAnsweredElsewhereMessage {
+	return CallAnsweredElsewhereMessage{
+		Type: MessageTypeCallAnsweredElsewhere,
+		Payload: CallAnsweredElsewherePayload{
+			CallID:     callID,
+			CallerID:   callerID,
+			AnsweredBy: answeredBy,
+		},
+	}
+}
+
+// NewTeammateStatusMessage creates a new teammate status message
+func NewTeammateStatusMessage(teammateID, status, statusText string) TeammateStatusMessage {
+	return TeammateStatusMessage{
+		Type: MessageTypeTeammateStatus,
+		Payload: TeammateStatusPayload{
+			TeammateID: teammateID,
+			Status:     status,
+			StatusText: statusText,
+		},
+	}
+}
+
+// NewTeammateActivityMessage creates a new teammate activity message
+func NewTeammateActivityMessage(teammateID, activity string) TeammateActivityMessage {
+	return TeammateActivityMessage{
+		Type: MessageTypeTeammateActivity,
+		Payload: TeammateActivityPayload{
+			TeammateID: teammateID,
+			Activity:   activity,
+		},
+	}
+}
+
+// NewTeamAnnouncementMessage creates a new team announcement message
+func NewTeamAnnouncementMessage(teamID uint, message, authorID, authorName string) TeamAnnouncementMessage {
+	return TeamAnnouncementMessage{
+		Type: MessageTypeTeamAnnouncement,
+		Payload: TeamAnnouncementPayload{
+			TeamID:     teamID,
+			Message:    message,
+			AuthorID:   authorID,
+			AuthorName: authorName,
+		},
+	}
+}
+
+// NewTeamMemberJoinedMessage creates a new team member joined message
+func NewTeamMemberJoinedMessage(teamID uint, memberID, memberName string) TeamMemberJoinedMessage {
+	return TeamMemberJoinedMessage{
+		Type: MessageTypeTeamMemberJoined,
+		Payload: TeamMemberJoinedPayload{
+			TeamID:     teamID,
+			MemberID:   memberID,
+			MemberName: memberName,
+		},
+	}
+}
+
+// NewTeamSettingsChangedMessage creates a new team settings changed message
+func NewTeamSettingsChangedMessage(teamID uint) TeamSettingsChangedMessage {
+	return TeamSettingsChangedMessage{
+		Type: MessageTypeTeamSettingsChanged,
+		Payload: TeamSettingsChangedPayload{
+			TeamID: teamID,
+		},
+	}
+}
+
+// NewActiveTeamChangedMessage creates a new active team changed message
+func NewActiveTeamChangedMessage(teamID *uint) ActiveTeamChangedMessage {
+	return ActiveTeamChangedMessage{
+		Type: MessageTypeActiveTeamChanged,
+		Payload: ActiveTeamChangedPayload{
+			TeamID: teamID,
+		},
+	}
+}
+
+// NewDirectMessageReceivedMessage creates a new direct message received message
+func NewDirectMessageReceivedMessage(messageID uint, senderID, senderName, recipientID, body string, sentAt time.Time) DirectMessageReceivedMessage {
+	return DirectMessageReceivedMessage{
+		Type: MessageTypeDirectMessageReceived,
+		Payload: DirectMessageReceivedPayload{
+			MessageID:   messageID,
+			SenderID:    senderID,
+			SenderName:  senderName,
+			RecipientID: recipientID,
+			Body:        body,
+			SentAt:      sentAt,
+		},
+	}
+}
+
+// NewDirectMessageReactionMessage creates a new direct message reaction message
+func NewDirectMessageReactionMessage(messageID uint, userID, emoji string, added bool, count int) DirectMessageReactionMessage {
+	return DirectMessageReactionMessage{
+		Type: MessageTypeDirectMessageReaction,
+		Payload: DirectMessageReactionPayload{
+			MessageID: messageID,
+			UserID:    userID,
+			Emoji:     emoji,
+			Added:     added,
+			Count:     count,
+		},
+	}
+}
+
+// NewDirectMessageStateMessage creates a new direct message state message
+func NewDirectMessageStateMessage(messageIDs []uint, recipientID, state string, at time.Time) DirectMessageStateMessage {
+	return DirectMessageStateMessage{
+		Type: MessageTypeDirectMessageState,
+		Payload: DirectMessageStatePayload{
+			MessageIDs:  messageIDs,
+			RecipientID: recipientID,
+			State:       state,
+			At:          at,
+		},
+	}
+}
+
+// NewFileTransferMessage creates a new file transfer message
+func NewFileTransferMessage(senderID, senderName, fileName, contentType string, size int64, checksum, url string, urlExpiresAt time.Time) FileTransferMessage {
+	return FileTransferMessage{
+		Type: MessageTypeFileTransfer,
+		Payload: FileTransferPayload{
+			SenderID:     senderID,
+			SenderName:   senderName,
+			FileName:     fileName,
+			ContentType:  contentType,
+			Size:         size,
+			SHA256:       checksum,
+			URL:          url,
+			URLExpiresAt: urlExpiresAt,
+		},
+	}
+}
+
+// NewLinkPreviewMessage creates a new link preview message
+func NewLinkPreviewMessage(messageKind string, messageID uint, url, title, description, imageURL, siteName string) LinkPreviewMessage {
+	return LinkPreviewMessage{
+		Type: MessageTypeLinkPreview,
+		Payload: LinkPreviewPayload{
+			MessageKind: messageKind,
+			MessageID:   messageID,
+			URL:         url,
+			Title:       title,
+			Description: description,
+			ImageURL:    imageURL,
+			SiteName:    siteName,
+		},
+	}
+}
+
+// NewTeamChatMessageMessage creates a new team chat message message
+func NewTeamChatMessageMessage(messageID, teamID uint, senderID, senderName, body string, sentAt time.Time) TeamChatMessageMessage {
+	return TeamChatMessageMessage{
+		Type: MessageTypeTeamChatMessage,
+		Payload: TeamChatMessagePayload{
+			MessageID:  messageID,
+			TeamID:     teamID,
+			SenderID:   senderID,
+			SenderName: senderName,
+			Body:       body,
+			SentAt:     sentAt,
+		},
+	}
+}
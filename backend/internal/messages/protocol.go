@@ -0,0 +1,61 @@
+package messages
+
+import (
+	"strconv"
+	"strings"
+)
+
+// ProtocolVersion is the latest version of the websocket message format
+const ProtocolVersion = 1
+
+// LegacyProtocolVersion is the version of the clients that connect without asking for one
+const LegacyProtocolVersion = 1
+
+// SupportedProtocolVersions are the versions of the message format the server
+// speaks, a change to the format that breaks older clients gets a new version
+var SupportedProtocolVersions = []int{1}
+
+// ParseProtocolVersions parses the comma separated protocol versions a client speaks
+func ParseProtocolVersions(value string) ([]int, error) {
+	var versions []int
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		version, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, version)
+	}
+
+	return versions, nil
+}
+
+// NegotiateProtocolVersion picks the latest version that both the client and the
+// server speak. Clients that didn't ask for any version get the legacy one.
+func NegotiateProtocolVersion(clientVersions []int) (int, bool) {
+	if len(clientVersions) == 0 {
+		return LegacyProtocolVersion, true
+	}
+
+	negotiated := 0
+	for _, version := range clientVersions {
+		for _, supported := range SupportedProtocolVersions {
+			if version == supported && version > negotiated {
+				negotiated = version
+			}
+		}
+	}
+
+	return negotiated, negotiated != 0
+}
+
+// TranslateForVersion rewrites an encoded server message into the format of an
+// older protocol version. Every version has the latest format for now, the
+// translations go here once a version changes it.
+func TranslateForVersion(version int, msg []byte) []byte {
+	return msg
+}
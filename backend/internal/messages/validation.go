@@ -0,0 +1,99 @@
+package messages
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+
+	"github.com/go-playground/validator"
+)
+
+// messageValidator checks messages against the validate tags of their type,
+// fields are reported by their JSON name so clients can tell which one is wrong
+var messageValidator = newMessageValidator()
+
+func newMessageValidator() *validator.Validate {
+	v := validator.New()
+	v.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+		if name == "-" {
+			return ""
+		}
+		return name
+	})
+
+	return v
+}
+
+// InvalidField is a field of a message that is missing or malformed
+type InvalidField struct {
+	// Path of the field in the message, e.g. payload.callee_id
+	Field string `json:"field"`
+	// Rule the field breaks, e.g. required, or type when it has the wrong JSON type
+	Reason string `json:"reason"`
+}
+
+// InvalidMessageError is returned for messages that don't match the format of their type
+type InvalidMessageError struct {
+	Fields []InvalidField
+}
+
+func (e *InvalidMessageError) Error() string {
+	fields := make([]string, len(e.Fields))
+	for i, field := range e.Fields {
+		fields[i] = field.Field + " (" + field.Reason + ")"
+	}
+
+	return "Invalid message: " + strings.Join(fields, ", ")
+}
+
+// validateMessage checks the parsed message against the validate tags of its type
+func validateMessage(parsed *ParsedMessage) error {
+	msg := parsed.message()
+	if msg == nil {
+		return nil
+	}
+
+	err := messageValidator.Struct(msg)
+	var validationErrors validator.ValidationErrors
+	if !errors.As(err, &validationErrors) {
+		return err
+	}
+
+	invalid := &InvalidMessageError{}
+	for _, fieldErr := range validationErrors {
+		// The namespace starts with the name of the message struct
+		path := fieldErr.Namespace()
+		if i := strings.Index(path, "."); i != -1 {
+			path = path[i+1:]
+		}
+		invalid.Fields = append(invalid.Fields, InvalidField{Field: path, Reason: fieldErr.Tag()})
+	}
+
+	return invalid
+}
+
+// malformedMessageError turns a JSON field of the wrong type into an InvalidMessageError,
+// other errors are returned as they are
+func malformedMessageError(err error) error {
+	var typeErr *json.UnmarshalTypeError
+	if !errors.As(err, &typeErr) || typeErr.Field == "" {
+		return err
+	}
+
+	return &InvalidMessageError{Fields: []InvalidField{{Field: typeErr.Field, Reason: "type"}}}
+}
+
+// message returns the message of whichever type was parsed, nil for unknown types
+func (p *ParsedMessage) message() interface{} {
+	v := reflect.ValueOf(p).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Field(i)
+		if field.Kind() == reflect.Ptr && !field.IsNil() {
+			return field.Interface()
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,76 @@
+package models
+
+import (
+	"crypto/rand"
+	"errors"
+	"slices"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ApiKeyPrefix is prepended to every personal access token so
+// it can be told apart from a JWT in the Authorization header
+const ApiKeyPrefix = "hopp_pat_"
+
+// Scopes that can be granted to an API key
+const (
+	// ApiKeyScopeRead allows only safe (GET/HEAD) requests
+	ApiKeyScopeRead = "read"
+	// ApiKeyScopeWrite allows mutating requests
+	ApiKeyScopeWrite = "write"
+)
+
+var ApiKeyScopes = []string{ApiKeyScopeRead, ApiKeyScopeWrite}
+
+// ApiKey is a personal access token that users can create to
+// script against the API without a browser login.
+// Only the SHA-256 hash of the token is stored.
+type ApiKey struct {
+	gorm.Model
+	UserID     string     `gorm:"not null;index" json:"user_id"`
+	User       User       `gorm:"foreignKey:UserID;references:ID" json:"-"`
+	Name       string     `gorm:"not null" json:"name" validate:"required"`
+	HashedKey  string     `gorm:"not null;uniqueIndex" json:"-"`
+	Prefix     string     `gorm:"not null" json:"prefix"` // First characters of the key, to help users identify it
+	Scopes     []string   `gorm:"serializer:json" json:"scopes"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+}
+
+// NewApiKey generates a new API key for the user and returns
+// the model along with the plain text key which is shown only once
+func NewApiKey(userID, name string, scopes []string, expiresAt *time.Time) (*ApiKey, string) {
+	plainKey := ApiKeyPrefix + rand.Text()
+
+	return &ApiKey{
+		UserID:    userID,
+		Name:      name,
+		HashedKey: HashToken(plainKey),
+		Prefix:    plainKey[:len(ApiKeyPrefix)+4],
+		Scopes:    scopes,
+		ExpiresAt: expiresAt,
+	}, plainKey
+}
+
+// GetApiKeyByPlainKey looks up a non-revoked API key with its user preloaded
+func GetApiKeyByPlainKey(db *gorm.DB, plainKey string) (*ApiKey, error) {
+	var key ApiKey
+	result := db.Preload("User").Where("hashed_key = ?", HashToken(plainKey)).First(&key)
+
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, errors.New("API key not found")
+		}
+		return nil, result.Error
+	}
+	return &key, nil
+}
+
+func (k *ApiKey) IsExpired() bool {
+	return k.ExpiresAt != nil && time.Now().After(*k.ExpiresAt)
+}
+
+func (k *ApiKey) HasScope(scope string) bool {
+	return slices.Contains(k.Scopes, scope)
+}
@@ -0,0 +1,34 @@
+package models
+
+import (
+	"gorm.io/gorm"
+)
+
+// AuditEventType is the kind of authentication event recorded
+type AuditEventType string
+
+const (
+	AuditEventSignUp       AuditEventType = "sign_up"
+	AuditEventSignIn       AuditEventType = "sign_in"
+	AuditEventSignInFailed AuditEventType = "sign_in_failed"
+	AuditEventSocialLogin  AuditEventType = "social_login"
+	AuditEventTokenRefresh AuditEventType = "token_refresh"
+	AuditEventLogout       AuditEventType = "logout"
+	// Support admin impersonation
+	AuditEventImpersonationStarted AuditEventType = "impersonation_started"
+	AuditEventImpersonatedAction   AuditEventType = "impersonated_action"
+)
+
+// AuditEvent is an authentication related event, used to show
+// users their recent account activity and to investigate abuse
+type AuditEvent struct {
+	gorm.Model
+	// UserID is empty for failed sign-ins of unknown emails
+	UserID    *string                `gorm:"index" json:"user_id"`
+	Email     string                 `gorm:"index" json:"email"`
+	Event     AuditEventType         `gorm:"not null;index" json:"event"`
+	Provider  string                 `json:"provider,omitempty"` // For social logins
+	IPAddress string                 `json:"ip_address"`
+	UserAgent string                 `json:"user_agent"`
+	Metadata  map[string]interface{} `gorm:"serializer:json" json:"metadata,omitempty"`
+}
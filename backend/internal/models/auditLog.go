@@ -0,0 +1,64 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// AuditLogEntry is a mutating API request, kept for compliance audits. Unlike
+// AuditEvent it covers every change made through the API, and it is append-only:
+// the database refuses to update or delete the entries.
+type AuditLogEntry struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `gorm:"index" json:"created_at"`
+	// Actor is empty for unauthenticated requests, like sign-ins and webhooks
+	ActorID    *string `gorm:"index" json:"actor_id"`
+	ActorEmail string  `gorm:"index" json:"actor_email"`
+	// Support admin acting as the actor with an impersonation token
+	Impersonator string `json:"impersonator,omitempty"`
+	// Whether the request was authenticated with a personal access token
+	APIKey bool   `json:"api_key"`
+	Method string `gorm:"not null" json:"method"`
+	// Route is the path pattern of the endpoint, like /api/auth/team/invitations/:id
+	Route     string            `gorm:"index" json:"route"`
+	Path      string            `json:"path"`
+	Params    map[string]string `gorm:"serializer:json" json:"params,omitempty"`
+	Status    int               `json:"status"`
+	IPAddress string            `json:"ip_address"`
+	UserAgent string            `json:"user_agent"`
+	// Before and after values of the sensitive fields the request changed
+	Changes []AuditChange `gorm:"serializer:json" json:"changes,omitempty"`
+}
+
+// AuditChange is the change of a sensitive field by a request
+type AuditChange struct {
+	Field  string      `json:"field"`
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+// EnsureAuditLogAppendOnly makes the database refuse updates and deletes of the
+// audit log entries, so not even a bug or a leaked database user can rewrite them
+func EnsureAuditLogAppendOnly(db *gorm.DB) error {
+	statements := []string{
+		`CREATE OR REPLACE FUNCTION audit_log_entries_append_only() RETURNS trigger AS $$
+		BEGIN
+			RAISE EXCEPTION 'audit log entries are append-only';
+		END;
+		$$ LANGUAGE plpgsql`,
+		`DROP TRIGGER IF EXISTS audit_log_entries_append_only ON audit_log_entries`,
+		`CREATE TRIGGER audit_log_entries_append_only
+		BEFORE UPDATE OR DELETE ON audit_log_entries
+		FOR EACH ROW EXECUTE FUNCTION audit_log_entries_append_only()`,
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, statement := range statements {
+			if err := tx.Exec(statement).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
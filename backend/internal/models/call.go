@@ -0,0 +1,89 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Call is a call between two teammates, created when the callee accepts
+// and completed when one of the participants ends it
+type Call struct {
+	gorm.Model
+	RoomName  string     `gorm:"not null;uniqueIndex" json:"room_name"`
+	TeamID    *uint      `gorm:"index" json:"team_id"`
+	CallerID  string     `gorm:"not null;index" json:"caller_id"`
+	Caller    *User      `gorm:"foreignKey:CallerID;references:ID" json:"caller,omitempty"`
+	CalleeID  string     `gorm:"not null;index" json:"callee_id"`
+	Callee    *User      `gorm:"foreignKey:CalleeID;references:ID" json:"callee,omitempty"`
+	StartedAt time.Time  `gorm:"not null" json:"started_at"`
+	EndedAt   *time.Time `json:"ended_at"`
+	// Duration of the call in seconds, set when the call ends
+	Duration int64 `json:"duration"`
+}
+
+// StartCall records a call that was just accepted
+func StartCall(db *gorm.DB, roomName string, caller, callee *User) (*Call, error) {
+	call := Call{
+		RoomName:  roomName,
+		TeamID:    caller.TeamID,
+		CallerID:  caller.ID,
+		CalleeID:  callee.ID,
+		StartedAt: time.Now(),
+	}
+
+	if err := db.Create(&call).Error; err != nil {
+		return nil, err
+	}
+
+	return &call, nil
+}
+
+// EndOngoingCall completes the ongoing call between the two users,
+// whichever of them started it. It returns nil if there is none.
+func EndOngoingCall(db *gorm.DB, userID, participantID string) (*Call, error) {
+	var call Call
+	result := db.Where("ended_at IS NULL AND ((caller_id = ? AND callee_id = ?) OR (caller_id = ? AND callee_id = ?))",
+		userID, participantID, participantID, userID).
+		Order("started_at DESC").
+		Limit(1).
+		Find(&call)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, nil
+	}
+
+	if err := call.End(db); err != nil {
+		return nil, err
+	}
+
+	return &call, nil
+}
+
+// GetOngoingCalls returns the calls that haven't ended yet
+func GetOngoingCalls(db *gorm.DB) ([]Call, error) {
+	var calls []Call
+	if err := db.Where("ended_at IS NULL").Find(&calls).Error; err != nil {
+		return nil, err
+	}
+
+	return calls, nil
+}
+
+// End completes the call now and adds it to the call stats
+func (c *Call) End(db *gorm.DB) error {
+	endedAt := time.Now()
+	c.EndedAt = &endedAt
+	c.Duration = int64(endedAt.Sub(c.StartedAt).Seconds())
+	err := db.Model(c).Updates(map[string]interface{}{
+		"ended_at": endedAt,
+		"duration": c.Duration,
+	}).Error
+	if err != nil {
+		return err
+	}
+
+	return RecordCallStats(db, c)
+}
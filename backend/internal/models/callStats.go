@@ -0,0 +1,63 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// CallStats aggregates the calls a user took part in, per team and UTC day
+type CallStats struct {
+	gorm.Model
+	TeamID uint      `gorm:"not null;uniqueIndex:idx_call_stats_team_user_day" json:"team_id"`
+	UserID string    `gorm:"not null;uniqueIndex:idx_call_stats_team_user_day" json:"user_id"`
+	Day    time.Time `gorm:"type:date;not null;uniqueIndex:idx_call_stats_team_user_day" json:"day"`
+	// Calls the user took part in, as caller or callee
+	Calls int64 `gorm:"not null;default:0" json:"calls"`
+	// Calls the user started, each call has exactly one caller
+	// so these add up to the number of calls of the team
+	OutgoingCalls int64 `gorm:"not null;default:0" json:"outgoing_calls"`
+	// Time the user spent in calls, in seconds
+	Seconds int64 `gorm:"not null;default:0" json:"seconds"`
+}
+
+// RecordCallStats adds a completed call to the stats of both participants
+func RecordCallStats(db *gorm.DB, call *Call) error {
+	if call.TeamID == nil {
+		return nil
+	}
+
+	day := call.StartedAt.UTC().Truncate(24 * time.Hour)
+	stats := []CallStats{
+		{TeamID: *call.TeamID, UserID: call.CallerID, Day: day, Calls: 1, OutgoingCalls: 1, Seconds: call.Duration},
+		{TeamID: *call.TeamID, UserID: call.CalleeID, Day: day, Calls: 1, Seconds: call.Duration},
+	}
+
+	return db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "team_id"}, {Name: "user_id"}, {Name: "day"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"calls":          gorm.Expr("call_stats.calls + excluded.calls"),
+			"outgoing_calls": gorm.Expr("call_stats.outgoing_calls + excluded.outgoing_calls"),
+			"seconds":        gorm.Expr("call_stats.seconds + excluded.seconds"),
+			"updated_at":     gorm.Expr("excluded.updated_at"),
+		}),
+	}).Create(&stats).Error
+}
+
+// BackfillCallStats aggregates the calls that ended before call stats were
+// introduced, days that already have stats are left untouched
+func BackfillCallStats(db *gorm.DB) error {
+	return db.Exec(`
+		INSERT INTO call_stats (team_id, user_id, day, calls, outgoing_calls, seconds, created_at, updated_at)
+		SELECT team_id, user_id, day, COUNT(*), SUM(outgoing), SUM(duration), NOW(), NOW()
+		FROM (
+			SELECT team_id, caller_id AS user_id, DATE(started_at AT TIME ZONE 'UTC') AS day, 1 AS outgoing, duration
+			FROM calls WHERE team_id IS NOT NULL AND ended_at IS NOT NULL AND deleted_at IS NULL
+			UNION ALL
+			SELECT team_id, callee_id AS user_id, DATE(started_at AT TIME ZONE 'UTC') AS day, 0 AS outgoing, duration
+			FROM calls WHERE team_id IS NOT NULL AND ended_at IS NOT NULL AND deleted_at IS NULL
+		) AS participations
+		GROUP BY team_id, user_id, day
+		ON CONFLICT DO NOTHING`).Error
+}
@@ -0,0 +1,54 @@
+package models
+
+import (
+	"gorm.io/gorm"
+)
+
+// CallbackRequest is left by a caller that couldn't reach the callee, asking
+// to be called back. It is removed once the callee calls back or dismisses it.
+type CallbackRequest struct {
+	gorm.Model
+	// User that asked to be called back
+	CallerID string `gorm:"not null;index" json:"caller_id"`
+	Caller   *User  `gorm:"foreignKey:CallerID;references:ID" json:"caller,omitempty"`
+	CalleeID string `gorm:"not null;index" json:"callee_id"`
+}
+
+// CreateCallbackRequest asks the callee to call the caller back, it returns
+// the pending request if the caller already left one
+func CreateCallbackRequest(db *gorm.DB, callerID, calleeID string) (*CallbackRequest, error) {
+	callbackRequest := CallbackRequest{
+		CallerID: callerID,
+		CalleeID: calleeID,
+	}
+
+	err := db.Where("caller_id = ? AND callee_id = ?", callerID, calleeID).
+		FirstOrCreate(&callbackRequest).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &callbackRequest, nil
+}
+
+// GetPendingCallbackRequests returns the callback requests the callee hasn't handled yet
+func GetPendingCallbackRequests(db *gorm.DB, calleeID string) ([]CallbackRequest, error) {
+	var callbackRequests []CallbackRequest
+	err := db.Preload("Caller", func(db *gorm.DB) *gorm.DB {
+		return db.Select("id, first_name, last_name, email, avatar_url")
+	}).
+		Where("callee_id = ?", calleeID).
+		Order("created_at").
+		Find(&callbackRequests).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return callbackRequests, nil
+}
+
+// CompleteCallbackRequests removes the callback requests the caller left
+// for the callee, done once the callee calls them back
+func CompleteCallbackRequests(db *gorm.DB, callerID, calleeID string) error {
+	return db.Where("caller_id = ? AND callee_id = ?", callerID, calleeID).Delete(&CallbackRequest{}).Error
+}
@@ -0,0 +1,79 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// DailyStats aggregates the usage of the whole service per UTC day, for the admin stats
+type DailyStats struct {
+	gorm.Model
+	Day     time.Time `gorm:"type:date;not null;uniqueIndex" json:"day"`
+	Signups int64     `gorm:"not null;default:0" json:"signups"`
+	// Users that were online during the day, an estimate from the presence of the users
+	ActiveUsers int64 `gorm:"not null;default:0" json:"active_users"`
+	Calls       int64 `gorm:"not null;default:0" json:"calls"`
+	// Email invitations sent during the day, and how many of them were accepted since
+	InvitationsSent     int64 `gorm:"not null;default:0" json:"invitations_sent"`
+	InvitationsAccepted int64 `gorm:"not null;default:0" json:"invitations_accepted"`
+}
+
+// RollupDailyStats computes the stats of the UTC day from the users, calls and
+// invitations of the day. Active users can only be counted while the day is recent,
+// the highest count seen is kept.
+func RollupDailyStats(db *gorm.DB, day time.Time, activeUsers int64) error {
+	day = day.UTC().Truncate(24 * time.Hour)
+	next := day.Add(24 * time.Hour)
+	stats := DailyStats{Day: day, ActiveUsers: activeUsers}
+
+	if err := db.Model(&User{}).Where("created_at >= ? AND created_at < ?", day, next).Count(&stats.Signups).Error; err != nil {
+		return err
+	}
+
+	if err := db.Model(&Call{}).Where("started_at >= ? AND started_at < ?", day, next).Count(&stats.Calls).Error; err != nil {
+		return err
+	}
+
+	invitations := db.Model(&EmailInvitation{}).Where("sent_at >= ? AND sent_at < ?", day, next).Session(&gorm.Session{})
+	if err := invitations.Count(&stats.InvitationsSent).Error; err != nil {
+		return err
+	}
+	if err := invitations.Where("status = ?", EmailInvitationAccepted).Count(&stats.InvitationsAccepted).Error; err != nil {
+		return err
+	}
+
+	return db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "day"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"signups":              gorm.Expr("excluded.signups"),
+			"active_users":         gorm.Expr("GREATEST(daily_stats.active_users, excluded.active_users)"),
+			"calls":                gorm.Expr("excluded.calls"),
+			"invitations_sent":     gorm.Expr("excluded.invitations_sent"),
+			"invitations_accepted": gorm.Expr("excluded.invitations_accepted"),
+			"updated_at":           gorm.Expr("excluded.updated_at"),
+		}),
+	}).Create(&stats).Error
+}
+
+// BackfillDailyStats aggregates the days from before daily stats were introduced,
+// days that already have stats are left untouched. Active users of past days
+// aren't known, they stay at zero.
+func BackfillDailyStats(db *gorm.DB) error {
+	return db.Exec(`
+		INSERT INTO daily_stats (day, signups, calls, invitations_sent, invitations_accepted, created_at, updated_at)
+		SELECT day, SUM(signups), SUM(calls), SUM(sent), SUM(accepted), NOW(), NOW()
+		FROM (
+			SELECT DATE(created_at AT TIME ZONE 'UTC') AS day, 1 AS signups, 0 AS calls, 0 AS sent, 0 AS accepted
+			FROM users
+			UNION ALL
+			SELECT DATE(started_at AT TIME ZONE 'UTC') AS day, 0, 1, 0, 0
+			FROM calls WHERE deleted_at IS NULL
+			UNION ALL
+			SELECT DATE(sent_at AT TIME ZONE 'UTC') AS day, 0, 0, 1, CASE WHEN status = 'accepted' THEN 1 ELSE 0 END
+			FROM email_invitations WHERE deleted_at IS NULL
+		) AS events
+		GROUP BY day
+		ON CONFLICT DO NOTHING`).Error
+}
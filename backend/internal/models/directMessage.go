@@ -0,0 +1,124 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// DirectMessageState is how far a direct message got on its way to the recipient
+type DirectMessageState string
+
+const (
+	// DirectMessageSent is stored, the recipient's app hasn't received it yet
+	DirectMessageSent DirectMessageState = "sent"
+	// DirectMessageDelivered reached an app of the recipient
+	DirectMessageDelivered DirectMessageState = "delivered"
+	// DirectMessageRead was seen by the recipient
+	DirectMessageRead DirectMessageState = "read"
+)
+
+// DirectMessage is a text message between two teammates
+type DirectMessage struct {
+	gorm.Model
+	SenderID    string `gorm:"not null;index:idx_direct_messages_conversation" json:"sender_id"`
+	RecipientID string `gorm:"not null;index:idx_direct_messages_conversation" json:"recipient_id"`
+	Body        string `gorm:"not null" json:"body"`
+	// When an app of the recipient received the message, nil until then
+	DeliveredAt *time.Time `json:"delivered_at"`
+	// When the recipient read the message, nil while it is unread
+	ReadAt *time.Time `json:"read_at"`
+	// Derived from DeliveredAt and ReadAt when the message is loaded
+	State DirectMessageState `gorm:"-" json:"state"`
+	// Filled by the handlers from the DirectMessageReaction table
+	Reactions []ReactionCount `gorm:"-" json:"reactions"`
+}
+
+// AfterFind derives the state of the loaded message
+func (m *DirectMessage) AfterFind(tx *gorm.DB) error {
+	switch {
+	case m.ReadAt != nil:
+		m.State = DirectMessageRead
+	case m.DeliveredAt != nil:
+		m.State = DirectMessageDelivered
+	default:
+		m.State = DirectMessageSent
+	}
+
+	return nil
+}
+
+// IsParticipant checks if the user sent or received the message
+func (m *DirectMessage) IsParticipant(userID string) bool {
+	return m.SenderID == userID || m.RecipientID == userID
+}
+
+// OtherParticipant returns the user on the other side of the message from the user
+func (m *DirectMessage) OtherParticipant(userID string) string {
+	if m.SenderID == userID {
+		return m.RecipientID
+	}
+
+	return m.SenderID
+}
+
+// CreateDirectMessage stores a message from the sender to the recipient
+func CreateDirectMessage(db *gorm.DB, senderID, recipientID, body string) (*DirectMessage, error) {
+	message := DirectMessage{
+		SenderID:    senderID,
+		RecipientID: recipientID,
+		Body:        body,
+	}
+	if err := db.Create(&message).Error; err != nil {
+		return nil, err
+	}
+	message.State = DirectMessageSent
+
+	return &message, nil
+}
+
+// ConversationQuery returns the query of the messages between the two users, in either direction
+func ConversationQuery(db *gorm.DB, userID, otherID string) *gorm.DB {
+	return db.Model(&DirectMessage{}).
+		Where("(sender_id = ? AND recipient_id = ?) OR (sender_id = ? AND recipient_id = ?)", userID, otherID, otherID, userID)
+}
+
+// MarkConversationRead marks the messages the other user sent to the user as read,
+// and returns the ones that weren't already
+func MarkConversationRead(db *gorm.DB, userID, otherID string) ([]DirectMessage, error) {
+	return markDirectMessagesRead(db.Where("sender_id = ? AND recipient_id = ? AND read_at IS NULL", otherID, userID))
+}
+
+// MarkDirectMessagesDelivered marks the messages sent to the recipient as delivered,
+// and returns the ones that weren't already
+func MarkDirectMessagesDelivered(db *gorm.DB, recipientID string, messageIDs []uint) ([]DirectMessage, error) {
+	var updated []DirectMessage
+	err := db.Model(&updated).
+		Clauses(clause.Returning{}).
+		Where("id IN ? AND recipient_id = ? AND delivered_at IS NULL", messageIDs, recipientID).
+		Update("delivered_at", time.Now()).Error
+
+	return updated, err
+}
+
+// MarkDirectMessagesRead marks the messages sent to the recipient as read,
+// and returns the ones that weren't already
+func MarkDirectMessagesRead(db *gorm.DB, recipientID string, messageIDs []uint) ([]DirectMessage, error) {
+	return markDirectMessagesRead(db.Where("id IN ? AND recipient_id = ? AND read_at IS NULL", messageIDs, recipientID))
+}
+
+// markDirectMessagesRead marks the messages of the query as read, read messages were delivered too
+func markDirectMessagesRead(query *gorm.DB) ([]DirectMessage, error) {
+	now := time.Now()
+
+	var updated []DirectMessage
+	err := query.Model(&updated).
+		Clauses(clause.Returning{}).
+		Updates(map[string]interface{}{
+			"read_at":      now,
+			"delivered_at": gorm.Expr("COALESCE(delivered_at, ?)", now),
+		}).Error
+
+	return updated, err
+}
@@ -0,0 +1,81 @@
+package models
+
+import (
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// DirectMessageReaction is an emoji a user reacted to a direct message with
+type DirectMessageReaction struct {
+	gorm.Model
+	DirectMessageID uint   `gorm:"not null;uniqueIndex:idx_direct_message_reaction" json:"direct_message_id"`
+	UserID          string `gorm:"not null;uniqueIndex:idx_direct_message_reaction" json:"user_id"`
+	Emoji           string `gorm:"not null;uniqueIndex:idx_direct_message_reaction" json:"emoji"`
+}
+
+// ReactionCount is how many users reacted to a message with an emoji
+type ReactionCount struct {
+	Emoji   string   `json:"emoji"`
+	Count   int      `json:"count"`
+	UserIDs []string `json:"user_ids"`
+}
+
+// AddDirectMessageReaction reacts to the message with the emoji, it is a no-op
+// if the user already did
+func AddDirectMessageReaction(db *gorm.DB, messageID uint, userID, emoji string) error {
+	reaction := DirectMessageReaction{
+		DirectMessageID: messageID,
+		UserID:          userID,
+		Emoji:           emoji,
+	}
+
+	return db.Clauses(clause.OnConflict{DoNothing: true}).Create(&reaction).Error
+}
+
+// RemoveDirectMessageReaction removes the reaction of the user with the emoji from the message.
+// Reactions are deleted for good, so the user can react with the same emoji again.
+func RemoveDirectMessageReaction(db *gorm.DB, messageID uint, userID, emoji string) error {
+	return db.Unscoped().
+		Where("direct_message_id = ? AND user_id = ? AND emoji = ?", messageID, userID, emoji).
+		Delete(&DirectMessageReaction{}).Error
+}
+
+// GetReactionCounts returns the reactions of the messages grouped by emoji,
+// in the order each emoji was first used
+func GetReactionCounts(db *gorm.DB, messageIDs []uint) (map[uint][]ReactionCount, error) {
+	counts := make(map[uint][]ReactionCount, len(messageIDs))
+	if len(messageIDs) == 0 {
+		return counts, nil
+	}
+
+	var reactions []DirectMessageReaction
+	err := db.Where("direct_message_id IN ?", messageIDs).
+		Order("created_at ASC").
+		Find(&reactions).Error
+	if err != nil {
+		return nil, err
+	}
+
+	for _, reaction := range reactions {
+		messageCounts := counts[reaction.DirectMessageID]
+		found := false
+		for i := range messageCounts {
+			if messageCounts[i].Emoji == reaction.Emoji {
+				messageCounts[i].Count++
+				messageCounts[i].UserIDs = append(messageCounts[i].UserIDs, reaction.UserID)
+				found = true
+				break
+			}
+		}
+		if !found {
+			messageCounts = append(messageCounts, ReactionCount{
+				Emoji:   reaction.Emoji,
+				Count:   1,
+				UserIDs: []string{reaction.UserID},
+			})
+		}
+		counts[reaction.DirectMessageID] = messageCounts
+	}
+
+	return counts, nil
+}
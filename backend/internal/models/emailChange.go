@@ -0,0 +1,27 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// EmailChange is a pending request to change a user's email.
+// The change needs to be confirmed from both the old and the new
+// address before User.Email is swapped.
+type EmailChange struct {
+	gorm.Model
+	UserID              string     `gorm:"not null;index" json:"user_id"`
+	User                User       `gorm:"foreignKey:UserID;references:ID" json:"-"`
+	NewEmail            string     `gorm:"not null" json:"new_email"`
+	OldEmailToken       string     `gorm:"not null;uniqueIndex" json:"-"` // Hashed
+	NewEmailToken       string     `gorm:"not null;uniqueIndex" json:"-"` // Hashed
+	OldEmailConfirmedAt *time.Time `json:"old_email_confirmed_at"`
+	NewEmailConfirmedAt *time.Time `json:"new_email_confirmed_at"`
+	ExpiresAt           time.Time  `json:"expires_at"`
+}
+
+// IsConfirmed returns true once both addresses have confirmed the change
+func (e *EmailChange) IsConfirmed() bool {
+	return e.OldEmailConfirmedAt != nil && e.NewEmailConfirmedAt != nil
+}
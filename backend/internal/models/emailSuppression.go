@@ -0,0 +1,111 @@
+package models
+
+import (
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type EmailSuppressionReason string
+
+const (
+	// The address hard-bounced, it doesn't exist or doesn't accept email
+	EmailSuppressionBounced EmailSuppressionReason = "bounced"
+	// The recipient marked an email as spam
+	EmailSuppressionComplained EmailSuppressionReason = "complained"
+	// The recipient unsubscribed from a category of emails
+	EmailSuppressionUnsubscribed EmailSuppressionReason = "unsubscribed"
+)
+
+// EmailCategory groups the emails users can unsubscribe from
+type EmailCategory string
+
+const (
+	// Emails about the account and its security, and invitations. They can't be
+	// unsubscribed from, only bounces and complaints stop them.
+	EmailCategoryEssential EmailCategory = ""
+	// The welcome email and news about Hopp
+	EmailCategoryProduct EmailCategory = "product"
+	// Calls the user missed while offline
+	EmailCategoryMissedCalls EmailCategory = "missed_calls"
+	// Users asking to join a team the user is an admin of
+	EmailCategoryJoinRequests EmailCategory = "join_requests"
+)
+
+// EmailCategories are the categories users can unsubscribe from
+var EmailCategories = []EmailCategory{
+	EmailCategoryProduct,
+	EmailCategoryMissedCalls,
+	EmailCategoryJoinRequests,
+}
+
+// IsValidEmailCategory checks if users can unsubscribe from the category
+func IsValidEmailCategory(category EmailCategory) bool {
+	for _, valid := range EmailCategories {
+		if category == valid {
+			return true
+		}
+	}
+
+	return false
+}
+
+// EmailSuppression stops the emails of a category to an address, or all of them
+// when the category is empty
+type EmailSuppression struct {
+	gorm.Model
+	Email    string                 `gorm:"not null;uniqueIndex:idx_email_suppressions_email_category" json:"email"`
+	Category EmailCategory          `gorm:"not null;default:'';uniqueIndex:idx_email_suppressions_email_category" json:"category"`
+	Reason   EmailSuppressionReason `gorm:"not null" json:"reason"`
+}
+
+// SuppressEmail stops all the emails to the address, it is a no-op if they already are
+func SuppressEmail(db *gorm.DB, email string, reason EmailSuppressionReason) error {
+	suppression := EmailSuppression{
+		Email:  strings.ToLower(email),
+		Reason: reason,
+	}
+
+	return db.Clauses(clause.OnConflict{DoNothing: true}).Create(&suppression).Error
+}
+
+// UnsubscribeEmail stops the emails of the category to the address
+func UnsubscribeEmail(db *gorm.DB, email string, category EmailCategory) error {
+	suppression := EmailSuppression{
+		Email:    strings.ToLower(email),
+		Category: category,
+		Reason:   EmailSuppressionUnsubscribed,
+	}
+
+	return db.Clauses(clause.OnConflict{DoNothing: true}).Create(&suppression).Error
+}
+
+// ResubscribeEmail sends the emails of the category to the address again.
+// Addresses suppressed for bounces or complaints stay suppressed.
+func ResubscribeEmail(db *gorm.DB, email string, category EmailCategory) error {
+	return db.Unscoped().
+		Where("email = ? AND category = ? AND reason = ?", strings.ToLower(email), category, EmailSuppressionUnsubscribed).
+		Delete(&EmailSuppression{}).Error
+}
+
+// GetUnsubscribedCategories returns the categories the address unsubscribed from
+func GetUnsubscribedCategories(db *gorm.DB, email string) ([]EmailCategory, error) {
+	var categories []EmailCategory
+	err := db.Model(&EmailSuppression{}).
+		Where("email = ? AND reason = ?", strings.ToLower(email), EmailSuppressionUnsubscribed).
+		Pluck("category", &categories).Error
+
+	return categories, err
+}
+
+// IsEmailSuppressed checks if emails of the category to the address are suppressed,
+// either all the emails to it or the ones of the category
+func IsEmailSuppressed(db *gorm.DB, email string, category EmailCategory) (bool, error) {
+	var count int64
+	err := db.Model(&EmailSuppression{}).
+		Where("email = ? AND category IN ?", strings.ToLower(email), []EmailCategory{EmailCategoryEssential, category}).
+		Count(&count).Error
+
+	return count > 0, err
+}
@@ -0,0 +1,36 @@
+package models
+
+import (
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// FeatureFlag turns a feature of the whole instance on or off without a deploy.
+// Flags that were never set are off.
+type FeatureFlag struct {
+	gorm.Model
+	Name    string `gorm:"not null;uniqueIndex" json:"name"`
+	Enabled bool   `gorm:"not null;default:false" json:"enabled"`
+}
+
+// IsFeatureEnabled checks if the feature flag is on
+func IsFeatureEnabled(db *gorm.DB, name string) bool {
+	var count int64
+	db.Model(&FeatureFlag{}).Where("name = ? AND enabled", name).Count(&count)
+	return count > 0
+}
+
+// SetFeatureFlag turns the feature flag on or off, creating it if needed
+func SetFeatureFlag(db *gorm.DB, name string, enabled bool) (*FeatureFlag, error) {
+	flag := FeatureFlag{Name: name, Enabled: enabled}
+
+	err := db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "name"}},
+		DoUpdates: clause.AssignmentColumns([]string{"enabled", "updated_at"}),
+	}).Create(&flag).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &flag, nil
+}
@@ -1,11 +1,29 @@
 package models
 
 import (
+	"errors"
 	"time"
 
 	"gorm.io/gorm"
 )
 
+// EmailInvitationTTL is how long the link of an invitation email stays valid
+const EmailInvitationTTL = 7 * 24 * time.Hour
+
+type EmailInvitationStatus string
+
+const (
+	EmailInvitationPending  EmailInvitationStatus = "pending"
+	EmailInvitationAccepted EmailInvitationStatus = "accepted"
+	EmailInvitationExpired  EmailInvitationStatus = "expired"
+)
+
+// Roles the invited user gets when joining the team
+const (
+	TeamRoleMember = "member"
+	TeamRoleAdmin  = "admin"
+)
+
 // EmailInvitation represents an email invitation sent to join a team
 type EmailInvitation struct {
 	gorm.Model
@@ -14,6 +32,128 @@ type EmailInvitation struct {
 	Email  string    `json:"email" gorm:"index"`
 	SentAt time.Time `json:"sent_at"`
 	SentBy string    `json:"sent_by"` // User ID who sent the invitation
+	// Token is the hashed secret of the invitation link, so the invitation
+	// is tied to the recipient instead of the shared team invitation UUID
+	Token      *string               `gorm:"uniqueIndex" json:"-"`
+	Status     EmailInvitationStatus `gorm:"not null;default:pending;index" json:"status"`
+	Role       string                `gorm:"not null;default:member" json:"role"`
+	AcceptedAt *time.Time            `json:"accepted_at"`
+	AcceptedBy *string               `json:"accepted_by"` // User ID who joined with the invitation
+	// Queued email of the invitation, and its delivery state once Resend reports it
+	OutboundEmailID *uint               `gorm:"index" json:"-"`
+	DeliveryStatus  EmailDeliveryStatus `json:"delivery_status,omitempty"`
+}
+
+// NewEmailInvitation creates a pending invitation for the email and
+// returns it along with the plain token to put in the invitation link
+func NewEmailInvitation(db *gorm.DB, teamID int, email, sentBy, role string) (*EmailInvitation, string, error) {
+	token, hashedToken := NewSecretToken()
+
+	invitation := EmailInvitation{
+		TeamID: teamID,
+		Email:  email,
+		SentAt: time.Now(),
+		SentBy: sentBy,
+		Token:  &hashedToken,
+		Status: EmailInvitationPending,
+		Role:   role,
+	}
+	if err := db.Create(&invitation).Error; err != nil {
+		return nil, "", err
+	}
+
+	return &invitation, token, nil
+}
+
+// IsExpired checks if the invitation link is older than EmailInvitationTTL
+func (i *EmailInvitation) IsExpired() bool {
+	return time.Since(i.SentAt) > EmailInvitationTTL
+}
+
+// GetPendingEmailInvitation returns the pending invitation of the link token,
+// or ErrInvitationExpired if it can no longer be used
+func GetPendingEmailInvitation(db *gorm.DB, token string) (*EmailInvitation, error) {
+	var invitation EmailInvitation
+	if err := db.Preload("Team").Where("token = ?", HashToken(token)).First(&invitation).Error; err != nil {
+		return nil, err
+	}
+
+	if invitation.Status == EmailInvitationPending && invitation.IsExpired() {
+		db.Model(&invitation).Update("status", EmailInvitationExpired)
+		invitation.Status = EmailInvitationExpired
+	}
+
+	if invitation.Status != EmailInvitationPending {
+		return nil, ErrInvitationExpired
+	}
+
+	return &invitation, nil
+}
+
+// HasPendingEmailInvitation checks if the email was invited to any team
+func HasPendingEmailInvitation(db *gorm.DB, email string) bool {
+	var count int64
+	db.Model(&EmailInvitation{}).
+		Where("email = ? AND status = ? AND sent_at > ?", email, EmailInvitationPending, time.Now().Add(-EmailInvitationTTL)).
+		Count(&count)
+	return count > 0
+}
+
+// AcceptEmailInvitations marks the pending invitations of the user to the team as
+// accepted, whichever invitation they joined with, and grants the invited role in
+// that team. The user needs to be a member of the team already.
+func AcceptEmailInvitations(db *gorm.DB, user *User, teamID uint) error {
+	var invitations []EmailInvitation
+	if err := db.Where("email = ? AND team_id = ? AND status = ?", user.Email, teamID, EmailInvitationPending).
+		Find(&invitations).Error; err != nil {
+		return err
+	}
+	if len(invitations) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	isAdmin := false
+	for _, invitation := range invitations {
+		isAdmin = isAdmin || invitation.Role == TeamRoleAdmin
+	}
+
+	err := db.Model(&EmailInvitation{}).
+		Where("email = ? AND team_id = ? AND status = ?", user.Email, teamID, EmailInvitationPending).
+		Updates(map[string]interface{}{
+			"status":      EmailInvitationAccepted,
+			"accepted_at": now,
+			"accepted_by": user.ID,
+		}).Error
+	if err != nil {
+		return err
+	}
+
+	if isAdmin {
+		return SetTeamAdmin(db, user.ID, teamID, true)
+	}
+
+	return nil
+}
+
+// ResolveInvitation finds the team of an invitation code, which is either the
+// shared team invitation UUID or the token of an invitation email.
+// The email invitation is nil for team invitation UUIDs.
+func ResolveInvitation(db *gorm.DB, code string) (*Team, *EmailInvitation, error) {
+	teamInvitation, err := GetValidTeamInvitation(db, code)
+	if err == nil {
+		return &teamInvitation.Team, nil, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil, err
+	}
+
+	emailInvitation, err := GetPendingEmailInvitation(db, code)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &emailInvitation.Team, emailInvitation, nil
 }
 
 // CanSendInvite checks if an invite can be sent to this email
@@ -0,0 +1,41 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type JoinRequestStatus string
+
+const (
+	JoinRequestPending  JoinRequestStatus = "pending"
+	JoinRequestApproved JoinRequestStatus = "approved"
+	JoinRequestRejected JoinRequestStatus = "rejected"
+)
+
+// JoinRequest is a request of a user to join a team they discovered,
+// team admins need to approve it before the user becomes a member
+type JoinRequest struct {
+	gorm.Model
+	UserID     string            `gorm:"not null;index" json:"user_id"`
+	User       User              `gorm:"foreignKey:UserID;references:ID" json:"user"`
+	TeamID     uint              `gorm:"not null;index" json:"team_id"`
+	Team       Team              `json:"-"`
+	Status     JoinRequestStatus `gorm:"not null;default:pending;index" json:"status"`
+	ReviewedBy *string           `json:"reviewed_by"` // User ID of the admin who reviewed the request
+	ReviewedAt *time.Time        `json:"reviewed_at"`
+}
+
+// GetTeamAdmins returns the admins of the team
+func GetTeamAdmins(db *gorm.DB, teamID uint) ([]User, error) {
+	var admins []User
+	err := db.Joins("JOIN team_memberships ON team_memberships.user_id = users.id AND team_memberships.deleted_at IS NULL").
+		Where("team_memberships.team_id = ? AND team_memberships.is_admin", teamID).
+		Find(&admins).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return admins, nil
+}
@@ -0,0 +1,65 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type MissedCallReason string
+
+const (
+	// The callee wasn't connected when they were called
+	MissedCallCalleeOffline MissedCallReason = "offline"
+	// The callee didn't answer before the ring timed out or the caller hung up
+	MissedCallNoAnswer MissedCallReason = "no_answer"
+)
+
+// MissedCall is a call the callee didn't pick up, kept until the callee clears it
+type MissedCall struct {
+	gorm.Model
+	CallerID string           `gorm:"not null;index" json:"caller_id"`
+	Caller   *User            `gorm:"foreignKey:CallerID;references:ID" json:"caller,omitempty"`
+	CalleeID string           `gorm:"not null;index" json:"callee_id"`
+	Reason   MissedCallReason `gorm:"not null" json:"reason"`
+	// When the callee's app was told about the missed call
+	NotifiedAt *time.Time `json:"notified_at"`
+}
+
+// RecordMissedCall stores a call the callee missed
+func RecordMissedCall(db *gorm.DB, callerID, calleeID string, reason MissedCallReason) (*MissedCall, error) {
+	missedCall := MissedCall{
+		CallerID: callerID,
+		CalleeID: calleeID,
+		Reason:   reason,
+	}
+
+	if err := db.Create(&missedCall).Error; err != nil {
+		return nil, err
+	}
+
+	return &missedCall, nil
+}
+
+// GetUnnotifiedMissedCalls returns the missed calls the callee's app wasn't told about yet
+func GetUnnotifiedMissedCalls(db *gorm.DB, calleeID string) ([]MissedCall, error) {
+	var missedCalls []MissedCall
+	err := db.Preload("Caller").
+		Where("callee_id = ? AND notified_at IS NULL", calleeID).
+		Order("created_at").
+		Find(&missedCalls).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return missedCalls, nil
+}
+
+// MarkMissedCallsNotified marks the missed calls as delivered to the callee's app
+func MarkMissedCallsNotified(db *gorm.DB, ids []uint) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	return db.Model(&MissedCall{}).Where("id IN ?", ids).Update("notified_at", time.Now()).Error
+}
@@ -0,0 +1,174 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type OutboundEmailStatus string
+
+const (
+	// The email waits for its first, or next, attempt
+	OutboundEmailPending OutboundEmailStatus = "pending"
+	// Resend accepted the email
+	OutboundEmailSent OutboundEmailStatus = "sent"
+	// Every attempt failed, the email is kept for inspection and isn't retried
+	OutboundEmailDead OutboundEmailStatus = "dead"
+)
+
+type EmailDeliveryStatus string
+
+// Delivery states Resend reports after it accepted an email
+const (
+	EmailDelivered       EmailDeliveryStatus = "delivered"
+	EmailDeliveryDelayed EmailDeliveryStatus = "delayed"
+	EmailBounced         EmailDeliveryStatus = "bounced"
+	EmailComplained      EmailDeliveryStatus = "complained"
+)
+
+// OutboundEmail is an email in the outbound queue, kept after it is sent
+type OutboundEmail struct {
+	gorm.Model
+	ToEmail  string              `gorm:"not null;index" json:"to_email"`
+	Subject  string              `gorm:"not null" json:"subject"`
+	HTML     string              `gorm:"not null" json:"-"`
+	Text     string              `json:"-"`
+	Status   OutboundEmailStatus `gorm:"not null;index:idx_outbound_emails_due" json:"status"`
+	Attempts int                 `gorm:"not null;default:0" json:"attempts"`
+	// When the email is tried next, while it is pending
+	NextAttemptAt time.Time  `gorm:"not null;index:idx_outbound_emails_due" json:"next_attempt_at"`
+	LastError     string     `json:"last_error,omitempty"`
+	SentAt        *time.Time `json:"sent_at"`
+	// ID of the email at Resend, once it is sent
+	ProviderID string `gorm:"index" json:"provider_id,omitempty"`
+	// Latest delivery state Resend reported, empty until it reports one
+	DeliveryStatus   EmailDeliveryStatus `json:"delivery_status,omitempty"`
+	DeliveryStatusAt *time.Time          `json:"delivery_status_at"`
+	// One-click unsubscribe link of the email, sent in its List-Unsubscribe header
+	UnsubscribeURL string `json:"-"`
+}
+
+// EnqueueEmail adds an email to the outbound queue, to be sent right away
+func EnqueueEmail(db *gorm.DB, toEmail, subject, html, text, unsubscribeURL string) (*OutboundEmail, error) {
+	outboundEmail := OutboundEmail{
+		ToEmail:        toEmail,
+		Subject:        subject,
+		HTML:           html,
+		Text:           text,
+		UnsubscribeURL: unsubscribeURL,
+		Status:         OutboundEmailPending,
+		NextAttemptAt:  time.Now(),
+	}
+	if err := db.Create(&outboundEmail).Error; err != nil {
+		return nil, err
+	}
+
+	return &outboundEmail, nil
+}
+
+// ClaimDueEmails returns up to limit pending emails that are due, and pushes their next
+// attempt back by the lease so other replicas don't send them at the same time.
+// An email whose sender dies mid-send is tried again once the lease runs out.
+func ClaimDueEmails(db *gorm.DB, limit int, lease time.Duration) ([]OutboundEmail, error) {
+	var due []OutboundEmail
+	err := db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ? AND next_attempt_at <= ?", OutboundEmailPending, time.Now()).
+			Order("next_attempt_at").
+			Limit(limit).
+			Find(&due).Error
+		if err != nil || len(due) == 0 {
+			return err
+		}
+
+		ids := make([]uint, len(due))
+		for i := range due {
+			ids[i] = due[i].ID
+		}
+
+		return tx.Model(&OutboundEmail{}).Where("id IN ?", ids).
+			Update("next_attempt_at", time.Now().Add(lease)).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return due, nil
+}
+
+// MarkEmailSent records that Resend accepted the email
+func MarkEmailSent(db *gorm.DB, outboundEmail *OutboundEmail, providerID string) error {
+	now := time.Now()
+	return db.Model(outboundEmail).Updates(map[string]interface{}{
+		"status":      OutboundEmailSent,
+		"attempts":    outboundEmail.Attempts + 1,
+		"sent_at":     &now,
+		"provider_id": providerID,
+		"last_error":  "",
+	}).Error
+}
+
+// MarkEmailFailed records a failed attempt, the email is tried again at retryAt
+// or dead-lettered when retryAt is nil
+func MarkEmailFailed(db *gorm.DB, outboundEmail *OutboundEmail, sendErr error, retryAt *time.Time) error {
+	updates := map[string]interface{}{
+		"attempts":   outboundEmail.Attempts + 1,
+		"last_error": sendErr.Error(),
+	}
+	if retryAt != nil {
+		updates["next_attempt_at"] = *retryAt
+	} else {
+		updates["status"] = OutboundEmailDead
+	}
+
+	return db.Model(outboundEmail).Updates(updates).Error
+}
+
+// CountDeadEmailsSince counts the emails dead-lettered after the time
+func CountDeadEmailsSince(db *gorm.DB, since time.Time) (int64, error) {
+	var count int64
+	err := db.Model(&OutboundEmail{}).
+		Where("status = ? AND updated_at > ?", OutboundEmailDead, since).
+		Count(&count).Error
+
+	return count, err
+}
+
+// UpdateEmailDeliveryStatus records the delivery state Resend reported for the email of
+// the provider ID, and returns the email. Emails that aren't in the queue return nil.
+func UpdateEmailDeliveryStatus(db *gorm.DB, providerID string, status EmailDeliveryStatus, at time.Time) (*OutboundEmail, error) {
+	var outboundEmail OutboundEmail
+	err := db.Where("provider_id = ?", providerID).First(&outboundEmail).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Events can arrive out of order, a bounce or a complaint isn't undone by a late delay
+	if outboundEmail.DeliveryStatusAt != nil && outboundEmail.DeliveryStatusAt.After(at) {
+		return &outboundEmail, nil
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&outboundEmail).Updates(map[string]interface{}{
+			"delivery_status":    status,
+			"delivery_status_at": at,
+		}).Error; err != nil {
+			return err
+		}
+
+		return tx.Model(&EmailInvitation{}).
+			Where("outbound_email_id = ?", outboundEmail.ID).
+			Update("delivery_status", status).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &outboundEmail, nil
+}
@@ -2,13 +2,22 @@ package models
 
 import (
 	"errors"
+	"time"
 
+	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
 
+// TeamInvitationTTL is how long a team invitation link stays valid
+const TeamInvitationTTL = 48 * time.Hour
+
+// ErrInvitationExpired is returned for team invitation links older than TeamInvitationTTL
+var ErrInvitationExpired = errors.New("invitation has expired")
+
 type Team struct {
 	gorm.Model
-	Name string `gorm:"not null" json:"name" validate:"required"`
+	Name    string `gorm:"not null" json:"name" validate:"required"`
+	LogoURL string `json:"logo_url"`
 }
 
 func GetTeamByID(db *gorm.DB, id string) (*Team, error) {
@@ -25,7 +34,7 @@ func GetTeamByID(db *gorm.DB, id string) (*Team, error) {
 }
 
 // TeamInvitation is a misc model to store team invitation URLs
-// It will have an expiry date from its creation date of 2 days.
+// It will have an expiry date from its creation date of 2 days (TeamInvitationTTL).
 // This is to prevent abuse of the invitation system.
 type TeamInvitation struct {
 	gorm.Model
@@ -33,3 +42,70 @@ type TeamInvitation struct {
 	Team     Team
 	UniqueID string `gorm:"not null" json:"unique_id" validate:"required"`
 }
+
+// IsExpired checks if the invitation is older than TeamInvitationTTL
+func (i *TeamInvitation) IsExpired() bool {
+	return time.Since(i.CreatedAt) > TeamInvitationTTL
+}
+
+// GetValidTeamInvitation returns the invitation with the given UUID,
+// or ErrInvitationExpired if it can no longer be used
+func GetValidTeamInvitation(db *gorm.DB, uniqueID string) (*TeamInvitation, error) {
+	var invitation TeamInvitation
+	if err := db.Where("unique_id = ?", uniqueID).Preload("Team").First(&invitation).Error; err != nil {
+		return nil, err
+	}
+
+	if invitation.IsExpired() {
+		return nil, ErrInvitationExpired
+	}
+
+	return &invitation, nil
+}
+
+// GetOrCreateTeamInvitation returns the current invitation of the team,
+// replacing it with a fresh one if it has expired
+func GetOrCreateTeamInvitation(db *gorm.DB, teamID int) (*TeamInvitation, error) {
+	var invitation TeamInvitation
+	result := db.Where("team_id = ?", teamID).Order("created_at DESC").First(&invitation)
+	if result.Error == nil && !invitation.IsExpired() {
+		return &invitation, nil
+	}
+	if result.Error != nil && !errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return nil, result.Error
+	}
+
+	return RegenerateTeamInvitation(db, teamID)
+}
+
+// RegenerateTeamInvitation revokes the current invitation link
+// of the team and creates a new one
+func RegenerateTeamInvitation(db *gorm.DB, teamID int) (*TeamInvitation, error) {
+	// Using uuid v7 to be indexable with B-tree
+	inviteUUID, err := uuid.NewV7()
+	if err != nil {
+		return nil, err
+	}
+
+	invitation := TeamInvitation{
+		TeamID:   teamID,
+		UniqueID: inviteUUID.String(),
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := RevokeTeamInvitations(tx, teamID); err != nil {
+			return err
+		}
+		return tx.Create(&invitation).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &invitation, nil
+}
+
+// RevokeTeamInvitations invalidates all the invitation links of the team
+func RevokeTeamInvitations(db *gorm.DB, teamID int) error {
+	return db.Where("team_id = ?", teamID).Delete(&TeamInvitation{}).Error
+}
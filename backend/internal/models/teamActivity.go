@@ -0,0 +1,38 @@
+package models
+
+import (
+	"gorm.io/gorm"
+)
+
+type TeamActivityType string
+
+const (
+	TeamActivityMemberJoined TeamActivityType = "member_joined"
+	TeamActivityCall         TeamActivityType = "call"
+	TeamActivityInviteSent   TeamActivityType = "invite_sent"
+)
+
+// TeamActivity is a notable event of a team, shown in the app's activity feed
+type TeamActivity struct {
+	gorm.Model
+	TeamID uint             `gorm:"not null;index" json:"team_id"`
+	Type   TeamActivityType `gorm:"not null" json:"type"`
+	// User that triggered the event
+	ActorID  *string                `gorm:"index" json:"actor_id"`
+	Actor    *User                  `gorm:"foreignKey:ActorID;references:ID" json:"actor,omitempty"`
+	Metadata map[string]interface{} `gorm:"serializer:json" json:"metadata"`
+}
+
+// RecordTeamActivity adds an event to the team's activity feed
+func RecordTeamActivity(db *gorm.DB, teamID uint, activityType TeamActivityType, actorID string, metadata map[string]interface{}) error {
+	activity := TeamActivity{
+		TeamID:   teamID,
+		Type:     activityType,
+		Metadata: metadata,
+	}
+	if actorID != "" {
+		activity.ActorID = &actorID
+	}
+
+	return db.Create(&activity).Error
+}
@@ -0,0 +1,28 @@
+package models
+
+import (
+	"gorm.io/gorm"
+)
+
+// TeamChatMessage is a message of the team-wide chat
+type TeamChatMessage struct {
+	gorm.Model
+	TeamID   uint   `gorm:"not null;index" json:"team_id"`
+	SenderID string `gorm:"not null" json:"sender_id"`
+	Sender   *User  `gorm:"foreignKey:SenderID;references:ID" json:"sender,omitempty"`
+	Body     string `gorm:"not null" json:"body"`
+}
+
+// CreateTeamChatMessage stores a message of the sender in the chat of the team
+func CreateTeamChatMessage(db *gorm.DB, teamID uint, senderID, body string) (*TeamChatMessage, error) {
+	message := TeamChatMessage{
+		TeamID:   teamID,
+		SenderID: senderID,
+		Body:     body,
+	}
+	if err := db.Create(&message).Error; err != nil {
+		return nil, err
+	}
+
+	return &message, nil
+}
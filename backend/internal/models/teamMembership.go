@@ -0,0 +1,106 @@
+package models
+
+import (
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// TeamMembership links a user to every team they are part of.
+// User.TeamID is the active team, the one the app currently shows.
+type TeamMembership struct {
+	gorm.Model
+	UserID string `gorm:"not null;uniqueIndex:idx_user_team" json:"user_id"`
+	User   User   `gorm:"foreignKey:UserID;references:ID" json:"-"`
+	TeamID uint   `gorm:"not null;uniqueIndex:idx_user_team;index" json:"team_id"`
+	Team   Team   `json:"team"`
+	// Admins manage this team only, a user can be an admin of one team and a member of another
+	IsAdmin bool `gorm:"not null;default:false" json:"is_admin"`
+}
+
+// AddTeamMembership makes the user a member of the team, it is a no-op
+// if they already are
+func AddTeamMembership(db *gorm.DB, userID string, teamID uint) error {
+	membership := TeamMembership{
+		UserID: userID,
+		TeamID: teamID,
+	}
+
+	result := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&membership)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return nil
+	}
+
+	return RecordTeamActivity(db, teamID, TeamActivityMemberJoined, userID, nil)
+}
+
+// SetTeamAdmin grants or removes the admin role of the user in the team
+func SetTeamAdmin(db *gorm.DB, userID string, teamID uint, isAdmin bool) error {
+	return db.Model(&TeamMembership{}).
+		Where("user_id = ? AND team_id = ?", userID, teamID).
+		Update("is_admin", isAdmin).Error
+}
+
+// WithActiveTeamRole loads the users along with their role in their active team,
+// which User.IsAdmin is read from
+func WithActiveTeamRole(db *gorm.DB) *gorm.DB {
+	return db.Select("users.*, COALESCE(team_memberships.is_admin, FALSE) AS is_admin").
+		Joins("LEFT JOIN team_memberships ON team_memberships.user_id = users.id " +
+			"AND team_memberships.team_id = users.team_id AND team_memberships.deleted_at IS NULL")
+}
+
+// IsTeamMember checks if the user is a member of the team
+func IsTeamMember(db *gorm.DB, userID string, teamID uint) bool {
+	var count int64
+	db.Model(&TeamMembership{}).Where("user_id = ? AND team_id = ?", userID, teamID).Count(&count)
+	return count > 0
+}
+
+// AreTeammates checks if the two users are members of a same team
+func AreTeammates(db *gorm.DB, userID, otherID string) bool {
+	var count int64
+	db.Model(&TeamMembership{}).
+		Where("user_id = ? AND team_id IN (?)", otherID,
+			db.Model(&TeamMembership{}).Select("team_id").Where("user_id = ?", userID)).
+		Count(&count)
+	return count > 0
+}
+
+// GetTeamMemberIDs returns the IDs of the members of the team
+func GetTeamMemberIDs(db *gorm.DB, teamID uint) ([]string, error) {
+	var userIDs []string
+	err := db.Model(&TeamMembership{}).Where("team_id = ?", teamID).Pluck("user_id", &userIDs).Error
+	return userIDs, err
+}
+
+// BackfillTeamMemberships creates the memberships of users that joined
+// their team before memberships were introduced
+func BackfillTeamMemberships(db *gorm.DB) error {
+	return db.Exec(`
+		INSERT INTO team_memberships (user_id, team_id, created_at, updated_at)
+		SELECT id, team_id, NOW(), NOW() FROM users WHERE team_id IS NOT NULL
+		ON CONFLICT DO NOTHING`).Error
+}
+
+// MigrateTeamAdmins moves the admin role from the users, where it applied to every
+// team they are part of, to the membership of the team that was active for them
+func MigrateTeamAdmins(db *gorm.DB) error {
+	if !db.Migrator().HasColumn(&User{}, "is_admin") {
+		return nil
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Exec(`
+			UPDATE team_memberships SET is_admin = TRUE
+			FROM users
+			WHERE users.id = team_memberships.user_id AND users.team_id = team_memberships.team_id AND users.is_admin`).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Migrator().DropColumn(&User{}, "is_admin")
+	})
+}
@@ -0,0 +1,70 @@
+package models
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+type TeamInvitePolicy string
+
+const (
+	// Every member of the team can invite people
+	TeamInvitePolicyEveryone TeamInvitePolicy = "everyone"
+	// Only admins can invite people
+	TeamInvitePolicyAdmins TeamInvitePolicy = "admins"
+)
+
+type TeamRecordingPolicy string
+
+const (
+	TeamRecordingDisabled TeamRecordingPolicy = "disabled"
+	TeamRecordingAllowed  TeamRecordingPolicy = "allowed"
+)
+
+// TeamSettings holds the policies admins set for their team.
+// Teams without a record use DefaultTeamSettings.
+type TeamSettings struct {
+	gorm.Model
+	TeamID                    uint             `gorm:"not null;uniqueIndex" json:"team_id"`
+	AllowAnonymousWatercooler bool             `json:"allow_anonymous_watercooler"`
+	InvitePolicy              TeamInvitePolicy `gorm:"not null" json:"invite_policy"`
+	// Do not disturb hours suggested to new members, in "HH:MM" format,
+	// applied by the clients in the member's local time
+	DefaultDNDStart *string             `json:"default_dnd_start"`
+	DefaultDNDEnd   *string             `json:"default_dnd_end"`
+	RecordingPolicy TeamRecordingPolicy `gorm:"not null" json:"recording_policy"`
+	// CIDRs the members have to connect from, from anywhere if empty
+	IPAllowlist []string `gorm:"serializer:json" json:"ip_allowlist"`
+}
+
+// DefaultTeamSettings returns the settings of a team that hasn't changed them
+func DefaultTeamSettings(teamID uint) TeamSettings {
+	return TeamSettings{
+		TeamID:                    teamID,
+		AllowAnonymousWatercooler: true,
+		InvitePolicy:              TeamInvitePolicyEveryone,
+		RecordingPolicy:           TeamRecordingDisabled,
+	}
+}
+
+// GetTeamSettings returns the settings of the team, or the defaults if
+// the team hasn't changed them
+func GetTeamSettings(db *gorm.DB, teamID uint) (*TeamSettings, error) {
+	var settings TeamSettings
+	err := db.Where("team_id = ?", teamID).First(&settings).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		settings = DefaultTeamSettings(teamID)
+		return &settings, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &settings, nil
+}
+
+// CanInvite checks if the invite policy lets the user invite people to the team
+func (s *TeamSettings) CanInvite(user *User) bool {
+	return s.InvitePolicy != TeamInvitePolicyAdmins || user.IsAdmin
+}
@@ -0,0 +1,59 @@
+package models
+
+import (
+	"crypto/rand"
+	"slices"
+
+	"gorm.io/gorm"
+)
+
+// Call and presence events sent to the webhooks of a team
+const (
+	WebhookEventCallStarted = "call.started"
+	WebhookEventCallEnded   = "call.ended"
+	WebhookEventCallMissed  = "call.missed"
+	WebhookEventUserOnline  = "user.online"
+	WebhookEventUserOffline = "user.offline"
+	WebhookEventUserDND     = "user.dnd"
+)
+
+var WebhookEvents = []string{
+	WebhookEventCallStarted, WebhookEventCallEnded, WebhookEventCallMissed,
+	WebhookEventUserOnline, WebhookEventUserOffline, WebhookEventUserDND,
+}
+
+// TeamWebhook is a URL the calls and presence changes of a team are sent to. Deliveries are signed
+// with the secret, which is only shown to the admin that created the webhook.
+type TeamWebhook struct {
+	gorm.Model
+	TeamID uint   `gorm:"not null;index" json:"team_id"`
+	URL    string `gorm:"not null" json:"url"`
+	Secret string `gorm:"not null" json:"-"`
+	// Events sent to the webhook, all of them if empty
+	Events []string `gorm:"serializer:json" json:"events"`
+}
+
+// NewTeamWebhook creates a webhook of the team with a new signing secret
+func NewTeamWebhook(teamID uint, url string, events []string) *TeamWebhook {
+	return &TeamWebhook{
+		TeamID: teamID,
+		URL:    url,
+		Secret: "whsec_" + rand.Text(),
+		Events: events,
+	}
+}
+
+// GetTeamWebhooks returns the webhooks of the team
+func GetTeamWebhooks(db *gorm.DB, teamID uint) ([]TeamWebhook, error) {
+	var webhooks []TeamWebhook
+	if err := db.Where("team_id = ?", teamID).Order("created_at").Find(&webhooks).Error; err != nil {
+		return nil, err
+	}
+
+	return webhooks, nil
+}
+
+// Subscribes checks if the event is sent to the webhook
+func (w *TeamWebhook) Subscribes(event string) bool {
+	return len(w.Events) == 0 || slices.Contains(w.Events, event)
+}
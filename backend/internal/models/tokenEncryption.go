@@ -0,0 +1,171 @@
+package models
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// encryptedTokenPrefix marks the encrypted values, the ones without it were
+// stored before the tokens were encrypted at rest
+const encryptedTokenPrefix = "enc:v1:"
+
+// tokenCipher encrypts the fields tagged with `gorm:"serializer:encrypted"`,
+// set by SetTokenEncryptionKey
+var tokenCipher cipher.AEAD
+
+func init() {
+	schema.RegisterSerializer("encrypted", EncryptedSerializer{})
+}
+
+// SetTokenEncryptionKey sets the secret the OAuth tokens of the linked identities
+// are encrypted with, the AES-256 key is derived from it
+func SetTokenEncryptionKey(secret string) error {
+	if secret == "" {
+		return errors.New("token encryption key is empty")
+	}
+
+	key := sha256.Sum256([]byte(secret))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return fmt.Errorf("failed to create cipher: %w", err)
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return fmt.Errorf("failed to create cipher: %w", err)
+	}
+
+	tokenCipher = aead
+	return nil
+}
+
+// EncryptedSerializer encrypts string fields with AES-GCM before they are stored
+type EncryptedSerializer struct{}
+
+// Scan decrypts the stored value into the field
+func (EncryptedSerializer) Scan(ctx context.Context, field *schema.Field, dst reflect.Value, dbValue interface{}) error {
+	var value string
+	switch v := dbValue.(type) {
+	case nil:
+	case string:
+		value = v
+	case []byte:
+		value = string(v)
+	default:
+		return fmt.Errorf("unsupported encrypted value %T", dbValue)
+	}
+
+	token, err := decryptToken(value)
+	if err != nil {
+		return err
+	}
+
+	field.ReflectValueOf(ctx, dst).SetString(token)
+	return nil
+}
+
+// Value encrypts the field before it is stored
+func (EncryptedSerializer) Value(ctx context.Context, field *schema.Field, dst reflect.Value, fieldValue interface{}) (interface{}, error) {
+	token, ok := fieldValue.(string)
+	if !ok {
+		return nil, fmt.Errorf("unsupported encrypted field %T", fieldValue)
+	}
+
+	return encryptToken(token)
+}
+
+func encryptToken(token string) (string, error) {
+	// Nothing to protect, and keeps the missing tokens easy to tell apart
+	if token == "" {
+		return "", nil
+	}
+	if tokenCipher == nil {
+		return "", errors.New("token encryption key is not set")
+	}
+
+	nonce := make([]byte, tokenCipher.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	sealed := tokenCipher.Seal(nonce, nonce, []byte(token), nil)
+	return encryptedTokenPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func decryptToken(value string) (string, error) {
+	encoded, ok := strings.CutPrefix(value, encryptedTokenPrefix)
+	if !ok {
+		// Stored before the encryption, EncryptIdentityTokens takes care of them
+		return value, nil
+	}
+	if tokenCipher == nil {
+		return "", errors.New("token encryption key is not set")
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode token: %w", err)
+	}
+	if len(sealed) < tokenCipher.NonceSize() {
+		return "", errors.New("encrypted token is too short")
+	}
+
+	nonce, ciphertext := sealed[:tokenCipher.NonceSize()], sealed[tokenCipher.NonceSize():]
+	token, err := tokenCipher.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt token: %w", err)
+	}
+
+	return string(token), nil
+}
+
+// EncryptIdentityTokens encrypts the OAuth tokens of the identities linked
+// before the tokens were encrypted at rest
+func EncryptIdentityTokens(db *gorm.DB) error {
+	var identities []struct {
+		ID           uint
+		AccessToken  string
+		RefreshToken string
+	}
+	if err := db.Table("user_identities").
+		Select("id, COALESCE(access_token, '') AS access_token, COALESCE(refresh_token, '') AS refresh_token").
+		Where("(access_token <> '' AND access_token NOT LIKE ?) OR (refresh_token <> '' AND refresh_token NOT LIKE ?)",
+			encryptedTokenPrefix+"%", encryptedTokenPrefix+"%").
+		Find(&identities).Error; err != nil {
+		return fmt.Errorf("failed to get identities: %w", err)
+	}
+
+	for _, identity := range identities {
+		updates := map[string]interface{}{}
+		for column, value := range map[string]string{
+			"access_token":  identity.AccessToken,
+			"refresh_token": identity.RefreshToken,
+		} {
+			if strings.HasPrefix(value, encryptedTokenPrefix) {
+				continue
+			}
+			encrypted, err := encryptToken(value)
+			if err != nil {
+				return err
+			}
+			updates[column] = encrypted
+		}
+
+		if err := db.Table("user_identities").Where("id = ?", identity.ID).Updates(updates).Error; err != nil {
+			return fmt.Errorf("failed to encrypt identity tokens: %w", err)
+		}
+	}
+
+	return nil
+}
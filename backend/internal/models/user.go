@@ -3,6 +3,7 @@ package models
 import (
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -15,8 +16,8 @@ type User struct {
 	FirstName      string    `gorm:"not null" json:"first_name" validate:"required"`
 	LastName       string    `gorm:"not null" json:"last_name" validate:"required"`
 	Email          string    `gorm:"not null;unique" json:"email" validate:"required,email"`
-	IsAdmin        bool      `gorm:"default:false" json:"is_admin"`
-	TeamID         *uint     `json:"team_id" gorm:"default:null"`
+	IsAdmin        bool      `gorm:"->;-:migration" json:"is_admin"` // Role in the active team, only loaded WithActiveTeamRole
+	TeamID         *uint     `json:"team_id" gorm:"default:null"`    // Active team, see TeamMembership for all teams
 	Team           *Team     `json:"team,omitempty"`
 	Password       string    `gorm:"-" json:"password" validate:"required,min=8"`
 	HashedPassword string    `json:"-"` // Removed "not null" constraint
@@ -27,6 +28,26 @@ type User struct {
 	SocialMetadata map[string]interface{} `gorm:"serializer:json" json:"social_metadata,omitempty"`
 	// General user metadata for onboarding, preferences, etc.
 	Metadata map[string]interface{} `gorm:"serializer:json" json:"metadata"`
+	// Do not disturb declines incoming calls, until DoNotDisturbUntil when set
+	DoNotDisturb      bool       `gorm:"default:false" json:"do_not_disturb"`
+	DoNotDisturbUntil *time.Time `json:"do_not_disturb_until"`
+	// Locale of the emails of the user, the default locale when empty
+	Locale string `json:"locale"`
+	// When the user proved they own their email: through an invitation sent to it,
+	// a verified provider account or an email change. Unset for unverified sign-ups.
+	EmailVerifiedAt *time.Time `json:"email_verified_at"`
+	// Super admins operate the hosted service through the admin API. Only granted
+	// in the database, never through the API, so it isn't part of the JSON.
+	IsSuperAdmin bool `gorm:"default:false" json:"-"`
+}
+
+// IsDoNotDisturbActive checks if the user is in do not disturb mode right now
+func (u *User) IsDoNotDisturbActive() bool {
+	if !u.DoNotDisturb {
+		return false
+	}
+
+	return u.DoNotDisturbUntil == nil || u.DoNotDisturbUntil.After(time.Now())
 }
 
 func (u *User) BeforeCreate(tx *gorm.DB) (err error) {
@@ -52,6 +73,15 @@ func (u *User) BeforeCreate(tx *gorm.DB) (err error) {
 	return
 }
 
+// AfterSave keeps the user a member of their active team, whichever way they joined it
+func (u *User) AfterSave(tx *gorm.DB) (err error) {
+	if u.TeamID == nil || u.ID == "" {
+		return nil
+	}
+
+	return AddTeamMembership(tx, u.ID, *u.TeamID)
+}
+
 func (u *User) CheckPassword(password string) bool {
 	err := bcrypt.CompareHashAndPassword([]byte(u.HashedPassword), []byte(password))
 	return err == nil
@@ -59,7 +89,7 @@ func (u *User) CheckPassword(password string) bool {
 
 func GetUserByEmail(db *gorm.DB, email string) (*User, error) {
 	var user User
-	result := db.Where("email = ?", email).First(&user)
+	result := db.Scopes(WithActiveTeamRole).Where("users.email = ?", email).First(&user)
 
 	if result.Error != nil {
 		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
@@ -72,7 +102,7 @@ func GetUserByEmail(db *gorm.DB, email string) (*User, error) {
 
 func GetUserByID(db *gorm.DB, id string) (*User, error) {
 	var user *User
-	result := db.Where("id = ?", id).First(&user)
+	result := db.Scopes(WithActiveTeamRole).Where("users.id = ?", id).First(&user)
 
 	if result.Error != nil {
 		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
@@ -90,23 +120,91 @@ func (u *User) GetRedisChannel() string {
 type UserWithActivity struct {
 	User
 	IsActive bool `json:"is_active"`
+	IsDND    bool `json:"is_dnd"`
+	// Status the teammate set, available unless they set another one
+	Status     string `json:"status"`
+	StatusText string `json:"status_text"`
+	// Activity of the teammate while online, sharing or pairing, empty otherwise
+	Activity string `json:"activity"`
+}
+
+// TeammatesQuery filters, sorts and paginates the teammates of a user
+type TeammatesQuery struct {
+	// Matched against the name and the email of the teammates
+	Search string
+	// One of the keys of teammatesSortColumns
+	Sort string
+	Desc bool
+	// Zero Limit returns all teammates
+	Limit  int
+	Offset int
+}
+
+// teammatesSortColumns maps the sort options of TeammatesQuery to columns
+var teammatesSortColumns = map[string][]string{
+	"name":   {"first_name", "last_name"},
+	"email":  {"email"},
+	"joined": {"team_memberships.created_at"},
 }
 
-func (u *User) GetTeammates(db *gorm.DB) ([]UserWithActivity, error) {
+// IsValidTeammatesSort checks if sort is a supported TeammatesQuery sort option
+func IsValidTeammatesSort(sort string) bool {
+	_, ok := teammatesSortColumns[sort]
+	return ok
+}
+
+// GetTeammates returns the page of teammates matching the query along with
+// the total number of matching teammates
+func (u *User) GetTeammates(db *gorm.DB, query TeammatesQuery) ([]UserWithActivity, int64, error) {
 	// First preload the user's team
 	if err := db.Preload("Team").Where("id = ?", u.ID).First(u).Error; err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 
 	if u.Team == nil {
-		return []UserWithActivity{}, nil
+		return []UserWithActivity{}, 0, nil
+	}
+
+	// Teammates are the members of the active team, whichever team is active for them
+	tx := db.Model(&User{}).
+		Joins("JOIN team_memberships ON team_memberships.user_id = users.id AND team_memberships.deleted_at IS NULL").
+		Where("team_memberships.team_id = ? AND users.id != ?", u.TeamID, u.ID)
+
+	if query.Search != "" {
+		pattern := "%" + strings.ToLower(query.Search) + "%"
+		tx = tx.Where("LOWER(first_name || ' ' || last_name) LIKE ? OR LOWER(email) LIKE ?", pattern, pattern)
+	}
+	// Reuse the filters for both the count and the page queries
+	tx = tx.Session(&gorm.Session{})
+
+	var total int64
+	if err := tx.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	sortColumns, ok := teammatesSortColumns[query.Sort]
+	if !ok {
+		sortColumns = teammatesSortColumns["name"]
+	}
+	direction := " ASC"
+	if query.Desc {
+		direction = " DESC"
+	}
+	page := tx
+	for _, column := range sortColumns {
+		page = page.Order(column + direction)
+	}
+	// Keep pages stable between teammates with the same name
+	page = page.Order("users.id")
+
+	if query.Limit > 0 {
+		page = page.Limit(query.Limit).Offset(query.Offset)
 	}
 
 	var teammates []User
-	if err := db.Select("id, first_name, last_name, email, avatar_url, team_id, is_admin, created_at, updated_at").
-		Where("team_id = ? AND id != ?", u.TeamID, u.ID).
+	if err := page.Select("users.id, first_name, last_name, email, avatar_url, users.team_id, team_memberships.is_admin, users.created_at, users.updated_at").
 		Find(&teammates).Error; err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 
 	// Convert to UserWithActivity
@@ -118,7 +216,72 @@ func (u *User) GetTeammates(db *gorm.DB) ([]UserWithActivity, error) {
 		}
 	}
 
-	return teammatesWithActivity, nil
+	return teammatesWithActivity, total, nil
+}
+
+// UsersQuery searches and paginates all the users, for the admin API
+type UsersQuery struct {
+	// Matched against the name and the email of the users
+	Search string
+	Limit  int
+	Offset int
+}
+
+// SearchUsers returns the page of users matching the query, newest first,
+// along with the total number of matching users
+func SearchUsers(db *gorm.DB, query UsersQuery) ([]User, int64, error) {
+	tx := db.Model(&User{})
+	if query.Search != "" {
+		pattern := "%" + strings.ToLower(query.Search) + "%"
+		tx = tx.Where("LOWER(first_name || ' ' || last_name) LIKE ? OR LOWER(email) LIKE ?", pattern, pattern)
+	}
+	tx = tx.Session(&gorm.Session{})
+
+	var total int64
+	if err := tx.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var users []User
+	err := tx.Scopes(WithActiveTeamRole).
+		Preload("Team").
+		Order("users.created_at DESC").
+		Order("users.id").
+		Limit(query.Limit).
+		Offset(query.Offset).
+		Find(&users).Error
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return users, total, nil
+}
+
+// GetAllTeammates returns the users sharing any team with the user,
+// used to let everyone that may see the user know about their presence
+func (u *User) GetAllTeammates(db *gorm.DB) ([]User, error) {
+	var teammates []User
+	err := db.Select("DISTINCT users.id, first_name, last_name, email, avatar_url").
+		Joins("JOIN team_memberships ON team_memberships.user_id = users.id AND team_memberships.deleted_at IS NULL").
+		Where("team_memberships.team_id IN (?) AND users.id != ?",
+			db.Model(&TeamMembership{}).Select("team_id").Where("user_id = ?", u.ID),
+			u.ID).
+		Find(&teammates).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return teammates, nil
+}
+
+// BackfillEmailVerifications marks the emails of users that joined with an
+// invitation sent to them as verified
+func BackfillEmailVerifications(db *gorm.DB) error {
+	return db.Exec(`
+		UPDATE users SET email_verified_at = email_invitations.accepted_at
+		FROM email_invitations
+		WHERE email_invitations.accepted_by = users.id AND LOWER(email_invitations.email) = LOWER(users.email)
+			AND users.email_verified_at IS NULL`).Error
 }
 
 // GetDisplayName returns the user's display name
@@ -0,0 +1,82 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/markbates/goth"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrIdentityLinkedToAnotherUser is returned when linking a provider account
+// that already belongs to a different user
+var ErrIdentityLinkedToAnotherUser = errors.New("identity is linked to another user")
+
+// UserIdentity is an OAuth provider account linked to a user,
+// a user can sign in with any of their linked identities
+type UserIdentity struct {
+	gorm.Model
+	UserID         string    `gorm:"not null;index" json:"user_id"`
+	User           User      `gorm:"foreignKey:UserID;references:ID" json:"-"`
+	Provider       string    `gorm:"not null;uniqueIndex:idx_provider_user" json:"provider"`
+	ProviderUserID string    `gorm:"not null;uniqueIndex:idx_provider_user" json:"provider_user_id"`
+	Email          string    `json:"email"`
+	AccessToken    string    `gorm:"serializer:encrypted" json:"-"`
+	RefreshToken   string    `gorm:"serializer:encrypted" json:"-"`
+	ExpiresAt      time.Time `json:"-"`
+}
+
+// GetUserByIdentity returns the user linked to the provider account
+func GetUserByIdentity(db *gorm.DB, provider, providerUserID string) (*User, error) {
+	var identity UserIdentity
+	if err := db.Preload("User").
+		Where("provider = ? AND provider_user_id = ?", provider, providerUserID).
+		First(&identity).Error; err != nil {
+		return nil, err
+	}
+
+	return &identity.User, nil
+}
+
+// RekeyIdentity changes the ID a provider account is known by, for providers
+// that started to identify their accounts differently
+func RekeyIdentity(db *gorm.DB, provider, oldProviderUserID, newProviderUserID string) error {
+	return db.Model(&UserIdentity{}).
+		Where("provider = ? AND provider_user_id = ?", provider, oldProviderUserID).
+		Update("provider_user_id", newProviderUserID).Error
+}
+
+// LinkIdentity links the provider account of gothUser to the user,
+// refreshing the stored tokens if it is already linked
+func LinkIdentity(db *gorm.DB, userID, provider string, gothUser goth.User) (*UserIdentity, error) {
+	var existing UserIdentity
+	err := db.Where("provider = ? AND provider_user_id = ?", provider, gothUser.UserID).First(&existing).Error
+	if err == nil && existing.UserID != userID {
+		return nil, ErrIdentityLinkedToAnotherUser
+	}
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("failed to get identity: %w", err)
+	}
+
+	identity := UserIdentity{
+		UserID:         userID,
+		Provider:       provider,
+		ProviderUserID: gothUser.UserID,
+		Email:          gothUser.Email,
+		AccessToken:    gothUser.AccessToken,
+		RefreshToken:   gothUser.RefreshToken,
+		ExpiresAt:      gothUser.ExpiresAt,
+	}
+
+	err = db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "provider"}, {Name: "provider_user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"email", "access_token", "refresh_token", "expires_at", "updated_at"}),
+	}).Create(&identity).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to link identity: %w", err)
+	}
+
+	return &identity, nil
+}
@@ -0,0 +1,25 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// UserSession tracks a JWT issued to one of the user's devices,
+// so users can see where they are signed in and revoke access.
+// Named UserSession as the `sessions` table is used by the cookie store.
+type UserSession struct {
+	gorm.Model
+	UserID     string    `gorm:"not null;index" json:"user_id"`
+	User       User      `gorm:"foreignKey:UserID;references:ID" json:"-"`
+	JTI        string    `gorm:"not null;uniqueIndex" json:"-"`
+	UserAgent  string    `json:"user_agent"`
+	IPAddress  string    `json:"ip_address"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	// Hash of the token in the "this wasn't me" link of the new device email
+	RevokeTokenHash *string `gorm:"uniqueIndex" json:"-"`
+	// Email of the support admin of an impersonation session, empty for the user's own sessions
+	Impersonator string `gorm:"not null;default:''" json:"impersonator,omitempty"`
+}
@@ -0,0 +1,20 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// HashToken returns the hex encoded SHA-256 of a secret token,
+// used to store API keys and one-time tokens without keeping them in plain text
+func HashToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+// NewSecretToken generates a random token and returns it along with its hash
+func NewSecretToken() (token string, hashed string) {
+	token = rand.Text()
+	return token, HashToken(token)
+}
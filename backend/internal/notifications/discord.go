@@ -0,0 +1,100 @@
+package notifications
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// discordColors are the colors of the embed strip of each category
+var discordColors = map[EventCategory]int{
+	EventCategorySignups: 0x2ecc71,
+	EventCategorySignins: 0x3498db,
+	EventCategoryCalls:   0x9b59b6,
+	EventCategoryHealth:  0xe74c3c,
+}
+
+// discordResolvedColor is the color of the embed strip of resolved incidents
+const discordResolvedColor = 0x2ecc71
+
+type discordEmbedField struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Inline bool   `json:"inline"`
+}
+
+type discordEmbed struct {
+	Title     string              `json:"title"`
+	Color     int                 `json:"color"`
+	Fields    []discordEmbedField `json:"fields,omitempty"`
+	Footer    map[string]string   `json:"footer,omitempty"`
+	Timestamp string              `json:"timestamp"`
+}
+
+// DiscordSink posts the events to a Discord channel with a webhook, as embeds
+type DiscordSink struct {
+	webhookURL string
+	client     *http.Client
+}
+
+// NewDiscordSink creates a sink posting to the channel of the webhook
+func NewDiscordSink(webhookURL string) *DiscordSink {
+	return &DiscordSink{
+		webhookURL: webhookURL,
+		client:     &http.Client{},
+	}
+}
+
+func (d *DiscordSink) Name() string { return "discord" }
+
+// Send posts the event to the channel as an embed
+func (d *DiscordSink) Send(ctx context.Context, event Event) error {
+	jsonPayload, err := json.Marshal(map[string]interface{}{
+		"embeds": []discordEmbed{newDiscordEmbed(event)},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal discord payload: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.webhookURL, bytes.NewBuffer(jsonPayload))
+	if err != nil {
+		return fmt.Errorf("failed to create discord request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send discord message: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// Webhooks answer 204 unless asked to wait for the message
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("discord webhook request failed with status code: %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// newDiscordEmbed lays the event out as an embed, with its details as fields
+func newDiscordEmbed(event Event) discordEmbed {
+	embed := discordEmbed{
+		Title:     event.Message(),
+		Color:     discordColors[event.Category()],
+		Footer:    map[string]string{"text": event.Kind()},
+		Timestamp: event.Time().UTC().Format(time.RFC3339),
+	}
+
+	for _, field := range event.Fields() {
+		embed.Fields = append(embed.Fields, discordEmbedField{Name: field.Name, Value: field.Value, Inline: true})
+	}
+
+	if incident, ok := event.(Incident); ok && incident.Resolved() {
+		embed.Color = discordResolvedColor
+	}
+
+	return embed
+}
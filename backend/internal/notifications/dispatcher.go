@@ -0,0 +1,144 @@
+package notifications
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// queueSize is how many deliveries can wait for a worker, events are dropped once it is full
+	queueSize = 1000
+	// workerCount is how many deliveries are sent at a time
+	workerCount = 4
+	// sinkTimeout is how long a sink has to deliver an event before the attempt is given up on
+	sinkTimeout = 10 * time.Second
+	// maxAttempts is how many times an event is sent to a sink before it is dropped
+	maxAttempts = 3
+	// minRetryBackoff is the wait before the first retry, it doubles after every failure
+	minRetryBackoff = 2 * time.Second
+)
+
+// delivery is an event on its way to a sink
+type delivery struct {
+	sink     Sink
+	event    Event
+	attempts int
+}
+
+// Dispatcher is a Notifier that queues every event for all its sinks, and sends them
+// from background workers with retries. Handlers never wait for a sink, and a slow
+// or failing sink only delays its own deliveries.
+type Dispatcher struct {
+	mu        sync.RWMutex
+	sinks     []Sink
+	routes    Routes
+	templates *Templates
+	queue     chan delivery
+	logger    echo.Logger
+}
+
+// NewDispatcher creates a Dispatcher without sinks, events go nowhere until one is registered.
+// Events are queued until Start is called.
+func NewDispatcher(logger echo.Logger) *Dispatcher {
+	return &Dispatcher{
+		queue:  make(chan delivery, queueSize),
+		logger: logger,
+	}
+}
+
+// Register adds a sink that gets all the events from now on
+func (d *Dispatcher) Register(sink Sink) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.sinks = append(d.sinks, sink)
+}
+
+// SetRoutes sets the rules of which sinks get the events of each severity
+func (d *Dispatcher) SetRoutes(routes Routes) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.routes = routes
+}
+
+// SetTemplates sets the templates of the messages of the events
+func (d *Dispatcher) SetTemplates(templates *Templates) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.templates = templates
+}
+
+// Start starts the workers that send the queued events
+func (d *Dispatcher) Start() {
+	for range workerCount {
+		go func() {
+			for delivery := range d.queue {
+				d.deliver(delivery)
+			}
+		}()
+	}
+}
+
+// Notify queues the event for the sinks its severity is routed to, without waiting for them
+func (d *Dispatcher) Notify(event Event) {
+	if d == nil {
+		return
+	}
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	message, ok, err := d.templates.render(event)
+	if err != nil {
+		d.logger.Errorf("Failed to render the notification template of %s, sending the default message: %v", event.Kind(), err)
+	}
+	if ok {
+		event = withMessage(event, message)
+	}
+
+	for _, sink := range d.sinks {
+		if d.routes.allows(event.Severity(), sink) {
+			d.enqueue(delivery{sink: sink, event: event})
+		}
+	}
+}
+
+// enqueue queues the delivery, or drops it when the queue is full
+// as waiting for room would hold up the caller
+func (d *Dispatcher) enqueue(delivery delivery) {
+	select {
+	case d.queue <- delivery:
+	default:
+		d.logger.Warnf("Notification queue is full, dropping %T for %s", delivery.event, delivery.sink.Name())
+	}
+}
+
+// deliver sends the event to the sink, and queues it again after a backoff when it fails
+func (d *Dispatcher) deliver(delivery delivery) {
+	ctx, cancel := context.WithTimeout(context.Background(), sinkTimeout)
+	defer cancel()
+
+	err := delivery.sink.Send(ctx, delivery.event)
+	if err == nil {
+		return
+	}
+
+	delivery.attempts++
+	if delivery.attempts >= maxAttempts {
+		d.logger.Errorf("Giving up on sending %T to %s after %d attempts: %v",
+			delivery.event, delivery.sink.Name(), delivery.attempts, err)
+		return
+	}
+
+	backoff := minRetryBackoff << (delivery.attempts - 1)
+	d.logger.Warnf("Failed to send %T to %s (attempt %d), retrying in %s: %v",
+		delivery.event, delivery.sink.Name(), delivery.attempts, backoff, err)
+
+	// Waiting in the worker would hold up the deliveries behind it
+	time.AfterFunc(backoff, func() { d.enqueue(delivery) })
+}
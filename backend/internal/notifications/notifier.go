@@ -0,0 +1,230 @@
+package notifications
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// EventCategory groups the events, sinks can send each category to a different place
+type EventCategory string
+
+const (
+	EventCategorySignups EventCategory = "signups"
+	EventCategorySignins EventCategory = "signins"
+	EventCategoryCalls   EventCategory = "calls"
+	EventCategoryHealth  EventCategory = "health"
+)
+
+// Severity is how urgently the team running Hopp needs to hear about an event,
+// routing rules send each severity to its own sinks
+type Severity string
+
+const (
+	SeverityInfo     Severity = "info"
+	SeverityWarn     Severity = "warn"
+	SeverityCritical Severity = "critical"
+)
+
+// Severities are the severities from the least to the most urgent
+var Severities = []Severity{SeverityInfo, SeverityWarn, SeverityCritical}
+
+// Field is a detail of an event, sinks lay the fields out in their own format
+type Field struct {
+	Name  string
+	Value string
+}
+
+// Event is something that happened the team running Hopp wants to hear about.
+// Events are typed structs, every sink renders them in its own format.
+type Event interface {
+	// Kind tells the events apart, like "signup"
+	Kind() string
+	Category() EventCategory
+	Severity() Severity
+	// Time is when the event happened
+	Time() time.Time
+	// Message is a one-line summary of the event, for sinks that post text
+	Message() string
+	// Fields are the details of the event, in the order they are shown
+	Fields() []Field
+}
+
+// UserEvent has what the events about a user carry, it is embedded in them
+type UserEvent struct {
+	UserID string    `json:"user_id"`
+	TeamID *uint     `json:"team_id,omitempty"`
+	At     time.Time `json:"at"`
+}
+
+func (e UserEvent) Time() time.Time { return e.At }
+
+// userFields are the fields of the user and their team
+func (e UserEvent) userFields() []Field {
+	fields := []Field{{Name: "User", Value: e.UserID}}
+	if e.TeamID != nil {
+		fields = append(fields, Field{Name: "Team", Value: strconv.FormatUint(uint64(*e.TeamID), 10)})
+	}
+
+	return fields
+}
+
+func newUserEvent(userID string, teamID *uint) UserEvent {
+	return UserEvent{UserID: userID, TeamID: teamID, At: time.Now()}
+}
+
+// SignupEvent is sent when a new user signs up
+type SignupEvent struct {
+	UserEvent
+}
+
+func NewSignupEvent(userID string, teamID *uint) SignupEvent {
+	return SignupEvent{UserEvent: newUserEvent(userID, teamID)}
+}
+
+func (SignupEvent) Kind() string            { return "signup" }
+func (SignupEvent) Category() EventCategory { return EventCategorySignups }
+func (SignupEvent) Severity() Severity      { return SeverityInfo }
+func (e SignupEvent) Message() string       { return fmt.Sprintf("New sign-up: %s", e.UserID) }
+func (e SignupEvent) Fields() []Field       { return e.userFields() }
+
+// SigninEvent is sent when a user signs in
+type SigninEvent struct {
+	UserEvent
+}
+
+func NewSigninEvent(userID string, teamID *uint) SigninEvent {
+	return SigninEvent{UserEvent: newUserEvent(userID, teamID)}
+}
+
+func (SigninEvent) Kind() string            { return "signin" }
+func (SigninEvent) Category() EventCategory { return EventCategorySignins }
+func (SigninEvent) Severity() Severity      { return SeverityInfo }
+func (e SigninEvent) Message() string       { return fmt.Sprintf("New sign-in: %s", e.UserID) }
+func (e SigninEvent) Fields() []Field       { return e.userFields() }
+
+// CallStartedEvent is sent when a user calls a teammate, the user is the caller
+type CallStartedEvent struct {
+	UserEvent
+	CalleeID string `json:"callee_id"`
+}
+
+func NewCallStartedEvent(callerID string, teamID *uint, calleeID string) CallStartedEvent {
+	return CallStartedEvent{UserEvent: newUserEvent(callerID, teamID), CalleeID: calleeID}
+}
+
+func (CallStartedEvent) Kind() string            { return "call_started" }
+func (CallStartedEvent) Category() EventCategory { return EventCategoryCalls }
+func (CallStartedEvent) Severity() Severity      { return SeverityInfo }
+
+func (e CallStartedEvent) Message() string {
+	return fmt.Sprintf("Call started: %s -> %s", e.UserID, e.CalleeID)
+}
+
+func (e CallStartedEvent) Fields() []Field {
+	return append(e.userFields(), Field{Name: "Callee", Value: e.CalleeID})
+}
+
+// WatercoolerJoinedEvent is sent when a user joins the watercooler room of their team
+type WatercoolerJoinedEvent struct {
+	UserEvent
+}
+
+func NewWatercoolerJoinedEvent(userID string, teamID *uint) WatercoolerJoinedEvent {
+	return WatercoolerJoinedEvent{UserEvent: newUserEvent(userID, teamID)}
+}
+
+func (WatercoolerJoinedEvent) Kind() string            { return "watercooler_joined" }
+func (WatercoolerJoinedEvent) Category() EventCategory { return EventCategoryCalls }
+func (WatercoolerJoinedEvent) Severity() Severity      { return SeverityInfo }
+func (e WatercoolerJoinedEvent) Fields() []Field       { return e.userFields() }
+
+func (e WatercoolerJoinedEvent) Message() string {
+	return fmt.Sprintf("User %s joined the watercooler room", e.UserID)
+}
+
+// Incident is an event about a condition that lasts until it is resolved. Sinks that
+// track incidents open one when it starts, and close it when the resolved event comes.
+type Incident interface {
+	Event
+	// IncidentKey is the same for the events of one condition
+	IncidentKey() string
+	Resolved() bool
+}
+
+// HealthEvent is sent by the health monitors when a check starts failing, and when it recovers.
+// Recoveries keep the severity of the failure, so they reach the sinks the incident was opened on.
+type HealthEvent struct {
+	Check   string `json:"check"`
+	Failing bool   `json:"failing"`
+	// What is wrong, while failing
+	Detail string    `json:"detail,omitempty"`
+	At     time.Time `json:"at"`
+}
+
+func NewHealthEvent(check string, err error) HealthEvent {
+	event := HealthEvent{Check: check, Failing: err != nil, At: time.Now()}
+	if err != nil {
+		event.Detail = err.Error()
+	}
+
+	return event
+}
+
+func (HealthEvent) Kind() string            { return "health" }
+func (HealthEvent) Category() EventCategory { return EventCategoryHealth }
+func (HealthEvent) Severity() Severity      { return SeverityCritical }
+func (e HealthEvent) Time() time.Time       { return e.At }
+func (e HealthEvent) IncidentKey() string   { return "hopp-health-" + e.Check }
+func (e HealthEvent) Resolved() bool        { return !e.Failing }
+
+func (e HealthEvent) Message() string {
+	if !e.Failing {
+		return fmt.Sprintf("Health check %s recovered", e.Check)
+	}
+
+	return fmt.Sprintf("Health check %s is failing: %s", e.Check, e.Detail)
+}
+
+func (e HealthEvent) Fields() []Field {
+	fields := []Field{{Name: "Check", Value: e.Check}}
+	if e.Failing {
+		fields = append(fields, Field{Name: "Detail", Value: e.Detail})
+	}
+
+	return fields
+}
+
+// Payload is the JSON rendering of an event, for sinks that take structured data
+type Payload struct {
+	Kind     string        `json:"kind"`
+	Category EventCategory `json:"category"`
+	Severity Severity      `json:"severity"`
+	Time     time.Time     `json:"time"`
+	Message  string        `json:"message"`
+	// The event struct itself
+	Data Event `json:"data"`
+}
+
+func NewPayload(event Event) Payload {
+	return Payload{
+		Kind:     event.Kind(),
+		Category: event.Category(),
+		Severity: event.Severity(),
+		Time:     event.Time(),
+		Message:  event.Message(),
+		Data:     unwrapEvent(event),
+	}
+}
+
+// Sink delivers events somewhere, like a chat
+type Sink interface {
+	Name() string
+	Send(ctx context.Context, event Event) error
+}
+
+// Notifier lets the registered sinks know about events
+type Notifier interface {
+	Notify(event Event)
+}
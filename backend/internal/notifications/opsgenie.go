@@ -0,0 +1,103 @@
+package notifications
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// opsgenieMessageLimit is the longest alert message Opsgenie accepts
+const opsgenieMessageLimit = 130
+
+// opsgeniePriorities map the severities to the priorities of the alerts
+var opsgeniePriorities = map[Severity]string{
+	SeverityInfo:     "P5",
+	SeverityWarn:     "P3",
+	SeverityCritical: "P1",
+}
+
+// OpsgenieSink creates Opsgenie alerts for the events. Like PagerDuty, every
+// event alerts, so routes should only send it the urgent ones.
+type OpsgenieSink struct {
+	apiURL string
+	apiKey string
+	source string
+	client *http.Client
+}
+
+// NewOpsgenieSink creates a sink alerting with the API key of an Opsgenie integration,
+// the source is where the alerts say they come from
+func NewOpsgenieSink(apiURL, apiKey, source string) *OpsgenieSink {
+	return &OpsgenieSink{
+		apiURL: apiURL,
+		apiKey: apiKey,
+		source: source,
+		client: &http.Client{},
+	}
+}
+
+func (o *OpsgenieSink) Name() string { return "opsgenie" }
+
+// Send creates an alert of the event. Incidents use their key as the alias of the
+// alert, so Opsgenie deduplicates them, and their resolved event closes the alert.
+func (o *OpsgenieSink) Send(ctx context.Context, event Event) error {
+	if incident, ok := event.(Incident); ok && incident.Resolved() {
+		return o.post(ctx, "/v2/alerts/"+url.PathEscape(incident.IncidentKey())+"/close?identifierType=alias", map[string]string{
+			"source": o.source,
+			"note":   event.Message(),
+		})
+	}
+
+	message := event.Message()
+	details := make(map[string]string)
+	for _, field := range event.Fields() {
+		details[field.Name] = field.Value
+	}
+
+	alert := map[string]interface{}{
+		"message":     message,
+		"description": message,
+		"priority":    opsgeniePriorities[event.Severity()],
+		"source":      o.source,
+		"tags":        []string{string(event.Category()), event.Kind()},
+		"details":     details,
+	}
+	if len(message) > opsgenieMessageLimit {
+		alert["message"] = message[:opsgenieMessageLimit]
+	}
+	if incident, ok := event.(Incident); ok {
+		alert["alias"] = incident.IncidentKey()
+	}
+
+	return o.post(ctx, "/v2/alerts", alert)
+}
+
+// post sends a request to the Alert API, it handles requests asynchronously and answers 202
+func (o *OpsgenieSink) post(ctx context.Context, path string, body interface{}) error {
+	jsonPayload, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("failed to marshal opsgenie payload: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.apiURL+path, bytes.NewBuffer(jsonPayload))
+	if err != nil {
+		return fmt.Errorf("failed to create opsgenie request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "GenieKey "+o.apiKey)
+
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send opsgenie request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("opsgenie request failed with status code: %d", resp.StatusCode)
+	}
+
+	return nil
+}
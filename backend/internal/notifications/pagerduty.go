@@ -0,0 +1,86 @@
+package notifications
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const pagerDutyEventsURL = "https://events.pagerduty.com/v2/enqueue"
+
+// pagerDutySeverities map the severities to the ones of the PagerDuty Events API
+var pagerDutySeverities = map[Severity]string{
+	SeverityInfo:     "info",
+	SeverityWarn:     "warning",
+	SeverityCritical: "critical",
+}
+
+// PagerDutySink triggers PagerDuty incidents for the events, with the Events API v2.
+// Every event triggers an incident, so routes should only send it the urgent ones.
+type PagerDutySink struct {
+	routingKey string
+	source     string
+	client     *http.Client
+}
+
+// NewPagerDutySink creates a sink triggering incidents on the service of the routing key,
+// the source is where the incidents say they come from
+func NewPagerDutySink(routingKey, source string) *PagerDutySink {
+	return &PagerDutySink{
+		routingKey: routingKey,
+		source:     source,
+		client:     &http.Client{},
+	}
+}
+
+func (p *PagerDutySink) Name() string { return "pagerduty" }
+
+// Send triggers an incident of the event. Incidents are deduplicated by their key,
+// and their resolved event resolves the PagerDuty incident.
+func (p *PagerDutySink) Send(ctx context.Context, event Event) error {
+	pagerDutyEvent := map[string]interface{}{
+		"routing_key":  p.routingKey,
+		"event_action": "trigger",
+		"payload": map[string]interface{}{
+			"summary":        event.Message(),
+			"source":         p.source,
+			"severity":       pagerDutySeverities[event.Severity()],
+			"timestamp":      event.Time().UTC().Format(time.RFC3339),
+			"group":          string(event.Category()),
+			"class":          event.Kind(),
+			"custom_details": NewPayload(event),
+		},
+	}
+	if incident, ok := event.(Incident); ok {
+		pagerDutyEvent["dedup_key"] = incident.IncidentKey()
+		if incident.Resolved() {
+			pagerDutyEvent["event_action"] = "resolve"
+		}
+	}
+
+	jsonPayload, err := json.Marshal(pagerDutyEvent)
+	if err != nil {
+		return fmt.Errorf("failed to marshal pagerduty payload: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, pagerDutyEventsURL, bytes.NewBuffer(jsonPayload))
+	if err != nil {
+		return fmt.Errorf("failed to create pagerduty request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send pagerduty event: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("pagerduty events request failed with status code: %d", resp.StatusCode)
+	}
+
+	return nil
+}
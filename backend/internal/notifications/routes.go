@@ -0,0 +1,50 @@
+package notifications
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Routes are the routing rules of a deployment, the names of the sinks each severity
+// goes to. Without any rules every event goes to every sink, once there are rules
+// the severities without one go nowhere.
+type Routes map[Severity][]string
+
+// ParseRoutes parses rules like "critical=telegram,pagerduty;info=slack"
+func ParseRoutes(value string) (Routes, error) {
+	routes := Routes{}
+	for _, rule := range strings.Split(value, ";") {
+		rule = strings.TrimSpace(rule)
+		if rule == "" {
+			continue
+		}
+
+		severity, sinks, ok := strings.Cut(rule, "=")
+		if !ok {
+			return nil, fmt.Errorf("notification route %q is not <severity>=<sinks>", rule)
+		}
+
+		severity = strings.TrimSpace(severity)
+		if !slices.Contains(Severities, Severity(severity)) {
+			return nil, fmt.Errorf("unknown severity %q in notification routes", severity)
+		}
+
+		for _, sink := range strings.Split(sinks, ",") {
+			if sink = strings.TrimSpace(sink); sink != "" {
+				routes[Severity(severity)] = append(routes[Severity(severity)], sink)
+			}
+		}
+	}
+
+	return routes, nil
+}
+
+// allows checks if the events of the severity go to the sink
+func (r Routes) allows(severity Severity, sink Sink) bool {
+	if len(r) == 0 {
+		return true
+	}
+
+	return slices.Contains(r[severity], sink.Name())
+}
@@ -0,0 +1,100 @@
+package notifications
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// SlackSink posts the events to Slack with incoming webhooks. A webhook posts to
+// the channel it was created for, so every category can have a webhook of its own.
+type SlackSink struct {
+	defaultWebhookURL  string
+	categoryWebhookURL map[EventCategory]string
+	client             *http.Client
+}
+
+// NewSlackSink creates a sink posting the events of the categories in the map to their
+// webhook, and the rest to the default webhook. Events without a webhook are dropped.
+func NewSlackSink(defaultWebhookURL string, categoryWebhookURL map[EventCategory]string) *SlackSink {
+	return &SlackSink{
+		defaultWebhookURL:  defaultWebhookURL,
+		categoryWebhookURL: categoryWebhookURL,
+		client:             &http.Client{},
+	}
+}
+
+func (s *SlackSink) Name() string { return "slack" }
+
+// Send posts the message of the event to the webhook of its category
+func (s *SlackSink) Send(ctx context.Context, event Event) error {
+	webhookURL := s.categoryWebhookURL[event.Category()]
+	if webhookURL == "" {
+		webhookURL = s.defaultWebhookURL
+	}
+	if webhookURL == "" {
+		return nil
+	}
+
+	jsonPayload, err := json.Marshal(newSlackMessage(event))
+	if err != nil {
+		return fmt.Errorf("failed to marshal slack payload: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhookURL, bytes.NewBuffer(jsonPayload))
+	if err != nil {
+		return fmt.Errorf("failed to create slack request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send slack message: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("slack webhook request failed with status code: %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// newSlackMessage lays the event out as Block Kit blocks, the text is the
+// fallback of the notifications and of the clients that can't show blocks
+func newSlackMessage(event Event) map[string]interface{} {
+	blocks := []map[string]interface{}{
+		{
+			"type": "section",
+			"text": map[string]string{"type": "mrkdwn", "text": "*" + event.Message() + "*"},
+		},
+	}
+
+	if fields := event.Fields(); len(fields) > 0 {
+		blockFields := make([]map[string]string, 0, len(fields))
+		for _, field := range fields {
+			blockFields = append(blockFields, map[string]string{
+				"type": "mrkdwn",
+				"text": fmt.Sprintf("*%s*\n%s", field.Name, field.Value),
+			})
+		}
+		blocks = append(blocks, map[string]interface{}{"type": "section", "fields": blockFields})
+	}
+
+	blocks = append(blocks, map[string]interface{}{
+		"type": "context",
+		"elements": []map[string]string{{
+			"type": "mrkdwn",
+			"text": fmt.Sprintf("%s · %s · <!date^%d^{date_short_pretty} {time_secs}|%s>",
+				event.Kind(), event.Severity(), event.Time().Unix(), event.Time().UTC().Format(time.RFC3339)),
+		}},
+	})
+
+	return map[string]interface{}{
+		"text":   event.Message(),
+		"blocks": blocks,
+	}
+}
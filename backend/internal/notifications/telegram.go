@@ -2,22 +2,170 @@ package notifications
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
-	"hopp-backend/internal/config"
 	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"golang.org/x/time/rate"
+)
+
+const (
+	// telegramRateInterval and telegramBurst keep the sink under the limit Telegram
+	// puts on bots posting to a group, about 20 messages a minute
+	telegramRateInterval = 3 * time.Second
+	telegramBurst        = 5
+	// telegramMaxPending is how many batched events are kept for the next summary,
+	// the oldest are dropped after it
+	telegramMaxPending = 1000
+	// telegramSummaryLines is how many events a summary lists, the rest are only counted
+	telegramSummaryLines = 30
 )
 
-// SendTelegramNotification sends a message to the configured Telegram chat using the Bot API.
-func SendTelegramNotification(message string, cfg *config.Config) error {
-	if cfg.Telegram.BotToken == "" || cfg.Telegram.ChatID == "" {
-		return fmt.Errorf("telegram bot token or chat ID is not configured")
+// TelegramSink posts the events to a Telegram chat using the Bot API. Info events are
+// batched into a summary posted every batch interval, the rest are posted right away,
+// and all the posts are rate limited.
+type TelegramSink struct {
+	botToken      string
+	chatID        string
+	batchInterval time.Duration
+	client        *http.Client
+	limiter       *rate.Limiter
+	logger        echo.Logger
+
+	mu      sync.Mutex
+	pending []Event
+}
+
+// NewTelegramSink creates a sink posting to the chat with the bot. Info events are posted
+// right away like the rest when the batch interval is zero.
+func NewTelegramSink(botToken, chatID string, batchInterval time.Duration, logger echo.Logger) *TelegramSink {
+	t := &TelegramSink{
+		botToken:      botToken,
+		chatID:        chatID,
+		batchInterval: batchInterval,
+		client:        &http.Client{},
+		limiter:       rate.NewLimiter(rate.Every(telegramRateInterval), telegramBurst),
+		logger:        logger,
+	}
+
+	if batchInterval > 0 {
+		go t.flushPeriodically()
+	}
+
+	return t
+}
+
+func (t *TelegramSink) Name() string { return "telegram" }
+
+// Send posts the message of the event to the chat, or batches it for the next summary
+func (t *TelegramSink) Send(ctx context.Context, event Event) error {
+	if t.batchInterval > 0 && event.Severity() == SeverityInfo {
+		t.batch(event)
+		return nil
+	}
+
+	return t.post(ctx, telegramText(event))
+}
+
+// telegramText is the message of the event followed by its fields, one per line
+func telegramText(event Event) string {
+	var text strings.Builder
+	text.WriteString(event.Message())
+	for _, field := range event.Fields() {
+		fmt.Fprintf(&text, "\n%s: %s", field.Name, field.Value)
+	}
+
+	return text.String()
+}
+
+// batch keeps the event for the next summary
+func (t *TelegramSink) batch(events ...Event) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.pending = append(t.pending, events...)
+	if dropped := len(t.pending) - telegramMaxPending; dropped > 0 {
+		t.pending = t.pending[dropped:]
+	}
+}
+
+// flushPeriodically posts the summary of the batched events every batch interval
+func (t *TelegramSink) flushPeriodically() {
+	ticker := time.NewTicker(t.batchInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		t.flush()
+	}
+}
+
+// flush posts the summary of the batched events, they are kept for the next one if it fails
+func (t *TelegramSink) flush() {
+	t.mu.Lock()
+	events := t.pending
+	t.pending = nil
+	t.mu.Unlock()
+
+	if len(events) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), sinkTimeout)
+	defer cancel()
+
+	if err := t.post(ctx, t.summary(events)); err != nil {
+		t.logger.Errorf("Failed to post the summary of %d events to telegram: %v", len(events), err)
+		t.batch(events...)
+	}
+}
+
+// summary counts the events by category and lists the latest of them
+func (t *TelegramSink) summary(events []Event) string {
+	counts := make(map[EventCategory]int)
+	var categories []EventCategory
+	for _, event := range events {
+		if counts[event.Category()] == 0 {
+			categories = append(categories, event.Category())
+		}
+		counts[event.Category()]++
 	}
 
-	apiURL := fmt.Sprintf("https://api.telegram.org/bot%s/sendMessage", cfg.Telegram.BotToken)
+	var summary strings.Builder
+	fmt.Fprintf(&summary, "%d events in the last %s:", len(events), t.batchInterval)
+	for i, category := range categories {
+		if i > 0 {
+			summary.WriteString(",")
+		}
+		fmt.Fprintf(&summary, " %d %s", counts[category], category)
+	}
+	summary.WriteString("\n")
+
+	listed := events[max(0, len(events)-telegramSummaryLines):]
+	if len(listed) < len(events) {
+		fmt.Fprintf(&summary, "\n... %d earlier events", len(events)-len(listed))
+	}
+	for _, event := range listed {
+		summary.WriteString("\n" + event.Message())
+	}
+
+	return summary.String()
+}
+
+// post sends a message to the chat, waiting for the rate limiter first
+func (t *TelegramSink) post(ctx context.Context, message string) error {
+	if err := t.limiter.Wait(ctx); err != nil {
+		return fmt.Errorf("telegram rate limit: %w", err)
+	}
+
+	apiURL := fmt.Sprintf("https://api.telegram.org/bot%s/sendMessage", t.botToken)
 
 	payload := map[string]string{
-		"chat_id": cfg.Telegram.ChatID,
+		"chat_id": t.chatID,
 		"text":    message,
 	}
 
@@ -26,14 +174,19 @@ func SendTelegramNotification(message string, cfg *config.Config) error {
 		return fmt.Errorf("failed to marshal telegram payload: %w", err)
 	}
 
-	resp, err := http.Post(apiURL, "application/json", bytes.NewBuffer(jsonPayload))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, apiURL, bytes.NewBuffer(jsonPayload))
+	if err != nil {
+		return fmt.Errorf("failed to create telegram request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := t.client.Do(req)
 	if err != nil {
 		return fmt.Errorf("failed to send telegram message: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		// Consider logging the response body here for debugging
 		return fmt.Errorf("telegram API request failed with status code: %d", resp.StatusCode)
 	}
 
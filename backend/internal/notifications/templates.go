@@ -0,0 +1,125 @@
+package notifications
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/template"
+)
+
+// Templates customize the messages of the events per deployment, by event kind.
+// Events without a template keep their default message.
+type Templates struct {
+	templates map[string]*template.Template
+	// Environment and Domain are passed to the templates
+	environment string
+	domain      string
+}
+
+// TemplateData is what the templates of the messages are executed with
+type TemplateData struct {
+	// The event struct, like {{.Event.UserID}}
+	Event Event
+	// The default message of the event
+	Message     string
+	Environment string
+	// Domain of the deployment, for links to its pages
+	Domain string
+}
+
+// LoadTemplates parses the templates in the JSON file, an object of event kinds to
+// text/template templates like {"signup": "[{{.Environment}}] {{.Message}}"}.
+// Without a file every event keeps its default message.
+func LoadTemplates(path, environment, domain string) (*Templates, error) {
+	templates := &Templates{
+		templates:   make(map[string]*template.Template),
+		environment: environment,
+		domain:      domain,
+	}
+	if path == "" {
+		return templates, nil
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading notification templates: %w", err)
+	}
+
+	var sources map[string]string
+	if err := json.Unmarshal(content, &sources); err != nil {
+		return nil, fmt.Errorf("parsing notification templates: %w", err)
+	}
+
+	for kind, source := range sources {
+		tmpl, err := template.New(kind).Option("missingkey=error").Parse(source)
+		if err != nil {
+			return nil, fmt.Errorf("parsing notification template of %s: %w", kind, err)
+		}
+		templates.templates[kind] = tmpl
+	}
+
+	return templates, nil
+}
+
+// render renders the message of the event with its template, and reports
+// whether the event has one
+func (t *Templates) render(event Event) (string, bool, error) {
+	if t == nil {
+		return "", false, nil
+	}
+
+	tmpl, ok := t.templates[event.Kind()]
+	if !ok {
+		return "", false, nil
+	}
+
+	var message bytes.Buffer
+	err := tmpl.Execute(&message, TemplateData{
+		Event:       event,
+		Message:     event.Message(),
+		Environment: t.environment,
+		Domain:      t.domain,
+	})
+	if err != nil {
+		return "", false, err
+	}
+
+	return message.String(), true, nil
+}
+
+// renderedEvent is an event with the message its template rendered
+type renderedEvent struct {
+	Event
+	message string
+}
+
+func (e renderedEvent) Message() string { return e.message }
+func (e renderedEvent) unwrap() Event   { return e.Event }
+
+// renderedIncident is a renderedEvent of an incident, so sinks still see it is one
+type renderedIncident struct {
+	Incident
+	message string
+}
+
+func (e renderedIncident) Message() string { return e.message }
+func (e renderedIncident) unwrap() Event   { return e.Incident }
+
+// withMessage returns the event with the message in place of its default one
+func withMessage(event Event, message string) Event {
+	if incident, ok := event.(Incident); ok {
+		return renderedIncident{Incident: incident, message: message}
+	}
+
+	return renderedEvent{Event: event, message: message}
+}
+
+// unwrapEvent returns the event struct of a rendered event
+func unwrapEvent(event Event) Event {
+	if rendered, ok := event.(interface{ unwrap() Event }); ok {
+		return rendered.unwrap()
+	}
+
+	return event
+}
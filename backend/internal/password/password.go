@@ -0,0 +1,105 @@
+package password
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hopp-backend/internal/config"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// ErrBreached is returned when the password was found in a known data breach
+var ErrBreached = errors.New("this password has appeared in a data breach, please choose a different one")
+
+var hibpClient = &http.Client{Timeout: 5 * time.Second}
+
+// Validate checks a candidate password against the configured complexity policy
+// and, if enabled, against the HaveIBeenPwned breached passwords list.
+// The returned error is safe to show to the user.
+func Validate(password string, cfg *config.Config) error {
+	policy := cfg.Password
+
+	if len(password) < policy.MinLength {
+		return fmt.Errorf("password must be at least %d characters long", policy.MinLength)
+	}
+
+	var hasUpper, hasLower, hasDigit, hasSymbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+			hasSymbol = true
+		}
+	}
+
+	if policy.RequireUpper && !hasUpper {
+		return errors.New("password must contain an uppercase letter")
+	}
+	if policy.RequireLower && !hasLower {
+		return errors.New("password must contain a lowercase letter")
+	}
+	if policy.RequireDigit && !hasDigit {
+		return errors.New("password must contain a digit")
+	}
+	if policy.RequireSymbol && !hasSymbol {
+		return errors.New("password must contain a symbol")
+	}
+
+	if policy.CheckBreached {
+		breached, err := IsBreached(password)
+		// Don't block users if the API is unavailable
+		if err == nil && breached {
+			return ErrBreached
+		}
+	}
+
+	return nil
+}
+
+// IsBreached checks the password against the HaveIBeenPwned range API.
+// Only the first 5 characters of the SHA-1 hash leave the server (k-anonymity).
+func IsBreached(password string) (bool, error) {
+	hash := sha1.Sum([]byte(password))
+	hashHex := strings.ToUpper(hex.EncodeToString(hash[:]))
+	prefix, suffix := hashHex[:5], hashHex[5:]
+
+	req, err := http.NewRequest("GET", "https://api.pwnedpasswords.com/range/"+prefix, nil)
+	if err != nil {
+		return false, fmt.Errorf("creating request: %w", err)
+	}
+	// Padding hides the real number of matches from anyone watching the traffic
+	req.Header.Add("Add-Padding", "true")
+
+	resp, err := hibpClient.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("making request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("HaveIBeenPwned API request failed with status code: %d", resp.StatusCode)
+	}
+
+	// Each line is in the format <hash suffix>:<count>
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		candidate, count, found := strings.Cut(scanner.Text(), ":")
+		if !found || candidate != suffix {
+			continue
+		}
+		// Padding entries have a count of 0
+		return strings.TrimSpace(count) != "0", nil
+	}
+
+	return false, scanner.Err()
+}
@@ -0,0 +1,56 @@
+package presence
+
+import (
+	"context"
+	"hopp-backend/internal/common"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Session activities teammates see, without what is being shared or with whom
+const (
+	ActivityNone    = ""
+	ActivitySharing = "sharing"
+	ActivityPairing = "pairing"
+)
+
+// activityTTL forgets the activity of users that never said they stopped,
+// it only shows while the user is online anyway
+const activityTTL = 24 * time.Hour
+
+// SetActivity stores the session activity of the user, ActivityNone clears it
+func SetActivity(ctx context.Context, rdb *redis.Client, userID, activity string) error {
+	key := common.GetPresenceActivityKey(userID)
+	if activity == ActivityNone {
+		return rdb.Del(ctx, key).Err()
+	}
+
+	return rdb.Set(ctx, key, activity, activityTTL).Err()
+}
+
+// BulkGetActivity returns the session activities of the users, in one round trip
+func BulkGetActivity(ctx context.Context, rdb *redis.Client, userIDs []string) (map[string]string, error) {
+	activities := make(map[string]string, len(userIDs))
+	if len(userIDs) == 0 {
+		return activities, nil
+	}
+
+	keys := make([]string, len(userIDs))
+	for i, userID := range userIDs {
+		keys[i] = common.GetPresenceActivityKey(userID)
+	}
+
+	values, err := rdb.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	for i, userID := range userIDs {
+		if activity, ok := values[i].(string); ok {
+			activities[userID] = activity
+		}
+	}
+
+	return activities, nil
+}
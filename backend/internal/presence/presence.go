@@ -0,0 +1,171 @@
+// Package presence tracks which users are online. A user is online while they
+// have a live websocket connection: the connections of a user, from any device and
+// server replica, are kept in a sorted set scored by when each of them expires.
+// The devices the connections come from are kept the same way.
+//
+// Every replica only knows its own connections, the shared state is in Redis, so
+// any replica answers the same. Replicas that go away without closing their
+// connections don't leave users online: their connections stop sending heartbeats
+// and drop off after TTL.
+//
+// Each device is owned by its latest connection, whose ID is the ownership token.
+// A device that reconnects, to the same or another replica, takes over from its old
+// connection, e.g. one left half-open by a laptop switching networks. The old
+// connection notices on its next heartbeat and is closed, so the device doesn't
+// get every message twice.
+package presence
+
+import (
+	"context"
+	"errors"
+	"hopp-backend/internal/common"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// TTL is how long a connection counts as online after its last heartbeat,
+// connections that stop sending heartbeats drop off by themselves
+const TTL = 30 * time.Second
+
+// activeUsersTTL keeps the active users of a day until the daily stats of the
+// day after were rolled up a few times
+const activeUsersTTL = 48 * time.Hour
+
+// ErrTakenOver is returned for connections whose device connected again since
+var ErrTakenOver = errors.New("device connected again")
+
+// Claim makes the connection the owner of its device, taking over from the older connection of the device
+func Claim(ctx context.Context, rdb *redis.Client, userID, deviceID, connectionID string) error {
+	return rdb.Set(ctx, common.GetDeviceOwnerKey(userID, deviceID), connectionID, TTL).Err()
+}
+
+// Refresh marks the connection of the user, and its device, as online for another TTL,
+// it is called when the connection opens and on every heartbeat. It reports whether
+// the user was offline until now, i.e. they had no live connection before this one.
+// Connections that lost their device to a newer connection get ErrTakenOver.
+func Refresh(ctx context.Context, rdb *redis.Client, userID, deviceID, connectionID string) (bool, error) {
+	now := time.Now()
+	expiresAt := float64(now.Add(TTL).UnixMilli())
+	expired := strconv.FormatInt(now.UnixMilli(), 10)
+	presenceKey := common.GetPresenceKey(userID)
+
+	ownerKey := common.GetDeviceOwnerKey(userID, deviceID)
+
+	pipe := rdb.TxPipeline()
+	owner := pipe.Get(ctx, ownerKey)
+	pipe.Expire(ctx, ownerKey, TTL)
+	pipe.ZRemRangeByScore(ctx, presenceKey, "-inf", expired)
+	live := pipe.ZCard(ctx, presenceKey)
+	for key, member := range map[string]string{
+		presenceKey:                      connectionID,
+		common.GetUserDevicesKey(userID): deviceID,
+	} {
+		pipe.ZAdd(ctx, key, redis.Z{Score: expiresAt, Member: member})
+		pipe.ZRemRangeByScore(ctx, key, "-inf", expired)
+		pipe.Expire(ctx, key, TTL)
+	}
+	// Count the user as active today, for the daily stats
+	activeUsersKey := common.GetActiveUsersKey(now.UTC().Format(time.DateOnly))
+	pipe.PFAdd(ctx, activeUsersKey, userID)
+	pipe.Expire(ctx, activeUsersKey, activeUsersTTL)
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return false, err
+	}
+
+	// The owner key of connections from before ownership was tracked is missing
+	if owner.Val() != "" && owner.Val() != connectionID {
+		return false, ErrTakenOver
+	}
+
+	return live.Val() == 0, nil
+}
+
+// Disconnect marks the connection of the user as offline right away. It reports
+// whether the user went offline, i.e. it was their last live connection.
+func Disconnect(ctx context.Context, rdb *redis.Client, userID, connectionID string) (bool, error) {
+	now := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	presenceKey := common.GetPresenceKey(userID)
+
+	pipe := rdb.TxPipeline()
+	removed := pipe.ZRem(ctx, presenceKey, connectionID)
+	remaining := pipe.ZCount(ctx, presenceKey, "("+now, "+inf")
+	if _, err := pipe.Exec(ctx); err != nil {
+		return false, err
+	}
+
+	return removed.Val() == 1 && remaining.Val() == 0, nil
+}
+
+// Devices returns the devices the user has a live connection from. A device that
+// disconnected stays in the list until its last heartbeat expires.
+func Devices(ctx context.Context, rdb *redis.Client, userID string) ([]string, error) {
+	now := strconv.FormatInt(time.Now().UnixMilli(), 10)
+
+	return rdb.ZRangeByScore(ctx, common.GetUserDevicesKey(userID), &redis.ZRangeBy{Min: "(" + now, Max: "+inf"}).Result()
+}
+
+// IsOnline checks if the user has a live connection
+func IsOnline(ctx context.Context, rdb *redis.Client, userID string) (bool, error) {
+	online, err := BulkIsOnline(ctx, rdb, []string{userID})
+	if err != nil {
+		return false, err
+	}
+
+	return online[userID], nil
+}
+
+// BulkIsOnline checks which of the users have a live connection, in one round trip
+func BulkIsOnline(ctx context.Context, rdb *redis.Client, userIDs []string) (map[string]bool, error) {
+	online := make(map[string]bool, len(userIDs))
+	if len(userIDs) == 0 {
+		return online, nil
+	}
+
+	now := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	pipe := rdb.Pipeline()
+	counts := make([]*redis.IntCmd, len(userIDs))
+	for i, userID := range userIDs {
+		counts[i] = pipe.ZCount(ctx, common.GetPresenceKey(userID), "("+now, "+inf")
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+
+	for i, userID := range userIDs {
+		online[userID] = counts[i].Val() > 0
+	}
+
+	return online, nil
+}
+
+// CountActiveUsers returns about how many users were online on the UTC day,
+// zero once the day is older than activeUsersTTL
+func CountActiveUsers(ctx context.Context, rdb *redis.Client, day time.Time) (int64, error) {
+	return rdb.PFCount(ctx, common.GetActiveUsersKey(day.UTC().Format(time.DateOnly))).Result()
+}
+
+// CountConnections returns how many live connections the users have together, in one round trip
+func CountConnections(ctx context.Context, rdb *redis.Client, userIDs []string) (int64, error) {
+	if len(userIDs) == 0 {
+		return 0, nil
+	}
+
+	now := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	pipe := rdb.Pipeline()
+	counts := make([]*redis.IntCmd, len(userIDs))
+	for i, userID := range userIDs {
+		counts[i] = pipe.ZCount(ctx, common.GetPresenceKey(userID), "("+now, "+inf")
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+
+	var total int64
+	for _, count := range counts {
+		total += count.Val()
+	}
+
+	return total, nil
+}
@@ -0,0 +1,73 @@
+package presence
+
+import (
+	"context"
+	"hopp-backend/internal/common"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Statuses users can set to tell teammates how available they are
+const (
+	StatusAvailable = "available"
+	StatusAway      = "away"
+	StatusFocus     = "focus"
+	StatusInMeeting = "in_meeting"
+)
+
+// Status is the status a user set along with its optional text
+type Status struct {
+	Status string `json:"status"`
+	Text   string `json:"status_text"`
+}
+
+// SetStatus stores the status of the user, setting it back to available clears it
+func SetStatus(ctx context.Context, rdb *redis.Client, userID string, status Status) error {
+	key := common.GetPresenceStatusKey(userID)
+	if status.Status == StatusAvailable && status.Text == "" {
+		return rdb.Del(ctx, key).Err()
+	}
+
+	return rdb.HSet(ctx, key, map[string]interface{}{
+		"status": status.Status,
+		"text":   status.Text,
+	}).Err()
+}
+
+// GetStatus returns the status of the user, available if they didn't set one
+func GetStatus(ctx context.Context, rdb *redis.Client, userID string) (Status, error) {
+	statuses, err := BulkGetStatus(ctx, rdb, []string{userID})
+	if err != nil {
+		return Status{Status: StatusAvailable}, err
+	}
+
+	return statuses[userID], nil
+}
+
+// BulkGetStatus returns the statuses of the users, in one round trip
+func BulkGetStatus(ctx context.Context, rdb *redis.Client, userIDs []string) (map[string]Status, error) {
+	statuses := make(map[string]Status, len(userIDs))
+	if len(userIDs) == 0 {
+		return statuses, nil
+	}
+
+	pipe := rdb.Pipeline()
+	values := make([]*redis.MapStringStringCmd, len(userIDs))
+	for i, userID := range userIDs {
+		values[i] = pipe.HGetAll(ctx, common.GetPresenceStatusKey(userID))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+
+	for i, userID := range userIDs {
+		status := Status{Status: StatusAvailable}
+		if stored := values[i].Val(); stored["status"] != "" {
+			status.Status = stored["status"]
+			status.Text = stored["text"]
+		}
+		statuses[userID] = status
+	}
+
+	return statuses, nil
+}
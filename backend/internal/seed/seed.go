@@ -0,0 +1,184 @@
+// Package seed fills a development database with a demo team, so the backend
+// can be run locally with realistic data. It is only reachable with the debug
+// endpoints on, and running it again leaves the data already there untouched.
+package seed
+
+import (
+	"errors"
+	"hopp-backend/internal/models"
+	"math/rand/v2"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Password of all the demo users
+const Password = "hoppless"
+
+// callHistoryDays is how far back the fake call history goes
+const callHistoryDays = 30
+
+// TeamName is the name of the demo team
+const TeamName = "Dunder Mifflin"
+
+type demoUser struct {
+	firstName string
+	lastName  string
+	email     string
+	isAdmin   bool
+}
+
+var demoUsers = []demoUser{
+	{firstName: "Michael", lastName: "Scott", email: "michael@dundermifflin.com", isAdmin: true},
+	{firstName: "Dwight", lastName: "Schrute", email: "dwight@dundermifflin.com"},
+	{firstName: "Jim", lastName: "Halpert", email: "jim@dundermifflin.com"},
+	{firstName: "Pam", lastName: "Beesly", email: "pam@dundermifflin.com"},
+	{firstName: "Angela", lastName: "Martin", email: "angela@dundermifflin.com"},
+}
+
+// demoInvitations are the people invited to the demo team that haven't joined yet
+var demoInvitations = []string{
+	"ryan@dundermifflin.com",
+	"kelly@dundermifflin.com",
+}
+
+// Result is what the seeding created, or found already there
+type Result struct {
+	TeamID      uint     `json:"team_id"`
+	Users       []string `json:"users"`
+	Password    string   `json:"password"`
+	Invitations []string `json:"invitations"`
+	// Calls created by this run, zero if the team already had a call history
+	Calls int `json:"calls"`
+}
+
+// Run creates the demo team with its users, invitations and call history
+func Run(db *gorm.DB) (*Result, error) {
+	result := &Result{Password: Password}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var team models.Team
+		if err := tx.Where(models.Team{Name: TeamName}).FirstOrCreate(&team).Error; err != nil {
+			return err
+		}
+		result.TeamID = team.ID
+
+		users := make([]models.User, len(demoUsers))
+		for i, demo := range demoUsers {
+			user, err := seedUser(tx, team.ID, demo)
+			if err != nil {
+				return err
+			}
+			users[i] = *user
+			result.Users = append(result.Users, user.Email)
+		}
+
+		for _, email := range demoInvitations {
+			if !models.HasPendingEmailInvitation(tx, email) {
+				if _, _, err := models.NewEmailInvitation(tx, int(team.ID), email, users[0].ID, models.TeamRoleMember); err != nil {
+					return err
+				}
+			}
+			result.Invitations = append(result.Invitations, email)
+		}
+
+		calls, err := seedCalls(tx, team.ID, users)
+		if err != nil {
+			return err
+		}
+		result.Calls = calls
+
+		// The admin stats of the days older than the rollup window
+		return models.BackfillDailyStats(tx)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// seedUser creates the demo user in the team, users that exist already are kept as they are
+func seedUser(tx *gorm.DB, teamID uint, demo demoUser) (*models.User, error) {
+	var user models.User
+	err := tx.Where("email = ?", demo.email).First(&user).Error
+	if err == nil {
+		return &user, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	user = models.User{
+		FirstName: demo.firstName,
+		LastName:  demo.lastName,
+		Email:     demo.email,
+		Password:  Password,
+		TeamID:    &teamID,
+	}
+	if err := tx.Create(&user).Error; err != nil {
+		return nil, err
+	}
+
+	if demo.isAdmin {
+		if err := models.SetTeamAdmin(tx, user.ID, teamID, true); err != nil {
+			return nil, err
+		}
+		user.IsAdmin = true
+	}
+
+	return &user, nil
+}
+
+// seedCalls creates a few calls a day between random members over the last
+// callHistoryDays days, unless the team already has calls
+func seedCalls(tx *gorm.DB, teamID uint, users []models.User) (int, error) {
+	var existing int64
+	if err := tx.Model(&models.Call{}).Where("team_id = ?", teamID).Count(&existing).Error; err != nil {
+		return 0, err
+	}
+	if existing > 0 || len(users) < 2 {
+		return 0, nil
+	}
+
+	// A fixed seed gives every contributor the same history
+	random := rand.New(rand.NewPCG(1926, 2025))
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+
+	created := 0
+	for day := callHistoryDays; day >= 1; day-- {
+		for range random.IntN(6) {
+			caller := users[random.IntN(len(users))]
+			callee := users[random.IntN(len(users))]
+			if caller.ID == callee.ID {
+				continue
+			}
+
+			// Between 9:00 and 18:00, lasting 1 to 45 minutes
+			startedAt := today.AddDate(0, 0, -day).
+				Add(9*time.Hour + time.Duration(random.IntN(9*60))*time.Minute)
+			duration := int64(60 + random.IntN(44*60))
+			endedAt := startedAt.Add(time.Duration(duration) * time.Second)
+
+			call := models.Call{
+				RoomName:  uuid.NewString(),
+				TeamID:    &teamID,
+				CallerID:  caller.ID,
+				CalleeID:  callee.ID,
+				StartedAt: startedAt,
+				EndedAt:   &endedAt,
+				Duration:  duration,
+			}
+			if err := tx.Create(&call).Error; err != nil {
+				return 0, err
+			}
+			if err := models.RecordCallStats(tx, &call); err != nil {
+				return 0, err
+			}
+			created++
+		}
+	}
+
+	return created, nil
+}
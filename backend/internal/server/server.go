@@ -9,11 +9,15 @@ import (
 	"hopp-backend/internal/email"
 	"hopp-backend/internal/handlers"
 	"hopp-backend/internal/models"
+	"hopp-backend/internal/notifications"
+	"hopp-backend/internal/storage"
 	"html/template"
 	"io"
 	"net/http"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/go-playground/validator"
@@ -24,7 +28,9 @@ import (
 	"github.com/labstack/gommon/log"
 	"github.com/markbates/goth"
 	"github.com/markbates/goth/gothic"
+	"github.com/markbates/goth/providers/azureadv2"
 	"github.com/markbates/goth/providers/google"
+	"github.com/markbates/goth/providers/openidConnect"
 	"github.com/markbates/goth/providers/slack"
 	"github.com/redis/go-redis/v9"
 	resend "github.com/resend/resend-go/v2"
@@ -69,8 +75,13 @@ func (l *SentryLogger) Error(i ...interface{}) {
 	l.Logger.Error(i...)
 }
 
+// shutdownTimeout bounds how long the server waits for requests and
+// websocket connections to finish when it shuts down
+const shutdownTimeout = 15 * time.Second
+
 type Server struct {
 	common.ServerState
+	hub *handlers.Hub
 }
 
 func New(cfg *config.Config) *Server {
@@ -80,7 +91,7 @@ func New(cfg *config.Config) *Server {
 	e.Logger.SetLevel(log.DEBUG)
 
 	return &Server{
-		common.ServerState{
+		ServerState: common.ServerState{
 			Echo:   e,
 			Config: cfg,
 		},
@@ -91,13 +102,32 @@ func (s *Server) Initialize() error {
 	// Initialize database
 	s.setupDatabase()
 
+	// The OAuth tokens of the linked identities are encrypted at rest
+	if err := models.SetTokenEncryptionKey(s.Config.Auth.TokenEncryptionKey); err != nil {
+		return fmt.Errorf("failed to set token encryption key: %w", err)
+	}
+
 	s.setupRedis()
 
 	// Initialize JWT
-	s.JwtIssuer = handlers.NewJwtAuth(s.Config.Auth.SessionSecret)
+	jwtIssuer, err := handlers.NewJwtAuth(s.Config, s.Redis)
+	if err != nil {
+		return fmt.Errorf("failed to initialize JWT: %w", err)
+	}
+	s.JwtIssuer = jwtIssuer
 
 	// Initialize Resend email client
-	s.setupEmailClient()
+	if err := s.setupEmailClient(); err != nil {
+		return fmt.Errorf("failed to initialize email client: %w", err)
+	}
+
+	// Initialize object storage for uploads
+	s.setupStorage()
+
+	// Initialize the sinks of the notifications about sign-ups, calls etc
+	if err := s.setupNotifier(); err != nil {
+		return fmt.Errorf("failed to initialize notifications: %w", err)
+	}
 
 	// Initialize session store
 	s.setupSessionStore()
@@ -114,9 +144,31 @@ func (s *Server) Initialize() error {
 	// Setup goth providers
 	s.setupGothProviders()
 
+	// Keep the Slack workspace members of Slack users fresh
+	handlers.StartSlackMemberSync(&s.ServerState)
+
+	// Clean up the calls participants dropped out of without ending them
+	handlers.StartStaleCallSweep(&s.ServerState)
+
+	// Page on-call when Redis, LiveKit or the email queue are failing
+	handlers.StartHealthMonitors(&s.ServerState)
+
+	// Roll up the daily stats of the admin dashboard
+	handlers.StartDailyStatsRollup(&s.ServerState)
+
+	// Delete the transferred files once their download URL expired
+	handlers.StartFileTransferCleanup(&s.ServerState)
+
+	// Send the queued emails, once their table exists
+	if emailClient, ok := s.EmailClient.(*email.ResendEmailClient); ok {
+		emailClient.StartQueueWorker(s.Config.Resend.QueueInterval)
+	}
+
 	// Setup middleware -
 	// Keep last to avoid Recover middleware and panic if something goes wrong on init
-	s.setupMiddleware()
+	if err := s.setupMiddleware(); err != nil {
+		return fmt.Errorf("failed to setup middleware: %w", err)
+	}
 
 	return nil
 }
@@ -178,40 +230,246 @@ func (s *Server) runMigrations() {
 		&models.Team{},
 		&models.TeamInvitation{},
 		&models.EmailInvitation{},
+		&models.ApiKey{},
+		&models.UserSession{},
+		&models.EmailChange{},
+		&models.AuditEvent{},
+		&models.UserIdentity{},
+		&models.TeamMembership{},
+		&models.JoinRequest{},
+		&models.TeamSettings{},
+		&models.TeamActivity{},
+		&models.Call{},
+		&models.MissedCall{},
+		&models.CallStats{},
+		&models.CallbackRequest{},
+		&models.TeamWebhook{},
+		&models.DirectMessage{},
+		&models.DirectMessageReaction{},
+		&models.TeamChatMessage{},
+		&models.OutboundEmail{},
+		&models.EmailSuppression{},
+		&models.DailyStats{},
+		&models.AuditLogEntry{},
+		&models.FeatureFlag{},
 	)
 	if err != nil {
 		s.Echo.Logger.Fatal(err)
 	}
+
+	if err := models.BackfillTeamMemberships(s.DB); err != nil {
+		s.Echo.Logger.Fatal(err)
+	}
+
+	if err := models.MigrateTeamAdmins(s.DB); err != nil {
+		s.Echo.Logger.Fatal(err)
+	}
+
+	if err := models.BackfillEmailVerifications(s.DB); err != nil {
+		s.Echo.Logger.Fatal(err)
+	}
+
+	if err := models.EncryptIdentityTokens(s.DB); err != nil {
+		s.Echo.Logger.Fatal(err)
+	}
+
+	if err := models.BackfillCallStats(s.DB); err != nil {
+		s.Echo.Logger.Fatal(err)
+	}
+
+	if err := models.BackfillDailyStats(s.DB); err != nil {
+		s.Echo.Logger.Fatal(err)
+	}
+
+	if err := models.EnsureAuditLogAppendOnly(s.DB); err != nil {
+		s.Echo.Logger.Fatal(err)
+	}
 }
 
-func (s *Server) setupMiddleware() {
-	s.Echo.Use(middleware.CORS())
+func (s *Server) setupMiddleware() error {
+	if err := s.setupIPFilter(); err != nil {
+		return err
+	}
+
+	s.Echo.Use(middleware.CORSWithConfig(middleware.CORSConfig{
+		// Let the client read the total of paginated responses
+		ExposeHeaders: []string{"X-Total-Count"},
+	}))
 	s.Echo.Use(session.Middleware(s.Store))
 	s.Echo.Use(middleware.Recover())
 	s.Echo.Use(echoprometheus.NewMiddleware("renkey_backend"))
+
+	return nil
+}
+
+// setupIPFilter restricts the networks the server is reachable from, and which
+// proxies are trusted to tell the address of the clients
+func (s *Server) setupIPFilter() error {
+	trustedProxies, err := handlers.ParseCIDRs(s.Config.Server.TrustedProxyCIDRs)
+	if err != nil {
+		return fmt.Errorf("invalid TRUSTED_PROXIES: %w", err)
+	}
+	if len(trustedProxies) > 0 {
+		trustOptions := make([]echo.TrustOption, len(trustedProxies))
+		for i, network := range trustedProxies {
+			trustOptions[i] = echo.TrustIPRange(network)
+		}
+		s.Echo.IPExtractor = echo.ExtractIPFromXFFHeader(trustOptions...)
+	} else {
+		// Forwarded headers would come from the clients, who could pick
+		// the address the IP filters and team allowlists check
+		s.Echo.IPExtractor = echo.ExtractIPDirect()
+	}
+
+	allowed, err := handlers.ParseCIDRs(s.Config.Server.AllowedCIDRs)
+	if err != nil {
+		return fmt.Errorf("invalid IP_ALLOWLIST: %w", err)
+	}
+	denied, err := handlers.ParseCIDRs(s.Config.Server.DeniedCIDRs)
+	if err != nil {
+		return fmt.Errorf("invalid IP_DENYLIST: %w", err)
+	}
+	if len(allowed) == 0 && len(denied) == 0 {
+		return nil
+	}
+
+	s.Echo.Use(handlers.IPFilterMiddleware(allowed, denied))
+
+	return nil
 }
 
 func (s *Server) setupGothProviders() {
 	// Set the session secret for Goth
 	gothic.Store = s.Store
 
-	goth.UseProviders(
+	providers := []goth.Provider{
 		google.New(s.Config.Auth.GoogleKey, s.Config.Auth.GoogleSecret, s.Config.Auth.GoogleRedirect, "email", "profile", "openid"),
 		slack.New(s.Config.Auth.SlackKey, s.Config.Auth.SlackSecret, s.Config.Auth.SlackRedirect, "users:read", "users:read.email", "team:read"),
-	)
+	}
+
+	if s.Config.Auth.MicrosoftKey != "" {
+		microsoft := azureadv2.New(s.Config.Auth.MicrosoftKey, s.Config.Auth.MicrosoftSecret, s.Config.Auth.MicrosoftRedirect,
+			azureadv2.ProviderOptions{
+				Tenant: azureadv2.TenantType(s.Config.Auth.MicrosoftTenant),
+				Scopes: []azureadv2.ScopeType{azureadv2.OpenIDScope, azureadv2.ProfileScope, azureadv2.EmailScope, azureadv2.UserReadScope},
+			})
+		// Expose the provider as /api/auth/social/microsoft
+		microsoft.SetName("microsoft")
+		providers = append(providers, microsoft)
+	}
+
+	if s.Config.Auth.OIDC.DiscoveryURL != "" {
+		oidc := s.Config.Auth.OIDC
+		// The discovery document is fetched on creation, don't fail the whole
+		// server if the issuer is temporarily unreachable
+		provider, err := openidConnect.NewNamed(oidc.Name, oidc.ClientID, oidc.ClientSecret, oidc.Redirect, oidc.DiscoveryURL, oidc.Scopes...)
+		if err != nil {
+			s.Echo.Logger.Errorf("Failed to setup OIDC provider %s: %v", oidc.Name, err)
+		} else {
+			providers = append(providers, provider)
+		}
+	}
+
+	goth.UseProviders(providers...)
 }
 
-func (s *Server) setupEmailClient() {
+func (s *Server) setupEmailClient() error {
 	apiKey := s.Config.Resend.APIKey
 	if apiKey == "" {
 		s.Echo.Logger.Warn("RESEND_API_KEY not configured, email notifications will be disabled")
-		return
+		return nil
+	}
+
+	templates, err := email.LoadTemplates(email.DefaultTemplatesDir)
+	if err != nil {
+		return err
+	}
+
+	// Without a secret anyone could forge the links, the emails go without them
+	var unsubscribeLinks *email.UnsubscribeLinks
+	if s.Config.Resend.UnsubscribeSecret != "" {
+		unsubscribeLinks = email.NewUnsubscribeLinks(s.Config.Server.DeployDomain, s.Config.Resend.UnsubscribeSecret)
+	} else {
+		s.Echo.Logger.Warn("EMAIL_UNSUBSCRIBE_SECRET not configured, emails will have no unsubscribe links")
 	}
 
 	resendClient := resend.NewClient(apiKey)
 	s.EmailClient = email.NewResendEmailClient(resendClient,
 		s.Config.Resend.DefaultSender,
+		templates,
+		unsubscribeLinks,
+		s.DB,
 		s.Echo.Logger)
+
+	return nil
+}
+
+func (s *Server) setupNotifier() error {
+	dispatcher := notifications.NewDispatcher(s.Echo.Logger)
+
+	routes, err := notifications.ParseRoutes(s.Config.Notifications.Routes)
+	if err != nil {
+		return err
+	}
+	dispatcher.SetRoutes(routes)
+
+	templates, err := notifications.LoadTemplates(s.Config.Notifications.TemplatesFile,
+		s.Config.Notifications.Environment,
+		s.Config.Server.DeployDomain)
+	if err != nil {
+		return err
+	}
+	dispatcher.SetTemplates(templates)
+
+	if s.Config.Telegram.BotToken != "" && s.Config.Telegram.ChatID != "" {
+		dispatcher.Register(notifications.NewTelegramSink(s.Config.Telegram.BotToken,
+			s.Config.Telegram.ChatID,
+			s.Config.Telegram.BatchInterval,
+			s.Echo.Logger))
+	}
+
+	slack := s.Config.SlackNotifications
+	if slack.WebhookURL != "" || slack.SignupsWebhookURL != "" || slack.SigninsWebhookURL != "" || slack.CallsWebhookURL != "" {
+		dispatcher.Register(notifications.NewSlackSink(slack.WebhookURL, map[notifications.EventCategory]string{
+			notifications.EventCategorySignups: slack.SignupsWebhookURL,
+			notifications.EventCategorySignins: slack.SigninsWebhookURL,
+			notifications.EventCategoryCalls:   slack.CallsWebhookURL,
+		}))
+	}
+
+	if s.Config.DiscordNotifications.WebhookURL != "" {
+		dispatcher.Register(notifications.NewDiscordSink(s.Config.DiscordNotifications.WebhookURL))
+	}
+
+	if s.Config.PagerDutyNotifications.RoutingKey != "" {
+		dispatcher.Register(notifications.NewPagerDutySink(s.Config.PagerDutyNotifications.RoutingKey, s.Config.Server.DeployDomain))
+	}
+
+	if s.Config.OpsgenieNotifications.APIKey != "" {
+		dispatcher.Register(notifications.NewOpsgenieSink(s.Config.OpsgenieNotifications.APIURL,
+			s.Config.OpsgenieNotifications.APIKey,
+			s.Config.Server.DeployDomain))
+	}
+
+	dispatcher.Start()
+	s.Notifier = dispatcher
+
+	return nil
+}
+
+func (s *Server) setupStorage() {
+	if s.Config.Storage.PrivateBucket == "" {
+		s.Echo.Logger.Warn("STORAGE_PRIVATE_BUCKET not configured, file transfers will be disabled")
+	} else {
+		s.PrivateStorage = storage.NewPrivateS3Storage(s.Config)
+	}
+
+	if s.Config.Storage.Bucket == "" {
+		s.Echo.Logger.Warn("STORAGE_BUCKET not configured, uploads will be disabled")
+		return
+	}
+
+	s.Storage = storage.NewS3Storage(s.Config)
 }
 
 func (s *Server) setupRoutes() {
@@ -225,9 +483,12 @@ func (s *Server) setupRoutes() {
 
 	// Set the EmailClient field directly
 	auth.ServerState.EmailClient = s.EmailClient
+	auth.ServerState.Storage = s.Storage
+	auth.ServerState.PrivateStorage = s.PrivateStorage
+	auth.ServerState.Notifier = s.Notifier
 
-	// API routes group
-	api := s.Echo.Group("/api")
+	// API routes group, every change made through it is written to the audit log
+	api := s.Echo.Group("/api", handlers.AuditLogMiddleware(s.DB))
 
 	// Public API endpoints
 	api.GET("/health", func(c echo.Context) error {
@@ -242,18 +503,89 @@ func (s *Server) setupRoutes() {
 	api.GET("/auth/social/:provider/callback", auth.SocialLoginCallback)
 	api.POST("/sign-up", auth.ManualSignUp)
 	api.POST("/sign-in", auth.ManualSignIn)
+	api.GET("/email-change/confirm", auth.ShowEmailChangeConfirmation)
+	api.POST("/email-change/confirm", auth.ConfirmEmailChange)
+	api.GET("/sessions/revoke", auth.ShowRevokeSessionConfirmation)
+	api.POST("/sessions/revoke", auth.RevokeSessionFromEmail)
+	// Device authorization flow for the desktop app
+	api.POST("/auth/device/code", auth.DeviceCode)
+	api.POST("/auth/device/token", auth.DeviceToken)
 	api.GET("/watercooler/meet-redirect", auth.WatercoolerMeetRedirect)
+	api.POST("/livekit/webhook", auth.LiveKitWebhook)
+	api.POST("/resend/webhook", auth.ResendWebhook)
+	api.GET("/email/unsubscribe", auth.UnsubscribeEmail)
+	api.POST("/email/unsubscribe", auth.UnsubscribeEmail)
 
 	// Protected API routes group
-	protectedAPI := api.Group("/auth", s.JwtIssuer.Middleware())
+	protectedAPI := api.Group("/auth", handlers.APIKeyMiddleware(s.DB), s.JwtIssuer.Middleware(), handlers.TeamIPAllowlistMiddleware(s.DB), handlers.SessionActivityMiddleware(s.DB), handlers.ImpersonationAuditMiddleware(s.DB))
 
 	protectedAPI.GET("/authenticate-app", auth.AuthenticateApp)
+	protectedAPI.POST("/logout", auth.Logout)
+	protectedAPI.POST("/device/approve", auth.ApproveDevice)
+	protectedAPI.POST("/device/deny", auth.DenyDevice)
+	protectedAPI.GET("/sessions", auth.ListSessions)
+	protectedAPI.GET("/activity/recent", auth.RecentActivity)
+	protectedAPI.POST("/admin/impersonate", auth.Impersonate)
+	protectedAPI.GET("/admin/email-templates", auth.ListEmailTemplates)
+	protectedAPI.POST("/admin/test-email", auth.SendTestEmail)
+	protectedAPI.DELETE("/sessions/:id", auth.RevokeSession)
+	protectedAPI.GET("/identities", auth.ListIdentities)
+	protectedAPI.POST("/identities/:provider/link", auth.LinkIdentity)
+	protectedAPI.DELETE("/identities/:id", auth.UnlinkIdentity)
 	protectedAPI.GET("/user", auth.User)
 	protectedAPI.PUT("/update-user-name", auth.UpdateName)
+	protectedAPI.POST("/change-email", auth.RequestEmailChange)
+	protectedAPI.PUT("/do-not-disturb", auth.UpdateDoNotDisturb)
+	protectedAPI.PUT("/locale", auth.UpdateLocale)
+	protectedAPI.GET("/email-preferences", auth.GetEmailPreferences)
+	protectedAPI.PUT("/email-preferences", auth.UpdateEmailPreferences)
+	protectedAPI.PUT("/presence/status", auth.UpdatePresenceStatus)
 	protectedAPI.GET("/teammates", auth.Teammates)
-	protectedAPI.GET("/websocket", handlers.CreateWSHandler(&s.ServerState))
+	protectedAPI.GET("/teams", auth.ListTeams)
+	protectedAPI.PUT("/active-team", auth.SwitchActiveTeam)
+	protectedAPI.POST("/team/logo", auth.UploadTeamLogo)
+	protectedAPI.POST("/team/leave", auth.LeaveTeam)
+	protectedAPI.GET("/team/activity", auth.TeamActivityFeed)
+	protectedAPI.GET("/team/calls", auth.TeamCallHistory)
+	protectedAPI.GET("/team/stats", auth.TeamCallStats)
+	protectedAPI.GET("/calls", auth.CallHistory)
+	protectedAPI.GET("/missed-calls", auth.ListMissedCalls)
+	protectedAPI.DELETE("/missed-calls", auth.ClearMissedCalls)
+	protectedAPI.DELETE("/missed-calls/:id", auth.ClearMissedCall)
+	protectedAPI.GET("/unread-counts", auth.UnreadCounts)
+	protectedAPI.GET("/callback-requests", auth.ListCallbackRequests)
+	protectedAPI.POST("/callback-requests", auth.CreateCallbackRequest)
+	protectedAPI.DELETE("/callback-requests/:id", auth.DismissCallbackRequest)
+	protectedAPI.GET("/team/settings", auth.GetTeamSettings)
+	protectedAPI.PUT("/team/settings", auth.UpdateTeamSettings)
+	protectedAPI.POST("/team/announcements", auth.PostTeamAnnouncement)
+	protectedAPI.GET("/team/chat", auth.TeamChatHistory)
+	protectedAPI.POST("/team/chat", auth.SendTeamChatMessage)
+	protectedAPI.GET("/direct-messages/:id", auth.ListDirectMessages)
+	protectedAPI.POST("/direct-messages/:id/read", auth.MarkDirectMessagesRead)
+	protectedAPI.POST("/direct-messages/messages/:id/reactions", auth.AddDirectMessageReaction)
+	protectedAPI.DELETE("/direct-messages/messages/:id/reactions", auth.RemoveDirectMessageReaction)
+	protectedAPI.POST("/file-transfers", auth.SendFileTransfer)
+	protectedAPI.GET("/team/webhooks", auth.ListTeamWebhooks)
+	protectedAPI.POST("/team/webhooks", auth.CreateTeamWebhook)
+	protectedAPI.DELETE("/team/webhooks/:id", auth.DeleteTeamWebhook)
+	s.hub = handlers.NewHub(&s.ServerState)
+	protectedAPI.GET("/websocket", handlers.CreateWSHandler(s.hub))
+	protectedAPI.GET("/api-keys", auth.ListApiKeys)
+	protectedAPI.POST("/api-keys", auth.CreateApiKey)
+	protectedAPI.DELETE("/api-keys/:id", auth.RevokeApiKey)
 	protectedAPI.GET("/get-invite-uuid", auth.GetInviteUUID)
+	protectedAPI.POST("/regenerate-invite-uuid", auth.RegenerateInviteUUID)
+	protectedAPI.DELETE("/invite-uuid", auth.RevokeInviteUUID)
 	protectedAPI.POST("/send-team-invites", auth.SendTeamInvites)
+	protectedAPI.GET("/team/invitations", auth.ListTeamInvitations)
+	protectedAPI.DELETE("/team/invitations/:id", auth.CancelTeamInvitation)
+	protectedAPI.GET("/teams/discover", auth.DiscoverTeams)
+	protectedAPI.GET("/slack/members-not-on-hopp", auth.SlackMembersNotOnHopp)
+	protectedAPI.POST("/teams/:id/join-requests", auth.CreateJoinRequest)
+	protectedAPI.GET("/team/join-requests", auth.ListJoinRequests)
+	protectedAPI.POST("/team/join-requests/:id/approve", auth.ApproveJoinRequest)
+	protectedAPI.POST("/team/join-requests/:id/reject", auth.RejectJoinRequest)
 	protectedAPI.POST("/metadata/onboarding-form", auth.UpdateOnboardingFormStatus)
 	// Temporary room functionality for alpha
 	// on-boarding of >2 people calls
@@ -262,6 +594,24 @@ func (s *Server) setupRoutes() {
 
 	// LiveKit server endpoint
 	protectedAPI.GET("/livekit/server-url", auth.GetLivekitServerURL)
+	protectedAPI.POST("/livekit/tokens/refresh", auth.RefreshCallTokens)
+	protectedAPI.POST("/calls/:room/dial-in", auth.CreateCallDialIn)
+	protectedAPI.DELETE("/calls/:room/dial-in", auth.DeleteCallDialIn)
+
+	// Admin API for operating the hosted service, for super admins only
+	adminAPI := api.Group("/admin", s.JwtIssuer.Middleware(), handlers.SessionActivityMiddleware(s.DB), auth.SuperAdminMiddleware())
+	adminAPI.GET("/users", auth.AdminListUsers)
+	adminAPI.POST("/users", auth.AdminCreateUser)
+	adminAPI.GET("/users/:id", auth.AdminGetUser)
+	adminAPI.GET("/users/:id/presence", auth.AdminUserPresence)
+	adminAPI.DELETE("/users/:id/sessions", auth.AdminRevokeUserSessions)
+	adminAPI.GET("/teams/:id", auth.AdminGetTeam)
+	adminAPI.GET("/teams/:id/invitations", auth.AdminListTeamInvitations)
+	adminAPI.POST("/teams/:id/invite-link", auth.AdminRotateTeamInvite)
+	adminAPI.GET("/feature-flags", auth.AdminListFeatureFlags)
+	adminAPI.PUT("/feature-flags/:name", auth.AdminSetFeatureFlag)
+	adminAPI.GET("/stats", auth.AdminStats)
+	adminAPI.GET("/audit-log", auth.AdminAuditLog)
 
 	// Debug endpoints - only enabled when ENABLE_DEBUG_ENDPOINTS=true
 	if s.Config.Server.Debug {
@@ -269,6 +619,7 @@ func (s *Server) setupRoutes() {
 			return c.Render(http.StatusOK, "debug.html", nil)
 		})
 		api.GET("/call-token", auth.GenerateDebugCallToken)
+		api.POST("/seed", auth.SeedDemoData)
 		api.GET("/jwt-debug", func(c echo.Context) error {
 			email := c.QueryParam("email")
 			token, err := s.JwtIssuer.GenerateToken(email)
@@ -282,6 +633,9 @@ func (s *Server) setupRoutes() {
 		})
 	}
 
+	// Public keys for services that need to verify our tokens
+	s.Echo.GET("/.well-known/jwks.json", auth.JWKS)
+
 	// SPA handler - serve index.html for all other routes
 	s.Echo.GET("/*", func(c echo.Context) error {
 		// Skip API routes
@@ -296,7 +650,47 @@ func (s *Server) setupRoutes() {
 	})
 }
 
+// Start serves requests until the process is asked to stop with SIGINT or SIGTERM,
+// then shuts the server down gracefully
 func (s *Server) Start() error {
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- s.listen()
+	}()
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(quit)
+
+	select {
+	case err := <-serverErr:
+		return err
+	case sig := <-quit:
+		s.Echo.Logger.Info("Received ", sig, ", shutting down")
+	}
+
+	return s.Shutdown()
+}
+
+// Shutdown stops accepting connections and waits for the ongoing requests, then tells
+// the websocket clients to reconnect and closes their connections
+func (s *Server) Shutdown() error {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	err := s.Echo.Shutdown(ctx)
+	if err != nil {
+		s.Echo.Logger.Error("Failed to shut down HTTP server: ", err)
+	}
+
+	if s.hub != nil {
+		s.hub.Shutdown(ctx)
+	}
+
+	return err
+}
+
+func (s *Server) listen() error {
 	serverURL := s.Config.Server.Host + ":" + s.Config.Server.Port
 
 	if s.Config.Server.TLS.Enabled {
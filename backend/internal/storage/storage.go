@@ -0,0 +1,219 @@
+package storage
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hopp-backend/internal/config"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Storage stores user uploaded files like team logos
+type Storage interface {
+	// Upload stores the object and returns its public URL
+	Upload(ctx context.Context, key, contentType string, body io.Reader, size int64) (string, error)
+	// PresignGet returns a URL anyone can download the object from until it expires
+	PresignGet(key string, expires time.Duration) (string, error)
+	// Delete removes the object, deleting a missing object is not an error
+	Delete(ctx context.Context, key string) error
+}
+
+// S3Storage implements Storage for S3 compatible object storage
+// (AWS S3, Cloudflare R2, MinIO etc.) using path-style requests
+type S3Storage struct {
+	endpoint  string
+	region    string
+	bucket    string
+	accessKey string
+	secretKey string
+	publicURL string
+	client    *http.Client
+}
+
+// NewS3Storage creates a new S3Storage of the public bucket from the storage configuration
+func NewS3Storage(cfg *config.Config) *S3Storage {
+	return newS3Storage(cfg, cfg.Storage.Bucket, cfg.Storage.PublicURL)
+}
+
+// NewPrivateS3Storage creates a new S3Storage of the private bucket, its objects
+// can only be downloaded through presigned URLs so Upload returns the plain object URL
+func NewPrivateS3Storage(cfg *config.Config) *S3Storage {
+	return newS3Storage(cfg, cfg.Storage.PrivateBucket, "")
+}
+
+func newS3Storage(cfg *config.Config, bucket, publicURL string) *S3Storage {
+	if publicURL == "" {
+		publicURL = fmt.Sprintf("%s/%s", strings.TrimSuffix(cfg.Storage.Endpoint, "/"), bucket)
+	}
+
+	return &S3Storage{
+		endpoint:  strings.TrimSuffix(cfg.Storage.Endpoint, "/"),
+		region:    cfg.Storage.Region,
+		bucket:    bucket,
+		accessKey: cfg.Storage.AccessKey,
+		secretKey: cfg.Storage.SecretKey,
+		publicURL: strings.TrimSuffix(publicURL, "/"),
+		client:    &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Upload puts the object in the bucket, signed with AWS Signature Version 4
+func (s *S3Storage) Upload(ctx context.Context, key, contentType string, body io.Reader, size int64) (string, error) {
+	objectURL, err := url.Parse(fmt.Sprintf("%s/%s/%s", s.endpoint, s.bucket, key))
+	if err != nil {
+		return "", fmt.Errorf("parsing object URL: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, objectURL.String(), body)
+	if err != nil {
+		return "", fmt.Errorf("creating request: %w", err)
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", contentType)
+
+	s.sign(req, time.Now().UTC())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("uploading object: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return "", fmt.Errorf("storage returned status %d: %s", resp.StatusCode, respBody)
+	}
+
+	return fmt.Sprintf("%s/%s", s.publicURL, key), nil
+}
+
+// Delete removes the object from the bucket, signed with AWS Signature Version 4
+func (s *S3Storage) Delete(ctx context.Context, key string) error {
+	objectURL, err := url.Parse(fmt.Sprintf("%s/%s/%s", s.endpoint, s.bucket, key))
+	if err != nil {
+		return fmt.Errorf("parsing object URL: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, objectURL.String(), nil)
+	if err != nil {
+		return fmt.Errorf("creating request: %w", err)
+	}
+
+	s.sign(req, time.Now().UTC())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("deleting object: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// S3 answers 204 even when the object doesn't exist, some compatible stores answer 404
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("storage returned status %d: %s", resp.StatusCode, respBody)
+	}
+
+	return nil
+}
+
+// PresignGet signs a GET request of the object in its query, with AWS Signature Version 4
+func (s *S3Storage) PresignGet(key string, expires time.Duration) (string, error) {
+	objectURL, err := url.Parse(fmt.Sprintf("%s/%s/%s", s.endpoint, s.bucket, key))
+	if err != nil {
+		return "", fmt.Errorf("parsing object URL: %w", err)
+	}
+
+	now := time.Now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	scope := fmt.Sprintf("%s/%s/s3/aws4_request", date, s.region)
+
+	query := url.Values{}
+	query.Set("X-Amz-Algorithm", "AWS4-HMAC-SHA256")
+	query.Set("X-Amz-Credential", fmt.Sprintf("%s/%s", s.accessKey, scope))
+	query.Set("X-Amz-Date", amzDate)
+	query.Set("X-Amz-Expires", fmt.Sprintf("%d", int64(expires.Seconds())))
+	query.Set("X-Amz-SignedHeaders", "host")
+	// Encode sorts the parameters by key, as the canonical request needs them
+	canonicalQuery := query.Encode()
+
+	canonicalRequest := strings.Join([]string{
+		http.MethodGet,
+		objectURL.EscapedPath(),
+		canonicalQuery,
+		fmt.Sprintf("host:%s\n", objectURL.Host),
+		"host",
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+
+	signature := hex.EncodeToString(hmacSHA256(s.signingKey(date), s.stringToSign(amzDate, scope, canonicalRequest)))
+	objectURL.RawQuery = canonicalQuery + "&X-Amz-Signature=" + signature
+
+	return objectURL.String(), nil
+}
+
+// sign adds the Authorization header of AWS Signature Version 4.
+// The payload is not signed so the body can be streamed.
+func (s *S3Storage) sign(req *http.Request, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := "UNSIGNED-PAYLOAD"
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalHeaders := fmt.Sprintf("host:%s\nx-amz-content-sha256:%s\nx-amz-date:%s\n",
+		req.URL.Host, payloadHash, amzDate)
+	// Requests without a body, like deletes, have no content type to sign
+	if contentType := req.Header.Get("Content-Type"); contentType != "" {
+		signedHeaders = "content-type;" + signedHeaders
+		canonicalHeaders = fmt.Sprintf("content-type:%s\n", contentType) + canonicalHeaders
+	}
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := fmt.Sprintf("%s/%s/s3/aws4_request", date, s.region)
+	signature := hex.EncodeToString(hmacSHA256(s.signingKey(date), s.stringToSign(amzDate, scope, canonicalRequest)))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKey, scope, signedHeaders, signature))
+}
+
+// stringToSign is the string of AWS Signature Version 4 for the canonical request
+func (s *S3Storage) stringToSign(amzDate, scope, canonicalRequest string) string {
+	canonicalHash := sha256.Sum256([]byte(canonicalRequest))
+	return strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hex.EncodeToString(canonicalHash[:]),
+	}, "\n")
+}
+
+// signingKey derives the key of the day that signs the requests
+func (s *S3Storage) signingKey(date string) []byte {
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), date)
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, "s3")
+	return hmacSHA256(key, "aws4_request")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
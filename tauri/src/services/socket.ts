@@ -49,7 +49,8 @@ class SocketService {
     console.log("Connecting 📶:", token);
 
     try {
-      this.socket = new WebSocket(`${this.baseUrl}?token=${token}`, [], {
+      // The token goes in a subprotocol, query tokens are off by default on the server
+      this.socket = new WebSocket(this.baseUrl, ["hopp", `bearer.${token}`], {
         minReconnectionDelay: 200,
         maxReconnectionDelay: 1000,
       });